@@ -0,0 +1,94 @@
+package v1beta1
+
+const (
+	// OnionAnnotation, set to "true" on a Service, exposes it as an onion
+	// service without writing an OnionService.
+	OnionAnnotation = "tor.stack.io/onion"
+	// OnionSOCKSPortAnnotation overrides the SOCKSPort of the OnionService
+	// created for an annotated Service.
+	OnionSOCKSPortAnnotation = "tor.stack.io/socks-port"
+	// OnionBackendsAnnotation enables HighAvailability with the given
+	// number of backends for an annotated Service.
+	OnionBackendsAnnotation = "tor.stack.io/onion-backends"
+	// OnionAddressAnnotation is written back on annotated Services with
+	// the onion address once it is known.
+	OnionAddressAnnotation = "tor.stack.io/onion-address"
+	// OnionLocationAnnotation, set on a clearnet Ingress to the name of an
+	// OnionService of its namespace, adds the Onion-Location header with
+	// its address to the responses.
+	OnionLocationAnnotation = "tor.stack.io/onion-location"
+	// IngressRejectedPathsAnnotation is written back on onion Ingresses
+	// with the paths left out of the proxy, one per line. Ingresses have no
+	// status conditions to report them.
+	IngressRejectedPathsAnnotation = "tor.stack.io/rejected-paths"
+	// OnionErrorAnnotation is written back on annotated Services which
+	// cannot be exposed, with the reason. No OnionService is created or
+	// updated until it is fixed.
+	OnionErrorAnnotation = "tor.stack.io/onion-error"
+)
+
+const (
+	// TorLabel set to TorLabelHideMe on a pod injects a tor client sidecar
+	// and points the containers to it through the proxy env vars.
+	TorLabel       = "tor"
+	TorLabelHideMe = "hide-me"
+	// SidecarExcludeContainersAnnotation is a comma separated list of
+	// containers left untouched by the sidecar injection.
+	SidecarExcludeContainersAnnotation = "tor.stack.io/exclude-containers"
+	// SidecarInjectedAnnotation marks pods already carrying the sidecar.
+	SidecarInjectedAnnotation = "tor.stack.io/injected"
+	// SidecarTransparentAnnotation, set to "true", forces the whole pod
+	// egress through the sidecar with iptables rules instead of relying on
+	// the proxy env vars.
+	SidecarTransparentAnnotation = "tor.stack.io/transparent"
+	// SidecarExcludeCIDRsAnnotation is a comma separated list of CIDRs
+	// reached directly in transparent mode, on top of the cluster ones.
+	SidecarExcludeCIDRsAnnotation = "tor.stack.io/exclude-cidrs"
+)
+
+const (
+	// EgressPolicyNamespaceLabel marks the namespaces holding at least one
+	// TorEgressPolicy, their pods are sent to the sidecar webhook.
+	EgressPolicyNamespaceLabel = "tor.stack.io/egress-policy"
+	// EgressPolicyAnnotation is set on pods configured by the webhook for
+	// a TorEgressPolicy, to the name of the policy.
+	EgressPolicyAnnotation = "tor.stack.io/egress-policy"
+	// TorProxyLabel marks the pods of a TorProxy, with its name. They are
+	// left out of the egress policy webhook so that the gateway of a
+	// namespace can start while the manager is down.
+	TorProxyLabel = "tor.stack.io/tor-proxy"
+)
+
+const (
+	// OnionServiceHashLabel is set on the objects an OnionService creates
+	// outside of its namespace, or has to list, to a hash of its namespace
+	// and name. Names do not always fit in label values.
+	OnionServiceHashLabel = "tor.stack.io/onion-service-hash"
+	// OnionServiceAnnotation and OnionServiceNamespaceAnnotation point
+	// back to the OnionService of the objects labelled with its hash.
+	OnionServiceAnnotation          = "tor.stack.io/onion-service"
+	OnionServiceNamespaceAnnotation = "tor.stack.io/onion-service-namespace"
+)
+
+const (
+	// OnionMetricsLabel marks the Services exposing the tor exporter of an
+	// OnionService, they are selected by the onion ServiceMonitor.
+	OnionMetricsLabel = "tor.stack.io/onion-metrics"
+	// AddressExportLabel marks the ConfigMaps and Secrets the onion address
+	// of an OnionService is exported to. Set to "true" on a namespace, it
+	// lets OnionServices of other namespaces export their address to it
+	// and restrict the ingress of their backends in it.
+	AddressExportLabel = "tor.stack.io/address-export"
+)
+
+const (
+	// OnionKeyGenerationLabel is set on the Secrets of OnionKeyGenerations
+	// to their name. The Secrets outlive them, it is not an owner.
+	OnionKeyGenerationLabel = "tor.stack.io/onion-key-generation"
+)
+
+const (
+	// OnionRestoreLabel is set on the Secrets restored by OnionRestores to
+	// their name.
+	OnionRestoreLabel = "tor.stack.io/onion-restore"
+)
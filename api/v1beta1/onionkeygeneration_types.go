@@ -0,0 +1,86 @@
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Prefix",type="string",JSONPath=".spec.prefix"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Rate",type="integer",JSONPath=".status.attemptsPerSecond"
+// +kubebuilder:printcolumn:name="ETA",type="date",JSONPath=".status.estimatedCompletionTime"
+// +kubebuilder:printcolumn:name="Onion Address",type="string",JSONPath=".status.onionAddress"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// OnionKeyGeneration runs a Job brute forcing an onion service key whose
+// address starts with Prefix, and stores it in a Secret OnionServices can
+// use through KeySecret.
+type OnionKeyGeneration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   OnionKeyGenerationSpec   `json:"spec,omitempty"`
+	Status OnionKeyGenerationStatus `json:"status,omitempty"`
+}
+
+type OnionKeyGenerationSpec struct {
+	// Prefix of the onion address, in base32. Every character multiplies
+	// the expected number of attempts by 32.
+	// +kubebuilder:validation:Pattern=`^[a-z2-7]+$`
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=12
+	Prefix string `json:"prefix"`
+	// Parallelism is the number of pods searching, each one uses every CPU
+	// it is given.
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Parallelism int32 `json:"parallelism,omitempty"`
+	// TimeoutSeconds after which the search is abandoned.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TimeoutSeconds *int64 `json:"timeoutSeconds,omitempty"`
+	// SecretName of the Secret the key is stored in, <name>-key by
+	// default. It is kept when the OnionKeyGeneration is deleted.
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+	// Resources of the search pods.
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+type OnionKeyGenerationStatus struct {
+	// Phase is Pending, Running, Succeeded or Failed.
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message,omitempty"`
+	// OnionAddress found, once Succeeded.
+	OnionAddress string `json:"onionAddress,omitempty"`
+	SecretName   string `json:"secretName,omitempty"`
+	// Attempts made so far by the running pods.
+	Attempts int64 `json:"attempts,omitempty"`
+	// AttemptsPerSecond of all the running pods.
+	AttemptsPerSecond int64 `json:"attemptsPerSecond,omitempty"`
+	// ExpectedAttempts is the average number of attempts to find the
+	// prefix.
+	ExpectedAttempts int64 `json:"expectedAttempts,omitempty"`
+	// EstimatedCompletionTime at the current rate, from the last progress
+	// report. Every attempt is independent, the expected attempts are still
+	// ahead however long the search ran. The search is random, it may well
+	// take less or more.
+	EstimatedCompletionTime *metav1.Time `json:"estimatedCompletionTime,omitempty"`
+	StartTime               *metav1.Time `json:"startTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+type OnionKeyGenerationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []OnionKeyGeneration `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OnionKeyGeneration{}, &OnionKeyGenerationList{})
+}
@@ -0,0 +1,66 @@
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Onion Address",type="string",JSONPath=".spec.source.onionAddress"
+// +kubebuilder:printcolumn:name="Secret",type="string",JSONPath=".status.secretName"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// OnionRestore rebuilds the key Secret of an onion service from the
+// backup of an OnionService, for OnionServices to use it as KeySecret.
+type OnionRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   OnionRestoreSpec   `json:"spec,omitempty"`
+	Status OnionRestoreStatus `json:"status,omitempty"`
+}
+
+type OnionRestoreSpec struct {
+	// Storage the backup is read from.
+	Storage BackupStorage `json:"storage"`
+	Source  RestoreSource `json:"source"`
+	// IdentitySecret holds, under the identity key, the PEM X25519 private
+	// key the backup was encrypted to.
+	IdentitySecret string `json:"identitySecret"`
+	// SecretName of the Secret the key is restored to, <name>-key by
+	// default. It is kept when the OnionRestore is deleted.
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+}
+
+// RestoreSource is the backup of an onion address of an OnionService.
+type RestoreSource struct {
+	// Name of the OnionService which was backed up.
+	Name string `json:"name"`
+	// Namespace of the OnionService, the one of the OnionRestore by
+	// default.
+	// +optional
+	Namespace    string `json:"namespace,omitempty"`
+	OnionAddress string `json:"onionAddress"`
+}
+
+type OnionRestoreStatus struct {
+	// Phase is Pending, Succeeded or Failed.
+	Phase        string `json:"phase,omitempty"`
+	Message      string `json:"message,omitempty"`
+	OnionAddress string `json:"onionAddress,omitempty"`
+	SecretName   string `json:"secretName,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+type OnionRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []OnionRestore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OnionRestore{}, &OnionRestoreList{})
+}
@@ -1,16 +1,37 @@
 package v1beta1
 
 import (
+	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 const CleanupOnionServiceFinalizer = "onionservice.torproxy/cleanup"
 
+const (
+	// ConditionBackendResolved reports whether Backend.ServiceRef points to
+	// an existing Service and port.
+	ConditionBackendResolved = "BackendResolved"
+
+	ReasonBackendResolved = "Resolved"
+	ReasonBackendNotFound = "BackendNotFound"
+	ReasonPortNotFound    = "PortNotFound"
+	ReasonInvalidPort     = "InvalidPort"
+
+	// ConditionAddressExported reports whether the onion address could be
+	// written to every namespace of AddressExport.
+	ConditionAddressExported = "AddressExported"
+
+	ReasonAddressExported     = "Exported"
+	ReasonNamespaceNotAllowed = "NamespaceNotAllowed"
+)
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Onion Address",type="string",JSONPath=".status.onionAddress"
+// +kubebuilder:printcolumn:name="Previous Address",type="string",JSONPath=".status.keyRotation.previousOnionAddress",priority=1
 // +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
@@ -22,6 +43,9 @@ type OnionService struct {
 	Status OnionServiceStatus `json:"status,omitempty"`
 }
 
+// +kubebuilder:validation:XValidation:rule="has(self.ports) || (has(self.hiddenServicePort) && self.hiddenServicePort > 0)",message="hiddenServicePort is required without ports"
+// +kubebuilder:validation:XValidation:rule="!(has(self.keyRotation) && has(self.highAvailability))",message="keyRotation is not supported with highAvailability"
+// +kubebuilder:validation:XValidation:rule="!(has(self.backup) && has(self.highAvailability))",message="backup is not supported with highAvailability"
 type OnionServiceSpec struct {
 	SOCKSPort int `json:"socksPort"`
 	// Entry policies to allow/deny SOCKS requests based on IP address.
@@ -33,15 +57,310 @@ type OnionServiceSpec struct {
 	// SOCKSPolicy accept6 FC00::/7
 	// SOCKSPolicy reject *
 	SOCKSPolicy         []string `json:"socksPolicy,omitempty"`
-	HiddenServicePort   int      `json:"hiddenServicePort"`
+	HiddenServicePort   int      `json:"hiddenServicePort,omitempty"`
 	HiddenServiceDir    string   `json:"hiddenServiceDir,omitempty"`
 	HiddenServiceTarget string   `json:"hiddenServiceTarget,omitempty"`
+
+	// Backend selects the target of the onion service by reference. When
+	// set, it takes precedence over HiddenServiceTarget.
+	Backend *OnionServiceBackend `json:"backend,omitempty"`
+
+	// Ports exposes several virtual ports forwarded to the Service
+	// referenced by Backend.ServiceRef. When set, it replaces
+	// HiddenServicePort and Backend.ServiceRef.Port.
+	Ports []OnionServicePort `json:"ports,omitempty"`
+
+	// HighAvailability runs several backend tor instances behind an
+	// Onionbalance frontend publishing a single master descriptor, so the
+	// onion address survives the loss of individual pods.
+	HighAvailability *HighAvailabilitySpec `json:"highAvailability,omitempty"`
+
+	// NetworkPolicy generates NetworkPolicies restricting the ingress of
+	// the tor pods and, optionally, of the backend.
+	NetworkPolicy *OnionNetworkPolicy `json:"networkPolicy,omitempty"`
+
+	// TorNetwork is the name of a TorNetwork of the namespace the onion
+	// service is published on instead of the public Tor network.
+	TorNetwork string `json:"torNetwork,omitempty"`
+
+	// Metrics runs the tor exporter next to tor, serving the bootstrap
+	// progress, circuits, traffic and onion service metrics of tor.
+	Metrics *OnionMetrics `json:"metrics,omitempty"`
+
+	// Probes tune the readiness and liveness probes of the tor pods,
+	// served by the tor agent from the state of tor.
+	Probes *OnionProbes `json:"probes,omitempty"`
+
+	// HealthCheck checks the targets of the HiddenServicePorts from the tor
+	// pods and reports their health in status.
+	HealthCheck *BackendHealthCheck `json:"healthCheck,omitempty"`
+
+	// AddressExport writes the onion address, the URL of every port and
+	// the public key of the onion service to a ConfigMap or Secret, for
+	// the workloads which need to know it.
+	AddressExport *AddressExport `json:"addressExport,omitempty"`
+
+	// KeySecret is the name of a Secret holding the hs_ed25519_secret_key,
+	// hs_ed25519_public_key and hostname of the onion service, such as the
+	// one of an OnionKeyGeneration. The onion service waits for the Secret
+	// to be filled. With HighAvailability, it is the key of the frontend.
+	KeySecret string `json:"keySecret,omitempty"`
+
+	// KeyRotation replaces the key of the onion service with a new one,
+	// serving both addresses for an overlap period. It is not supported
+	// with HighAvailability, such OnionServices are rejected.
+	KeyRotation *KeyRotation `json:"keyRotation,omitempty"`
+
+	// Backup periodically exports the keys of the onion service, encrypted,
+	// to an S3 compatible storage. OnionRestores bring them back. It is not
+	// supported with HighAvailability, such OnionServices are rejected.
+	Backup *OnionBackup `json:"backup,omitempty"`
+}
+
+type OnionBackup struct {
+	Storage BackupStorage `json:"storage"`
+	// Recipient is the PEM X25519 public key the keys are encrypted to, see
+	// `openssl pkey -pubout`. Only its private key can decrypt them.
+	Recipient string `json:"recipient"`
+	// PeriodSeconds between two backups.
+	// +kubebuilder:default=3600
+	// +kubebuilder:validation:Minimum=60
+	// +optional
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+}
+
+// BackupStorage is a bucket of an S3 compatible storage, such as MinIO.
+// Backups are stored as <prefix><namespace>/<name>/<onion address>.
+type BackupStorage struct {
+	// Endpoint is the URL of the storage, e.g. http://minio.minio:9000,
+	// without path. It is reached from the tor pods for backups and from
+	// the manager for restores.
+	// +kubebuilder:validation:Pattern=`^https?://[^/?#@\s]+/?$`
+	// +kubebuilder:validation:MaxLength=2048
+	Endpoint string `json:"endpoint"`
+	Bucket   string `json:"bucket"`
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+	// +kubebuilder:default=us-east-1
+	// +optional
+	Region string `json:"region,omitempty"`
+	// CredentialsSecret holds the accessKeyID and secretAccessKey of the
+	// storage.
+	CredentialsSecret string `json:"credentialsSecret"`
+}
+
+type KeyRotation struct {
+	// Trigger starts a rotation whenever it changes, e.g. set it to the
+	// date of the rotation. Changes during an overlap period are applied
+	// once it ends.
+	// +optional
+	Trigger string `json:"trigger,omitempty"`
+	// OverlapSeconds the previous address is still served for after a
+	// rotation.
+	// +kubebuilder:default=86400
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	OverlapSeconds int64 `json:"overlapSeconds,omitempty"`
+}
+
+type AddressExport struct {
+	// Kind of the object written.
+	// +kubebuilder:validation:Enum=ConfigMap;Secret
+	// +kubebuilder:default=ConfigMap
+	// +optional
+	Kind string `json:"kind,omitempty"`
+	// Name of the object, <name>-onion-address by default.
+	// +optional
+	Name string `json:"name,omitempty"`
+	// Namespaces the object is written to, the namespace of the
+	// OnionService by default. Other namespaces have to opt in with the
+	// tor.stack.io/address-export=true label, the address is not written
+	// to the others and the AddressExported condition lists them.
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+type BackendHealthCheck struct {
+	// Type of the checks: TCP connects to the targets, HTTP requests Path
+	// and expects a 2xx or 3xx answer.
+	// +kubebuilder:validation:Enum=TCP;HTTP
+	// +kubebuilder:default=TCP
+	// +optional
+	Type string `json:"type,omitempty"`
+	// Path requested by HTTP checks.
+	// +kubebuilder:default="/"
+	// +optional
+	Path string `json:"path,omitempty"`
+	// PeriodSeconds between two checks.
+	// +kubebuilder:default=10
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+	// TimeoutSeconds of a check.
+	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+	// StopAdvertising takes the onion service down in tor while none of
+	// its targets is reachable, so that visitors are not introduced to a
+	// failing service. It is restored once a target is back.
+	// +optional
+	StopAdvertising bool `json:"stopAdvertising,omitempty"`
+}
+
+type OnionProbes struct {
+	// Readiness passes once tor is bootstrapped and, as asked, once it
+	// uploaded the descriptor and reaches the backends.
+	Readiness *OnionReadinessProbe `json:"readiness,omitempty"`
+	// Liveness fails while the control port of tor does not answer.
+	Liveness *ProbeThresholds `json:"liveness,omitempty"`
+}
+
+type OnionReadinessProbe struct {
+	ProbeThresholds `json:",inline"`
+	// SuccessThreshold is the number of consecutive successes for the pod
+	// to become ready again.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	SuccessThreshold int32 `json:"successThreshold,omitempty"`
+	// Descriptor waits for the descriptor of the onion service to be
+	// uploaded to the directories.
+	// +kubebuilder:default=true
+	// +optional
+	Descriptor *bool `json:"descriptor,omitempty"`
+	// Backend waits for the targets of every HiddenServicePort to accept
+	// TCP connections.
+	// +optional
+	Backend bool `json:"backend,omitempty"`
+}
+
+// ProbeThresholds are the timings of a probe, see corev1.Probe. Unset
+// fields take the Kubernetes defaults.
+type ProbeThresholds struct {
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+}
+
+type OnionMetrics struct {
+	// Port the exporter listens on, exposed by the <name>-metrics Service.
+	// +kubebuilder:default=9130
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port int32 `json:"port,omitempty"`
+}
+
+type OnionNetworkPolicy struct {
+	// SOCKSFrom are allowed to reach the SOCKS port, on top of the
+	// addresses accepted by SOCKSPolicy. Nothing else can reach the tor
+	// pods.
+	SOCKSFrom []networkingv1.NetworkPolicyPeer `json:"socksFrom,omitempty"`
+	// RestrictBackend lets only the tor pods reach the pods selected by the
+	// Service referenced by Backend.ServiceRef. The Service namespace, when
+	// not the one of the OnionService, must be labelled
+	// tor.stack.io/address-export=true.
+	RestrictBackend bool `json:"restrictBackend,omitempty"`
+}
+
+type OnionServiceBackend struct {
+	// ServiceRef is resolved to the cluster DNS name and port of the Service.
+	ServiceRef *ServiceReference `json:"serviceRef,omitempty"`
+}
+
+type ServiceReference struct {
+	Name string `json:"name"`
+	// Namespace of the Service, defaults to the OnionService namespace.
+	Namespace string `json:"namespace,omitempty"`
+	// Port name or number exposed by the Service.
+	Port intstr.IntOrString `json:"port,omitempty"`
+}
+
+type OnionServicePort struct {
+	Name string `json:"name,omitempty"`
+	// Virtual port exposed on the onion address.
+	Port int32 `json:"port"`
+	// Port name or number of the referenced Service, defaults to Port.
+	TargetPort intstr.IntOrString `json:"targetPort,omitempty"`
+}
+
+type HighAvailabilitySpec struct {
+	// Number of backend tor instances, each one with its own introduction
+	// points and HiddenServiceDir.
+	// +kubebuilder:validation:Minimum=2
+	// +kubebuilder:default=2
+	Backends int32 `json:"backends,omitempty"`
+	// Image running the onionbalance frontend. It needs to ship both tor
+	// and onionbalance.
+	OnionbalanceImage string `json:"onionbalanceImage,omitempty"`
 }
 
 type OnionServiceStatus struct {
 	OnionAddress string `json:"onionAddress,omitempty"`
 	Phase        string `json:"phase,omitempty"`
 	Message      string `json:"message,omitempty"`
+	// ReadyTime is when the OnionService first became Ready.
+	ReadyTime *metav1.Time `json:"readyTime,omitempty"`
+	// Backends lists the instance addresses aggregated by the Onionbalance
+	// frontend when HighAvailability is enabled.
+	Backends []OnionBackendStatus `json:"backends,omitempty"`
+	// BackendHealth is the health of the HiddenServicePort targets as seen
+	// from the tor pods, when HealthCheck is set.
+	BackendHealth []OnionPortHealth `json:"backendHealth,omitempty"`
+	// Backup is the outcome of the last backup, when Backup is set.
+	Backup *OnionBackupStatus `json:"backup,omitempty"`
+	// KeyRotation is the state of the last key rotation.
+	KeyRotation *KeyRotationStatus `json:"keyRotation,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+type OnionBackupStatus struct {
+	LastBackupTime *metav1.Time `json:"lastBackupTime,omitempty"`
+	// Objects written by the last backup, one per onion address.
+	Objects   []string `json:"objects,omitempty"`
+	LastError string   `json:"lastError,omitempty"`
+}
+
+type KeyRotationStatus struct {
+	// Trigger of the last rotation.
+	Trigger string `json:"trigger,omitempty"`
+	// OnionAddress of the new key, it is the OnionAddress of the
+	// OnionService from the start of the rotation.
+	OnionAddress string `json:"onionAddress,omitempty"`
+	// PreviousOnionAddress is still served until RetireTime.
+	PreviousOnionAddress string       `json:"previousOnionAddress,omitempty"`
+	StartTime            *metav1.Time `json:"startTime,omitempty"`
+	RetireTime           *metav1.Time `json:"retireTime,omitempty"`
+}
+
+type OnionPortHealth struct {
+	// Port is the virtual port of the onion service.
+	Port   int32  `json:"port"`
+	Target string `json:"target"`
+	// Reachable from every tor pod.
+	Reachable bool `json:"reachable"`
+	// LatencyMilliseconds of the slowest successful check.
+	LatencyMilliseconds int64 `json:"latencyMilliseconds,omitempty"`
+	// LastError of a failed check, prefixed by the pod it failed from.
+	LastError     string       `json:"lastError,omitempty"`
+	LastCheckTime *metav1.Time `json:"lastCheckTime,omitempty"`
+}
+
+type OnionBackendStatus struct {
+	Name         string `json:"name"`
+	OnionAddress string `json:"onionAddress,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,93 @@
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas,selectorpath=.status.selector
+// +kubebuilder:printcolumn:name="Replicas",type="integer",JSONPath=".status.readyReplicas"
+// +kubebuilder:printcolumn:name="Connections",type="integer",JSONPath=".status.connections"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// SnowflakeProxy runs standalone Snowflake proxies, relaying the WebRTC
+// connections of censored users to the Snowflake bridge.
+type SnowflakeProxy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SnowflakeProxySpec   `json:"spec,omitempty"`
+	Status SnowflakeProxyStatus `json:"status,omitempty"`
+}
+
+type SnowflakeProxySpec struct {
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=0
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// BrokerURL the proxies poll for clients, the Tor project broker by
+	// default.
+	BrokerURL string `json:"brokerURL,omitempty"`
+	// STUNURLs used to discover the public address of the proxies.
+	STUNURLs []string `json:"stunURLs,omitempty"`
+	// RelayURL is the default WebSocket relay clients are forwarded to.
+	RelayURL string `json:"relayURL,omitempty"`
+	// Capacity is the maximum number of clients served at once by each
+	// proxy, unlimited when 0.
+	// +kubebuilder:validation:Minimum=0
+	Capacity int32 `json:"capacity,omitempty"`
+
+	NAT *SnowflakeNAT `json:"nat,omitempty"`
+
+	Image     string                      `json:"image,omitempty"`
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// SnowflakeNAT configures the NAT traversal of the proxies. Restricted
+// NATs only serve a fraction of the clients, host networking lets the
+// proxies reach the unrestricted type on nodes with a public address.
+type SnowflakeNAT struct {
+	// HostNetwork runs the proxies in the network namespace of the nodes.
+	HostNetwork bool `json:"hostNetwork,omitempty"`
+	// EphemeralPortsRange limits the UDP ports used by WebRTC, e.g.
+	// "30000:30100", to be opened in the firewalls.
+	// +kubebuilder:validation:Pattern=`^[0-9]+:[0-9]+$`
+	EphemeralPortsRange string `json:"ephemeralPortsRange,omitempty"`
+	// ProbeServer tests the NAT type of the proxies.
+	ProbeServer string `json:"probeServer,omitempty"`
+	// RetestInterval between two NAT type tests, 0 disables them.
+	RetestInterval *metav1.Duration `json:"retestInterval,omitempty"`
+}
+
+type SnowflakeProxyStatus struct {
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Replicas and ReadyReplicas of the proxy Deployment.
+	Replicas      int32 `json:"replicas,omitempty"`
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+	// Selector of the proxy pods, used by the scale subresource.
+	Selector string `json:"selector,omitempty"`
+
+	// Connections served by the running proxies since they started.
+	Connections int64 `json:"connections,omitempty"`
+	// InboundBytes and OutboundBytes relayed by the running proxies.
+	InboundBytes  int64 `json:"inboundBytes,omitempty"`
+	OutboundBytes int64 `json:"outboundBytes,omitempty"`
+	// LastScrapeTime of the proxy metrics.
+	LastScrapeTime *metav1.Time `json:"lastScrapeTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+type SnowflakeProxyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []SnowflakeProxy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SnowflakeProxy{}, &SnowflakeProxyList{})
+}
@@ -0,0 +1,93 @@
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	BridgeTransportObfs4     = "obfs4"
+	BridgeTransportWebTunnel = "webtunnel"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Nickname",type="string",JSONPath=".spec.nickname"
+// +kubebuilder:printcolumn:name="Transport",type="string",JSONPath=".spec.transport.type"
+// +kubebuilder:printcolumn:name="Address",type="string",JSONPath=".status.address"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// TorBridge runs a bridge relay serving censored users through a pluggable
+// transport.
+type TorBridge struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TorBridgeSpec   `json:"spec,omitempty"`
+	Status TorBridgeStatus `json:"status,omitempty"`
+}
+
+type TorBridgeSpec struct {
+	// TorRelaySpec configures the bridge like a relay, myFamily is ignored
+	// as it would link the bridge to public relays.
+	TorRelaySpec `json:",inline"`
+
+	// +kubebuilder:default={}
+	Transport BridgeTransport `json:"transport,omitempty"`
+	// Distribution is the BridgeDistribution method used by BridgeDB to
+	// hand out the bridge, none keeps it private.
+	// +kubebuilder:validation:Enum=any;https;email;moat;settings;telegram;none
+	// +kubebuilder:default=any
+	Distribution string `json:"distribution,omitempty"`
+}
+
+// +kubebuilder:validation:XValidation:rule="self.type != 'webtunnel' || has(self.url)",message="url is required by webtunnel"
+type BridgeTransport struct {
+	// +kubebuilder:validation:Enum=obfs4;webtunnel
+	// +kubebuilder:default=obfs4
+	Type string `json:"type,omitempty"`
+	// Port the transport listens on. webtunnel serves plain HTTP on it,
+	// behind the web server of url.
+	// +kubebuilder:default=9002
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port,omitempty"`
+	// Binary of the transport executed by tor, lyrebird for obfs4 and
+	// webtunnel for webtunnel in /usr/bin by default. It is an absolute
+	// path.
+	// +kubebuilder:validation:MaxLength=4096
+	// +kubebuilder:validation:Pattern=`^(/[-A-Za-z0-9._+]+)+$`
+	Binary string `json:"binary,omitempty"`
+	// Image providing Binary. It is copied into the tor container by an
+	// init container, the binary is expected in the tor image otherwise.
+	Image string `json:"image,omitempty"`
+	// URL of the HTTPS endpoint forwarding to the webtunnel port.
+	// +kubebuilder:validation:MaxLength=2048
+	// +kubebuilder:validation:Pattern=`^https://[^\x00-\x20\x7f"\\]+$`
+	URL string `json:"url,omitempty"`
+}
+
+type TorBridgeStatus struct {
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Fingerprint of the bridge identity key.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Address the transport is reachable at.
+	Address string `json:"address,omitempty"`
+	// BridgeLine to give to clients, e.g. in the Bridge torrc option.
+	BridgeLine string `json:"bridgeLine,omitempty"`
+	// SecretName holds the bridge line in its bridgeline key.
+	SecretName string `json:"secretName,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+type TorBridgeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []TorBridge `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TorBridge{}, &TorBridgeList{})
+}
@@ -0,0 +1,83 @@
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// EgressModeGateway points the selected pods to the TorProxy Service.
+	EgressModeGateway = "Gateway"
+	// EgressModeSidecar injects a transparent tor sidecar in the selected
+	// pods, TorProxy settings are not used.
+	EgressModeSidecar = "Sidecar"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Mode",type="string",JSONPath=".spec.mode"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Selected",type="integer",JSONPath=".status.selectedPods"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// TorEgressPolicy forces the egress of the selected pods of its namespace
+// through Tor, blocking direct connections with a NetworkPolicy.
+type TorEgressPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TorEgressPolicySpec   `json:"spec,omitempty"`
+	Status TorEgressPolicyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:validation:XValidation:rule="self.mode != 'Gateway' || has(self.torProxy)",message="torProxy is required in Gateway mode"
+type TorEgressPolicySpec struct {
+	// PodSelector selects the pods of the namespace the policy applies to,
+	// an empty selector selects all of them.
+	PodSelector metav1.LabelSelector `json:"podSelector"`
+
+	// Mode is Gateway to egress through TorProxy, or Sidecar to egress
+	// through a tor sidecar running in transparent mode.
+	// +kubebuilder:validation:Enum=Gateway;Sidecar
+	// +kubebuilder:default=Gateway
+	Mode string `json:"mode,omitempty"`
+
+	// TorProxy the pods egress through in Gateway mode.
+	// +optional
+	TorProxy *TorProxyReference `json:"torProxy,omitempty"`
+}
+
+type TorProxyReference struct {
+	Name string `json:"name"`
+	// Namespace of the TorProxy, defaults to the policy namespace.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type TorEgressPolicyStatus struct {
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message,omitempty"`
+	// NetworkPolicyName is the NetworkPolicy enforcing the policy.
+	NetworkPolicyName string `json:"networkPolicyName,omitempty"`
+	// SelectedPods is the number of pods matching the PodSelector.
+	SelectedPods int32 `json:"selectedPods,omitempty"`
+	// NonCompliantPods are the selected pods not configured to egress
+	// through Tor, usually because they were created before the policy.
+	// Their direct egress is blocked all the same.
+	NonCompliantPods []NonCompliantPod `json:"nonCompliantPods,omitempty"`
+}
+
+type NonCompliantPod struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// +kubebuilder:object:root=true
+
+type TorEgressPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []TorEgressPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TorEgressPolicy{}, &TorEgressPolicyList{})
+}
@@ -0,0 +1,93 @@
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ExitPolicyPresetReduced is the reduced exit policy of the Tor
+	// project, allowing common services while avoiding most abuse.
+	ExitPolicyPresetReduced = "Reduced"
+	// ExitPolicyPresetWeb only allows HTTP and HTTPS.
+	ExitPolicyPresetWeb = "Web"
+	// ExitPolicyPresetDefault is the default exit policy of tor.
+	ExitPolicyPresetDefault = "Default"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Nickname",type="string",JSONPath=".spec.nickname"
+// +kubebuilder:printcolumn:name="Fingerprint",type="string",JSONPath=".status.fingerprint"
+// +kubebuilder:printcolumn:name="Address",type="string",JSONPath=".status.address"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// TorExitRelay runs an exit relay of the public Tor network.
+type TorExitRelay struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TorExitRelaySpec `json:"spec,omitempty"`
+	Status TorRelayStatus   `json:"status,omitempty"`
+}
+
+type TorExitRelaySpec struct {
+	TorRelaySpec `json:",inline"`
+
+	// AbuseContact is published in ContactInfo, exit operators must be
+	// reachable when abuse complaints come in.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=1024
+	// +kubebuilder:validation:Pattern=`^[^\x00-\x1f\x7f]*$`
+	AbuseContact string `json:"abuseContact"`
+
+	ExitPolicy ExitPolicy `json:"exitPolicy,omitempty"`
+	// IPv6Exit allows exiting to IPv6 destinations.
+	IPv6Exit bool `json:"ipv6Exit,omitempty"`
+
+	DNS *ExitDNS `json:"dns,omitempty"`
+}
+
+type ExitPolicy struct {
+	// Rules are evaluated in order before the preset, the first matching
+	// rule wins.
+	Rules []ExitPolicyRule `json:"rules,omitempty"`
+	// Preset completes the rules. Everything not accepted is rejected when
+	// no preset is set.
+	// +kubebuilder:validation:Enum=Reduced;Web;Default
+	Preset string `json:"preset,omitempty"`
+}
+
+type ExitPolicyRule struct {
+	// +kubebuilder:validation:Enum=accept;reject
+	Action string `json:"action"`
+	// Address is *, *4, *6, an address or a CIDR, IPv6 ones between
+	// brackets.
+	// +kubebuilder:default="*"
+	Address string `json:"address,omitempty"`
+	// Ports is *, a port or a range, e.g. 1-1024.
+	// +kubebuilder:default="*"
+	Ports string `json:"ports,omitempty"`
+}
+
+type ExitDNS struct {
+	// Nameservers resolve the exit traffic instead of the pod resolver,
+	// ideally a local caching resolver.
+	Nameservers []string `json:"nameservers,omitempty"`
+	// DetectHijacking makes tor check that the nameservers do not hijack
+	// failing requests.
+	// +kubebuilder:default=true
+	DetectHijacking *bool `json:"detectHijacking,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+type TorExitRelayList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []TorExitRelay `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TorExitRelay{}, &TorExitRelayList{})
+}
@@ -0,0 +1,67 @@
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Authorities",type="integer",JSONPath=".spec.authorities"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.readyNodes"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// TorNetwork runs a private Tor network (TestingTorNetwork) with its own
+// directory authorities, relays and exits. OnionServices and TorProxies
+// referencing it use it instead of the public Tor network, e.g. in CI.
+type TorNetwork struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TorNetworkSpec   `json:"spec,omitempty"`
+	Status TorNetworkStatus `json:"status,omitempty"`
+}
+
+type TorNetworkSpec struct {
+	// Authorities is the number of directory authorities.
+	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=9
+	Authorities int32 `json:"authorities,omitempty"`
+	// Relays is the number of non-exit relays.
+	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=0
+	Relays int32 `json:"relays,omitempty"`
+	// Exits is the number of exit relays, they exit to everything,
+	// including the private addresses of the cluster network.
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=0
+	Exits int32 `json:"exits,omitempty"`
+
+	Image     string                      `json:"image,omitempty"`
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+type TorNetworkStatus struct {
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message,omitempty"`
+	// DirAuthorities are the DirAuthority lines of the network, clients
+	// need them along with TestingTorNetwork 1.
+	DirAuthorities []string `json:"dirAuthorities,omitempty"`
+	// Nodes and ReadyNodes of the network, authorities included.
+	Nodes      int32 `json:"nodes,omitempty"`
+	ReadyNodes int32 `json:"readyNodes,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+type TorNetworkList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []TorNetwork `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TorNetwork{}, &TorNetworkList{})
+}
@@ -0,0 +1,103 @@
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas,selectorpath=.status.selector
+// +kubebuilder:printcolumn:name="Replicas",type="integer",JSONPath=".status.readyReplicas"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// TorProxy runs client-only tor instances behind a Service, letting
+// workloads route their outbound traffic through the Tor network.
+type TorProxy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TorProxySpec   `json:"spec,omitempty"`
+	Status TorProxyStatus `json:"status,omitempty"`
+}
+
+type TorProxySpec struct {
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=0
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// SOCKS is the SOCKS5 listener, it defaults to port 9050.
+	SOCKS *TorProxyListener `json:"socks,omitempty"`
+	// HTTPTunnel is the HTTP CONNECT listener (HTTPTunnelPort).
+	HTTPTunnel *TorProxyListener `json:"httpTunnel,omitempty"`
+	// DNS resolves names through Tor (DNSPort), A and AAAA only.
+	DNS *TorProxyListener `json:"dns,omitempty"`
+
+	// Policy restricts the clients allowed to use the listeners, the first
+	// matching rule wins. Everything is accepted when empty.
+	Policy []TorPolicyRule `json:"policy,omitempty"`
+
+	// Isolation flags applied to every listener, streams differing by the
+	// given properties never share a circuit.
+	IsolationFlags []IsolationFlag `json:"isolationFlags,omitempty"`
+
+	// ExitCountries are the ISO 3166 country codes exits are picked from.
+	// +kubebuilder:validation:items:Pattern=`^[A-Za-z]{2}$`
+	ExitCountries []string `json:"exitCountries,omitempty"`
+	// ExcludeExitCountries are never used as exits.
+	// +kubebuilder:validation:items:Pattern=`^[A-Za-z]{2}$`
+	ExcludeExitCountries []string `json:"excludeExitCountries,omitempty"`
+	// StrictNodes fails circuits instead of falling back to exits outside
+	// of ExitCountries.
+	StrictNodes bool `json:"strictNodes,omitempty"`
+
+	// TorNetwork is the name of a TorNetwork of the namespace used instead
+	// of the public Tor network.
+	TorNetwork string `json:"torNetwork,omitempty"`
+
+	Image     string                      `json:"image,omitempty"`
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+type TorProxyListener struct {
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+}
+
+type TorPolicyRule struct {
+	// +kubebuilder:validation:Enum=accept;reject
+	Action string `json:"action"`
+	// Address is an IPv4/IPv6 address or CIDR, IPv6 ones between
+	// brackets, or *, *4 or *6 for every address.
+	// +kubebuilder:validation:Pattern=`^(\*[46]?|[0-9]{1,3}(\.[0-9]{1,3}){3}(/[0-9]{1,2})?|\[[0-9A-Fa-f:.]+\](/[0-9]{1,3})?)$`
+	Address string `json:"address"`
+}
+
+// +kubebuilder:validation:Enum=IsolateClientAddr;IsolateSOCKSAuth;IsolateClientProtocol;IsolateDestPort;IsolateDestAddr
+type IsolationFlag string
+
+type TorProxyStatus struct {
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Replicas and ReadyReplicas of the tor Deployment.
+	Replicas      int32 `json:"replicas,omitempty"`
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+	// Selector of the tor pods, used by the scale subresource.
+	Selector string `json:"selector,omitempty"`
+	// ServiceName is the Service exposing the listeners.
+	ServiceName string `json:"serviceName,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+type TorProxyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []TorProxy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TorProxy{}, &TorProxyList{})
+}
@@ -0,0 +1,118 @@
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// RelayExposeLoadBalancer publishes the ORPort through a LoadBalancer
+	// Service, its address is advertised by the relay.
+	RelayExposeLoadBalancer = "LoadBalancer"
+	// RelayExposeHostPort binds the ORPort on the node running the relay.
+	RelayExposeHostPort = "HostPort"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Nickname",type="string",JSONPath=".spec.nickname"
+// +kubebuilder:printcolumn:name="Fingerprint",type="string",JSONPath=".status.fingerprint"
+// +kubebuilder:printcolumn:name="Address",type="string",JSONPath=".status.address"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// TorRelay runs a non-exit (middle or guard) relay of the public Tor
+// network.
+type TorRelay struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TorRelaySpec   `json:"spec,omitempty"`
+	Status TorRelayStatus `json:"status,omitempty"`
+}
+
+type TorRelaySpec struct {
+	// Nickname of the relay, up to 19 alphanumeric characters.
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9]{1,19}$`
+	Nickname string `json:"nickname"`
+	// ContactInfo is published in the relay descriptor so that the
+	// operator can be reached, e.g. an email address.
+	// +kubebuilder:validation:MaxLength=1024
+	// +kubebuilder:validation:Pattern=`^[^\x00-\x1f\x7f]*$`
+	ContactInfo string `json:"contactInfo,omitempty"`
+
+	// +kubebuilder:default=9001
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	ORPort int32 `json:"orPort,omitempty"`
+	// Address advertised by the relay, a hostname or an IP address. It
+	// defaults to the LoadBalancer address, tor guesses it otherwise.
+	// +kubebuilder:validation:MaxLength=255
+	// +kubebuilder:validation:Pattern=`^([A-Za-z0-9]([-A-Za-z0-9.]*[A-Za-z0-9])?|[0-9A-Fa-f:.]+|\[[0-9A-Fa-f:.]+\])$`
+	Address string `json:"address,omitempty"`
+	// Expose is LoadBalancer or HostPort.
+	// +kubebuilder:validation:Enum=LoadBalancer;HostPort
+	// +kubebuilder:default=LoadBalancer
+	Expose string `json:"expose,omitempty"`
+
+	Bandwidth  *RelayBandwidth  `json:"bandwidth,omitempty"`
+	Accounting *RelayAccounting `json:"accounting,omitempty"`
+	// MyFamily lists the fingerprints of the other relays run by the same
+	// operator, clients never use two of them in the same circuit.
+	// +kubebuilder:validation:items:Pattern=`^\$?[0-9A-Fa-f]{40}$`
+	MyFamily []string `json:"myFamily,omitempty"`
+
+	// Storage is the size of the volume keeping the identity keys.
+	// +kubebuilder:default="100Mi"
+	Storage *resource.Quantity `json:"storage,omitempty"`
+
+	Image     string                      `json:"image,omitempty"`
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+type RelayBandwidth struct {
+	// Rate is the average bandwidth allowed, e.g. "5 MBytes"
+	// (RelayBandwidthRate).
+	// +kubebuilder:validation:Pattern=`^[0-9]+ ?[a-zA-Z]*$`
+	Rate string `json:"rate"`
+	// Burst is the peak bandwidth allowed (RelayBandwidthBurst).
+	// +kubebuilder:validation:Pattern=`^[0-9]+ ?[a-zA-Z]*$`
+	Burst string `json:"burst,omitempty"`
+}
+
+type RelayAccounting struct {
+	// Max is the traffic allowed per period, e.g. "500 GBytes", the relay
+	// hibernates once it is reached.
+	// +kubebuilder:validation:Pattern=`^[0-9]+ ?[a-zA-Z]*$`
+	Max string `json:"max"`
+	// Start of the period, e.g. "month 1 00:00", "week 1 00:00" or
+	// "day 00:00".
+	// +kubebuilder:validation:Pattern=`^(month [0-9]{1,2}|week [1-7]|day) [0-9]{2}:[0-9]{2}$`
+	Start string `json:"start,omitempty"`
+	// Rule counts the traffic: sum of both directions, in, out or the max
+	// of the two.
+	// +kubebuilder:validation:Enum=sum;in;out;max
+	Rule string `json:"rule,omitempty"`
+}
+
+type TorRelayStatus struct {
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Fingerprint of the relay identity key.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Address the ORPort is reachable at.
+	Address string `json:"address,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+type TorRelayList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []TorRelay `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TorRelay{}, &TorRelayList{})
+}
@@ -21,11 +21,1357 @@ limitations under the License.
 package v1beta1
 
 import (
+	"k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AddressExport) DeepCopyInto(out *AddressExport) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AddressExport.
+func (in *AddressExport) DeepCopy() *AddressExport {
+	if in == nil {
+		return nil
+	}
+	out := new(AddressExport)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackendHealthCheck) DeepCopyInto(out *BackendHealthCheck) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackendHealthCheck.
+func (in *BackendHealthCheck) DeepCopy() *BackendHealthCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(BackendHealthCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupStorage) DeepCopyInto(out *BackupStorage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupStorage.
+func (in *BackupStorage) DeepCopy() *BackupStorage {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupStorage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BridgeTransport) DeepCopyInto(out *BridgeTransport) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BridgeTransport.
+func (in *BridgeTransport) DeepCopy() *BridgeTransport {
+	if in == nil {
+		return nil
+	}
+	out := new(BridgeTransport)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExitDNS) DeepCopyInto(out *ExitDNS) {
+	*out = *in
+	if in.Nameservers != nil {
+		in, out := &in.Nameservers, &out.Nameservers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DetectHijacking != nil {
+		in, out := &in.DetectHijacking, &out.DetectHijacking
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExitDNS.
+func (in *ExitDNS) DeepCopy() *ExitDNS {
+	if in == nil {
+		return nil
+	}
+	out := new(ExitDNS)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExitPolicy) DeepCopyInto(out *ExitPolicy) {
+	*out = *in
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]ExitPolicyRule, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExitPolicy.
+func (in *ExitPolicy) DeepCopy() *ExitPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(ExitPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExitPolicyRule) DeepCopyInto(out *ExitPolicyRule) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExitPolicyRule.
+func (in *ExitPolicyRule) DeepCopy() *ExitPolicyRule {
+	if in == nil {
+		return nil
+	}
+	out := new(ExitPolicyRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HighAvailabilitySpec) DeepCopyInto(out *HighAvailabilitySpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HighAvailabilitySpec.
+func (in *HighAvailabilitySpec) DeepCopy() *HighAvailabilitySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HighAvailabilitySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyRotation) DeepCopyInto(out *KeyRotation) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyRotation.
+func (in *KeyRotation) DeepCopy() *KeyRotation {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyRotation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyRotationStatus) DeepCopyInto(out *KeyRotationStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.RetireTime != nil {
+		in, out := &in.RetireTime, &out.RetireTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyRotationStatus.
+func (in *KeyRotationStatus) DeepCopy() *KeyRotationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyRotationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NonCompliantPod) DeepCopyInto(out *NonCompliantPod) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NonCompliantPod.
+func (in *NonCompliantPod) DeepCopy() *NonCompliantPod {
+	if in == nil {
+		return nil
+	}
+	out := new(NonCompliantPod)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionBackendStatus) DeepCopyInto(out *OnionBackendStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionBackendStatus.
+func (in *OnionBackendStatus) DeepCopy() *OnionBackendStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionBackendStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionBackup) DeepCopyInto(out *OnionBackup) {
+	*out = *in
+	out.Storage = in.Storage
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionBackup.
+func (in *OnionBackup) DeepCopy() *OnionBackup {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionBackup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionBackupStatus) DeepCopyInto(out *OnionBackupStatus) {
+	*out = *in
+	if in.LastBackupTime != nil {
+		in, out := &in.LastBackupTime, &out.LastBackupTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Objects != nil {
+		in, out := &in.Objects, &out.Objects
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionBackupStatus.
+func (in *OnionBackupStatus) DeepCopy() *OnionBackupStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionBackupStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionKeyGeneration) DeepCopyInto(out *OnionKeyGeneration) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionKeyGeneration.
+func (in *OnionKeyGeneration) DeepCopy() *OnionKeyGeneration {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionKeyGeneration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *OnionKeyGeneration) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionKeyGenerationList) DeepCopyInto(out *OnionKeyGenerationList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]OnionKeyGeneration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionKeyGenerationList.
+func (in *OnionKeyGenerationList) DeepCopy() *OnionKeyGenerationList {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionKeyGenerationList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *OnionKeyGenerationList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionKeyGenerationSpec) DeepCopyInto(out *OnionKeyGenerationSpec) {
+	*out = *in
+	if in.TimeoutSeconds != nil {
+		in, out := &in.TimeoutSeconds, &out.TimeoutSeconds
+		*out = new(int64)
+		**out = **in
+	}
+	in.Resources.DeepCopyInto(&out.Resources)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionKeyGenerationSpec.
+func (in *OnionKeyGenerationSpec) DeepCopy() *OnionKeyGenerationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionKeyGenerationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionKeyGenerationStatus) DeepCopyInto(out *OnionKeyGenerationStatus) {
+	*out = *in
+	if in.EstimatedCompletionTime != nil {
+		in, out := &in.EstimatedCompletionTime, &out.EstimatedCompletionTime
+		*out = (*in).DeepCopy()
+	}
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionKeyGenerationStatus.
+func (in *OnionKeyGenerationStatus) DeepCopy() *OnionKeyGenerationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionKeyGenerationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionMetrics) DeepCopyInto(out *OnionMetrics) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionMetrics.
+func (in *OnionMetrics) DeepCopy() *OnionMetrics {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionMetrics)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionNetworkPolicy) DeepCopyInto(out *OnionNetworkPolicy) {
+	*out = *in
+	if in.SOCKSFrom != nil {
+		in, out := &in.SOCKSFrom, &out.SOCKSFrom
+		*out = make([]v1.NetworkPolicyPeer, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionNetworkPolicy.
+func (in *OnionNetworkPolicy) DeepCopy() *OnionNetworkPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionNetworkPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionPortHealth) DeepCopyInto(out *OnionPortHealth) {
+	*out = *in
+	if in.LastCheckTime != nil {
+		in, out := &in.LastCheckTime, &out.LastCheckTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionPortHealth.
+func (in *OnionPortHealth) DeepCopy() *OnionPortHealth {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionPortHealth)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionProbes) DeepCopyInto(out *OnionProbes) {
+	*out = *in
+	if in.Readiness != nil {
+		in, out := &in.Readiness, &out.Readiness
+		*out = new(OnionReadinessProbe)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Liveness != nil {
+		in, out := &in.Liveness, &out.Liveness
+		*out = new(ProbeThresholds)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionProbes.
+func (in *OnionProbes) DeepCopy() *OnionProbes {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionProbes)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionReadinessProbe) DeepCopyInto(out *OnionReadinessProbe) {
+	*out = *in
+	out.ProbeThresholds = in.ProbeThresholds
+	if in.Descriptor != nil {
+		in, out := &in.Descriptor, &out.Descriptor
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionReadinessProbe.
+func (in *OnionReadinessProbe) DeepCopy() *OnionReadinessProbe {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionReadinessProbe)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionRestore) DeepCopyInto(out *OnionRestore) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionRestore.
+func (in *OnionRestore) DeepCopy() *OnionRestore {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionRestore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *OnionRestore) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionRestoreList) DeepCopyInto(out *OnionRestoreList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]OnionRestore, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionRestoreList.
+func (in *OnionRestoreList) DeepCopy() *OnionRestoreList {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionRestoreList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *OnionRestoreList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionRestoreSpec) DeepCopyInto(out *OnionRestoreSpec) {
+	*out = *in
+	out.Storage = in.Storage
+	out.Source = in.Source
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionRestoreSpec.
+func (in *OnionRestoreSpec) DeepCopy() *OnionRestoreSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionRestoreSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionRestoreStatus) DeepCopyInto(out *OnionRestoreStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionRestoreStatus.
+func (in *OnionRestoreStatus) DeepCopy() *OnionRestoreStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionRestoreStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OnionService) DeepCopyInto(out *OnionService) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionService.
+func (in *OnionService) DeepCopy() *OnionService {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionService)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *OnionService) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionServiceBackend) DeepCopyInto(out *OnionServiceBackend) {
+	*out = *in
+	if in.ServiceRef != nil {
+		in, out := &in.ServiceRef, &out.ServiceRef
+		*out = new(ServiceReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionServiceBackend.
+func (in *OnionServiceBackend) DeepCopy() *OnionServiceBackend {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionServiceBackend)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionServiceList) DeepCopyInto(out *OnionServiceList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]OnionService, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionServiceList.
+func (in *OnionServiceList) DeepCopy() *OnionServiceList {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionServiceList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *OnionServiceList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionServicePort) DeepCopyInto(out *OnionServicePort) {
+	*out = *in
+	out.TargetPort = in.TargetPort
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionServicePort.
+func (in *OnionServicePort) DeepCopy() *OnionServicePort {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionServicePort)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionServiceSpec) DeepCopyInto(out *OnionServiceSpec) {
+	*out = *in
+	if in.SOCKSPolicy != nil {
+		in, out := &in.SOCKSPolicy, &out.SOCKSPolicy
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Backend != nil {
+		in, out := &in.Backend, &out.Backend
+		*out = new(OnionServiceBackend)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]OnionServicePort, len(*in))
+		copy(*out, *in)
+	}
+	if in.HighAvailability != nil {
+		in, out := &in.HighAvailability, &out.HighAvailability
+		*out = new(HighAvailabilitySpec)
+		**out = **in
+	}
+	if in.NetworkPolicy != nil {
+		in, out := &in.NetworkPolicy, &out.NetworkPolicy
+		*out = new(OnionNetworkPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Metrics != nil {
+		in, out := &in.Metrics, &out.Metrics
+		*out = new(OnionMetrics)
+		**out = **in
+	}
+	if in.Probes != nil {
+		in, out := &in.Probes, &out.Probes
+		*out = new(OnionProbes)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HealthCheck != nil {
+		in, out := &in.HealthCheck, &out.HealthCheck
+		*out = new(BackendHealthCheck)
+		**out = **in
+	}
+	if in.AddressExport != nil {
+		in, out := &in.AddressExport, &out.AddressExport
+		*out = new(AddressExport)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.KeyRotation != nil {
+		in, out := &in.KeyRotation, &out.KeyRotation
+		*out = new(KeyRotation)
+		**out = **in
+	}
+	if in.Backup != nil {
+		in, out := &in.Backup, &out.Backup
+		*out = new(OnionBackup)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionServiceSpec.
+func (in *OnionServiceSpec) DeepCopy() *OnionServiceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionServiceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionServiceStatus) DeepCopyInto(out *OnionServiceStatus) {
+	*out = *in
+	if in.ReadyTime != nil {
+		in, out := &in.ReadyTime, &out.ReadyTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Backends != nil {
+		in, out := &in.Backends, &out.Backends
+		*out = make([]OnionBackendStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.BackendHealth != nil {
+		in, out := &in.BackendHealth, &out.BackendHealth
+		*out = make([]OnionPortHealth, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Backup != nil {
+		in, out := &in.Backup, &out.Backup
+		*out = new(OnionBackupStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.KeyRotation != nil {
+		in, out := &in.KeyRotation, &out.KeyRotation
+		*out = new(KeyRotationStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionServiceStatus.
+func (in *OnionServiceStatus) DeepCopy() *OnionServiceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionServiceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProbeThresholds) DeepCopyInto(out *ProbeThresholds) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProbeThresholds.
+func (in *ProbeThresholds) DeepCopy() *ProbeThresholds {
+	if in == nil {
+		return nil
+	}
+	out := new(ProbeThresholds)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RelayAccounting) DeepCopyInto(out *RelayAccounting) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RelayAccounting.
+func (in *RelayAccounting) DeepCopy() *RelayAccounting {
+	if in == nil {
+		return nil
+	}
+	out := new(RelayAccounting)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RelayBandwidth) DeepCopyInto(out *RelayBandwidth) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RelayBandwidth.
+func (in *RelayBandwidth) DeepCopy() *RelayBandwidth {
+	if in == nil {
+		return nil
+	}
+	out := new(RelayBandwidth)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RestoreSource) DeepCopyInto(out *RestoreSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RestoreSource.
+func (in *RestoreSource) DeepCopy() *RestoreSource {
+	if in == nil {
+		return nil
+	}
+	out := new(RestoreSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceReference) DeepCopyInto(out *ServiceReference) {
+	*out = *in
+	out.Port = in.Port
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceReference.
+func (in *ServiceReference) DeepCopy() *ServiceReference {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnowflakeNAT) DeepCopyInto(out *SnowflakeNAT) {
+	*out = *in
+	if in.RetestInterval != nil {
+		in, out := &in.RetestInterval, &out.RetestInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnowflakeNAT.
+func (in *SnowflakeNAT) DeepCopy() *SnowflakeNAT {
+	if in == nil {
+		return nil
+	}
+	out := new(SnowflakeNAT)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnowflakeProxy) DeepCopyInto(out *SnowflakeProxy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnowflakeProxy.
+func (in *SnowflakeProxy) DeepCopy() *SnowflakeProxy {
+	if in == nil {
+		return nil
+	}
+	out := new(SnowflakeProxy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *SnowflakeProxy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnowflakeProxyList) DeepCopyInto(out *SnowflakeProxyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]SnowflakeProxy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnowflakeProxyList.
+func (in *SnowflakeProxyList) DeepCopy() *SnowflakeProxyList {
+	if in == nil {
+		return nil
+	}
+	out := new(SnowflakeProxyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *SnowflakeProxyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnowflakeProxySpec) DeepCopyInto(out *SnowflakeProxySpec) {
+	*out = *in
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.STUNURLs != nil {
+		in, out := &in.STUNURLs, &out.STUNURLs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NAT != nil {
+		in, out := &in.NAT, &out.NAT
+		*out = new(SnowflakeNAT)
+		(*in).DeepCopyInto(*out)
+	}
+	in.Resources.DeepCopyInto(&out.Resources)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnowflakeProxySpec.
+func (in *SnowflakeProxySpec) DeepCopy() *SnowflakeProxySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SnowflakeProxySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnowflakeProxyStatus) DeepCopyInto(out *SnowflakeProxyStatus) {
+	*out = *in
+	if in.LastScrapeTime != nil {
+		in, out := &in.LastScrapeTime, &out.LastScrapeTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnowflakeProxyStatus.
+func (in *SnowflakeProxyStatus) DeepCopy() *SnowflakeProxyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SnowflakeProxyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorBridge) DeepCopyInto(out *TorBridge) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorBridge.
+func (in *TorBridge) DeepCopy() *TorBridge {
+	if in == nil {
+		return nil
+	}
+	out := new(TorBridge)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TorBridge) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorBridgeList) DeepCopyInto(out *TorBridgeList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]TorBridge, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorBridgeList.
+func (in *TorBridgeList) DeepCopy() *TorBridgeList {
+	if in == nil {
+		return nil
+	}
+	out := new(TorBridgeList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TorBridgeList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorBridgeSpec) DeepCopyInto(out *TorBridgeSpec) {
+	*out = *in
+	in.TorRelaySpec.DeepCopyInto(&out.TorRelaySpec)
+	out.Transport = in.Transport
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorBridgeSpec.
+func (in *TorBridgeSpec) DeepCopy() *TorBridgeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TorBridgeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorBridgeStatus) DeepCopyInto(out *TorBridgeStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorBridgeStatus.
+func (in *TorBridgeStatus) DeepCopy() *TorBridgeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TorBridgeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorEgressPolicy) DeepCopyInto(out *TorEgressPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorEgressPolicy.
+func (in *TorEgressPolicy) DeepCopy() *TorEgressPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(TorEgressPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TorEgressPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorEgressPolicyList) DeepCopyInto(out *TorEgressPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]TorEgressPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorEgressPolicyList.
+func (in *TorEgressPolicyList) DeepCopy() *TorEgressPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(TorEgressPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TorEgressPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorEgressPolicySpec) DeepCopyInto(out *TorEgressPolicySpec) {
+	*out = *in
+	in.PodSelector.DeepCopyInto(&out.PodSelector)
+	if in.TorProxy != nil {
+		in, out := &in.TorProxy, &out.TorProxy
+		*out = new(TorProxyReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorEgressPolicySpec.
+func (in *TorEgressPolicySpec) DeepCopy() *TorEgressPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TorEgressPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorEgressPolicyStatus) DeepCopyInto(out *TorEgressPolicyStatus) {
+	*out = *in
+	if in.NonCompliantPods != nil {
+		in, out := &in.NonCompliantPods, &out.NonCompliantPods
+		*out = make([]NonCompliantPod, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorEgressPolicyStatus.
+func (in *TorEgressPolicyStatus) DeepCopy() *TorEgressPolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TorEgressPolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorExitRelay) DeepCopyInto(out *TorExitRelay) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorExitRelay.
+func (in *TorExitRelay) DeepCopy() *TorExitRelay {
+	if in == nil {
+		return nil
+	}
+	out := new(TorExitRelay)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TorExitRelay) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorExitRelayList) DeepCopyInto(out *TorExitRelayList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]TorExitRelay, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorExitRelayList.
+func (in *TorExitRelayList) DeepCopy() *TorExitRelayList {
+	if in == nil {
+		return nil
+	}
+	out := new(TorExitRelayList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TorExitRelayList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorExitRelaySpec) DeepCopyInto(out *TorExitRelaySpec) {
+	*out = *in
+	in.TorRelaySpec.DeepCopyInto(&out.TorRelaySpec)
+	in.ExitPolicy.DeepCopyInto(&out.ExitPolicy)
+	if in.DNS != nil {
+		in, out := &in.DNS, &out.DNS
+		*out = new(ExitDNS)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorExitRelaySpec.
+func (in *TorExitRelaySpec) DeepCopy() *TorExitRelaySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TorExitRelaySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorNetwork) DeepCopyInto(out *TorNetwork) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorNetwork.
+func (in *TorNetwork) DeepCopy() *TorNetwork {
+	if in == nil {
+		return nil
+	}
+	out := new(TorNetwork)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TorNetwork) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorNetworkList) DeepCopyInto(out *TorNetworkList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]TorNetwork, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorNetworkList.
+func (in *TorNetworkList) DeepCopy() *TorNetworkList {
+	if in == nil {
+		return nil
+	}
+	out := new(TorNetworkList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TorNetworkList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorNetworkSpec) DeepCopyInto(out *TorNetworkSpec) {
+	*out = *in
+	in.Resources.DeepCopyInto(&out.Resources)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorNetworkSpec.
+func (in *TorNetworkSpec) DeepCopy() *TorNetworkSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TorNetworkSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorNetworkStatus) DeepCopyInto(out *TorNetworkStatus) {
+	*out = *in
+	if in.DirAuthorities != nil {
+		in, out := &in.DirAuthorities, &out.DirAuthorities
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorNetworkStatus.
+func (in *TorNetworkStatus) DeepCopy() *TorNetworkStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TorNetworkStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorPolicyRule) DeepCopyInto(out *TorPolicyRule) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorPolicyRule.
+func (in *TorPolicyRule) DeepCopy() *TorPolicyRule {
+	if in == nil {
+		return nil
+	}
+	out := new(TorPolicyRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorProxy) DeepCopyInto(out *TorProxy) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
@@ -33,18 +1379,18 @@ func (in *OnionService) DeepCopyInto(out *OnionService) {
 	out.Status = in.Status
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionService.
-func (in *OnionService) DeepCopy() *OnionService {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorProxy.
+func (in *TorProxy) DeepCopy() *TorProxy {
 	if in == nil {
 		return nil
 	}
-	out := new(OnionService)
+	out := new(TorProxy)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *OnionService) DeepCopyObject() runtime.Object {
+func (in *TorProxy) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -52,31 +1398,31 @@ func (in *OnionService) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *OnionServiceList) DeepCopyInto(out *OnionServiceList) {
+func (in *TorProxyList) DeepCopyInto(out *TorProxyList) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ListMeta.DeepCopyInto(&out.ListMeta)
 	if in.Items != nil {
 		in, out := &in.Items, &out.Items
-		*out = make([]OnionService, len(*in))
+		*out = make([]TorProxy, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionServiceList.
-func (in *OnionServiceList) DeepCopy() *OnionServiceList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorProxyList.
+func (in *TorProxyList) DeepCopy() *TorProxyList {
 	if in == nil {
 		return nil
 	}
-	out := new(OnionServiceList)
+	out := new(TorProxyList)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *OnionServiceList) DeepCopyObject() runtime.Object {
+func (in *TorProxyList) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -84,36 +1430,212 @@ func (in *OnionServiceList) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *OnionServiceSpec) DeepCopyInto(out *OnionServiceSpec) {
+func (in *TorProxyListener) DeepCopyInto(out *TorProxyListener) {
 	*out = *in
-	if in.SOCKSPolicy != nil {
-		in, out := &in.SOCKSPolicy, &out.SOCKSPolicy
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorProxyListener.
+func (in *TorProxyListener) DeepCopy() *TorProxyListener {
+	if in == nil {
+		return nil
+	}
+	out := new(TorProxyListener)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorProxyReference) DeepCopyInto(out *TorProxyReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorProxyReference.
+func (in *TorProxyReference) DeepCopy() *TorProxyReference {
+	if in == nil {
+		return nil
+	}
+	out := new(TorProxyReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorProxySpec) DeepCopyInto(out *TorProxySpec) {
+	*out = *in
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.SOCKS != nil {
+		in, out := &in.SOCKS, &out.SOCKS
+		*out = new(TorProxyListener)
+		**out = **in
+	}
+	if in.HTTPTunnel != nil {
+		in, out := &in.HTTPTunnel, &out.HTTPTunnel
+		*out = new(TorProxyListener)
+		**out = **in
+	}
+	if in.DNS != nil {
+		in, out := &in.DNS, &out.DNS
+		*out = new(TorProxyListener)
+		**out = **in
+	}
+	if in.Policy != nil {
+		in, out := &in.Policy, &out.Policy
+		*out = make([]TorPolicyRule, len(*in))
+		copy(*out, *in)
+	}
+	if in.IsolationFlags != nil {
+		in, out := &in.IsolationFlags, &out.IsolationFlags
+		*out = make([]IsolationFlag, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExitCountries != nil {
+		in, out := &in.ExitCountries, &out.ExitCountries
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExcludeExitCountries != nil {
+		in, out := &in.ExcludeExitCountries, &out.ExcludeExitCountries
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	in.Resources.DeepCopyInto(&out.Resources)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionServiceSpec.
-func (in *OnionServiceSpec) DeepCopy() *OnionServiceSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorProxySpec.
+func (in *TorProxySpec) DeepCopy() *TorProxySpec {
 	if in == nil {
 		return nil
 	}
-	out := new(OnionServiceSpec)
+	out := new(TorProxySpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *OnionServiceStatus) DeepCopyInto(out *OnionServiceStatus) {
+func (in *TorProxyStatus) DeepCopyInto(out *TorProxyStatus) {
 	*out = *in
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionServiceStatus.
-func (in *OnionServiceStatus) DeepCopy() *OnionServiceStatus {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorProxyStatus.
+func (in *TorProxyStatus) DeepCopy() *TorProxyStatus {
 	if in == nil {
 		return nil
 	}
-	out := new(OnionServiceStatus)
+	out := new(TorProxyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorRelay) DeepCopyInto(out *TorRelay) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorRelay.
+func (in *TorRelay) DeepCopy() *TorRelay {
+	if in == nil {
+		return nil
+	}
+	out := new(TorRelay)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TorRelay) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorRelayList) DeepCopyInto(out *TorRelayList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]TorRelay, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorRelayList.
+func (in *TorRelayList) DeepCopy() *TorRelayList {
+	if in == nil {
+		return nil
+	}
+	out := new(TorRelayList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TorRelayList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorRelaySpec) DeepCopyInto(out *TorRelaySpec) {
+	*out = *in
+	if in.Bandwidth != nil {
+		in, out := &in.Bandwidth, &out.Bandwidth
+		*out = new(RelayBandwidth)
+		**out = **in
+	}
+	if in.Accounting != nil {
+		in, out := &in.Accounting, &out.Accounting
+		*out = new(RelayAccounting)
+		**out = **in
+	}
+	if in.MyFamily != nil {
+		in, out := &in.MyFamily, &out.MyFamily
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Storage != nil {
+		in, out := &in.Storage, &out.Storage
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	in.Resources.DeepCopyInto(&out.Resources)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorRelaySpec.
+func (in *TorRelaySpec) DeepCopy() *TorRelaySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TorRelaySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorRelayStatus) DeepCopyInto(out *TorRelayStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorRelayStatus.
+func (in *TorRelayStatus) DeepCopy() *TorRelayStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TorRelayStatus)
 	in.DeepCopyInto(out)
 	return out
 }
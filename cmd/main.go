@@ -20,6 +20,7 @@ import (
 	"crypto/tls"
 	"flag"
 	"os"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -28,15 +29,32 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/kubernetes"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
 
 	torv1beta1 "github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/agent"
+	"github.com/fulviodenza/torproxy/internal/controllers/egresspolicy"
+	"github.com/fulviodenza/torproxy/internal/controllers/gateway"
+	"github.com/fulviodenza/torproxy/internal/controllers/ingress"
+	"github.com/fulviodenza/torproxy/internal/controllers/onionkeygeneration"
+	"github.com/fulviodenza/torproxy/internal/controllers/onionrestore"
 	"github.com/fulviodenza/torproxy/internal/controllers/onionservice"
+	"github.com/fulviodenza/torproxy/internal/controllers/service"
+	"github.com/fulviodenza/torproxy/internal/controllers/snowflake"
+	"github.com/fulviodenza/torproxy/internal/controllers/torbridge"
+	"github.com/fulviodenza/torproxy/internal/controllers/torexitrelay"
+	"github.com/fulviodenza/torproxy/internal/controllers/tornetwork"
+	"github.com/fulviodenza/torproxy/internal/controllers/torproxy"
+	"github.com/fulviodenza/torproxy/internal/controllers/torrelay"
+	"github.com/fulviodenza/torproxy/internal/webhook/exitrelay"
+	"github.com/fulviodenza/torproxy/internal/webhook/sidecar"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -49,6 +67,8 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(torv1beta1.AddToScheme(scheme))
+	utilruntime.Must(gatewayv1.AddToScheme(scheme))
+	utilruntime.Must(gatewayv1alpha2.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -58,6 +78,8 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var enableGatewayAPI bool
+	var clusterCIDRs string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metric endpoint binds to. "+
 		"Use the port :8080. If not set, it will be 0 in order to disable the metrics server")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
@@ -68,6 +90,24 @@ func main() {
 		"If set the metrics endpoint is served securely")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.BoolVar(&enableGatewayAPI, "enable-gateway-api", false,
+		"If set, the onion GatewayClass controller is started. "+
+			"It requires the experimental Gateway API CRDs, TCPRoute included, to be installed.")
+	flag.StringVar(&clusterCIDRs, "cluster-cidrs", "",
+		"Comma separated pod and service CIDRs reached directly by pods in transparent tor mode. "+
+			"Transparent mode is refused until they are set.")
+	flag.StringVar(&sidecar.ClusterDNS, "cluster-dns", "",
+		"The IP of the cluster DNS Service, cluster names of the pods in transparent tor mode are resolved by it.")
+	flag.StringVar(&sidecar.VirtualNetwork, "virtual-network", sidecar.VirtualNetwork,
+		"The IPv4 network tor maps .onion names to in transparent tor mode, it must not overlap --cluster-cidrs.")
+	flag.StringVar(&sidecar.DNSImage, "dns-image", sidecar.DNSImage,
+		"The image of the DNS container of the pods in transparent tor mode, /tor-dns is run.")
+	flag.StringVar(&agent.Image, "agent-image", agent.Image,
+		"The image of the tor agent sidecar of the tor pods, /tor-agent is run.")
+	flag.StringVar(&onionservice.ExporterImage, "exporter-image", onionservice.ExporterImage,
+		"The image of the tor exporter sidecar of OnionServices with metrics, /tor-exporter is run.")
+	flag.StringVar(&onionkeygeneration.Image, "vanity-image", onionkeygeneration.Image,
+		"The image of the OnionKeyGeneration search pods, /onion-vanity is run.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -125,13 +165,130 @@ func main() {
 	}
 
 	if err = (&onionservice.OnionServiceReconciler{
-		Client:     mgr.GetClient(),
-		KubeClient: kubernetes.NewForConfigOrDie(mgr.GetConfig()),
-		Scheme:     mgr.GetScheme(),
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "OnionService")
 		os.Exit(1)
 	}
+	if err = (&service.ServiceReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Service")
+		os.Exit(1)
+	}
+	if err = (&ingress.IngressReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Ingress")
+		os.Exit(1)
+	}
+	if err = (&ingress.OnionLocationReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "OnionLocation")
+		os.Exit(1)
+	}
+	if err = (&torproxy.TorProxyReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "TorProxy")
+		os.Exit(1)
+	}
+	if err = (&egresspolicy.TorEgressPolicyReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "TorEgressPolicy")
+		os.Exit(1)
+	}
+	if err = (&torrelay.TorRelayReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "TorRelay")
+		os.Exit(1)
+	}
+	if err = (&torexitrelay.TorExitRelayReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "TorExitRelay")
+		os.Exit(1)
+	}
+	if err = (&torbridge.TorBridgeReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "TorBridge")
+		os.Exit(1)
+	}
+	if err = (&snowflake.SnowflakeProxyReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "SnowflakeProxy")
+		os.Exit(1)
+	}
+	if err = (&tornetwork.TorNetworkReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "TorNetwork")
+		os.Exit(1)
+	}
+	if err = (&onionkeygeneration.OnionKeyGenerationReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "OnionKeyGeneration")
+		os.Exit(1)
+	}
+	if err = (&onionrestore.OnionRestoreReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "OnionRestore")
+		os.Exit(1)
+	}
+	if enableGatewayAPI {
+		if err = (&gateway.GatewayClassReconciler{
+			Client: mgr.GetClient(),
+			Scheme: mgr.GetScheme(),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "GatewayClass")
+			os.Exit(1)
+		}
+		if err = (&gateway.GatewayReconciler{
+			Client: mgr.GetClient(),
+			Scheme: mgr.GetScheme(),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Gateway")
+			os.Exit(1)
+		}
+	}
+	for _, cidr := range strings.Split(clusterCIDRs, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			sidecar.ClusterCIDRs = append(sidecar.ClusterCIDRs, cidr)
+		}
+	}
+	if err := sidecar.CheckTransparent(); err != nil {
+		setupLog.Info("pods in transparent tor mode will be refused", "reason", err.Error())
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		mgr.GetWebhookServer().Register(sidecar.Path, &webhook.Admission{Handler: &sidecar.Injector{
+			Client:  mgr.GetClient(),
+			Decoder: admission.NewDecoder(mgr.GetScheme()),
+		}})
+		if err = exitrelay.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "TorExitRelay")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
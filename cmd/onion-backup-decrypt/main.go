@@ -0,0 +1,86 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// onion-backup-decrypt opens an onion key backup downloaded from the
+// storage with the private key of its recipient, and writes the key files
+// tor reads from a HiddenServiceDir, without going through the cluster.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/fulviodenza/torproxy/internal/backup"
+)
+
+func main() {
+	var identityFile string
+	var outputDir string
+	flag.StringVar(&identityFile, "identity", "", "The PEM X25519 private key the backup was encrypted to.")
+	flag.StringVar(&outputDir, "output-dir", ".", "The directory the key files are written to.")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s --identity=<key.pem> [--output-dir=<dir>] <backup file or ->\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if identityFile == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	pem, err := os.ReadFile(identityFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	identity, err := backup.ParseIdentity(pem)
+	if err != nil {
+		log.Fatalf("--identity: %v", err)
+	}
+
+	var sealed []byte
+	if flag.Arg(0) == "-" {
+		sealed, err = io.ReadAll(os.Stdin)
+	} else {
+		sealed, err = os.ReadFile(flag.Arg(0))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	files, err := backup.Decrypt(identity, sealed)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.MkdirAll(outputDir, 0o700); err != nil {
+		log.Fatal(err)
+	}
+	for name, content := range files {
+		if name != filepath.Base(name) {
+			log.Fatalf("refusing to write %q outside of %s", name, outputDir)
+		}
+		path := filepath.Join(outputDir, name)
+		if err := os.WriteFile(path, content, 0o600); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("wrote %s", path)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// onion-vanity runs in the pods of an OnionKeyGeneration Job: it searches
+// an onion key whose address starts with a prefix, publishes its progress
+// to the status ConfigMap and stores the key found in the Secret.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/fulviodenza/torproxy/internal/agent"
+	"github.com/fulviodenza/torproxy/internal/onion"
+)
+
+func main() {
+	var prefix string
+	var secret string
+	var configMap string
+	var workers int
+	var interval time.Duration
+	flag.StringVar(&prefix, "prefix", "", "The prefix of the onion address to find.")
+	flag.StringVar(&secret, "secret", "", "The Secret the key found is stored in.")
+	flag.StringVar(&configMap, "configmap", "", "The status ConfigMap the progress is published to.")
+	flag.IntVar(&workers, "workers", runtime.NumCPU(), "The number of goroutines generating keys.")
+	flag.DurationVar(&interval, "interval", 10*time.Second, "How often the progress is published.")
+	flag.Parse()
+
+	if err := onion.ValidPrefix(prefix); err != nil {
+		log.Fatal(err)
+	}
+	if secret == "" || configMap == "" {
+		log.Fatal("--secret and --configmap are required")
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	client := kubernetes.NewForConfigOrDie(config)
+	namespace := os.Getenv("POD_NAMESPACE")
+	pod := os.Getenv("POD_NAME")
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	attempts := &atomic.Uint64{}
+	found := make(chan *onion.Key, 1)
+	go func() {
+		key, err := onion.Search(ctx, prefix, workers, attempts)
+		if err != nil {
+			log.Printf("search stopped: %v", err)
+		}
+		found <- key
+	}()
+
+	log.Printf("searching %s with %d workers, %.0f attempts expected", prefix, workers, onion.ExpectedAttempts(prefix))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last, lastTime := uint64(0), time.Now()
+	for {
+		select {
+		case key := <-found:
+			if key == nil {
+				os.Exit(1)
+			}
+			if err := store(ctx, client, namespace, secret, key); err != nil {
+				log.Fatalf("storing the key: %v", err)
+			}
+			log.Printf("found %s after %d attempts", key.Hostname, attempts.Load())
+			return
+
+		case now := <-ticker.C:
+			current := attempts.Load()
+			rate := float64(current-last) / now.Sub(lastTime).Seconds()
+			last, lastTime = current, now
+			if err := publish(ctx, client, namespace, configMap, pod, current, uint64(rate)); err != nil {
+				log.Printf("publishing the progress: %v", err)
+			}
+
+			// Another pod of the Job may have found a key first.
+			stored, err := client.CoreV1().Secrets(namespace).Get(ctx, secret, metav1.GetOptions{})
+			if err == nil && len(stored.Data[onion.HostnameFile]) > 0 {
+				log.Printf("%s was found by another pod", stored.Data[onion.HostnameFile])
+				return
+			}
+		}
+	}
+}
+
+// publish patches the attempts and rate of the pod into the ConfigMap.
+func publish(ctx context.Context, client kubernetes.Interface, namespace, configMap, pod string, attempts, rate uint64) error {
+	patch, err := json.Marshal(map[string]any{"data": map[string]string{
+		agent.Key(pod, onion.AttemptsKey): strconv.FormatUint(attempts, 10),
+		agent.Key(pod, onion.RateKey):     strconv.FormatUint(rate, 10),
+	}})
+	if err != nil {
+		return err
+	}
+	_, err = client.CoreV1().ConfigMaps(namespace).Patch(ctx, configMap, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// store writes the key to the Secret, unless another pod did first.
+func store(ctx context.Context, client kubernetes.Interface, namespace, name string, key *onion.Key) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if len(secret.Data[onion.HostnameFile]) > 0 {
+			log.Printf("%s was found by another pod first", secret.Data[onion.HostnameFile])
+			return nil
+		}
+
+		secret.Data = map[string][]byte{
+			onion.SecretKeyFile: key.SecretKey,
+			onion.PublicKeyFile: key.PublicKey,
+			onion.HostnameFile:  []byte(key.Hostname),
+		}
+		_, err = client.CoreV1().Secrets(namespace).Update(ctx, secret, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// tor-agent runs next to tor and publishes the files tor writes, such as
+// the onion hostname, and its bootstrap progress to a status ConfigMap
+// read by the controllers. It also backs up the onion keys, when asked.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/fulviodenza/torproxy/internal/agent"
+	"github.com/fulviodenza/torproxy/internal/backup"
+)
+
+func main() {
+	flags := &agent.Flags{}
+	if err := flags.NewFlagSet(os.Args[0], flag.ExitOnError).Parse(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+
+	if flags.ConfigMap == "" {
+		log.Fatal("--configmap is required")
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	publisher := &agent.Publisher{
+		Client:    kubernetes.NewForConfigOrDie(config),
+		Namespace: os.Getenv("POD_NAMESPACE"),
+		ConfigMap: flags.ConfigMap,
+		Pod:       os.Getenv("POD_NAME"),
+		Files:     flags.Files,
+	}
+	if flags.ControlAddress != "" {
+		publisher.Control = &agent.Control{Address: flags.ControlAddress, CookieFile: flags.CookieFile}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if flags.CheckBackends != "" {
+		if flags.CheckBackends != agent.CheckTCP && flags.CheckBackends != agent.CheckHTTP {
+			log.Fatalf("--check-backends must be %s or %s", agent.CheckTCP, agent.CheckHTTP)
+		}
+		if flags.StopAdvertising && publisher.Control == nil {
+			log.Fatal("--stop-advertising needs --control-address")
+		}
+		publisher.Checker = &agent.Checker{
+			TorrcFile:       flags.Torrc,
+			Type:            flags.CheckBackends,
+			Path:            flags.CheckPath,
+			Timeout:         flags.CheckTimeout,
+			StopAdvertising: flags.StopAdvertising,
+			Control:         publisher.Control,
+		}
+		go publisher.Checker.Run(ctx, flags.CheckInterval, log.Printf)
+	}
+
+	if flags.BackupEndpoint != "" {
+		recipient, err := backup.ParseRecipient([]byte(flags.BackupRecipient))
+		if err != nil {
+			log.Fatalf("--backup-recipient: %v", err)
+		}
+		publisher.Backup = &agent.Backup{
+			Storage: &backup.S3{
+				Endpoint:        flags.BackupEndpoint,
+				Bucket:          flags.BackupBucket,
+				Region:          flags.BackupRegion,
+				AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+				SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			},
+			Recipient: recipient,
+			Prefix:    flags.BackupPrefix,
+			Dirs:      flags.BackupDirs,
+		}
+		go publisher.Backup.Run(ctx, flags.BackupInterval, log.Printf)
+	}
+
+	if flags.HealthAddress != "" {
+		if publisher.Control == nil {
+			log.Fatal("--health-address needs --control-address")
+		}
+		health := &agent.Health{
+			Control:      publisher.Control,
+			HostnameFile: flags.ReadyDescriptor,
+		}
+		if flags.ReadyBackends {
+			health.TorrcFile = flags.Torrc
+		}
+		go health.WatchDescriptors(ctx, log.Printf)
+
+		server := &http.Server{Addr: flags.HealthAddress, Handler: health.Handler(), ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			log.Fatal(server.ListenAndServe())
+		}()
+	}
+
+	log.Printf("publishing the state of tor to %s/%s", publisher.Namespace, flags.ConfigMap)
+	publisher.Run(ctx, flags.Interval, log.Printf)
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// tor-dns runs next to the tor sidecar of the pods in transparent mode and
+// answers their DNS queries, redirected to it: cluster names are forwarded
+// to the cluster DNS, every other name to the DNSPort of tor.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fulviodenza/torproxy/internal/resolver"
+)
+
+func main() {
+	forwarder := &resolver.Forwarder{}
+	var listenAddress string
+	flag.StringVar(&listenAddress, "listen-address", "127.0.0.1:5300", "The UDP and TCP address the queries are answered on.")
+	flag.StringVar(&forwarder.ClusterDomain, "cluster-domain", "cluster.local", "The DNS domain of the cluster.")
+	flag.StringVar(&forwarder.ClusterDNS, "cluster-dns", "", "The host:port of the cluster DNS, cluster names are resolved by it.")
+	flag.StringVar(&forwarder.Tor, "tor-dns", "127.0.0.1:5353", "The DNSPort of tor, every other name is resolved by it.")
+	flag.DurationVar(&forwarder.Timeout, "timeout", 10*time.Second, "How long an upstream is waited for.")
+	flag.Parse()
+
+	if forwarder.ClusterDNS == "" {
+		log.Fatal("--cluster-dns is required")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	conn, err := net.ListenPacket("udp", listenAddress)
+	if err != nil {
+		log.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", listenAddress)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	errs := make(chan error, 2)
+	go func() { errs <- forwarder.ServeUDP(ctx, conn, log.Printf) }()
+	go func() { errs <- forwarder.ServeTCP(ctx, listener, log.Printf) }()
+
+	log.Printf("answering DNS queries on %s", listenAddress)
+	if err := <-errs; err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// tor-exporter runs next to tor and exports its state, read from the
+// control port and the MetricsPort, as Prometheus metrics.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/fulviodenza/torproxy/internal/exporter"
+)
+
+func main() {
+	var controlAddress string
+	var cookieFile string
+	var torMetricsURL string
+	var listenAddress string
+	flag.StringVar(&controlAddress, "control-address", "127.0.0.1:9051", "The address of the control port of tor.")
+	flag.StringVar(&cookieFile, "cookie-file", "/var/run/tor/control.authcookie",
+		"The authentication cookie written by tor, see CookieAuthFile.")
+	flag.StringVar(&torMetricsURL, "tor-metrics-url", "http://127.0.0.1:9035/metrics",
+		"The URL of the MetricsPort of tor, relayed as is. Empty to disable.")
+	flag.StringVar(&listenAddress, "listen-address", ":9130", "The address the metrics endpoint binds to.")
+	flag.Parse()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&exporter.Collector{
+		Address:    controlAddress,
+		CookieFile: cookieFile,
+		Timeout:    5 * time.Second,
+	})
+
+	gatherers := prometheus.Gatherers{registry}
+	if torMetricsURL != "" {
+		gatherers = append(gatherers, &exporter.MetricsPortGatherer{URL: torMetricsURL})
+	}
+
+	http.Handle("/metrics", promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{
+		ErrorHandling: promhttp.ContinueOnError,
+	}))
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	log.Printf("serving tor metrics on %s", listenAddress)
+	server := &http.Server{Addr: listenAddress, ReadHeaderTimeout: 10 * time.Second}
+	log.Fatal(server.ListenAndServe())
+}
@@ -0,0 +1,266 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestAgent(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Agent Suite")
+}
+
+var _ = Describe("Publisher", func() {
+	ctx := context.Background()
+
+	It("publishes the files written by tor under the pod keys", func() {
+		dir := GinkgoT().TempDir()
+		hostname := filepath.Join(dir, "hostname")
+
+		kubeClient := kubefake.NewSimpleClientset(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-tor-status", Namespace: "default"},
+		})
+		publisher := &Publisher{
+			Client:    kubeClient,
+			Namespace: "default",
+			ConfigMap: "web-tor-status",
+			Pod:       "web-0",
+			Files:     map[string]string{"hostname": hostname},
+		}
+		read := func() map[string]string {
+			configMap, err := kubeClient.CoreV1().ConfigMaps("default").Get(ctx, "web-tor-status", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			return configMap.Data
+		}
+
+		By("waiting for tor to write the file")
+		Expect(publisher.Publish(ctx)).To(Succeed())
+		Expect(read()).To(BeEmpty())
+
+		By("publishing it once written")
+		Expect(os.WriteFile(hostname, []byte("abc.onion\n"), 0o600)).To(Succeed())
+		Expect(publisher.Publish(ctx)).To(Succeed())
+		Expect(read()).To(Equal(map[string]string{"web-0.hostname": "abc.onion"}))
+
+		By("patching only on changes")
+		actions := len(kubeClient.Actions())
+		Expect(publisher.Publish(ctx)).To(Succeed())
+		Expect(kubeClient.Actions()).To(HaveLen(actions))
+
+		By("removing it once gone")
+		Expect(os.Remove(hostname)).To(Succeed())
+		Expect(publisher.Publish(ctx)).To(Succeed())
+		Expect(read()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Resources", func() {
+	ctx := context.Background()
+	controller := true
+	owner := metav1.OwnerReference{APIVersion: "tor.stack.io/v1beta1", Kind: "OnionService", Name: "web", UID: "uid", Controller: &controller}
+
+	It("lets the agents patch their status ConfigMap only", func() {
+		c := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+		Expect(Reconcile(ctx, c, owner, "default", "web")).To(Succeed())
+		Expect(Reconcile(ctx, c, owner, "default", "web")).To(Succeed())
+
+		Expect(c.Get(ctx, types.NamespacedName{Name: "web-tor-status", Namespace: "default"}, &corev1.ConfigMap{})).To(Succeed())
+		Expect(c.Get(ctx, types.NamespacedName{Name: "web-tor-agent", Namespace: "default"}, &corev1.ServiceAccount{})).To(Succeed())
+
+		role := &rbacv1.Role{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "web-tor-agent", Namespace: "default"}, role)).To(Succeed())
+		Expect(role.Rules).To(Equal([]rbacv1.PolicyRule{{
+			APIGroups:     []string{""},
+			Resources:     []string{"configmaps"},
+			ResourceNames: []string{"web-tor-status"},
+			Verbs:         []string{"get", "patch"},
+		}}))
+
+		roleBinding := &rbacv1.RoleBinding{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "web-tor-agent", Namespace: "default"}, roleBinding)).To(Succeed())
+		Expect(roleBinding.Subjects).To(ConsistOf(rbacv1.Subject{Kind: "ServiceAccount", Name: "web-tor-agent", Namespace: "default"}))
+	})
+
+	It("leaves alone the resources of another object of the same name", func() {
+		c := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+		relay := metav1.OwnerReference{APIVersion: "tor.stack.io/v1beta1", Kind: "TorRelay", Name: "web", UID: "relay-uid", Controller: &controller}
+		Expect(Reconcile(ctx, c, relay, "default", "web")).To(Succeed())
+
+		err := Reconcile(ctx, c, owner, "default", "web", rbacv1.PolicyRule{
+			APIGroups: []string{""},
+			Resources: []string{"secrets"},
+			Verbs:     []string{"get"},
+		})
+		Expect(IsConflict(err)).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("ConfigMap web-tor-status is controlled by TorRelay web")))
+
+		// Objects without a controller are not taken over either.
+		c = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(&rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-tor-agent", Namespace: "default"},
+		}).Build()
+		err = Reconcile(ctx, c, owner, "default", "web")
+		Expect(err).To(MatchError(ContainSubstring("Role web-tor-agent exists and has no controller")))
+		role := &rbacv1.Role{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "web-tor-agent", Namespace: "default"}, role)).To(Succeed())
+		Expect(role.Rules).To(BeEmpty())
+	})
+
+	It("reads and prunes what the agents published", func() {
+		c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-tor-status", Namespace: "default"},
+			Data: map[string]string{
+				"web-7d9f.hostname":  "old.onion",
+				"web-8a1c.hostname":  "abc.onion",
+				"web-8a1c.bootstrap": "100",
+			},
+		}).Build()
+
+		Expect(Published(ctx, c, "default", "web", "web-8a1c", "hostname")).To(Equal("abc.onion"))
+		Expect(Published(ctx, c, "default", "other", "other-0", "hostname")).To(BeEmpty())
+
+		Expect(Prune(ctx, c, "default", "web", []string{"web-8a1c"})).To(Succeed())
+		configMap := &corev1.ConfigMap{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "web-tor-status", Namespace: "default"}, configMap)).To(Succeed())
+		Expect(configMap.Data).To(Equal(map[string]string{
+			"web-8a1c.hostname":  "abc.onion",
+			"web-8a1c.bootstrap": "100",
+		}))
+	})
+})
+
+var _ = Describe("Backends", func() {
+	It("parses the HiddenServicePort lines of the torrc", func() {
+		Expect(Backends("SOCKSPort 9050\n" +
+			"HiddenServicePort 80 web.default.svc.cluster.local:8080\n" +
+			"HiddenServicePort 22\n" +
+			"HiddenServicePort 443 8443\n" +
+			"HiddenServicePort 6667 unix:/run/irc.sock\n")).To(Equal([]Backend{
+			{Port: 80, Network: "tcp", Address: "web.default.svc.cluster.local:8080"},
+			{Port: 22, Network: "tcp", Address: "127.0.0.1:22"},
+			{Port: 443, Network: "tcp", Address: "127.0.0.1:8443"},
+			{Port: 6667, Network: "unix", Address: "/run/irc.sock"},
+		}))
+	})
+})
+
+// fakeControl accepts any cookie and records the commands it receives.
+func fakeControl(commands chan<- string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	DeferCleanup(listener.Close)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if !strings.HasPrefix(line, "AUTHENTICATE") {
+						commands <- strings.TrimSpace(line)
+					}
+					conn.Write([]byte("250 OK\r\n"))
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+var _ = Describe("Checker", func() {
+	ctx := context.Background()
+
+	torrc := func(lines ...string) string {
+		file := filepath.Join(GinkgoT().TempDir(), "torrc")
+		Expect(os.WriteFile(file, []byte(strings.Join(lines, "\n")), 0o600)).To(Succeed())
+		return file
+	}
+
+	It("checks the backends over TCP and HTTP", func() {
+		healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/login", http.StatusFound)
+		}))
+		DeferCleanup(healthy.Close)
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		DeferCleanup(failing.Close)
+
+		checker := &Checker{
+			TorrcFile: torrc(
+				"HiddenServicePort 80 "+strings.TrimPrefix(healthy.URL, "http://"),
+				"HiddenServicePort 81 "+strings.TrimPrefix(failing.URL, "http://"),
+			),
+			Type: CheckHTTP,
+			Path: "/",
+		}
+		Expect(checker.Check(ctx)).To(Succeed())
+
+		snapshot := checker.Snapshot()
+		Expect(snapshot).To(HaveLen(2))
+		Expect(snapshot[0].Reachable).To(BeTrue())
+		Expect(snapshot[1].Reachable).To(BeFalse())
+		Expect(snapshot[1].Error).To(Equal("unexpected status 502 Bad Gateway"))
+
+		By("keeping the snapshot while nothing changes")
+		Expect(checker.Check(ctx)).To(Succeed())
+		Expect(checker.Snapshot()[0].CheckTime).To(Equal(snapshot[0].CheckTime))
+
+		By("connecting only with TCP checks")
+		checker.Type = CheckTCP
+		Expect(checker.Check(ctx)).To(Succeed())
+		Expect(checker.Snapshot()[1].Reachable).To(BeTrue())
+	})
+
+	It("stops advertising while all the backends are down", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		address := listener.Addr().String()
+
+		commands := make(chan string, 10)
+		checker := &Checker{
+			TorrcFile:       torrc("HiddenServicePort 80 " + address),
+			Type:            CheckTCP,
+			StopAdvertising: true,
+			Control: &Control{
+				Address:    fakeControl(commands),
+				CookieFile: torrc("cookie"),
+			},
+		}
+
+		Expect(checker.Check(ctx)).To(Succeed())
+		Expect(commands).To(Receive(Equal("SIGNAL RELOAD")))
+		Expect(checker.Check(ctx)).To(Succeed())
+		Expect(commands).NotTo(Receive())
+
+		listener.Close()
+		Expect(checker.Check(ctx)).To(Succeed())
+		Expect(commands).To(Receive(Equal("RESETCONF HiddenServiceDir")))
+	})
+})
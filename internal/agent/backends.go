@@ -0,0 +1,224 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fulviodenza/torproxy/internal/torcontrol"
+)
+
+const (
+	// BackendsKey is published with the JSON encoded []BackendHealth of
+	// the HiddenServicePort targets.
+	BackendsKey = "backends"
+
+	CheckTCP  = "tcp"
+	CheckHTTP = "http"
+
+	// refreshInterval bounds how long the latencies published may be
+	// stale, changes of reachability are published right away.
+	refreshInterval = time.Minute
+)
+
+// BackendHealth is the result of the last check of a HiddenServicePort
+// target.
+type BackendHealth struct {
+	Port      int32     `json:"port"`
+	Target    string    `json:"target"`
+	Reachable bool      `json:"reachable"`
+	LatencyMs int64     `json:"latencyMs,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CheckTime time.Time `json:"checkTime"`
+}
+
+// Checker checks the targets of the HiddenServicePort lines of the torrc
+// from the tor pod and, when asked to, stops tor from advertising the
+// onion service while none of them is reachable.
+type Checker struct {
+	TorrcFile string
+	// Type is CheckTCP or CheckHTTP, HTTP checks pass on 2xx and 3xx.
+	Type    string
+	Path    string
+	Timeout time.Duration
+
+	// StopAdvertising removes the onion service from tor while all the
+	// backends are down, it is restored from the torrc once one is back.
+	StopAdvertising bool
+	Control         *Control
+
+	mu       sync.Mutex
+	snapshot []BackendHealth
+	// advertising is nil until tor is told either way.
+	advertising *bool
+}
+
+// Run checks the backends every interval until ctx is done.
+func (c *Checker) Run(ctx context.Context, interval time.Duration, logf func(format string, args ...any)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.Check(ctx); err != nil {
+			logf("checking the backends: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Snapshot returns the results to publish.
+func (c *Checker) Snapshot() []BackendHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.snapshot
+}
+
+// Check checks every backend once.
+func (c *Checker) Check(ctx context.Context) error {
+	torrc, err := os.ReadFile(c.TorrcFile)
+	if err != nil {
+		return err
+	}
+
+	results := []BackendHealth{}
+	anyReachable := false
+	for _, backend := range Backends(string(torrc)) {
+		result := c.check(ctx, backend)
+		anyReachable = anyReachable || result.Reachable
+		results = append(results, result)
+	}
+
+	c.mu.Lock()
+	if len(c.snapshot) == 0 || changed(c.snapshot, results) || time.Since(c.snapshot[0].CheckTime) > refreshInterval {
+		c.snapshot = results
+	}
+	c.mu.Unlock()
+
+	if c.StopAdvertising && len(results) > 0 {
+		return c.advertise(ctx, anyReachable)
+	}
+	return nil
+}
+
+// changed reports whether the reachability of the backends changed.
+func changed(old, new []BackendHealth) bool {
+	if len(old) != len(new) {
+		return true
+	}
+	for i := range old {
+		if old[i].Port != new[i].Port || old[i].Target != new[i].Target ||
+			old[i].Reachable != new[i].Reachable || old[i].Error != new[i].Error {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Checker) check(ctx context.Context, backend Backend) BackendHealth {
+	result := BackendHealth{
+		Port:      backend.Port,
+		Target:    backend.Target(),
+		CheckTime: time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+
+	start := time.Now()
+	var err error
+	if c.Type == CheckHTTP && backend.Network == "tcp" {
+		err = c.checkHTTP(ctx, backend)
+	} else {
+		err = c.checkTCP(ctx, backend)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Reachable = true
+	result.LatencyMs = time.Since(start).Milliseconds()
+	return result
+}
+
+func (c *Checker) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return 3 * time.Second
+	}
+	return c.Timeout
+}
+
+func (c *Checker) checkTCP(ctx context.Context, backend Backend) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, backend.Network, backend.Address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (c *Checker) checkHTTP(ctx context.Context, backend Backend) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+backend.Address+c.Path, nil)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{
+		// Redirects are answers of a healthy backend.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// advertise removes the onion services from tor or reloads them from the
+// torrc. The first call always tells tor, a previous agent may have left
+// them removed.
+func (c *Checker) advertise(ctx context.Context, advertise bool) error {
+	if c.advertising != nil && *c.advertising == advertise {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+
+	conn, err := torcontrol.Dial(ctx, c.Control.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.AuthenticateCookie(c.Control.CookieFile); err != nil {
+		return err
+	}
+	if advertise {
+		err = conn.Signal("RELOAD")
+	} else {
+		err = conn.ResetConf("HiddenServiceDir")
+	}
+	if err != nil {
+		return err
+	}
+
+	c.advertising = &advertise
+	return nil
+}
@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"context"
+	"crypto/ecdh"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fulviodenza/torproxy/internal/backup"
+	"github.com/fulviodenza/torproxy/internal/onion"
+)
+
+// BackupKey is published with the outcome of the last backup of the keys
+// of the onion services.
+const BackupKey = "backup"
+
+// BackupState is the outcome of a backup.
+type BackupState struct {
+	Time time.Time `json:"time"`
+	// Objects written, one per onion address.
+	Objects []string `json:"objects,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Backup encrypts the keys of the HiddenServiceDirs to Recipient and
+// stores them in Storage, as <Prefix><onion address>.
+type Backup struct {
+	Storage   *backup.S3
+	Recipient *ecdh.PublicKey
+	Prefix    string
+	Dirs      []string
+
+	mu    sync.Mutex
+	state *BackupState
+}
+
+// Run backs up the keys every interval until ctx is done.
+func (b *Backup) Run(ctx context.Context, interval time.Duration, logf func(format string, args ...any)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		state := b.Backup(ctx)
+		if state.Error != "" {
+			logf("backing up the onion keys: %s", state.Error)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Snapshot returns the outcome of the last backup, nil before the first
+// one.
+func (b *Backup) Snapshot() *BackupState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Backup stores the keys of every HiddenServiceDir. Directories tor did
+// not fill yet are skipped.
+func (b *Backup) Backup(ctx context.Context) BackupState {
+	state := BackupState{Time: time.Now()}
+	var errors []string
+
+	for _, dir := range b.Dirs {
+		files := map[string][]byte{}
+		for _, name := range []string{onion.SecretKeyFile, onion.PublicKeyFile, onion.HostnameFile} {
+			content, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				break
+			}
+			files[name] = content
+		}
+		if len(files) < 3 {
+			continue
+		}
+
+		sealed, err := backup.Encrypt(b.Recipient, files)
+		if err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		object := b.Prefix + strings.TrimSpace(string(files[onion.HostnameFile]))
+		if err := b.Storage.Put(ctx, object, sealed); err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		state.Objects = append(state.Objects, object)
+	}
+	state.Error = strings.Join(errors, "; ")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = &state
+	return state
+}
@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Flags is the command line of the tor-agent. The controllers building the
+// agent container are tested against it.
+type Flags struct {
+	ConfigMap      string
+	Files          map[string]string
+	ControlAddress string
+	CookieFile     string
+	Interval       time.Duration
+
+	HealthAddress   string
+	ReadyDescriptor string
+	ReadyBackends   bool
+	Torrc           string
+
+	CheckBackends   string
+	CheckPath       string
+	CheckInterval   time.Duration
+	CheckTimeout    time.Duration
+	StopAdvertising bool
+
+	BackupEndpoint  string
+	BackupBucket    string
+	BackupRegion    string
+	BackupPrefix    string
+	BackupRecipient string
+	BackupDirs      []string
+	BackupInterval  time.Duration
+}
+
+// NewFlagSet registers the agent flags, stored in f, on a new FlagSet.
+func (f *Flags) NewFlagSet(name string, errorHandling flag.ErrorHandling) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, errorHandling)
+	f.Files = map[string]string{}
+
+	fs.StringVar(&f.ConfigMap, "configmap", "", "The status ConfigMap to publish to.")
+	fs.Var(fileFlags(f.Files), "file", "A file to publish, as key=path. Can be repeated.")
+	fs.StringVar(&f.ControlAddress, "control-address", "",
+		"The address of the control port of tor, the bootstrap progress is published when set.")
+	fs.StringVar(&f.CookieFile, "cookie-file", "/var/run/tor/control.authcookie",
+		"The authentication cookie written by tor, see CookieAuthFile.")
+	fs.DurationVar(&f.Interval, "interval", 5*time.Second, "How often the state of tor is read.")
+	fs.StringVar(&f.HealthAddress, "health-address", "",
+		"The address the liveness and readiness endpoints of tor bind to, they need --control-address. Empty to disable.")
+	fs.StringVar(&f.ReadyDescriptor, "ready-descriptor", "",
+		"The hostname file of the onion service, tor is only ready once its descriptor is uploaded when set.")
+	fs.BoolVar(&f.ReadyBackends, "ready-backends", false,
+		"Only report tor ready once the targets of the HiddenServicePort lines of --torrc accept connections.")
+	fs.StringVar(&f.Torrc, "torrc", "/etc/tor/torrc", "The torrc of tor.")
+	fs.StringVar(&f.CheckBackends, "check-backends", "",
+		"Check the targets of the HiddenServicePort lines of --torrc, with tcp or http checks. Empty to disable.")
+	fs.StringVar(&f.CheckPath, "check-path", "/", "The path requested by http checks.")
+	fs.DurationVar(&f.CheckInterval, "check-interval", 10*time.Second, "How often the backends are checked.")
+	fs.DurationVar(&f.CheckTimeout, "check-timeout", 3*time.Second, "The timeout of a backend check.")
+	fs.BoolVar(&f.StopAdvertising, "stop-advertising", false,
+		"Remove the onion service from tor while no backend is reachable, it needs --control-address.")
+	fs.StringVar(&f.BackupEndpoint, "backup-endpoint", "",
+		"The URL of the S3 compatible storage the keys of --backup-dir are backed up to. Empty to disable. "+
+			"The credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.")
+	fs.StringVar(&f.BackupBucket, "backup-bucket", "", "The bucket the keys are backed up to.")
+	fs.StringVar(&f.BackupRegion, "backup-region", "us-east-1", "The region of the backup storage.")
+	fs.StringVar(&f.BackupPrefix, "backup-prefix", "", "The prefix of the backup objects, followed by the onion address.")
+	fs.StringVar(&f.BackupRecipient, "backup-recipient", "", "The PEM X25519 public key the backups are encrypted to.")
+	fs.Var((*dirFlags)(&f.BackupDirs), "backup-dir", "A HiddenServiceDir to back up. Can be repeated.")
+	fs.DurationVar(&f.BackupInterval, "backup-interval", time.Hour, "How often the keys are backed up.")
+	return fs
+}
+
+// fileFlags collects the repeated --file key=path flags.
+type fileFlags map[string]string
+
+func (f fileFlags) String() string {
+	return fmt.Sprint(map[string]string(f))
+}
+
+func (f fileFlags) Set(value string) error {
+	key, path, ok := strings.Cut(value, "=")
+	if !ok || key == "" || path == "" {
+		return fmt.Errorf("expected key=path, got %q", value)
+	}
+	f[key] = path
+	return nil
+}
+
+// dirFlags collects the repeated --backup-dir flags.
+type dirFlags []string
+
+func (f *dirFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *dirFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
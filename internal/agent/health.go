@@ -0,0 +1,204 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fulviodenza/torproxy/internal/torcontrol"
+)
+
+const (
+	// HealthPort serves the probes of the tor pods, /livez and /readyz.
+	HealthPort = 9131
+
+	LivenessPath  = "/livez"
+	ReadinessPath = "/readyz"
+)
+
+// Health backs the probes of the tor container with the state of tor: it
+// is live while its control port answers and ready once bootstrapped and,
+// as asked, once the descriptor is uploaded and the backends are reachable.
+type Health struct {
+	Control *Control
+	// HostnameFile holds the onion address of the service, the descriptor
+	// must have been uploaded when set.
+	HostnameFile string
+	// TorrcFile lists the backends, they must accept connections when set.
+	TorrcFile string
+	Timeout   time.Duration
+
+	uploaded atomic.Bool
+}
+
+func (h *Health) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(LivenessPath, h.serve(h.Live))
+	mux.HandleFunc(ReadinessPath, h.serve(h.Ready))
+	return mux
+}
+
+func (h *Health) serve(check func(context.Context) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := check(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// Live checks that tor answers on its control port.
+func (h *Health) Live(ctx context.Context) error {
+	_, err := h.getInfo(ctx, "version")
+	return err
+}
+
+// Ready checks that tor can serve the onion service.
+func (h *Health) Ready(ctx context.Context) error {
+	info, err := h.getInfo(ctx, "status/bootstrap-phase")
+	if err != nil {
+		return err
+	}
+	progress, _ := torcontrol.BootstrapProgress(info["status/bootstrap-phase"])
+	if progress < 100 {
+		return fmt.Errorf("tor is bootstrapping, %d%% done", progress)
+	}
+
+	if h.HostnameFile != "" && !h.uploaded.Load() {
+		return errors.New("the onion service descriptor is not uploaded yet")
+	}
+
+	if h.TorrcFile != "" {
+		torrc, err := os.ReadFile(h.TorrcFile)
+		if err != nil {
+			return err
+		}
+		for _, backend := range Backends(string(torrc)) {
+			if err := h.dial(ctx, backend); err != nil {
+				return fmt.Errorf("backend %s of port %d: %w", backend.Target(), backend.Port, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (h *Health) timeout() time.Duration {
+	if h.Timeout == 0 {
+		return 5 * time.Second
+	}
+	return h.Timeout
+}
+
+func (h *Health) dial(ctx context.Context, backend Backend) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout())
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, backend.Network, backend.Address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (h *Health) getInfo(ctx context.Context, keys ...string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout())
+	defer cancel()
+
+	conn, err := torcontrol.Dial(ctx, h.Control.Address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.AuthenticateCookie(h.Control.CookieFile); err != nil {
+		return nil, err
+	}
+	return conn.GetInfo(keys...)
+}
+
+// WatchDescriptors follows the uploads of the descriptor until ctx is
+// done, reconnecting to tor when it restarts.
+func (h *Health) WatchDescriptors(ctx context.Context, logf func(format string, args ...any)) {
+	if h.HostnameFile == "" {
+		return
+	}
+
+	for {
+		err := h.watchDescriptors(ctx)
+		// A restarted tor uploads its descriptor again.
+		h.uploaded.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+		logf("watching the descriptor uploads: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (h *Health) watchDescriptors(ctx context.Context) error {
+	dialCtx, cancel := context.WithTimeout(ctx, h.timeout())
+	defer cancel()
+	conn, err := torcontrol.Dial(dialCtx, h.Control.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Unblock ReadEvent once ctx is done.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if err := conn.AuthenticateCookie(h.Control.CookieFile); err != nil {
+		return err
+	}
+	// The agent may start after the upload, e.g. when its container is
+	// restarted: tor uploads the descriptor as soon as it is built, so a
+	// known descriptor counts as uploaded.
+	if hostname, err := os.ReadFile(h.HostnameFile); err == nil {
+		address := strings.TrimSuffix(strings.TrimSpace(string(hostname)), ".onion")
+		if address != "" {
+			if _, err := conn.GetInfo("hs/service/desc/id/" + address); err == nil {
+				h.uploaded.Store(true)
+			}
+		}
+	}
+
+	if err := conn.SetEvents("HS_DESC"); err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	for {
+		event, err := conn.ReadEvent()
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(event)
+		if len(fields) >= 2 && fields[0] == "HS_DESC" && fields[1] == "UPLOADED" {
+			h.uploaded.Store(true)
+		}
+	}
+}
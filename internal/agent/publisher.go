@@ -0,0 +1,144 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/fulviodenza/torproxy/internal/torcontrol"
+)
+
+// Publisher runs in the agent sidecar, it reads the files and the control
+// port of tor and patches what changed into the status ConfigMap.
+type Publisher struct {
+	Client    kubernetes.Interface
+	Namespace string
+	ConfigMap string
+	Pod       string
+
+	// Files are published by key, once tor writes them.
+	Files   map[string]string
+	Control *Control
+	// Checker results are published, when set.
+	Checker *Checker
+	// Backup outcomes are published, when set.
+	Backup *Backup
+
+	published map[string]string
+}
+
+// Run publishes every interval until ctx is done.
+func (p *Publisher) Run(ctx context.Context, interval time.Duration, logf func(format string, args ...any)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.Publish(ctx); err != nil {
+			logf("publishing the state of tor: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Publish reads the state of tor and patches the ConfigMap when it
+// changed since the last successful call.
+func (p *Publisher) Publish(ctx context.Context) error {
+	values := p.read(ctx)
+	if reflect.DeepEqual(values, p.published) {
+		return nil
+	}
+
+	data := map[string]any{}
+	for key, value := range values {
+		data[Key(p.Pod, key)] = value
+	}
+	// A null value removes the key from the ConfigMap.
+	for key := range p.published {
+		if _, ok := values[key]; !ok {
+			data[Key(p.Pod, key)] = nil
+		}
+	}
+	patch, err := json.Marshal(map[string]any{"data": data})
+	if err != nil {
+		return err
+	}
+
+	_, err = p.Client.CoreV1().ConfigMaps(p.Namespace).Patch(ctx, p.ConfigMap, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return err
+	}
+	p.published = values
+	return nil
+}
+
+// read returns the values known so far, the files tor did not write yet
+// and an unreachable control port are left out.
+func (p *Publisher) read(ctx context.Context) map[string]string {
+	values := map[string]string{}
+	for key, path := range p.Files {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if value := strings.TrimSpace(string(content)); value != "" {
+			values[key] = value
+		}
+	}
+
+	if p.Control != nil {
+		if progress, ok := p.bootstrapProgress(ctx); ok {
+			values[BootstrapKey] = strconv.Itoa(progress)
+		}
+	}
+
+	if p.Checker != nil {
+		if snapshot := p.Checker.Snapshot(); snapshot != nil {
+			if backends, err := json.Marshal(snapshot); err == nil {
+				values[BackendsKey] = string(backends)
+			}
+		}
+	}
+
+	if p.Backup != nil {
+		if snapshot := p.Backup.Snapshot(); snapshot != nil {
+			if state, err := json.Marshal(snapshot); err == nil {
+				values[BackupKey] = string(state)
+			}
+		}
+	}
+
+	return values
+}
+
+func (p *Publisher) bootstrapProgress(ctx context.Context) (int, bool) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	conn, err := torcontrol.Dial(ctx, p.Control.Address)
+	if err != nil {
+		return 0, false
+	}
+	defer conn.Close()
+
+	if err := conn.AuthenticateCookie(p.Control.CookieFile); err != nil {
+		return 0, false
+	}
+	info, err := conn.GetInfo("status/bootstrap-phase")
+	if err != nil {
+		return 0, false
+	}
+	return torcontrol.BootstrapProgress(info["status/bootstrap-phase"])
+}
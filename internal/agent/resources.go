@@ -0,0 +1,295 @@
+// Package agent publishes the state of tor, read from its pod by the
+// tor-agent sidecar (cmd/tor-agent), to the controllers: the agent patches
+// a status ConfigMap the controllers read, so they do not need to exec
+// into the tor pods.
+package agent
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Image runs cmd/tor-agent, it is shipped in the manager image.
+var Image = "fulviodenza/torproxy:latest"
+
+const (
+	// ContainerName of the agent sidecar.
+	ContainerName = "tor-agent"
+
+	// BootstrapKey is published with the bootstrap progress of tor, in
+	// percent, when the agent reads the control port.
+	BootstrapKey = "bootstrap"
+)
+
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
+
+// ErrConflict is returned when an object the owner needs already exists
+// and is controlled by another object, e.g. a relay and an OnionService of
+// the same name.
+var ErrConflict = goerrors.New("name already in use")
+
+// IsConflict reports whether err is, or wraps, ErrConflict.
+func IsConflict(err error) bool {
+	return goerrors.Is(err, ErrConflict)
+}
+
+// CheckController fails with ErrConflict unless the object is controlled by
+// owner, so that owners sharing a name do not overwrite each other.
+func CheckController(object client.Object, kind string, owner metav1.OwnerReference) error {
+	controller := metav1.GetControllerOf(object)
+	if controller != nil && controller.UID == owner.UID {
+		return nil
+	}
+	if controller == nil {
+		return fmt.Errorf("%w: %s %s exists and has no controller", ErrConflict, kind, object.GetName())
+	}
+	return fmt.Errorf("%w: %s %s is controlled by %s %s", ErrConflict, kind, object.GetName(), controller.Kind, controller.Name)
+}
+
+// ConfigMapName is the status ConfigMap the agents of the tor pods of name
+// publish to.
+func ConfigMapName(name string) string {
+	return name + "-tor-status"
+}
+
+// ServiceAccountName is the identity of the tor pods of name, it may only
+// patch their status ConfigMap.
+func ServiceAccountName(name string) string {
+	return name + "-tor-agent"
+}
+
+// Key of a value published by the agent of a pod, e.g. "web-0.hostname".
+func Key(pod, key string) string {
+	return pod + "." + key
+}
+
+// Control is the control port read by the agent for the bootstrap
+// progress of tor.
+type Control struct {
+	Address    string
+	CookieFile string
+}
+
+// Container returns the agent sidecar publishing the given files, by key,
+// and the bootstrap progress read from control, if any. The mounts give
+// the agent access to the files and to the control cookie.
+func Container(name string, files map[string]string, control *Control, mounts []corev1.VolumeMount) corev1.Container {
+	keys := make([]string, 0, len(files))
+	for key := range files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	args := []string{"--configmap=" + ConfigMapName(name)}
+	for _, key := range keys {
+		args = append(args, fmt.Sprintf("--file=%s=%s", key, files[key]))
+	}
+	if control != nil {
+		args = append(args,
+			"--control-address="+control.Address,
+			"--cookie-file="+control.CookieFile)
+	}
+
+	torUID := int64(101)
+	readOnlyMounts := make([]corev1.VolumeMount, 0, len(mounts))
+	for _, mount := range mounts {
+		mount.ReadOnly = true
+		readOnlyMounts = append(readOnlyMounts, mount)
+	}
+
+	return corev1.Container{
+		Name:    ContainerName,
+		Image:   Image,
+		Command: []string{"/tor-agent"},
+		Args:    args,
+		Env: []corev1.EnvVar{
+			{
+				Name: "POD_NAME",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+				},
+			},
+			{
+				Name: "POD_NAMESPACE",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"},
+				},
+			},
+		},
+		VolumeMounts: readOnlyMounts,
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser:  &torUID,
+			RunAsGroup: &torUID,
+		},
+	}
+}
+
+// Reconcile creates the status ConfigMap of the tor pods of name and the
+// ServiceAccount, Role and RoleBinding letting their agents patch it, and
+// nothing else but the extra rules. It fails with ErrConflict when one of
+// them belongs to another object: an OnionService and a relay of the same
+// name would otherwise share the identity and the status of their pods.
+func Reconcile(ctx context.Context, c client.Client, owner metav1.OwnerReference, namespace, name string, rules ...rbacv1.PolicyRule) error {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            ConfigMapName(name),
+			Namespace:       namespace,
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+	}
+	// The data belongs to the agents, the ConfigMap is only created.
+	foundConfigMap := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: configMap.Name, Namespace: namespace}, foundConfigMap)
+	if err != nil && errors.IsNotFound(err) {
+		if err := c.Create(ctx, configMap); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else if err := CheckController(foundConfigMap, "ConfigMap", owner); err != nil {
+		return err
+	}
+
+	serviceAccount := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            ServiceAccountName(name),
+			Namespace:       namespace,
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+	}
+	foundServiceAccount := &corev1.ServiceAccount{}
+	err = c.Get(ctx, types.NamespacedName{Name: serviceAccount.Name, Namespace: namespace}, foundServiceAccount)
+	if err != nil && errors.IsNotFound(err) {
+		if err := c.Create(ctx, serviceAccount); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else if err := CheckController(foundServiceAccount, "ServiceAccount", owner); err != nil {
+		return err
+	}
+
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            ServiceAccountName(name),
+			Namespace:       namespace,
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups:     []string{""},
+				Resources:     []string{"configmaps"},
+				ResourceNames: []string{ConfigMapName(name)},
+				Verbs:         []string{"get", "patch"},
+			},
+		},
+	}
+	role.Rules = append(role.Rules, rules...)
+	foundRole := &rbacv1.Role{}
+	err = c.Get(ctx, types.NamespacedName{Name: role.Name, Namespace: namespace}, foundRole)
+	if err != nil && errors.IsNotFound(err) {
+		if err := c.Create(ctx, role); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else if err := CheckController(foundRole, "Role", owner); err != nil {
+		return err
+	} else if !reflect.DeepEqual(foundRole.Rules, role.Rules) {
+		foundRole.Rules = role.Rules
+		if err := c.Update(ctx, foundRole); err != nil {
+			return err
+		}
+	}
+
+	roleBinding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            ServiceAccountName(name),
+			Namespace:       namespace,
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     role.Name,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      serviceAccount.Name,
+				Namespace: namespace,
+			},
+		},
+	}
+	// The role reference is immutable and never changes, only the subjects
+	// are kept in sync.
+	foundRoleBinding := &rbacv1.RoleBinding{}
+	err = c.Get(ctx, types.NamespacedName{Name: roleBinding.Name, Namespace: namespace}, foundRoleBinding)
+	if err != nil && errors.IsNotFound(err) {
+		return c.Create(ctx, roleBinding)
+	} else if err != nil {
+		return err
+	}
+	if err := CheckController(foundRoleBinding, "RoleBinding", owner); err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(foundRoleBinding.Subjects, roleBinding.Subjects) {
+		foundRoleBinding.Subjects = roleBinding.Subjects
+		return c.Update(ctx, foundRoleBinding)
+	}
+
+	return nil
+}
+
+// Published returns the value published under key by the agent of pod,
+// empty until the agent publishes it.
+func Published(ctx context.Context, c client.Client, namespace, name, pod, key string) (string, error) {
+	configMap := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: ConfigMapName(name), Namespace: namespace}, configMap)
+	if err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	return configMap.Data[Key(pod, key)], nil
+}
+
+// Prune removes the values published by pods which are gone.
+func Prune(ctx context.Context, c client.Client, namespace, name string, pods []string) error {
+	configMap := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: ConfigMapName(name), Namespace: namespace}, configMap)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	alive := map[string]bool{}
+	for _, pod := range pods {
+		alive[pod] = true
+	}
+
+	pruned := false
+	for key := range configMap.Data {
+		// Keys have no dots, pod names may.
+		separator := strings.LastIndex(key, ".")
+		if separator > 0 && !alive[key[:separator]] {
+			delete(configMap.Data, key)
+			pruned = true
+		}
+	}
+	if !pruned {
+		return nil
+	}
+	return c.Update(ctx, configMap)
+}
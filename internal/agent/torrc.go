@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Backend is the target of a HiddenServicePort line of the torrc.
+type Backend struct {
+	// Port is the virtual port of the onion service.
+	Port int32
+	// Network and Address are dialed to reach the target, "tcp" or "unix".
+	Network string
+	Address string
+}
+
+// Target is the target as written in the torrc.
+func (b Backend) Target() string {
+	if b.Network == "unix" {
+		return "unix:" + b.Address
+	}
+	return b.Address
+}
+
+// Backends parses the HiddenServicePort lines of torrc. As in tor, a
+// missing target is the virtual port on localhost and a lone port is on
+// localhost. Lines repeated for several HiddenServiceDirs, as during a key
+// rotation, are listed once.
+func Backends(torrc string) []Backend {
+	backends := []Backend{}
+	seen := map[Backend]bool{}
+	for _, line := range strings.Split(torrc, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "HiddenServicePort") {
+			continue
+		}
+
+		port, err := strconv.ParseInt(fields[1], 10, 32)
+		if err != nil {
+			continue
+		}
+		backend := Backend{Port: int32(port), Network: "tcp"}
+
+		target := fields[1]
+		if len(fields) > 2 {
+			target = fields[2]
+		}
+		switch {
+		case strings.HasPrefix(target, "unix:"):
+			backend.Network = "unix"
+			backend.Address = strings.Trim(strings.TrimPrefix(target, "unix:"), `"`)
+		case !strings.Contains(target, ":"):
+			backend.Address = net.JoinHostPort("127.0.0.1", target)
+		default:
+			backend.Address = target
+		}
+		if !seen[backend] {
+			seen[backend] = true
+			backends = append(backends, backend)
+		}
+	}
+	return backends
+}
@@ -0,0 +1,155 @@
+// Package backup encrypts onion service keys and stores them in S3
+// compatible object storage, to restore them when their volume is lost.
+package backup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// Backups use their own format, only the public key of the recipient is
+// needed to write them:
+//
+//	magic || ephemeral X25519 public key (32 bytes) || nonce (12 bytes) || ciphertext
+//
+// The ciphertext is the AES-256-GCM seal of the JSON object mapping the
+// file names to their base64 content, with magic as additional data. Its
+// key is HKDF-SHA256 of the X25519 shared secret of the ephemeral key and
+// the recipient, salted with both public keys, ephemeral first, with info
+// as info. cmd/onion-backup-decrypt opens them offline.
+const (
+	magic = "torproxy-backup-v1\n"
+	info  = "torproxy onion backup v1"
+)
+
+// ParseRecipient parses the PEM X25519 public key backups are encrypted
+// to, as written by `openssl pkey -pubout`.
+func ParseRecipient(data []byte) (*ecdh.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM public key found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	recipient, ok := key.(*ecdh.PublicKey)
+	if !ok || recipient.Curve() != ecdh.X25519() {
+		return nil, errors.New("the public key is not an X25519 key")
+	}
+	return recipient, nil
+}
+
+// ParseIdentity parses the PEM X25519 private key backups are decrypted
+// with, as written by `openssl genpkey -algorithm x25519`.
+func ParseIdentity(data []byte) (*ecdh.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	identity, ok := key.(*ecdh.PrivateKey)
+	if !ok || identity.Curve() != ecdh.X25519() {
+		return nil, errors.New("the private key is not an X25519 key")
+	}
+	return identity, nil
+}
+
+// Encrypt seals the files, by name, for the recipient.
+func Encrypt(recipient *ecdh.PublicKey, files map[string][]byte) ([]byte, error) {
+	plaintext, err := json.Marshal(files)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := fileCipher(shared, ephemeral.PublicKey(), recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString(magic)
+	out.Write(ephemeral.PublicKey().Bytes())
+	out.Write(nonce)
+	out.Write(aead.Seal(nil, nonce, plaintext, []byte(magic)))
+	return out.Bytes(), nil
+}
+
+// Decrypt opens a backup with the identity it was encrypted for.
+func Decrypt(identity *ecdh.PrivateKey, data []byte) (map[string][]byte, error) {
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return nil, errors.New("not a torproxy backup")
+	}
+	data = data[len(magic):]
+
+	keySize := len(identity.PublicKey().Bytes())
+	if len(data) < keySize {
+		return nil, errors.New("truncated backup")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(data[:keySize])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := identity.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := fileCipher(shared, ephemeral, identity.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	data = data[keySize:]
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("truncated backup")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(magic))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting the backup: %w", err)
+	}
+
+	files := map[string][]byte{}
+	return files, json.Unmarshal(plaintext, &files)
+}
+
+// fileCipher derives the AES-GCM cipher of a backup from the shared
+// secret of the X25519 exchange, bound to both public keys.
+func fileCipher(shared []byte, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephemeral.Bytes()...), recipient.Bytes()...)
+	key, err := hkdf.Key(sha256.New, shared, salt, info, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,201 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Get for missing objects.
+var ErrNotFound = errors.New("object not found")
+
+// ErrInvalidEndpoint is returned for endpoints which are not the http or
+// https URL of a storage, without path, credentials or query.
+var ErrInvalidEndpoint = errors.New("invalid storage endpoint")
+
+// maxObjectSize bounds what Get reads, backups are a few hundred bytes.
+const maxObjectSize = 1 << 20
+
+// noRedirects is the default client: the requests are signed for the
+// endpoint and only go there.
+var noRedirects = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// S3 is a client of the objects of a bucket of an S3 compatible storage,
+// such as MinIO, addressed in path style and signed with AWS Signature
+// Version 4.
+type S3 struct {
+	// Endpoint is the URL of the storage, e.g. http://minio.minio:9000.
+	Endpoint        string
+	Bucket          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+
+	Client *http.Client
+}
+
+// Put stores the object.
+func (s *S3) Put(ctx context.Context, key string, body []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s.error(resp)
+	}
+	return nil
+}
+
+// Get returns the content of the object, ErrNotFound if it is missing.
+func (s *S3) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxObjectSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > maxObjectSize {
+			return nil, fmt.Errorf("%s/%s: object larger than %d bytes", s.Bucket, key, maxObjectSize)
+		}
+		return body, nil
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%s/%s: %w", s.Bucket, key, ErrNotFound)
+	default:
+		return nil, s.error(resp)
+	}
+}
+
+// endpoint parses the endpoint of the storage.
+func (s *S3) endpoint() (*url.URL, error) {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEndpoint, err)
+	}
+	if (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" || endpoint.User != nil ||
+		(endpoint.Path != "" && endpoint.Path != "/") || endpoint.RawQuery != "" || endpoint.Fragment != "" {
+		return nil, fmt.Errorf("%w: %q is not an http(s)://host[:port] URL", ErrInvalidEndpoint, s.Endpoint)
+	}
+	return endpoint, nil
+}
+
+func (s *S3) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+	endpoint, err := s.endpoint()
+	if err != nil {
+		return nil, err
+	}
+	endpoint.Path = "/" + s.Bucket + "/" + key
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	payload := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+	s.sign(req, time.Now())
+
+	client := s.Client
+	if client == nil {
+		client = noRedirects
+	}
+	return client.Do(req)
+}
+
+// error reports the failed request with the S3 error code, if any. The
+// rest of the body is left out: the endpoint is set by users and may not
+// be a storage, its answers must not show up in statuses.
+func (s *S3) error(resp *http.Response) error {
+	var s3Error struct {
+		Code string `xml:"Code"`
+	}
+	_ = xml.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&s3Error)
+	if s3Error.Code == "" {
+		return fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status)
+	}
+	return fmt.Errorf("%s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, s3Error.Code)
+}
+
+// sign adds the Signature Version 4 authorization of req, covering its
+// host and headers. X-Amz-Content-Sha256 must be set.
+func (s *S3) sign(req *http.Request, now time.Time) {
+	now = now.UTC()
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format("20060102T150405Z"),
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + s.SecretAccessKey)
+	for _, part := range []string{date, s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// ObjectPrefix is the prefix of the backups of the OnionService, followed
+// by the onion address.
+func ObjectPrefix(prefix, namespace, name string) string {
+	return prefix + namespace + "/" + name + "/"
+}
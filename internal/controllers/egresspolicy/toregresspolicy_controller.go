@@ -0,0 +1,388 @@
+package egresspolicy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/controllers/torproxy"
+	"github.com/fulviodenza/torproxy/internal/webhook/sidecar"
+)
+
+const egressPolicyFinalizerName = "toregresspolicy.tor.stack.io/finalizer"
+
+var (
+	// DNSNamespace and DNSLabels select the cluster DNS pods, the selected
+	// pods are always allowed to reach them.
+	DNSNamespace = "kube-system"
+	DNSLabels    = map[string]string{"k8s-app": "kube-dns"}
+)
+
+type TorEgressPolicyReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=tor.stack.io,resources=toregresspolicies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=tor.stack.io,resources=toregresspolicies/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=tor.stack.io,resources=toregresspolicies/finalizers,verbs=update
+// +kubebuilder:rbac:groups=tor.stack.io,resources=torproxies,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+
+func (r *TorEgressPolicyReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	log := log.FromContext(ctx)
+	log.Info("got new request: ", "namespace/name", req.NamespacedName.String())
+
+	policy := &v1beta1.TorEgressPolicy{}
+	err := r.Get(ctx, req.NamespacedName, policy)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	if !policy.DeletionTimestamp.IsZero() {
+		if controllerutil.ContainsFinalizer(policy, egressPolicyFinalizerName) {
+			if err := r.cleanupNamespace(ctx, policy); err != nil {
+				return reconcile.Result{}, err
+			}
+
+			controllerutil.RemoveFinalizer(policy, egressPolicyFinalizerName)
+			if err := r.Update(ctx, policy); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+		return reconcile.Result{}, nil
+	}
+
+	if !controllerutil.ContainsFinalizer(policy, egressPolicyFinalizerName) {
+		controllerutil.AddFinalizer(policy, egressPolicyFinalizerName)
+		if err := r.Update(ctx, policy); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	// The label sends the pods of the namespace to the webhook.
+	if err := r.labelNamespace(ctx, policy.Namespace, true); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	var torProxy *v1beta1.TorProxy
+	if policy.Spec.Mode == v1beta1.EgressModeGateway {
+		torProxy, err = r.getTorProxy(ctx, policy)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	if err := r.reconcileNetworkPolicy(ctx, policy, torProxy); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{}, r.reconcileStatus(ctx, policy, torProxy)
+}
+
+func (r *TorEgressPolicyReconciler) getTorProxy(ctx context.Context, policy *v1beta1.TorEgressPolicy) (*v1beta1.TorProxy, error) {
+	key, ok := sidecar.TorProxyKey(policy)
+	if !ok {
+		return nil, nil
+	}
+
+	torProxy := &v1beta1.TorProxy{}
+	if err := r.Get(ctx, key, torProxy); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return torProxy, nil
+}
+
+func (r *TorEgressPolicyReconciler) labelNamespace(ctx context.Context, name string, enabled bool) error {
+	namespace := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name}, namespace); err != nil {
+		return err
+	}
+
+	if _, ok := namespace.Labels[v1beta1.EgressPolicyNamespaceLabel]; ok == enabled {
+		return nil
+	}
+
+	patch := client.MergeFrom(namespace.DeepCopy())
+	if enabled {
+		if namespace.Labels == nil {
+			namespace.Labels = map[string]string{}
+		}
+		namespace.Labels[v1beta1.EgressPolicyNamespaceLabel] = "true"
+	} else {
+		delete(namespace.Labels, v1beta1.EgressPolicyNamespaceLabel)
+	}
+	return r.Patch(ctx, namespace, patch)
+}
+
+// cleanupNamespace unlabels the namespace once its last policy is deleted.
+// The NetworkPolicy is garbage collected with the policy.
+func (r *TorEgressPolicyReconciler) cleanupNamespace(ctx context.Context, policy *v1beta1.TorEgressPolicy) error {
+	policyList := &v1beta1.TorEgressPolicyList{}
+	if err := r.List(ctx, policyList, client.InNamespace(policy.Namespace)); err != nil {
+		return err
+	}
+
+	for _, other := range policyList.Items {
+		if other.Name != policy.Name && other.DeletionTimestamp.IsZero() {
+			return nil
+		}
+	}
+
+	err := r.labelNamespace(ctx, policy.Namespace, false)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func dnsRule() networkingv1.NetworkPolicyEgressRule {
+	udp := corev1.ProtocolUDP
+	tcp := corev1.ProtocolTCP
+	port := intstr.FromInt32(53)
+
+	return networkingv1.NetworkPolicyEgressRule{
+		To: []networkingv1.NetworkPolicyPeer{
+			{
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{corev1.LabelMetadataName: DNSNamespace},
+				},
+				PodSelector: &metav1.LabelSelector{
+					MatchLabels: DNSLabels,
+				},
+			},
+		},
+		Ports: []networkingv1.NetworkPolicyPort{
+			{Protocol: &udp, Port: &port},
+			{Protocol: &tcp, Port: &port},
+		},
+	}
+}
+
+// gatewayRule allows the TorProxy pods, on their listeners when known.
+func gatewayRule(policy *v1beta1.TorEgressPolicy, torProxy *v1beta1.TorProxy) networkingv1.NetworkPolicyEgressRule {
+	key, _ := sidecar.TorProxyKey(policy)
+
+	rule := networkingv1.NetworkPolicyEgressRule{
+		To: []networkingv1.NetworkPolicyPeer{
+			{
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{corev1.LabelMetadataName: key.Namespace},
+				},
+				PodSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"app": key.Name},
+				},
+			},
+		},
+	}
+
+	if torProxy != nil {
+		for _, containerPort := range torproxy.ContainerPorts(torProxy) {
+			protocol := containerPort.Protocol
+			port := intstr.FromInt32(containerPort.ContainerPort)
+			rule.Ports = append(rule.Ports, networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &port})
+		}
+	}
+	return rule
+}
+
+// sidecarRule allows the tor sidecar to reach the relays, anything but the
+// cluster networks. Connections bypassing the sidecar are rejected by the
+// transparent mode rules in the pod.
+func sidecarRule() networkingv1.NetworkPolicyEgressRule {
+	var v4, v6 []string
+	for _, cidr := range sidecar.ClusterCIDRs {
+		ip, _, err := net.ParseCIDR(cidr)
+		switch {
+		case err != nil:
+			continue
+		case ip.To4() != nil:
+			v4 = append(v4, cidr)
+		default:
+			v6 = append(v6, cidr)
+		}
+	}
+
+	return networkingv1.NetworkPolicyEgressRule{
+		To: []networkingv1.NetworkPolicyPeer{
+			{IPBlock: &networkingv1.IPBlock{CIDR: "0.0.0.0/0", Except: v4}},
+			{IPBlock: &networkingv1.IPBlock{CIDR: "::/0", Except: v6}},
+		},
+	}
+}
+
+func (r *TorEgressPolicyReconciler) reconcileNetworkPolicy(ctx context.Context, policy *v1beta1.TorEgressPolicy, torProxy *v1beta1.TorProxy) error {
+	egress := []networkingv1.NetworkPolicyEgressRule{dnsRule()}
+	switch policy.Spec.Mode {
+	case v1beta1.EgressModeGateway:
+		if _, ok := sidecar.TorProxyKey(policy); ok {
+			egress = append(egress, gatewayRule(policy, torProxy))
+		}
+	case v1beta1.EgressModeSidecar:
+		egress = append(egress, sidecarRule())
+	}
+
+	networkPolicy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      policy.Name + "-tor-egress",
+			Namespace: policy.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(policy, v1beta1.GroupVersion.WithKind("TorEgressPolicy")),
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: sidecar.PodSelector(policy),
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress:      egress,
+		},
+	}
+
+	found := &networkingv1.NetworkPolicy{}
+	err := r.Get(ctx, types.NamespacedName{Name: networkPolicy.Name, Namespace: networkPolicy.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return r.Create(ctx, networkPolicy)
+	} else if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(found.Spec, networkPolicy.Spec) {
+		found.Spec = networkPolicy.Spec
+		return r.Update(ctx, found)
+	}
+
+	return nil
+}
+
+// nonCompliantReason explains why a selected pod does not egress through
+// Tor, it is empty for compliant pods. It looks at what the webhook
+// injected, not at the annotations pods can set themselves.
+func nonCompliantReason(policy *v1beta1.TorEgressPolicy, torProxy *v1beta1.TorProxy, pod *corev1.Pod) string {
+	if policy.Spec.Mode == v1beta1.EgressModeGateway {
+		if torProxy == nil || !sidecar.Wired(pod, torProxy) {
+			return "ProxyNotConfigured"
+		}
+		return ""
+	}
+	return sidecar.NonCompliantReason(pod)
+}
+
+func (r *TorEgressPolicyReconciler) reconcileStatus(ctx context.Context, policy *v1beta1.TorEgressPolicy, torProxy *v1beta1.TorProxy) error {
+	labelSelector := sidecar.PodSelector(policy)
+	selector, err := metav1.LabelSelectorAsSelector(&labelSelector)
+	if err != nil {
+		return r.updateStatus(ctx, policy, "Error", fmt.Sprintf("invalid podSelector: %v", err))
+	}
+
+	podList := &corev1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(policy.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return err
+	}
+
+	selected := int32(0)
+	nonCompliant := []v1beta1.NonCompliantPod{}
+	for _, pod := range podList.Items {
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		selected++
+		if reason := nonCompliantReason(policy, torProxy, &pod); reason != "" {
+			nonCompliant = append(nonCompliant, v1beta1.NonCompliantPod{Name: pod.Name, Reason: reason})
+		}
+	}
+	sort.Slice(nonCompliant, func(i, j int) bool { return nonCompliant[i].Name < nonCompliant[j].Name })
+
+	policy.Status.NetworkPolicyName = policy.Name + "-tor-egress"
+	policy.Status.SelectedPods = selected
+	policy.Status.NonCompliantPods = nil
+	if len(nonCompliant) > 0 {
+		policy.Status.NonCompliantPods = nonCompliant
+	}
+
+	switch {
+	case policy.Spec.Mode == v1beta1.EgressModeGateway && torProxy == nil:
+		return r.updateStatus(ctx, policy, "Pending", "TorProxy not found, direct egress is blocked")
+	case len(nonCompliant) > 0:
+		return r.updateStatus(ctx, policy, "NonCompliant",
+			fmt.Sprintf("%d/%d selected pods do not egress through Tor, recreate them", len(nonCompliant), selected))
+	}
+	return r.updateStatus(ctx, policy, "Ready", fmt.Sprintf("%d selected pods egress through Tor", selected))
+}
+
+// updateStatus updates the TorEgressPolicy status
+func (r *TorEgressPolicyReconciler) updateStatus(ctx context.Context, policy *v1beta1.TorEgressPolicy, phase, message string) error {
+	policy.Status.Phase = phase
+	policy.Status.Message = message
+
+	return r.Status().Update(ctx, policy)
+}
+
+// policiesForPod enqueues the policies of the pod namespace, so that their
+// status tracks the pods being created and deleted.
+func (r *TorEgressPolicyReconciler) policiesForPod(ctx context.Context, obj client.Object) []reconcile.Request {
+	policyList := &v1beta1.TorEgressPolicyList{}
+	if err := r.List(ctx, policyList, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(policyList.Items))
+	for _, policy := range policyList.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: policy.Name, Namespace: policy.Namespace},
+		})
+	}
+	return requests
+}
+
+// policiesForTorProxy enqueues the policies referencing the TorProxy.
+func (r *TorEgressPolicyReconciler) policiesForTorProxy(ctx context.Context, obj client.Object) []reconcile.Request {
+	policyList := &v1beta1.TorEgressPolicyList{}
+	if err := r.List(ctx, policyList); err != nil {
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, policy := range policyList.Items {
+		key, ok := sidecar.TorProxyKey(&policy)
+		if !ok || key.Name != obj.GetName() || key.Namespace != obj.GetNamespace() {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: policy.Name, Namespace: policy.Namespace},
+		})
+	}
+	return requests
+}
+
+func (r *TorEgressPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1beta1.TorEgressPolicy{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(r.policiesForPod)).
+		Watches(&v1beta1.TorProxy{}, handler.EnqueueRequestsFromMapFunc(r.policiesForTorProxy)).
+		Complete(r)
+}
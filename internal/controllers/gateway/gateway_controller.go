@@ -0,0 +1,736 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/controllers/onionservice"
+	"github.com/fulviodenza/torproxy/internal/proxy"
+)
+
+// GatewayReconciler publishes the Gateways of an onion GatewayClass as
+// onion services: each listener becomes a virtual port of an OnionService
+// forwarding to a reverse proxy programmed with the attached HTTPRoutes
+// and TCPRoutes.
+type GatewayReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gateways,verbs=get;list;watch
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gateways/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes;tcproutes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes/status;tcproutes/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=tor.stack.io,resources=onionservices,verbs=get;list;watch;create;update;patch;delete
+
+func (r *GatewayReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	gateway := &gatewayv1.Gateway{}
+	err := r.Get(ctx, req.NamespacedName, gateway)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	if !gateway.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	gatewayClass := &gatewayv1.GatewayClass{}
+	err = r.Get(ctx, types.NamespacedName{Name: string(gateway.Spec.GatewayClassName)}, gatewayClass)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	if !isOnionGatewayClass(gatewayClass) {
+		return reconcile.Result{}, nil
+	}
+
+	p := newProgram(gateway)
+
+	httpRoutes := &gatewayv1.HTTPRouteList{}
+	if err := r.List(ctx, httpRoutes); err != nil {
+		return reconcile.Result{}, err
+	}
+	for i := range httpRoutes.Items {
+		if err := r.attachHTTPRoute(ctx, p, &httpRoutes.Items[i]); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	tcpRoutes := &gatewayv1alpha2.TCPRouteList{}
+	if err := r.List(ctx, tcpRoutes); err != nil {
+		return reconcile.Result{}, err
+	}
+	for i := range tcpRoutes.Items {
+		if err := r.attachTCPRoute(ctx, p, &tcpRoutes.Items[i]); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	owner := *metav1.NewControllerRef(gateway, gatewayv1.SchemeGroupVersion.WithKind("Gateway"))
+	config := p.config()
+
+	address := ""
+	if len(config.Ports()) > 0 {
+		if err := proxy.Reconcile(ctx, r.Client, owner, gateway.Namespace, gateway.Name+"-onion-proxy", config); err != nil {
+			return reconcile.Result{}, err
+		}
+
+		onion, err := r.reconcileOnionService(ctx, gateway, owner, config.Ports())
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		address = onion.Status.OnionAddress
+	}
+
+	return reconcile.Result{}, r.reconcileStatus(ctx, gateway, p, address)
+}
+
+// program accumulates, listener by listener, the routes attached to a
+// Gateway.
+type program struct {
+	gateway   *gatewayv1.Gateway
+	http      map[gatewayv1.SectionName]*proxy.HTTPServer
+	tcp       map[gatewayv1.SectionName]*proxy.TCPServer
+	attached  map[gatewayv1.SectionName]int32
+	namespace map[string]*corev1.Namespace
+}
+
+func newProgram(gateway *gatewayv1.Gateway) *program {
+	p := &program{
+		gateway:   gateway,
+		http:      map[gatewayv1.SectionName]*proxy.HTTPServer{},
+		tcp:       map[gatewayv1.SectionName]*proxy.TCPServer{},
+		attached:  map[gatewayv1.SectionName]int32{},
+		namespace: map[string]*corev1.Namespace{},
+	}
+
+	for _, listener := range gateway.Spec.Listeners {
+		switch listener.Protocol {
+		case gatewayv1.HTTPProtocolType:
+			p.http[listener.Name] = &proxy.HTTPServer{Port: int32(listener.Port), Routes: map[string][]proxy.Route{}}
+		case gatewayv1.TCPProtocolType:
+			p.tcp[listener.Name] = &proxy.TCPServer{Port: int32(listener.Port)}
+		}
+	}
+
+	return p
+}
+
+// config returns the proxy routing table, HTTP listeners sharing a port
+// are served by the same server.
+func (p *program) config() proxy.Config {
+	config := proxy.Config{}
+	byPort := map[int32]int{}
+
+	for _, listener := range p.gateway.Spec.Listeners {
+		if server, ok := p.http[listener.Name]; ok {
+			i, ok := byPort[server.Port]
+			if !ok {
+				byPort[server.Port] = len(config.HTTP)
+				config.HTTP = append(config.HTTP, proxy.HTTPServer{Port: server.Port, Routes: map[string][]proxy.Route{}})
+				i = len(config.HTTP) - 1
+			}
+			for host, routes := range server.Routes {
+				config.HTTP[i].Routes[host] = append(config.HTTP[i].Routes[host], routes...)
+			}
+		}
+		if server, ok := p.tcp[listener.Name]; ok && len(server.Backends) > 0 {
+			config.TCP = append(config.TCP, *server)
+		}
+	}
+
+	return config
+}
+
+// listenersFor returns the listeners of the Gateway the route is allowed
+// to attach to through the parentRef, with the Accepted reason when there
+// are none.
+func (r *GatewayReconciler) listenersFor(ctx context.Context, p *program, routeNamespace string, ref gatewayv1.ParentReference, protocol gatewayv1.ProtocolType) ([]gatewayv1.Listener, gatewayv1.RouteConditionReason, error) {
+	matching := false
+	listeners := []gatewayv1.Listener{}
+	for _, listener := range p.gateway.Spec.Listeners {
+		if ref.SectionName != nil && *ref.SectionName != listener.Name {
+			continue
+		}
+		if ref.Port != nil && *ref.Port != listener.Port {
+			continue
+		}
+		matching = true
+
+		if listener.Protocol != protocol {
+			continue
+		}
+
+		allowed, err := r.namespaceAllowed(ctx, p, routeNamespace, listener)
+		if err != nil {
+			return nil, "", err
+		}
+		if allowed {
+			listeners = append(listeners, listener)
+		}
+	}
+
+	if !matching {
+		return nil, gatewayv1.RouteReasonNoMatchingParent, nil
+	}
+	if len(listeners) == 0 {
+		return nil, gatewayv1.RouteReasonNotAllowedByListeners, nil
+	}
+	return listeners, gatewayv1.RouteReasonAccepted, nil
+}
+
+func (r *GatewayReconciler) namespaceAllowed(ctx context.Context, p *program, routeNamespace string, listener gatewayv1.Listener) (bool, error) {
+	from := gatewayv1.NamespacesFromSame
+	if listener.AllowedRoutes != nil && listener.AllowedRoutes.Namespaces != nil && listener.AllowedRoutes.Namespaces.From != nil {
+		from = *listener.AllowedRoutes.Namespaces.From
+	}
+
+	switch from {
+	case gatewayv1.NamespacesFromAll:
+		return true, nil
+	case gatewayv1.NamespacesFromSelector:
+		selector, err := metav1.LabelSelectorAsSelector(listener.AllowedRoutes.Namespaces.Selector)
+		if err != nil {
+			return false, nil
+		}
+		namespace, ok := p.namespace[routeNamespace]
+		if !ok {
+			namespace = &corev1.Namespace{}
+			if err := r.Get(ctx, types.NamespacedName{Name: routeNamespace}, namespace); err != nil {
+				return false, client.IgnoreNotFound(err)
+			}
+			p.namespace[routeNamespace] = namespace
+		}
+		return selector.Matches(labels.Set(namespace.Labels)), nil
+	default:
+		return routeNamespace == p.gateway.Namespace, nil
+	}
+}
+
+// refersTo tells whether the parentRef of a route in routeNamespace is the
+// Gateway.
+func refersTo(gateway *gatewayv1.Gateway, routeNamespace string, ref gatewayv1.ParentReference) bool {
+	if ref.Kind != nil && *ref.Kind != "Gateway" {
+		return false
+	}
+	if ref.Group != nil && *ref.Group != gatewayv1.GroupName {
+		return false
+	}
+	namespace := routeNamespace
+	if ref.Namespace != nil {
+		namespace = string(*ref.Namespace)
+	}
+	return string(ref.Name) == gateway.Name && namespace == gateway.Namespace
+}
+
+// resolveBackends resolves the Service backendRefs of a route. Missing
+// Services are skipped and reported with the ResolvedRefs reason.
+func (r *GatewayReconciler) resolveBackends(ctx context.Context, routeNamespace string, refs []gatewayv1.BackendRef) ([]proxy.Backend, gatewayv1.RouteConditionReason, string, error) {
+	backends := []proxy.Backend{}
+	reason := gatewayv1.RouteReasonResolvedRefs
+	message := "All references resolved"
+
+	for _, ref := range refs {
+		if (ref.Group != nil && *ref.Group != "") || (ref.Kind != nil && *ref.Kind != "Service") {
+			reason, message = gatewayv1.RouteReasonInvalidKind, fmt.Sprintf("backend %s is not a Service", ref.Name)
+			continue
+		}
+		if ref.Namespace != nil && string(*ref.Namespace) != routeNamespace {
+			reason, message = gatewayv1.RouteReasonRefNotPermitted, fmt.Sprintf("backend %s is in another namespace", ref.Name)
+			continue
+		}
+		if ref.Port == nil {
+			reason, message = gatewayv1.RouteReasonUnsupportedValue, fmt.Sprintf("backend %s has no port", ref.Name)
+			continue
+		}
+
+		service := &corev1.Service{}
+		err := r.Get(ctx, types.NamespacedName{Name: string(ref.Name), Namespace: routeNamespace}, service)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				reason, message = gatewayv1.RouteReasonBackendNotFound, fmt.Sprintf("Service %s not found", ref.Name)
+				continue
+			}
+			return nil, "", "", err
+		}
+
+		weight := int32(1)
+		if ref.Weight != nil {
+			weight = *ref.Weight
+		}
+		if weight == 0 {
+			continue
+		}
+
+		backends = append(backends, proxy.Backend{
+			Address: fmt.Sprintf("%s.%s.svc.%s:%d", service.Name, service.Namespace, onionservice.ClusterDomain, *ref.Port),
+			Weight:  weight,
+		})
+	}
+
+	return backends, reason, message, nil
+}
+
+func (r *GatewayReconciler) attachHTTPRoute(ctx context.Context, p *program, route *gatewayv1.HTTPRoute) error {
+	dropped := droppedRules(route)
+	parents, err := r.parentStatuses(ctx, p, route.Namespace, route.Generation, route.Spec.ParentRefs, route.Status.Parents, gatewayv1.HTTPProtocolType,
+		func(listener gatewayv1.Listener) (gatewayv1.RouteConditionReason, string, error) {
+			server := p.http[listener.Name]
+
+			hostnames := []string{}
+			for _, hostname := range route.Spec.Hostnames {
+				hostnames = append(hostnames, string(hostname))
+			}
+			if len(hostnames) == 0 && listener.Hostname != nil {
+				hostnames = append(hostnames, string(*listener.Hostname))
+			}
+			if len(hostnames) == 0 {
+				hostnames = append(hostnames, "")
+			}
+
+			reason, message := gatewayv1.RouteReasonResolvedRefs, "All references resolved"
+			for i, rule := range route.Spec.Rules {
+				if dropped[i] != nil {
+					continue
+				}
+				refs := []gatewayv1.BackendRef{}
+				for _, ref := range rule.BackendRefs {
+					refs = append(refs, ref.BackendRef)
+				}
+				backends, ruleReason, ruleMessage, err := r.resolveBackends(ctx, route.Namespace, refs)
+				if err != nil {
+					return "", "", err
+				}
+				if ruleReason != gatewayv1.RouteReasonResolvedRefs {
+					reason, message = ruleReason, ruleMessage
+				}
+
+				for _, path := range rulePaths(rule) {
+					path.Backends = backends
+					for _, hostname := range hostnames {
+						server.Routes[hostname] = append(server.Routes[hostname], path)
+					}
+				}
+			}
+			return reason, message, nil
+		})
+	if err != nil {
+		return err
+	}
+	reportDroppedRules(p.gateway, route, parents, dropped)
+
+	if reflect.DeepEqual(parents, route.Status.Parents) {
+		return nil
+	}
+	route.Status.Parents = parents
+	return r.Status().Update(ctx, route)
+}
+
+// droppedRules returns, by index, why the rules of the route with a path
+// the proxy cannot route are dropped.
+func droppedRules(route *gatewayv1.HTTPRoute) map[int]error {
+	dropped := map[int]error{}
+	for i, rule := range route.Spec.Rules {
+		for _, path := range rulePaths(rule) {
+			if err := path.Validate(); err != nil {
+				dropped[i] = err
+				break
+			}
+		}
+	}
+	return dropped
+}
+
+// reportDroppedRules sets the PartiallyInvalid condition of the parents
+// which accepted the route when some of its rules are dropped. A route
+// whose rules are all dropped is not accepted.
+func reportDroppedRules(gateway *gatewayv1.Gateway, route *gatewayv1.HTTPRoute, parents []gatewayv1.RouteParentStatus, dropped map[int]error) {
+	messages := []string{}
+	for i := range route.Spec.Rules {
+		if err := dropped[i]; err != nil {
+			messages = append(messages, fmt.Sprintf("rule %d: %v", i, err))
+		}
+	}
+
+	for i := range parents {
+		status := &parents[i]
+		if status.ControllerName != ControllerName || !refersTo(gateway, route.Namespace, status.ParentRef) {
+			continue
+		}
+
+		accepted := meta.IsStatusConditionTrue(status.Conditions, string(gatewayv1.RouteConditionAccepted))
+		if len(messages) == 0 || len(messages) == len(route.Spec.Rules) || !accepted {
+			meta.RemoveStatusCondition(&status.Conditions, string(gatewayv1.RouteConditionPartiallyInvalid))
+		}
+		if len(messages) == 0 || !accepted {
+			continue
+		}
+
+		if len(messages) == len(route.Spec.Rules) {
+			condition := metav1.Condition{
+				Type:               string(gatewayv1.RouteConditionAccepted),
+				Status:             metav1.ConditionFalse,
+				Reason:             string(gatewayv1.RouteReasonUnsupportedValue),
+				Message:            "Every rule is invalid: " + strings.Join(messages, ", "),
+				ObservedGeneration: route.Generation,
+			}
+			// parentStatuses accepted the route again, the transition
+			// time is kept to not update the status every time.
+			for _, previous := range route.Status.Parents {
+				if previous.ControllerName != ControllerName || !reflect.DeepEqual(previous.ParentRef, status.ParentRef) {
+					continue
+				}
+				if c := meta.FindStatusCondition(previous.Conditions, condition.Type); c != nil && c.Status == condition.Status {
+					condition.LastTransitionTime = c.LastTransitionTime
+				}
+			}
+			meta.SetStatusCondition(&status.Conditions, condition)
+			continue
+		}
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               string(gatewayv1.RouteConditionPartiallyInvalid),
+			Status:             metav1.ConditionTrue,
+			Reason:             string(gatewayv1.RouteReasonUnsupportedValue),
+			Message:            "Dropped Rule " + strings.Join(messages, ", "),
+			ObservedGeneration: route.Generation,
+		})
+	}
+}
+
+// rulePaths returns the path matches of an HTTPRoute rule, header and
+// query matches are not supported by the proxy.
+func rulePaths(rule gatewayv1.HTTPRouteRule) []proxy.Route {
+	paths := []proxy.Route{}
+	for _, match := range rule.Matches {
+		if match.Path == nil || match.Path.Value == nil {
+			continue
+		}
+
+		path := proxy.Route{Path: *match.Path.Value, PathType: proxy.PathPrefix}
+		if match.Path.Type != nil {
+			switch *match.Path.Type {
+			case gatewayv1.PathMatchExact:
+				path.PathType = proxy.PathExact
+			case gatewayv1.PathMatchRegularExpression:
+				path.PathType = proxy.PathRegularExpression
+			}
+		}
+		paths = append(paths, path)
+	}
+
+	if len(paths) == 0 {
+		paths = append(paths, proxy.Route{Path: "/", PathType: proxy.PathPrefix})
+	}
+	return paths
+}
+
+func (r *GatewayReconciler) attachTCPRoute(ctx context.Context, p *program, route *gatewayv1alpha2.TCPRoute) error {
+	parents, err := r.parentStatuses(ctx, p, route.Namespace, route.Generation, route.Spec.ParentRefs, route.Status.Parents, gatewayv1.TCPProtocolType,
+		func(listener gatewayv1.Listener) (gatewayv1.RouteConditionReason, string, error) {
+			server := p.tcp[listener.Name]
+
+			reason, message := gatewayv1.RouteReasonResolvedRefs, "All references resolved"
+			for _, rule := range route.Spec.Rules {
+				backends, ruleReason, ruleMessage, err := r.resolveBackends(ctx, route.Namespace, rule.BackendRefs)
+				if err != nil {
+					return "", "", err
+				}
+				if ruleReason != gatewayv1.RouteReasonResolvedRefs {
+					reason, message = ruleReason, ruleMessage
+				}
+				server.Backends = append(server.Backends, backends...)
+			}
+			return reason, message, nil
+		})
+	if err != nil {
+		return err
+	}
+
+	if reflect.DeepEqual(parents, route.Status.Parents) {
+		return nil
+	}
+	route.Status.Parents = parents
+	return r.Status().Update(ctx, route)
+}
+
+// parentStatuses attaches a route to the listeners of the Gateway through
+// attach and returns the route parent statuses updated accordingly.
+func (r *GatewayReconciler) parentStatuses(
+	ctx context.Context,
+	p *program,
+	routeNamespace string,
+	generation int64,
+	refs []gatewayv1.ParentReference,
+	current []gatewayv1.RouteParentStatus,
+	protocol gatewayv1.ProtocolType,
+	attach func(listener gatewayv1.Listener) (gatewayv1.RouteConditionReason, string, error),
+) ([]gatewayv1.RouteParentStatus, error) {
+	var parents []gatewayv1.RouteParentStatus
+	for i := range current {
+		parents = append(parents, *current[i].DeepCopy())
+	}
+
+	for _, ref := range refs {
+		if !refersTo(p.gateway, routeNamespace, ref) {
+			continue
+		}
+
+		listeners, acceptedReason, err := r.listenersFor(ctx, p, routeNamespace, ref, protocol)
+		if err != nil {
+			return nil, err
+		}
+
+		resolvedReason, resolvedMessage := gatewayv1.RouteReasonResolvedRefs, "All references resolved"
+		for _, listener := range listeners {
+			p.attached[listener.Name]++
+			resolvedReason, resolvedMessage, err = attach(listener)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		status := parentStatus(&parents, ref)
+		status.ControllerName = ControllerName
+
+		accepted := metav1.ConditionTrue
+		if acceptedReason != gatewayv1.RouteReasonAccepted {
+			accepted = metav1.ConditionFalse
+		}
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               string(gatewayv1.RouteConditionAccepted),
+			Status:             accepted,
+			Reason:             string(acceptedReason),
+			ObservedGeneration: generation,
+		})
+
+		resolved := metav1.ConditionTrue
+		if resolvedReason != gatewayv1.RouteReasonResolvedRefs {
+			resolved = metav1.ConditionFalse
+		}
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               string(gatewayv1.RouteConditionResolvedRefs),
+			Status:             resolved,
+			Reason:             string(resolvedReason),
+			Message:            resolvedMessage,
+			ObservedGeneration: generation,
+		})
+	}
+
+	return parents, nil
+}
+
+// parentStatus returns the status entry of the parentRef, adding it when
+// missing.
+func parentStatus(parents *[]gatewayv1.RouteParentStatus, ref gatewayv1.ParentReference) *gatewayv1.RouteParentStatus {
+	for i := range *parents {
+		if (*parents)[i].ControllerName == ControllerName && reflect.DeepEqual((*parents)[i].ParentRef, ref) {
+			return &(*parents)[i]
+		}
+	}
+	*parents = append(*parents, gatewayv1.RouteParentStatus{ParentRef: ref})
+	return &(*parents)[len(*parents)-1]
+}
+
+func (r *GatewayReconciler) reconcileOnionService(ctx context.Context, gateway *gatewayv1.Gateway, owner metav1.OwnerReference, ports []int32) (*v1beta1.OnionService, error) {
+	onionPorts := []v1beta1.OnionServicePort{}
+	for _, port := range ports {
+		onionPorts = append(onionPorts, v1beta1.OnionServicePort{
+			Port:       port,
+			TargetPort: intstr.FromInt32(port),
+		})
+	}
+
+	onion := &v1beta1.OnionService{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            gateway.Name + "-onion",
+			Namespace:       gateway.Namespace,
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		Spec: v1beta1.OnionServiceSpec{
+			SOCKSPort: 9050,
+			Backend: &v1beta1.OnionServiceBackend{
+				ServiceRef: &v1beta1.ServiceReference{
+					Name: gateway.Name + "-onion-proxy",
+				},
+			},
+			Ports: onionPorts,
+		},
+	}
+
+	found := &v1beta1.OnionService{}
+	err := r.Get(ctx, types.NamespacedName{Name: onion.Name, Namespace: onion.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return onion, r.Create(ctx, onion)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !reflect.DeepEqual(found.Spec, onion.Spec) {
+		found.Spec = onion.Spec
+		return found, r.Update(ctx, found)
+	}
+
+	return found, nil
+}
+
+// reconcileStatus reports the onion address of the Gateway and the state
+// of its listeners.
+func (r *GatewayReconciler) reconcileStatus(ctx context.Context, gateway *gatewayv1.Gateway, p *program, address string) error {
+	status := gateway.Status.DeepCopy()
+
+	status.Addresses = nil
+	if address != "" {
+		hostname := gatewayv1.HostnameAddressType
+		status.Addresses = []gatewayv1.GatewayStatusAddress{
+			{Type: &hostname, Value: address},
+		}
+	}
+
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               string(gatewayv1.GatewayConditionAccepted),
+		Status:             metav1.ConditionTrue,
+		Reason:             string(gatewayv1.GatewayReasonAccepted),
+		ObservedGeneration: gateway.Generation,
+	})
+
+	programmed := metav1.Condition{
+		Type:               string(gatewayv1.GatewayConditionProgrammed),
+		Status:             metav1.ConditionTrue,
+		Reason:             string(gatewayv1.GatewayReasonProgrammed),
+		ObservedGeneration: gateway.Generation,
+	}
+	if address == "" {
+		programmed.Status = metav1.ConditionFalse
+		programmed.Reason = string(gatewayv1.GatewayReasonAddressNotAssigned)
+		programmed.Message = "Waiting for the onion address"
+	}
+	meta.SetStatusCondition(&status.Conditions, programmed)
+
+	listeners := []gatewayv1.ListenerStatus{}
+	for _, listener := range gateway.Spec.Listeners {
+		listenerStatus := gatewayv1.ListenerStatus{Name: listener.Name}
+		for _, current := range status.Listeners {
+			if current.Name == listener.Name {
+				listenerStatus.Conditions = current.Conditions
+			}
+		}
+
+		accepted := metav1.Condition{
+			Type:               string(gatewayv1.ListenerConditionAccepted),
+			Status:             metav1.ConditionTrue,
+			Reason:             string(gatewayv1.ListenerReasonAccepted),
+			ObservedGeneration: gateway.Generation,
+		}
+		switch listener.Protocol {
+		case gatewayv1.HTTPProtocolType:
+			listenerStatus.SupportedKinds = []gatewayv1.RouteGroupKind{{Kind: "HTTPRoute"}}
+		case gatewayv1.TCPProtocolType:
+			listenerStatus.SupportedKinds = []gatewayv1.RouteGroupKind{{Kind: "TCPRoute"}}
+		default:
+			listenerStatus.SupportedKinds = []gatewayv1.RouteGroupKind{}
+			accepted.Status = metav1.ConditionFalse
+			accepted.Reason = string(gatewayv1.ListenerReasonUnsupportedProtocol)
+			accepted.Message = fmt.Sprintf("protocol %s is not supported by onion gateways", listener.Protocol)
+		}
+		meta.SetStatusCondition(&listenerStatus.Conditions, accepted)
+
+		listenerProgrammed := metav1.Condition{
+			Type:               string(gatewayv1.ListenerConditionProgrammed),
+			Status:             accepted.Status,
+			Reason:             string(gatewayv1.ListenerReasonProgrammed),
+			ObservedGeneration: gateway.Generation,
+		}
+		if accepted.Status == metav1.ConditionFalse {
+			listenerProgrammed.Reason = string(gatewayv1.ListenerReasonInvalid)
+		}
+		meta.SetStatusCondition(&listenerStatus.Conditions, listenerProgrammed)
+
+		listenerStatus.AttachedRoutes = p.attached[listener.Name]
+		listeners = append(listeners, listenerStatus)
+	}
+	status.Listeners = listeners
+
+	if reflect.DeepEqual(*status, gateway.Status) {
+		return nil
+	}
+	gateway.Status = *status
+	return r.Status().Update(ctx, gateway)
+}
+
+// gatewaysForRoute enqueues the Gateways referenced by a route.
+func gatewaysForRoute(refs func(client.Object) []gatewayv1.ParentReference) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		requests := []reconcile.Request{}
+		for _, ref := range refs(obj) {
+			if ref.Kind != nil && *ref.Kind != "Gateway" {
+				continue
+			}
+			namespace := obj.GetNamespace()
+			if ref.Namespace != nil {
+				namespace = string(*ref.Namespace)
+			}
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: string(ref.Name), Namespace: namespace},
+			})
+		}
+		return requests
+	}
+}
+
+// allGateways enqueues every Gateway, backend Services can be referenced
+// by routes attached to any of them.
+func (r *GatewayReconciler) allGateways(ctx context.Context, obj client.Object) []reconcile.Request {
+	gateways := &gatewayv1.GatewayList{}
+	if err := r.List(ctx, gateways); err != nil {
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, gateway := range gateways.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: gateway.Name, Namespace: gateway.Namespace},
+		})
+	}
+	return requests
+}
+
+func (r *GatewayReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&gatewayv1.Gateway{}).
+		Owns(&v1beta1.OnionService{}).
+		Watches(&gatewayv1.HTTPRoute{}, handler.EnqueueRequestsFromMapFunc(gatewaysForRoute(func(obj client.Object) []gatewayv1.ParentReference {
+			return obj.(*gatewayv1.HTTPRoute).Spec.ParentRefs
+		}))).
+		Watches(&gatewayv1alpha2.TCPRoute{}, handler.EnqueueRequestsFromMapFunc(gatewaysForRoute(func(obj client.Object) []gatewayv1.ParentReference {
+			return obj.(*gatewayv1alpha2.TCPRoute).Spec.ParentRefs
+		}))).
+		Watches(&corev1.Service{}, handler.EnqueueRequestsFromMapFunc(r.allGateways)).
+		Complete(r)
+}
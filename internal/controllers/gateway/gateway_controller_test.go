@@ -0,0 +1,278 @@
+package gateway
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+var _ = Describe("onion Gateway", Ordered, func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "onion-gateway", Namespace: "default"}
+
+	BeforeAll(func() {
+		Expect(k8sClient.Create(ctx, &gatewayv1.GatewayClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "onion"},
+			Spec:       gatewayv1.GatewayClassSpec{ControllerName: ControllerName},
+		})).To(Succeed())
+
+		Expect(k8sClient.Create(ctx, &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: key.Namespace},
+			Spec: corev1.ServiceSpec{
+				Ports: []corev1.ServicePort{{Name: "http", Port: 8080}},
+			},
+		})).To(Succeed())
+
+		Expect(k8sClient.Create(ctx, &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "ssh", Namespace: key.Namespace},
+			Spec: corev1.ServiceSpec{
+				Ports: []corev1.ServicePort{{Name: "ssh", Port: 22}},
+			},
+		})).To(Succeed())
+
+		Expect(k8sClient.Create(ctx, &gatewayv1.Gateway{
+			ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+			Spec: gatewayv1.GatewaySpec{
+				GatewayClassName: "onion",
+				Listeners: []gatewayv1.Listener{
+					{Name: "http", Protocol: gatewayv1.HTTPProtocolType, Port: 80},
+					{Name: "ssh", Protocol: gatewayv1.TCPProtocolType, Port: 22},
+					{Name: "dns", Protocol: gatewayv1.UDPProtocolType, Port: 53},
+				},
+			},
+		})).To(Succeed())
+
+		Expect(k8sClient.Create(ctx, &gatewayv1.HTTPRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: key.Namespace},
+			Spec: gatewayv1.HTTPRouteSpec{
+				CommonRouteSpec: gatewayv1.CommonRouteSpec{
+					ParentRefs: []gatewayv1.ParentReference{{Name: gatewayv1.ObjectName(key.Name)}},
+				},
+				Rules: []gatewayv1.HTTPRouteRule{
+					{
+						Matches: []gatewayv1.HTTPRouteMatch{
+							{Path: &gatewayv1.HTTPPathMatch{Type: ptr.To(gatewayv1.PathMatchPathPrefix), Value: ptr.To("/api")}},
+						},
+						BackendRefs: []gatewayv1.HTTPBackendRef{
+							{BackendRef: gatewayv1.BackendRef{BackendObjectReference: gatewayv1.BackendObjectReference{
+								Name: "web", Port: ptr.To(gatewayv1.PortNumber(8080)),
+							}}},
+						},
+					},
+				},
+			},
+		})).To(Succeed())
+
+		Expect(k8sClient.Create(ctx, &gatewayv1alpha2.TCPRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "ssh", Namespace: key.Namespace},
+			Spec: gatewayv1alpha2.TCPRouteSpec{
+				CommonRouteSpec: gatewayv1.CommonRouteSpec{
+					ParentRefs: []gatewayv1.ParentReference{{Name: gatewayv1.ObjectName(key.Name), SectionName: ptr.To(gatewayv1.SectionName("ssh"))}},
+				},
+				Rules: []gatewayv1alpha2.TCPRouteRule{
+					{BackendRefs: []gatewayv1.BackendRef{{BackendObjectReference: gatewayv1.BackendObjectReference{
+						Name: "ssh", Port: ptr.To(gatewayv1.PortNumber(22)),
+					}}}},
+				},
+			},
+		})).To(Succeed())
+	})
+
+	It("accepts the GatewayClass", func() {
+		Eventually(func(g Gomega) {
+			gatewayClass := &gatewayv1.GatewayClass{}
+			g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "onion"}, gatewayClass)).To(Succeed())
+			g.Expect(meta.IsStatusConditionTrue(gatewayClass.Status.Conditions, string(gatewayv1.GatewayClassConditionStatusAccepted))).To(BeTrue())
+		}, timeout, interval).Should(Succeed())
+	})
+
+	It("maps the listeners to the OnionService ports", func() {
+		Eventually(func(g Gomega) {
+			onion := &v1beta1.OnionService{}
+			g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: key.Name + "-onion", Namespace: key.Namespace}, onion)).To(Succeed())
+			g.Expect(onion.Spec.Backend.ServiceRef.Name).To(Equal(key.Name + "-onion-proxy"))
+
+			ports := []int32{}
+			for _, port := range onion.Spec.Ports {
+				ports = append(ports, port.Port)
+			}
+			g.Expect(ports).To(ConsistOf(int32(80), int32(22)))
+		}, timeout, interval).Should(Succeed())
+	})
+
+	It("programs the proxy with the route backends", func() {
+		Eventually(func(g Gomega) {
+			cm := &corev1.ConfigMap{}
+			g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: key.Name + "-onion-proxy", Namespace: key.Namespace}, cm)).To(Succeed())
+			g.Expect(cm.Data["nginx.conf"]).To(ContainSubstring("location /api {"))
+			g.Expect(cm.Data["nginx.conf"]).To(ContainSubstring("proxy_pass http://web.default.svc.cluster.local:8080;"))
+			g.Expect(cm.Data["nginx.conf"]).To(ContainSubstring("listen 22;"))
+			g.Expect(cm.Data["nginx.conf"]).To(ContainSubstring("proxy_pass ssh.default.svc.cluster.local:22;"))
+		}, timeout, interval).Should(Succeed())
+	})
+
+	It("accepts the attached routes", func() {
+		Eventually(func(g Gomega) {
+			route := &gatewayv1.HTTPRoute{}
+			g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "web", Namespace: key.Namespace}, route)).To(Succeed())
+			g.Expect(route.Status.Parents).To(HaveLen(1))
+			g.Expect(route.Status.Parents[0].ControllerName).To(Equal(ControllerName))
+			g.Expect(meta.IsStatusConditionTrue(route.Status.Parents[0].Conditions, string(gatewayv1.RouteConditionAccepted))).To(BeTrue())
+			g.Expect(meta.IsStatusConditionTrue(route.Status.Parents[0].Conditions, string(gatewayv1.RouteConditionResolvedRefs))).To(BeTrue())
+		}, timeout, interval).Should(Succeed())
+
+		Eventually(func(g Gomega) {
+			route := &gatewayv1alpha2.TCPRoute{}
+			g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "ssh", Namespace: key.Namespace}, route)).To(Succeed())
+			g.Expect(route.Status.Parents).To(HaveLen(1))
+			g.Expect(meta.IsStatusConditionTrue(route.Status.Parents[0].Conditions, string(gatewayv1.RouteConditionAccepted))).To(BeTrue())
+		}, timeout, interval).Should(Succeed())
+	})
+
+	It("reports missing backends", func() {
+		Expect(k8sClient.Create(ctx, &gatewayv1.HTTPRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "missing", Namespace: key.Namespace},
+			Spec: gatewayv1.HTTPRouteSpec{
+				CommonRouteSpec: gatewayv1.CommonRouteSpec{
+					ParentRefs: []gatewayv1.ParentReference{{Name: gatewayv1.ObjectName(key.Name)}},
+				},
+				Rules: []gatewayv1.HTTPRouteRule{
+					{BackendRefs: []gatewayv1.HTTPBackendRef{
+						{BackendRef: gatewayv1.BackendRef{BackendObjectReference: gatewayv1.BackendObjectReference{
+							Name: "missing", Port: ptr.To(gatewayv1.PortNumber(80)),
+						}}},
+					}},
+				},
+			},
+		})).To(Succeed())
+
+		Eventually(func(g Gomega) {
+			route := &gatewayv1.HTTPRoute{}
+			g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "missing", Namespace: key.Namespace}, route)).To(Succeed())
+			g.Expect(route.Status.Parents).To(HaveLen(1))
+			condition := meta.FindStatusCondition(route.Status.Parents[0].Conditions, string(gatewayv1.RouteConditionResolvedRefs))
+			g.Expect(condition).NotTo(BeNil())
+			g.Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			g.Expect(condition.Reason).To(Equal(string(gatewayv1.RouteReasonBackendNotFound)))
+		}, timeout, interval).Should(Succeed())
+	})
+
+	It("reports the listeners and the onion address", func() {
+		onion := &v1beta1.OnionService{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: key.Name + "-onion", Namespace: key.Namespace}, onion)).To(Succeed())
+		onion.Status.OnionAddress = "example.onion"
+		onion.Status.Phase = "Ready"
+		Expect(k8sClient.Status().Update(ctx, onion)).To(Succeed())
+
+		Eventually(func(g Gomega) {
+			gateway := &gatewayv1.Gateway{}
+			g.Expect(k8sClient.Get(ctx, key, gateway)).To(Succeed())
+			g.Expect(gateway.Status.Addresses).To(HaveLen(1))
+			g.Expect(gateway.Status.Addresses[0].Value).To(Equal("example.onion"))
+			g.Expect(meta.IsStatusConditionTrue(gateway.Status.Conditions, string(gatewayv1.GatewayConditionProgrammed))).To(BeTrue())
+
+			g.Expect(gateway.Status.Listeners).To(HaveLen(3))
+			for _, listener := range gateway.Status.Listeners {
+				accepted := meta.FindStatusCondition(listener.Conditions, string(gatewayv1.ListenerConditionAccepted))
+				g.Expect(accepted).NotTo(BeNil())
+				switch listener.Name {
+				case "http":
+					g.Expect(listener.AttachedRoutes).To(Equal(int32(2)))
+				case "ssh":
+					g.Expect(listener.AttachedRoutes).To(Equal(int32(1)))
+				case "dns":
+					g.Expect(accepted.Status).To(Equal(metav1.ConditionFalse))
+					g.Expect(accepted.Reason).To(Equal(string(gatewayv1.ListenerReasonUnsupportedProtocol)))
+				}
+			}
+		}, timeout, interval).Should(Succeed())
+	})
+
+	It("drops the rules the proxy cannot route", func() {
+		typedRule := func(pathType gatewayv1.PathMatchType, path string) gatewayv1.HTTPRouteRule {
+			return gatewayv1.HTTPRouteRule{
+				Matches: []gatewayv1.HTTPRouteMatch{
+					{Path: &gatewayv1.HTTPPathMatch{Type: ptr.To(pathType), Value: ptr.To(path)}},
+				},
+				BackendRefs: []gatewayv1.HTTPBackendRef{
+					{BackendRef: gatewayv1.BackendRef{BackendObjectReference: gatewayv1.BackendObjectReference{
+						Name: "web", Port: ptr.To(gatewayv1.PortNumber(8080)),
+					}}},
+				},
+			}
+		}
+		rule := func(path string) gatewayv1.HTTPRouteRule {
+			return typedRule(gatewayv1.PathMatchPathPrefix, path)
+		}
+		Expect(k8sClient.Create(ctx, &gatewayv1.HTTPRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "partial", Namespace: key.Namespace},
+			Spec: gatewayv1.HTTPRouteSpec{
+				CommonRouteSpec: gatewayv1.CommonRouteSpec{
+					ParentRefs: []gatewayv1.ParentReference{{Name: gatewayv1.ObjectName(key.Name)}},
+				},
+				Rules: []gatewayv1.HTTPRouteRule{
+					rule("/shop"),
+					rule("/evil;return"),
+					typedRule(gatewayv1.PathMatchRegularExpression, `^/items/\d+$`),
+					typedRule(gatewayv1.PathMatchRegularExpression, "^/(evil"),
+				},
+			},
+		})).To(Succeed())
+
+		Eventually(func(g Gomega) {
+			route := &gatewayv1.HTTPRoute{}
+			g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "partial", Namespace: key.Namespace}, route)).To(Succeed())
+			g.Expect(route.Status.Parents).To(HaveLen(1))
+			g.Expect(meta.IsStatusConditionTrue(route.Status.Parents[0].Conditions, string(gatewayv1.RouteConditionAccepted))).To(BeTrue())
+			condition := meta.FindStatusCondition(route.Status.Parents[0].Conditions, string(gatewayv1.RouteConditionPartiallyInvalid))
+			g.Expect(condition).NotTo(BeNil())
+			g.Expect(condition.Reason).To(Equal(string(gatewayv1.RouteReasonUnsupportedValue)))
+			g.Expect(condition.Message).To(HavePrefix("Dropped Rule rule 1: "))
+			g.Expect(condition.Message).To(ContainSubstring("rule 3: invalid regular expression"))
+
+			cm := &corev1.ConfigMap{}
+			g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: key.Name + "-onion-proxy", Namespace: key.Namespace}, cm)).To(Succeed())
+			g.Expect(cm.Data["nginx.conf"]).To(ContainSubstring("location /shop {"))
+			g.Expect(cm.Data["nginx.conf"]).To(ContainSubstring(`location ~ "^/items/\\d+$" {`))
+			g.Expect(cm.Data["nginx.conf"]).NotTo(ContainSubstring("evil"))
+		}, timeout, interval).Should(Succeed())
+
+		Expect(k8sClient.Create(ctx, &gatewayv1.HTTPRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "invalid", Namespace: key.Namespace},
+			Spec: gatewayv1.HTTPRouteSpec{
+				CommonRouteSpec: gatewayv1.CommonRouteSpec{
+					ParentRefs: []gatewayv1.ParentReference{{Name: gatewayv1.ObjectName(key.Name)}},
+				},
+				Rules: []gatewayv1.HTTPRouteRule{rule("/evil;return")},
+			},
+		})).To(Succeed())
+
+		Eventually(func(g Gomega) {
+			route := &gatewayv1.HTTPRoute{}
+			g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "invalid", Namespace: key.Namespace}, route)).To(Succeed())
+			g.Expect(route.Status.Parents).To(HaveLen(1))
+			accepted := meta.FindStatusCondition(route.Status.Parents[0].Conditions, string(gatewayv1.RouteConditionAccepted))
+			g.Expect(accepted).NotTo(BeNil())
+			g.Expect(accepted.Status).To(Equal(metav1.ConditionFalse))
+			g.Expect(accepted.Reason).To(Equal(string(gatewayv1.RouteReasonUnsupportedValue)))
+			g.Expect(meta.FindStatusCondition(route.Status.Parents[0].Conditions, string(gatewayv1.RouteConditionPartiallyInvalid))).To(BeNil())
+		}, timeout, interval).Should(Succeed())
+	})
+})
@@ -0,0 +1,63 @@
+package gateway
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+// ControllerName is the controllerName of the GatewayClasses handled by
+// the operator.
+const ControllerName gatewayv1.GatewayController = "tor.stack.io/onion"
+
+// GatewayClassReconciler accepts the GatewayClasses of ControllerName.
+type GatewayClassReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gatewayclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gatewayclasses/status,verbs=get;update;patch
+
+func (r *GatewayClassReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	gatewayClass := &gatewayv1.GatewayClass{}
+	err := r.Get(ctx, req.NamespacedName, gatewayClass)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	changed := meta.SetStatusCondition(&gatewayClass.Status.Conditions, metav1.Condition{
+		Type:               string(gatewayv1.GatewayClassConditionStatusAccepted),
+		Status:             metav1.ConditionTrue,
+		Reason:             string(gatewayv1.GatewayClassReasonAccepted),
+		Message:            "GatewayClass is handled by the onion controller",
+		ObservedGeneration: gatewayClass.Generation,
+	})
+	if !changed {
+		return reconcile.Result{}, nil
+	}
+
+	return reconcile.Result{}, r.Status().Update(ctx, gatewayClass)
+}
+
+func isOnionGatewayClass(obj client.Object) bool {
+	return obj.(*gatewayv1.GatewayClass).Spec.ControllerName == ControllerName
+}
+
+func (r *GatewayClassReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&gatewayv1.GatewayClass{}, builder.WithPredicates(predicate.NewPredicateFuncs(isOnionGatewayClass))).
+		Complete(r)
+}
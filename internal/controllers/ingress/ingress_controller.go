@@ -0,0 +1,287 @@
+package ingress
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/controllers/onionservice"
+	"github.com/fulviodenza/torproxy/internal/proxy"
+)
+
+// IngressReconciler publishes Ingresses of class "onion" as onion
+// services: an OnionService forwards to a reverse proxy implementing the
+// host and path routing of the Ingress rules.
+type IngressReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// IngressClassName is the class of the Ingresses handled by the controller.
+var IngressClassName = "onion"
+
+const (
+	proxyPort = 80
+
+	legacyIngressClassAnnotation = "kubernetes.io/ingress.class"
+)
+
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=tor.stack.io,resources=onionservices,verbs=get;list;watch;create;update;patch;delete
+
+func (r *IngressReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ingress := &networkingv1.Ingress{}
+	err := r.Get(ctx, req.NamespacedName, ingress)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	// Owned objects are garbage collected with the Ingress.
+	if !ingress.DeletionTimestamp.IsZero() || !isOnionIngress(ingress) {
+		return reconcile.Result{}, nil
+	}
+
+	config, rejected, err := r.proxyConfig(ctx, ingress)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if err := r.reconcileRejectedPaths(ctx, ingress, rejected); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	owner := *metav1.NewControllerRef(ingress, networkingv1.SchemeGroupVersion.WithKind("Ingress"))
+	if err := proxy.Reconcile(ctx, r.Client, owner, ingress.Namespace, ingress.Name+"-onion-proxy", config); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	onion, err := r.reconcileOnionService(ctx, ingress)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{}, r.reconcileStatus(ctx, ingress, onion)
+}
+
+func isOnionIngress(ingress *networkingv1.Ingress) bool {
+	if ingress.Spec.IngressClassName != nil {
+		return *ingress.Spec.IngressClassName == IngressClassName
+	}
+	return ingress.Annotations[legacyIngressClassAnnotation] == IngressClassName
+}
+
+// proxyConfig resolves the Ingress backends into the proxy routing table.
+// Backends whose Service or port are missing are skipped until they show up.
+// Paths the proxy cannot route are skipped and returned.
+func (r *IngressReconciler) proxyConfig(ctx context.Context, ingress *networkingv1.Ingress) (proxy.Config, []string, error) {
+	server := proxy.HTTPServer{
+		Port:   proxyPort,
+		Routes: map[string][]proxy.Route{},
+	}
+
+	if ingress.Spec.DefaultBackend != nil {
+		backend, err := r.resolveBackend(ctx, ingress.Namespace, ingress.Spec.DefaultBackend)
+		if err != nil {
+			return proxy.Config{}, nil, err
+		}
+		if backend != "" {
+			server.DefaultBackends = []proxy.Backend{{Address: backend}}
+		}
+	}
+
+	rejected := []string{}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			route := proxy.Route{
+				Path:     path.Path,
+				PathType: proxy.PathPrefix,
+			}
+			if route.Path == "" {
+				route.Path = "/"
+			}
+			if path.PathType != nil && *path.PathType == networkingv1.PathTypeExact {
+				route.PathType = proxy.PathExact
+			}
+			if err := route.Validate(); err != nil {
+				host := rule.Host
+				if host == "" {
+					host = "*"
+				}
+				rejected = append(rejected, fmt.Sprintf("%s: %v", host, err))
+				continue
+			}
+
+			backend, err := r.resolveBackend(ctx, ingress.Namespace, &path.Backend)
+			if err != nil {
+				return proxy.Config{}, nil, err
+			}
+			if backend == "" {
+				continue
+			}
+			route.Backends = []proxy.Backend{{Address: backend}}
+			server.Routes[rule.Host] = append(server.Routes[rule.Host], route)
+		}
+	}
+
+	return proxy.Config{HTTP: []proxy.HTTPServer{server}}, rejected, nil
+}
+
+// reconcileRejectedPaths reports the rejected paths in the
+// IngressRejectedPathsAnnotation, removed once there are none.
+func (r *IngressReconciler) reconcileRejectedPaths(ctx context.Context, ingress *networkingv1.Ingress, rejected []string) error {
+	value := strings.Join(rejected, "\n")
+	if ingress.Annotations[v1beta1.IngressRejectedPathsAnnotation] == value {
+		return nil
+	}
+
+	if value == "" {
+		delete(ingress.Annotations, v1beta1.IngressRejectedPathsAnnotation)
+	} else {
+		if ingress.Annotations == nil {
+			ingress.Annotations = map[string]string{}
+		}
+		ingress.Annotations[v1beta1.IngressRejectedPathsAnnotation] = value
+	}
+	return r.Update(ctx, ingress)
+}
+
+// resolveBackend returns the cluster address of an Ingress Service backend.
+func (r *IngressReconciler) resolveBackend(ctx context.Context, namespace string, backend *networkingv1.IngressBackend) (string, error) {
+	log := log.FromContext(ctx)
+
+	if backend.Service == nil {
+		return "", nil
+	}
+
+	service := &corev1.Service{}
+	err := r.Get(ctx, types.NamespacedName{Name: backend.Service.Name, Namespace: namespace}, service)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("backend service not found, skipping", "service", backend.Service.Name)
+			return "", nil
+		}
+		return "", err
+	}
+
+	for _, p := range service.Spec.Ports {
+		if (backend.Service.Port.Name != "" && p.Name == backend.Service.Port.Name) || p.Port == backend.Service.Port.Number {
+			return fmt.Sprintf("%s.%s.svc.%s:%d", service.Name, namespace, onionservice.ClusterDomain, p.Port), nil
+		}
+	}
+
+	log.Info("backend service port not found, skipping", "service", backend.Service.Name)
+	return "", nil
+}
+
+func (r *IngressReconciler) reconcileOnionService(ctx context.Context, ingress *networkingv1.Ingress) (*v1beta1.OnionService, error) {
+	onion := &v1beta1.OnionService{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ingress.Name + "-onion",
+			Namespace: ingress.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(ingress, networkingv1.SchemeGroupVersion.WithKind("Ingress")),
+			},
+		},
+		Spec: v1beta1.OnionServiceSpec{
+			SOCKSPort:         9050,
+			HiddenServicePort: proxyPort,
+			Backend: &v1beta1.OnionServiceBackend{
+				ServiceRef: &v1beta1.ServiceReference{
+					Name: ingress.Name + "-onion-proxy",
+					Port: intstr.FromInt32(proxyPort),
+				},
+			},
+		},
+	}
+
+	found := &v1beta1.OnionService{}
+	err := r.Get(ctx, types.NamespacedName{Name: onion.Name, Namespace: onion.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return onion, r.Create(ctx, onion)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !reflect.DeepEqual(found.Spec, onion.Spec) {
+		found.Spec = onion.Spec
+		return found, r.Update(ctx, found)
+	}
+
+	return found, nil
+}
+
+// reconcileStatus publishes the onion address as the Ingress load balancer
+// hostname.
+func (r *IngressReconciler) reconcileStatus(ctx context.Context, ingress *networkingv1.Ingress, onion *v1beta1.OnionService) error {
+	loadBalancer := networkingv1.IngressLoadBalancerStatus{}
+	if onion.Status.OnionAddress != "" {
+		loadBalancer.Ingress = []networkingv1.IngressLoadBalancerIngress{
+			{Hostname: onion.Status.OnionAddress},
+		}
+	}
+
+	if reflect.DeepEqual(ingress.Status.LoadBalancer, loadBalancer) {
+		return nil
+	}
+
+	ingress.Status.LoadBalancer = loadBalancer
+	return r.Status().Update(ctx, ingress)
+}
+
+// ingressesForService enqueues the onion Ingresses of the namespace when
+// one of their backend Services changes.
+func (r *IngressReconciler) ingressesForService(ctx context.Context, obj client.Object) []reconcile.Request {
+	ingressList := &networkingv1.IngressList{}
+	if err := r.List(ctx, ingressList, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, ingress := range ingressList.Items {
+		if isOnionIngress(&ingress) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: ingress.Name, Namespace: ingress.Namespace},
+			})
+		}
+	}
+	return requests
+}
+
+func (r *IngressReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&networkingv1.Ingress{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return isOnionIngress(obj.(*networkingv1.Ingress))
+		}))).
+		Owns(&v1beta1.OnionService{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.ConfigMap{}).
+		Watches(&corev1.Service{}, handler.EnqueueRequestsFromMapFunc(r.ingressesForService)).
+		Complete(r)
+}
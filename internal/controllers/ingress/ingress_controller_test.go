@@ -0,0 +1,83 @@
+package ingress
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+var _ = Describe("onion Ingress", func() {
+	It("reports the paths the proxy cannot route", func() {
+		ctx := context.Background()
+		scheme := runtime.NewScheme()
+		Expect(v1beta1.AddToScheme(scheme)).To(Succeed())
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		Expect(networkingv1.AddToScheme(scheme)).To(Succeed())
+
+		backend := networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+			Name: "web",
+			Port: networkingv1.ServiceBackendPort{Number: 80},
+		}}
+		prefix := ptr.To(networkingv1.PathTypePrefix)
+		ingress := &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "ingress-uid"},
+			Spec: networkingv1.IngressSpec{
+				IngressClassName: ptr.To(IngressClassName),
+				Rules: []networkingv1.IngressRule{{
+					Host: "shop.example",
+					IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: []networkingv1.HTTPIngressPath{
+							{Path: "/api", PathType: prefix, Backend: backend},
+							{Path: "/a { return 200 evil; } location /b", PathType: prefix, Backend: backend},
+						},
+					}},
+				}},
+			},
+		}
+		service := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 80}}},
+		}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ingress, service).
+			WithStatusSubresource(ingress).Build()
+		r := &IngressReconciler{Client: c, Scheme: scheme}
+		request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(ingress)}
+
+		_, err := r.Reconcile(ctx, request)
+		Expect(err).NotTo(HaveOccurred())
+
+		cm := &corev1.ConfigMap{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "web-onion-proxy", Namespace: "default"}, cm)).To(Succeed())
+		Expect(cm.Data["nginx.conf"]).To(ContainSubstring("location /api {"))
+		Expect(cm.Data["nginx.conf"]).NotTo(ContainSubstring("evil"))
+
+		found := &networkingv1.Ingress{}
+		Expect(c.Get(ctx, request.NamespacedName, found)).To(Succeed())
+		Expect(found.Annotations[v1beta1.IngressRejectedPathsAnnotation]).To(HavePrefix("shop.example: path "))
+
+		// Fixing the path clears the report.
+		found.Spec.Rules[0].HTTP.Paths[1].Path = "/b"
+		Expect(c.Update(ctx, found)).To(Succeed())
+		_, err = r.Reconcile(ctx, request)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(c.Get(ctx, request.NamespacedName, found)).To(Succeed())
+		Expect(found.Annotations).NotTo(HaveKey(v1beta1.IngressRejectedPathsAnnotation))
+		Expect(c.Get(ctx, types.NamespacedName{Name: "web-onion-proxy", Namespace: "default"}, cm)).To(Succeed())
+		Expect(cm.Data["nginx.conf"]).To(ContainSubstring("location /b {"))
+	})
+})
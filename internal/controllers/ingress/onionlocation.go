@@ -0,0 +1,215 @@
+package ingress
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+// OnionLocationReconciler advertises the onion address of an OnionService
+// on the responses of a clearnet Ingress, with the Onion-Location header
+// Tor Browser uses to offer the onion site. The header is added by
+// ingress-nginx, from the ConfigMap referenced by its custom-headers
+// annotation.
+type OnionLocationReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+const (
+	// nginxCustomHeadersAnnotation points ingress-nginx to a ConfigMap of
+	// headers added to the responses.
+	nginxCustomHeadersAnnotation = "nginx.ingress.kubernetes.io/custom-headers"
+
+	onionLocationHeader = "Onion-Location"
+)
+
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=tor.stack.io,resources=onionservices,verbs=get;list;watch
+
+func (r *OnionLocationReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	log := log.FromContext(ctx)
+
+	ingress := &networkingv1.Ingress{}
+	err := r.Get(ctx, req.NamespacedName, ingress)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	// The ConfigMap is garbage collected with the Ingress.
+	if !ingress.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	name := ingress.Annotations[v1beta1.OnionLocationAnnotation]
+	if name == "" {
+		return reconcile.Result{}, r.cleanup(ctx, ingress)
+	}
+
+	onion := &v1beta1.OnionService{}
+	err = r.Get(ctx, types.NamespacedName{Name: name, Namespace: ingress.Namespace}, onion)
+	if err != nil && !errors.IsNotFound(err) {
+		return reconcile.Result{}, err
+	}
+	if onion.Status.OnionAddress == "" {
+		// The OnionService is watched, wait for it to publish its address.
+		log.Info("waiting for the onion address", "onionservice", name)
+		return reconcile.Result{}, r.cleanup(ctx, ingress)
+	}
+
+	current := ingress.Annotations[nginxCustomHeadersAnnotation]
+	if current != "" && current != onionLocationConfigMapRef(ingress) {
+		log.Info("custom headers already set on the ingress, skipping", "configmap", current)
+		return reconcile.Result{}, nil
+	}
+
+	if err := r.reconcileConfigMap(ctx, ingress, onionLocation(onion)); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if current == "" {
+		patch := client.MergeFrom(ingress.DeepCopy())
+		ingress.Annotations[nginxCustomHeadersAnnotation] = onionLocationConfigMapRef(ingress)
+		return reconcile.Result{}, r.Patch(ctx, ingress, patch)
+	}
+
+	return reconcile.Result{}, nil
+}
+
+func onionLocationConfigMapName(ingress *networkingv1.Ingress) string {
+	return ingress.Name + "-onion-location"
+}
+
+// onionLocationConfigMapRef is the namespace/name reference expected by
+// ingress-nginx.
+func onionLocationConfigMapRef(ingress *networkingv1.Ingress) string {
+	return ingress.Namespace + "/" + onionLocationConfigMapName(ingress)
+}
+
+// onionLocation is the URL advertised for the onion address, on the same
+// path as the clearnet request. https is used when the OnionService serves
+// port 443, http on port 80 or on its first port otherwise.
+func onionLocation(onion *v1beta1.OnionService) string {
+	ports := []int32{}
+	for _, port := range onion.Spec.Ports {
+		ports = append(ports, port.Port)
+	}
+	if len(ports) == 0 {
+		ports = append(ports, int32(onion.Spec.HiddenServicePort))
+	}
+
+	url := "http://" + onion.Status.OnionAddress
+	switch {
+	case slices.Contains(ports, 443):
+		url = "https://" + onion.Status.OnionAddress
+	case !slices.Contains(ports, 80) && ports[0] > 0:
+		url = fmt.Sprintf("http://%s:%d", onion.Status.OnionAddress, ports[0])
+	}
+	// Expanded by nginx on every response.
+	return url + "$request_uri"
+}
+
+func (r *OnionLocationReconciler) reconcileConfigMap(ctx context.Context, ingress *networkingv1.Ingress, location string) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      onionLocationConfigMapName(ingress),
+			Namespace: ingress.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(ingress, networkingv1.SchemeGroupVersion.WithKind("Ingress")),
+			},
+		},
+		Data: map[string]string{
+			onionLocationHeader: location,
+		},
+	}
+
+	found := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: cm.Name, Namespace: cm.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return r.Create(ctx, cm)
+	} else if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(found.Data, cm.Data) {
+		found.Data = cm.Data
+		return r.Update(ctx, found)
+	}
+	return nil
+}
+
+// cleanup removes the header once the annotation is removed or the onion
+// address is not known.
+func (r *OnionLocationReconciler) cleanup(ctx context.Context, ingress *networkingv1.Ingress) error {
+	if ingress.Annotations[nginxCustomHeadersAnnotation] == onionLocationConfigMapRef(ingress) {
+		patch := client.MergeFrom(ingress.DeepCopy())
+		delete(ingress.Annotations, nginxCustomHeadersAnnotation)
+		if err := r.Patch(ctx, ingress, patch); err != nil {
+			return err
+		}
+	}
+
+	found := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: onionLocationConfigMapName(ingress), Namespace: ingress.Namespace}, found)
+	if err == nil && metav1.IsControlledBy(found, ingress) {
+		return client.IgnoreNotFound(r.Delete(ctx, found))
+	}
+	return client.IgnoreNotFound(err)
+}
+
+// hasOnionLocation selects the Ingresses annotated with an OnionService
+// and the ones still carrying the header after the annotation is removed.
+func hasOnionLocation(obj client.Object) bool {
+	ingress := obj.(*networkingv1.Ingress)
+	return ingress.Annotations[v1beta1.OnionLocationAnnotation] != "" ||
+		ingress.Annotations[nginxCustomHeadersAnnotation] == onionLocationConfigMapRef(ingress)
+}
+
+// ingressesForOnionService enqueues the Ingresses advertising the
+// OnionService when its address changes.
+func (r *OnionLocationReconciler) ingressesForOnionService(ctx context.Context, obj client.Object) []reconcile.Request {
+	ingressList := &networkingv1.IngressList{}
+	if err := r.List(ctx, ingressList, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, ingress := range ingressList.Items {
+		if ingress.Annotations[v1beta1.OnionLocationAnnotation] == obj.GetName() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: ingress.Name, Namespace: ingress.Namespace},
+			})
+		}
+	}
+	return requests
+}
+
+func (r *OnionLocationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("onionlocation").
+		For(&networkingv1.Ingress{}, builder.WithPredicates(predicate.NewPredicateFuncs(hasOnionLocation))).
+		Owns(&corev1.ConfigMap{}).
+		Watches(&v1beta1.OnionService{}, handler.EnqueueRequestsFromMapFunc(r.ingressesForOnionService)).
+		Complete(r)
+}
@@ -0,0 +1,149 @@
+package ingress
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+func TestIngress(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Ingress Suite")
+}
+
+const onionAddress = "m5vuxl6bihjgzsfnxnr4zsgapyj2xecwxxvefxqdvvvqlvhrhxmtqkid.onion"
+
+var _ = Describe("Onion-Location", func() {
+	DescribeTable("advertises the scheme and port the onion service serves",
+		func(spec v1beta1.OnionServiceSpec, location string) {
+			onion := &v1beta1.OnionService{
+				Spec:   spec,
+				Status: v1beta1.OnionServiceStatus{OnionAddress: onionAddress},
+			}
+			Expect(onionLocation(onion)).To(Equal(location))
+		},
+		Entry("port 80", v1beta1.OnionServiceSpec{HiddenServicePort: 80},
+			"http://"+onionAddress+"$request_uri"),
+		Entry("port 443", v1beta1.OnionServiceSpec{HiddenServicePort: 443},
+			"https://"+onionAddress+"$request_uri"),
+		Entry("ports 80 and 443", v1beta1.OnionServiceSpec{Ports: []v1beta1.OnionServicePort{{Port: 80}, {Port: 443}}},
+			"https://"+onionAddress+"$request_uri"),
+		Entry("another port", v1beta1.OnionServiceSpec{Ports: []v1beta1.OnionServicePort{{Port: 8080}}},
+			"http://"+onionAddress+":8080$request_uri"),
+	)
+
+	Describe("Reconcile", func() {
+		var (
+			ctx     context.Context
+			c       client.Client
+			r       *OnionLocationReconciler
+			ingress *networkingv1.Ingress
+			onion   *v1beta1.OnionService
+			request reconcile.Request
+		)
+
+		configMapKey := types.NamespacedName{Name: "web-onion-location", Namespace: "default"}
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			scheme := runtime.NewScheme()
+			Expect(v1beta1.AddToScheme(scheme)).To(Succeed())
+			Expect(corev1.AddToScheme(scheme)).To(Succeed())
+			Expect(networkingv1.AddToScheme(scheme)).To(Succeed())
+
+			ingress = &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "web",
+					Namespace:   "default",
+					UID:         "ingress-uid",
+					Annotations: map[string]string{v1beta1.OnionLocationAnnotation: "web-onion"},
+				},
+			}
+			onion = &v1beta1.OnionService{
+				ObjectMeta: metav1.ObjectMeta{Name: "web-onion", Namespace: "default"},
+				Spec:       v1beta1.OnionServiceSpec{HiddenServicePort: 443},
+				Status:     v1beta1.OnionServiceStatus{OnionAddress: onionAddress},
+			}
+			c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(ingress, onion).
+				WithStatusSubresource(onion).Build()
+			r = &OnionLocationReconciler{Client: c, Scheme: scheme}
+			request = reconcile.Request{NamespacedName: client.ObjectKeyFromObject(ingress)}
+		})
+
+		header := func() string {
+			cm := &corev1.ConfigMap{}
+			Expect(c.Get(ctx, configMapKey, cm)).To(Succeed())
+			return cm.Data[onionLocationHeader]
+		}
+
+		customHeaders := func() string {
+			found := &networkingv1.Ingress{}
+			Expect(c.Get(ctx, request.NamespacedName, found)).To(Succeed())
+			return found.Annotations[nginxCustomHeadersAnnotation]
+		}
+
+		It("adds the header to annotated Ingresses", func() {
+			_, err := r.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(header()).To(Equal("https://" + onionAddress + "$request_uri"))
+			Expect(customHeaders()).To(Equal("default/web-onion-location"))
+		})
+
+		It("follows the onion address", func() {
+			_, err := r.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+
+			next := "5gkqgqbzc2ex7ts4vvo4rfhp3iwzg5pvozbmq4rsmqwslzqkqihrr4yd.onion"
+			Expect(c.Get(ctx, client.ObjectKeyFromObject(onion), onion)).To(Succeed())
+			onion.Status.OnionAddress = next
+			Expect(c.Status().Update(ctx, onion)).To(Succeed())
+			Expect(r.ingressesForOnionService(ctx, onion)).To(Equal([]reconcile.Request{request}))
+
+			_, err = r.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(header()).To(Equal("https://" + next + "$request_uri"))
+		})
+
+		It("removes the header with the annotation", func() {
+			_, err := r.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(c.Get(ctx, request.NamespacedName, ingress)).To(Succeed())
+			delete(ingress.Annotations, v1beta1.OnionLocationAnnotation)
+			Expect(c.Update(ctx, ingress)).To(Succeed())
+			Expect(hasOnionLocation(ingress)).To(BeTrue())
+
+			_, err = r.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(customHeaders()).To(BeEmpty())
+			err = c.Get(ctx, configMapKey, &corev1.ConfigMap{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("leaves existing custom headers alone", func() {
+			Expect(c.Get(ctx, request.NamespacedName, ingress)).To(Succeed())
+			ingress.Annotations[nginxCustomHeadersAnnotation] = "default/custom-headers"
+			Expect(c.Update(ctx, ingress)).To(Succeed())
+
+			_, err := r.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(customHeaders()).To(Equal("default/custom-headers"))
+			err = c.Get(ctx, configMapKey, &corev1.ConfigMap{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+})
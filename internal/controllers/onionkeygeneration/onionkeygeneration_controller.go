@@ -0,0 +1,352 @@
+package onionkeygeneration
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/agent"
+	"github.com/fulviodenza/torproxy/internal/onion"
+)
+
+// OnionKeyGenerationReconciler runs the Job searching the vanity key of an
+// OnionKeyGeneration and reports its progress.
+type OnionKeyGenerationReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// Image runs cmd/onion-vanity, it is shipped in the manager image.
+var Image = "fulviodenza/torproxy:latest"
+
+const (
+	backoffLimit = int32(3)
+)
+
+// +kubebuilder:rbac:groups=tor.stack.io,resources=onionkeygenerations,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=tor.stack.io,resources=onionkeygenerations/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+
+func (r *OnionKeyGenerationReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	log := log.FromContext(ctx)
+	log.Info("got new request: ", "namespace/name", req.NamespacedName.String())
+
+	generation := &v1beta1.OnionKeyGeneration{}
+	err := r.Get(ctx, req.NamespacedName, generation)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	// The Job and the status ConfigMap are garbage collected, the Secret
+	// is kept.
+	if !generation.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+	if generation.Status.Phase == "Succeeded" || generation.Status.Phase == "Failed" {
+		return reconcile.Result{}, nil
+	}
+
+	secret, err := r.reconcileSecret(ctx, generation)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if hostname := string(secret.Data[onion.HostnameFile]); hostname != "" {
+		status := generation.Status.DeepCopy()
+		status.Phase = "Succeeded"
+		status.Message = ""
+		status.OnionAddress = hostname
+		status.SecretName = secret.Name
+		status.AttemptsPerSecond = 0
+		status.EstimatedCompletionTime = nil
+		return reconcile.Result{}, r.updateStatus(ctx, generation, status)
+	}
+
+	owner := *metav1.NewControllerRef(generation, v1beta1.GroupVersion.WithKind("OnionKeyGeneration"))
+	// The search pods may only read and fill the Secret of the key.
+	err = agent.Reconcile(ctx, r.Client, owner, generation.Namespace, agentName(generation), rbacv1.PolicyRule{
+		APIGroups:     []string{""},
+		Resources:     []string{"secrets"},
+		ResourceNames: []string{secret.Name},
+		Verbs:         []string{"get", "update"},
+	})
+	if agent.IsConflict(err) {
+		status := generation.Status.DeepCopy()
+		status.Phase = "Pending"
+		status.Message = err.Error()
+		return reconcile.Result{}, r.updateStatus(ctx, generation, status)
+	} else if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	job, err := r.reconcileJob(ctx, generation, owner, secret.Name)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	progress := &corev1.ConfigMap{}
+	err = r.Get(ctx, types.NamespacedName{Name: agent.ConfigMapName(agentName(generation)), Namespace: generation.Namespace}, progress)
+	if err != nil && !errors.IsNotFound(err) {
+		return reconcile.Result{}, err
+	}
+
+	podList := &corev1.PodList{}
+	err = r.List(ctx, podList, client.InNamespace(generation.Namespace),
+		client.MatchingLabels{v1beta1.OnionKeyGenerationLabel: generation.Name})
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	running := map[string]bool{}
+	for _, pod := range podList.Items {
+		if pod.Status.Phase == corev1.PodRunning {
+			running[pod.Name] = true
+		}
+	}
+
+	status := jobStatus(generation, job, progress.Data, running, time.Now())
+	status.SecretName = secret.Name
+	return reconcile.Result{}, r.updateStatus(ctx, generation, status)
+}
+
+// agentName names the status ConfigMap and the identity of the search
+// pods, apart from the ones of an OnionService of the same name.
+func agentName(generation *v1beta1.OnionKeyGeneration) string {
+	return generation.Name + "-keygen"
+}
+
+func secretName(generation *v1beta1.OnionKeyGeneration) string {
+	if generation.Spec.SecretName == "" {
+		return generation.Name + "-key"
+	}
+	return generation.Spec.SecretName
+}
+
+// reconcileSecret creates the empty Secret the search pods fill with the
+// key. It is not owned, to outlive the OnionKeyGeneration.
+func (r *OnionKeyGenerationReconciler) reconcileSecret(ctx context.Context, generation *v1beta1.OnionKeyGeneration) (*corev1.Secret, error) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName(generation),
+			Namespace: generation.Namespace,
+			Labels: map[string]string{
+				v1beta1.OnionKeyGenerationLabel: generation.Name,
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+	}
+
+	found := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: secret.Name, Namespace: secret.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return secret, r.Create(ctx, secret)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if found.Labels[v1beta1.OnionKeyGenerationLabel] != generation.Name {
+		return nil, fmt.Errorf("secret %s exists and does not belong to the OnionKeyGeneration", found.Name)
+	}
+	return found, nil
+}
+
+// reconcileJob creates the search Job. Its pod template is immutable, it
+// is never updated.
+func (r *OnionKeyGenerationReconciler) reconcileJob(ctx context.Context, generation *v1beta1.OnionKeyGeneration, owner metav1.OwnerReference, secret string) (*batchv1.Job, error) {
+	job := searchJob(generation, owner, secret)
+
+	found := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return job, r.Create(ctx, job)
+	} else if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// searchJob runs Parallelism search pods, the Job completes as soon as one
+// of them finds the key, the others exit once they see the Secret filled.
+func searchJob(generation *v1beta1.OnionKeyGeneration, owner metav1.OwnerReference, secret string) *batchv1.Job {
+	parallelism := generation.Spec.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	args := []string{
+		"--prefix=" + generation.Spec.Prefix,
+		"--secret=" + secret,
+		"--configmap=" + agent.ConfigMapName(agentName(generation)),
+	}
+	// Go sizes its workers after the CPUs of the node, not the limit.
+	if cpu, ok := generation.Spec.Resources.Limits[corev1.ResourceCPU]; ok {
+		workers := int64(math.Ceil(float64(cpu.MilliValue()) / 1000))
+		args = append(args, "--workers="+strconv.FormatInt(max(workers, 1), 10))
+	}
+
+	labels := map[string]string{
+		v1beta1.OnionKeyGenerationLabel: generation.Name,
+	}
+	backoffLimit := backoffLimit
+	nonRoot := true
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            generation.Name + "-keygen",
+			Namespace:       generation.Namespace,
+			Labels:          labels,
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		Spec: batchv1.JobSpec{
+			Parallelism:           &parallelism,
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: generation.Spec.TimeoutSeconds,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:      corev1.RestartPolicyOnFailure,
+					ServiceAccountName: agent.ServiceAccountName(agentName(generation)),
+					Containers: []corev1.Container{
+						{
+							Name:    "onion-vanity",
+							Image:   Image,
+							Command: []string{"/onion-vanity"},
+							Args:    args,
+							Env: []corev1.EnvVar{
+								{
+									Name: "POD_NAME",
+									ValueFrom: &corev1.EnvVarSource{
+										FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+									},
+								},
+								{
+									Name: "POD_NAMESPACE",
+									ValueFrom: &corev1.EnvVarSource{
+										FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"},
+									},
+								},
+							},
+							Resources: generation.Spec.Resources,
+							SecurityContext: &corev1.SecurityContext{
+								RunAsNonRoot: &nonRoot,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// jobStatus reports the progress of the search from the Job and the
+// values published by its pods, the running ones make the rate. The search
+// has no memory, the expected attempts are still ahead of it at now.
+func jobStatus(generation *v1beta1.OnionKeyGeneration, job *batchv1.Job, published map[string]string, running map[string]bool, now time.Time) *v1beta1.OnionKeyGenerationStatus {
+	status := generation.Status.DeepCopy()
+	status.ExpectedAttempts = int64(onion.ExpectedAttempts(generation.Spec.Prefix))
+	status.StartTime = job.Status.StartTime
+
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			status.Phase = "Failed"
+			status.Message = condition.Message
+			status.AttemptsPerSecond = 0
+			status.EstimatedCompletionTime = nil
+			return status
+		}
+	}
+
+	// Pods which are gone keep counting in the attempts, not in the rate.
+	var attempts, rate int64
+	for key, value := range published {
+		separator := strings.LastIndex(key, ".")
+		if separator < 0 {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		switch key[separator+1:] {
+		case onion.AttemptsKey:
+			attempts += n
+		case onion.RateKey:
+			if running[key[:separator]] {
+				rate += n
+			}
+		}
+	}
+
+	// The estimate only moves with the progress, not with every reconcile.
+	unchanged := status.Attempts == attempts && status.AttemptsPerSecond == rate
+	status.Attempts = attempts
+	status.AttemptsPerSecond = rate
+	if !unchanged {
+		status.EstimatedCompletionTime = nil
+	}
+	if len(running) == 0 && job.Status.StartTime == nil {
+		status.Phase = "Pending"
+		status.Message = "Waiting for the search pods to start"
+		return status
+	}
+
+	status.Phase = "Running"
+	status.Message = fmt.Sprintf("Searching %s, %d of %d expected attempts", generation.Spec.Prefix, attempts, status.ExpectedAttempts)
+	if rate > 0 && status.EstimatedCompletionTime == nil {
+		eta := now.Add(time.Duration(float64(status.ExpectedAttempts) / float64(rate) * float64(time.Second)))
+		status.EstimatedCompletionTime = &metav1.Time{Time: eta.Truncate(time.Second)}
+	}
+	return status
+}
+
+func (r *OnionKeyGenerationReconciler) updateStatus(ctx context.Context, generation *v1beta1.OnionKeyGeneration, status *v1beta1.OnionKeyGenerationStatus) error {
+	if reflect.DeepEqual(&generation.Status, status) {
+		return nil
+	}
+	generation.Status = *status
+	return r.Status().Update(ctx, generation)
+}
+
+// generationForSecret enqueues the OnionKeyGeneration of a Secret once the
+// key is stored.
+func (r *OnionKeyGenerationReconciler) generationForSecret(ctx context.Context, obj client.Object) []reconcile.Request {
+	name := obj.GetLabels()[v1beta1.OnionKeyGenerationLabel]
+	if name == "" {
+		return nil
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{Name: name, Namespace: obj.GetNamespace()},
+	}}
+}
+
+func (r *OnionKeyGenerationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1beta1.OnionKeyGeneration{}).
+		Owns(&batchv1.Job{}).
+		Owns(&corev1.ConfigMap{}).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.generationForSecret)).
+		Complete(r)
+}
@@ -0,0 +1,83 @@
+package onionkeygeneration
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+func TestOnionKeyGeneration(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "OnionKeyGeneration Suite")
+}
+
+var _ = Describe("onion key generation", func() {
+	generation := &v1beta1.OnionKeyGeneration{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"},
+		Spec: v1beta1.OnionKeyGenerationSpec{
+			Prefix:      "shop",
+			Parallelism: 2,
+		},
+	}
+
+	It("sizes the search workers after the CPU limit", func() {
+		generation := generation.DeepCopy()
+		generation.Spec.Resources.Limits = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1500m")}
+
+		job := searchJob(generation, metav1.OwnerReference{}, "shop-key")
+		Expect(*job.Spec.Parallelism).To(Equal(int32(2)))
+		Expect(strings.Join(job.Spec.Template.Spec.Containers[0].Args, " ")).To(Equal(
+			"--prefix=shop --secret=shop-key --configmap=shop-keygen-tor-status --workers=2"))
+	})
+
+	It("reports the rate of the running pods and the ETA", func() {
+		start := metav1.NewTime(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))
+		now := start.Add(time.Hour)
+		job := &batchv1.Job{Status: batchv1.JobStatus{StartTime: &start, Active: 1}}
+		published := map[string]string{
+			"shop-keygen-a.attempts": "6000000",
+			"shop-keygen-a.rate":     "100000",
+			"shop-keygen-b.attempts": "4000000",
+			"shop-keygen-b.rate":     "50000",
+		}
+		running := map[string]bool{"shop-keygen-a": true}
+
+		status := jobStatus(generation, job, published, running, now)
+		Expect(status.Phase).To(Equal("Running"))
+		Expect(status.Attempts).To(Equal(int64(10000000)))
+		Expect(status.AttemptsPerSecond).To(Equal(int64(100000)))
+		Expect(status.ExpectedAttempts).To(Equal(int64(1048576)))
+		Expect(status.EstimatedCompletionTime.Time).To(Equal(now.Add(10 * time.Second)))
+
+		// The estimate stays put until the pods publish their progress.
+		generation := generation.DeepCopy()
+		generation.Status = *status
+		status = jobStatus(generation, job, published, running, now.Add(time.Minute))
+		Expect(status.EstimatedCompletionTime.Time).To(Equal(now.Add(10 * time.Second)))
+
+		published["shop-keygen-a.attempts"] = "12000000"
+		status = jobStatus(generation, job, published, running, now.Add(time.Minute))
+		Expect(status.EstimatedCompletionTime.Time).To(Equal(now.Add(time.Minute + 10*time.Second)))
+	})
+
+	It("fails with the Job", func() {
+		job := &batchv1.Job{Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{{
+			Type:    batchv1.JobFailed,
+			Status:  corev1.ConditionTrue,
+			Message: "Job was active longer than specified deadline",
+		}}}}
+
+		status := jobStatus(generation, job, nil, nil, time.Now())
+		Expect(status.Phase).To(Equal("Failed"))
+		Expect(status.Message).To(Equal("Job was active longer than specified deadline"))
+	})
+})
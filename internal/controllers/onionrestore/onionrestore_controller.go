@@ -0,0 +1,204 @@
+package onionrestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/backup"
+	"github.com/fulviodenza/torproxy/internal/onion"
+)
+
+// OnionRestoreReconciler rebuilds key Secrets from the backups of the
+// OnionServices.
+type OnionRestoreReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=tor.stack.io,resources=onionrestores,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=tor.stack.io,resources=onionrestores/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+
+func (r *OnionRestoreReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	log := log.FromContext(ctx)
+	log.Info("got new request: ", "namespace/name", req.NamespacedName.String())
+
+	restore := &v1beta1.OnionRestore{}
+	err := r.Get(ctx, req.NamespacedName, restore)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	// The restored Secret is kept.
+	if !restore.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+	if restore.Status.Phase == "Succeeded" || restore.Status.Phase == "Failed" {
+		return reconcile.Result{}, nil
+	}
+
+	files, err := r.fetch(ctx, restore)
+	if errors.Is(err, backup.ErrNotFound) || errors.Is(err, backup.ErrInvalidEndpoint) || errors.Is(err, errInvalidBackup) {
+		return reconcile.Result{}, r.updateStatus(ctx, restore, "Failed", "", err.Error())
+	} else if err != nil {
+		// The storage or the Secrets may not be there yet, retry with
+		// backoff.
+		if statusErr := r.updateStatus(ctx, restore, "Pending", "", err.Error()); statusErr != nil {
+			return reconcile.Result{}, statusErr
+		}
+		return reconcile.Result{}, err
+	}
+
+	if err := r.reconcileSecret(ctx, restore, files); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	log.Info("restored onion key", "address", restore.Spec.Source.OnionAddress, "secret", secretName(restore))
+	return reconcile.Result{}, r.updateStatus(ctx, restore, "Succeeded", restore.Spec.Source.OnionAddress, "")
+}
+
+// errInvalidBackup is returned for backups which cannot be restored, it
+// is no use to retry.
+var errInvalidBackup = errors.New("invalid backup")
+
+func secretName(restore *v1beta1.OnionRestore) string {
+	if restore.Spec.SecretName == "" {
+		return restore.Name + "-key"
+	}
+	return restore.Spec.SecretName
+}
+
+// fetch downloads and decrypts the backup, and checks it holds the key of
+// the onion address.
+func (r *OnionRestoreReconciler) fetch(ctx context.Context, restore *v1beta1.OnionRestore) (map[string][]byte, error) {
+	credentials := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: restore.Spec.Storage.CredentialsSecret, Namespace: restore.Namespace}, credentials)
+	if err != nil {
+		return nil, err
+	}
+	identitySecret := &corev1.Secret{}
+	err = r.Get(ctx, types.NamespacedName{Name: restore.Spec.IdentitySecret, Namespace: restore.Namespace}, identitySecret)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := backup.ParseIdentity(identitySecret.Data["identity"])
+	if err != nil {
+		return nil, fmt.Errorf("%w: secret %s: %v", errInvalidBackup, identitySecret.Name, err)
+	}
+
+	storage := &backup.S3{
+		Endpoint:        restore.Spec.Storage.Endpoint,
+		Bucket:          restore.Spec.Storage.Bucket,
+		Region:          restore.Spec.Storage.Region,
+		AccessKeyID:     string(credentials.Data["accessKeyID"]),
+		SecretAccessKey: string(credentials.Data["secretAccessKey"]),
+	}
+	if storage.Region == "" {
+		storage.Region = "us-east-1"
+	}
+	sealed, err := storage.Get(ctx, objectKey(restore))
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := backup.Decrypt(identity, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidBackup, err)
+	}
+	if err := checkKey(files, restore.Spec.Source.OnionAddress); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidBackup, err)
+	}
+	return files, nil
+}
+
+// objectKey is the backup of the source onion address.
+func objectKey(restore *v1beta1.OnionRestore) string {
+	namespace := restore.Spec.Source.Namespace
+	if namespace == "" {
+		namespace = restore.Namespace
+	}
+	return backup.ObjectPrefix(restore.Spec.Storage.Prefix, namespace, restore.Spec.Source.Name) +
+		restore.Spec.Source.OnionAddress
+}
+
+// checkKey makes sure the files are a valid key of the onion address.
+func checkKey(files map[string][]byte, address string) error {
+	if _, err := onion.ParseSecretKey(files[onion.SecretKeyFile]); err != nil {
+		return err
+	}
+	pub, err := onion.ParsePublicKey(files[onion.PublicKeyFile])
+	if err != nil {
+		return err
+	}
+	hostname := onion.Address(pub) + ".onion"
+	if hostname != address || strings.TrimSpace(string(files[onion.HostnameFile])) != hostname {
+		return fmt.Errorf("the backup holds the key of %s, not of %s", hostname, address)
+	}
+	return nil
+}
+
+// reconcileSecret writes the key to the Secret, in the format of the
+// KeySecret of OnionServices. It is not owned, to outlive the restore.
+func (r *OnionRestoreReconciler) reconcileSecret(ctx context.Context, restore *v1beta1.OnionRestore, files map[string][]byte) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName(restore),
+			Namespace: restore.Namespace,
+			Labels: map[string]string{
+				v1beta1.OnionRestoreLabel: restore.Name,
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			onion.SecretKeyFile: files[onion.SecretKeyFile],
+			onion.PublicKeyFile: files[onion.PublicKeyFile],
+			onion.HostnameFile:  []byte(strings.TrimSpace(string(files[onion.HostnameFile]))),
+		},
+	}
+
+	found := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: secret.Name, Namespace: secret.Namespace}, found)
+	if err != nil && apierrors.IsNotFound(err) {
+		return r.Create(ctx, secret)
+	} else if err != nil {
+		return err
+	}
+
+	if found.Labels[v1beta1.OnionRestoreLabel] != restore.Name {
+		return fmt.Errorf("secret %s exists and does not belong to the OnionRestore", found.Name)
+	}
+	found.Data = secret.Data
+	return r.Update(ctx, found)
+}
+
+func (r *OnionRestoreReconciler) updateStatus(ctx context.Context, restore *v1beta1.OnionRestore, phase, onionAddress, message string) error {
+	restore.Status.Phase = phase
+	restore.Status.OnionAddress = onionAddress
+	restore.Status.Message = message
+	if phase == "Succeeded" {
+		restore.Status.SecretName = secretName(restore)
+	}
+	return r.Status().Update(ctx, restore)
+}
+
+func (r *OnionRestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1beta1.OnionRestore{}).
+		Complete(r)
+}
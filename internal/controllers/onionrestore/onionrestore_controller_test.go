@@ -0,0 +1,66 @@
+package onionrestore
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/onion"
+)
+
+func TestOnionRestore(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "OnionRestore Suite")
+}
+
+var _ = Describe("onion restore", func() {
+	restore := &v1beta1.OnionRestore{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "restore"},
+		Spec: v1beta1.OnionRestoreSpec{
+			Storage: v1beta1.BackupStorage{Prefix: "prod/"},
+			Source: v1beta1.RestoreSource{
+				Name:         "shop",
+				OnionAddress: "m5vuxl6bihjgzsfnxnr4zsgapyj2xecwxxvefxqdvvvqlvhrhxmtqkid.onion",
+			},
+		},
+	}
+
+	It("finds the backup in the namespace of the restore by default", func() {
+		Expect(objectKey(restore)).To(Equal("prod/restore/shop/m5vuxl6bihjgzsfnxnr4zsgapyj2xecwxxvefxqdvvvqlvhrhxmtqkid.onion"))
+
+		source := restore.DeepCopy()
+		source.Spec.Source.Namespace = "default"
+		Expect(objectKey(source)).To(Equal("prod/default/shop/m5vuxl6bihjgzsfnxnr4zsgapyj2xecwxxvefxqdvvvqlvhrhxmtqkid.onion"))
+	})
+
+	It("names the Secret after the restore by default", func() {
+		Expect(secretName(restore)).To(Equal("shop-key"))
+
+		named := restore.DeepCopy()
+		named.Spec.SecretName = "shop-onion-key"
+		Expect(secretName(named)).To(Equal("shop-onion-key"))
+	})
+
+	It("only accepts the key of the onion address", func() {
+		key, err := onion.GenerateKey()
+		Expect(err).NotTo(HaveOccurred())
+		files := map[string][]byte{
+			onion.SecretKeyFile: key.SecretKey,
+			onion.PublicKeyFile: key.PublicKey,
+			onion.HostnameFile:  []byte(key.Hostname + "\n"),
+		}
+		Expect(checkKey(files, key.Hostname)).To(Succeed())
+		Expect(checkKey(files, restore.Spec.Source.OnionAddress)).NotTo(Succeed())
+
+		other, err := onion.GenerateKey()
+		Expect(err).NotTo(HaveOccurred())
+		files[onion.HostnameFile] = []byte(other.Hostname)
+		Expect(checkKey(files, key.Hostname)).NotTo(Succeed())
+
+		delete(files, onion.SecretKeyFile)
+		Expect(checkKey(files, key.Hostname)).NotTo(Succeed())
+	})
+})
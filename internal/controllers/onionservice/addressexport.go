@@ -0,0 +1,310 @@
+package onionservice
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/onion"
+)
+
+const (
+	addressExportConfigMap = "ConfigMap"
+	addressExportSecret    = "Secret"
+)
+
+func addressExportName(onion *v1beta1.OnionService) string {
+	if onion.Spec.AddressExport.Name == "" {
+		return onion.Name + "-onion-address"
+	}
+	return onion.Spec.AddressExport.Name
+}
+
+func addressExportLabels(onion *v1beta1.OnionService) map[string]string {
+	return map[string]string{
+		v1beta1.AddressExportLabel:    "true",
+		v1beta1.OnionServiceHashLabel: onionServiceHash(onion),
+	}
+}
+
+// onionURL is the URL of the virtual port of the onion address.
+func onionURL(hostname string, port int32) string {
+	switch port {
+	case 80:
+		return "http://" + hostname
+	case 443:
+		return "https://" + hostname
+	default:
+		return fmt.Sprintf("http://%s:%d", hostname, port)
+	}
+}
+
+// addressExportData lists the onion address, its URLs, the one of the
+// first port as url, and the base64 encoded ed25519 public key.
+func addressExportData(hostname string, ports []hiddenServicePort) (map[string]string, error) {
+	pub, err := onion.PublicKeyFromAddress(hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]string{
+		"hostname":  hostname,
+		"publicKey": base64.StdEncoding.EncodeToString(pub),
+	}
+	for i, port := range ports {
+		if i == 0 {
+			data["url"] = onionURL(hostname, port.port)
+		}
+		data[fmt.Sprintf("url-%d", port.port)] = onionURL(hostname, port.port)
+	}
+	return data, nil
+}
+
+// reconcileAddressExports writes the onion address to the ConfigMaps or
+// Secrets of spec.addressExport once it is known, and deletes the ones no
+// longer wanted.
+func (r *OnionServiceReconciler) reconcileAddressExports(ctx context.Context, onion *v1beta1.OnionService, ports []hiddenServicePort) error {
+	if onion.Spec.AddressExport == nil {
+		if err := r.deleteAddressExports(ctx, onion, nil); err != nil {
+			return err
+		}
+		if apimeta.RemoveStatusCondition(&onion.Status.Conditions, v1beta1.ConditionAddressExported) {
+			return r.Status().Update(ctx, onion)
+		}
+		return nil
+	}
+	if onion.Status.OnionAddress == "" {
+		return nil
+	}
+
+	data, err := addressExportData(onion.Status.OnionAddress, ports)
+	if err != nil {
+		return err
+	}
+
+	namespaces := onion.Spec.AddressExport.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{onion.Namespace}
+	}
+
+	keep := []client.Object{}
+	refused := []string{}
+	for _, namespace := range namespaces {
+		allowed, err := r.namespaceAllowed(ctx, onion, namespace)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			refused = append(refused, namespace)
+			continue
+		}
+
+		meta := metav1.ObjectMeta{
+			Name:        addressExportName(onion),
+			Namespace:   namespace,
+			Labels:      addressExportLabels(onion),
+			Annotations: onionServiceAnnotations(onion),
+		}
+		// Objects in other namespaces are deleted with the finalizer.
+		if namespace == onion.Namespace {
+			meta.OwnerReferences = []metav1.OwnerReference{
+				*metav1.NewControllerRef(onion, v1beta1.GroupVersion.WithKind("OnionService")),
+			}
+		}
+
+		var object client.Object
+		if onion.Spec.AddressExport.Kind == addressExportSecret {
+			object = &corev1.Secret{ObjectMeta: meta, StringData: data}
+			err = r.reconcileAddressSecret(ctx, onion, object.(*corev1.Secret))
+		} else {
+			object = &corev1.ConfigMap{ObjectMeta: meta, Data: data}
+			err = r.reconcileAddressConfigMap(ctx, onion, object.(*corev1.ConfigMap))
+		}
+		if err != nil {
+			return err
+		}
+		keep = append(keep, object)
+	}
+
+	if err := r.deleteAddressExports(ctx, onion, keep); err != nil {
+		return err
+	}
+	return r.updateAddressExportCondition(ctx, onion, refused)
+}
+
+// namespaceAllowed tells whether objects of the OnionService, its address
+// or a backend NetworkPolicy, may be written to the namespace: its own one,
+// or one labelled to accept them.
+func (r *OnionServiceReconciler) namespaceAllowed(ctx context.Context, onion *v1beta1.OnionService, namespace string) (bool, error) {
+	if namespace == onion.Namespace {
+		return true, nil
+	}
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: namespace}, ns); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return ns.Labels[v1beta1.AddressExportLabel] == "true", nil
+}
+
+// updateAddressExportCondition reports the namespaces the address was not
+// exported to, the status is only written when the condition changes.
+func (r *OnionServiceReconciler) updateAddressExportCondition(ctx context.Context, onion *v1beta1.OnionService, refused []string) error {
+	condition := metav1.Condition{
+		Type:               v1beta1.ConditionAddressExported,
+		Status:             metav1.ConditionTrue,
+		Reason:             v1beta1.ReasonAddressExported,
+		Message:            "The onion address is exported to every namespace",
+		ObservedGeneration: onion.Generation,
+	}
+	if len(refused) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = v1beta1.ReasonNamespaceNotAllowed
+		condition.Message = fmt.Sprintf("Namespaces %s do not exist or are not labelled %s=true",
+			strings.Join(refused, ", "), v1beta1.AddressExportLabel)
+	}
+	if !apimeta.SetStatusCondition(&onion.Status.Conditions, condition) {
+		return nil
+	}
+	return r.Status().Update(ctx, onion)
+}
+
+// adopt refuses to overwrite objects the OnionService did not create.
+func adopt(onion *v1beta1.OnionService, found client.Object) error {
+	labels := found.GetLabels()
+	if labels[v1beta1.AddressExportLabel] != "true" ||
+		labels[v1beta1.OnionServiceHashLabel] != onionServiceHash(onion) {
+		return fmt.Errorf("%s/%s exists and is not an address export of this OnionService", found.GetNamespace(), found.GetName())
+	}
+	return nil
+}
+
+func (r *OnionServiceReconciler) reconcileAddressConfigMap(ctx context.Context, onion *v1beta1.OnionService, cm *corev1.ConfigMap) error {
+	found := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: cm.Name, Namespace: cm.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return r.Create(ctx, cm)
+	} else if err != nil {
+		return err
+	}
+
+	if err := adopt(onion, found); err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(found.Data, cm.Data) {
+		found.Data = cm.Data
+		return r.Update(ctx, found)
+	}
+	return nil
+}
+
+func (r *OnionServiceReconciler) reconcileAddressSecret(ctx context.Context, onion *v1beta1.OnionService, secret *corev1.Secret) error {
+	found := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: secret.Name, Namespace: secret.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return r.Create(ctx, secret)
+	} else if err != nil {
+		return err
+	}
+
+	if err := adopt(onion, found); err != nil {
+		return err
+	}
+	data := map[string][]byte{}
+	for key, value := range secret.StringData {
+		data[key] = []byte(value)
+	}
+	if !reflect.DeepEqual(found.Data, data) {
+		found.Data = data
+		return r.Update(ctx, found)
+	}
+	return nil
+}
+
+// deleteAddressExports deletes the address exports of the OnionService, in
+// any namespace, but the kept ones.
+func (r *OnionServiceReconciler) deleteAddressExports(ctx context.Context, onion *v1beta1.OnionService, keep []client.Object) error {
+	kept := func(object client.Object) bool {
+		for _, k := range keep {
+			if reflect.TypeOf(k) == reflect.TypeOf(object) && k.GetName() == object.GetName() && k.GetNamespace() == object.GetNamespace() {
+				return true
+			}
+		}
+		return false
+	}
+
+	configMapList := &corev1.ConfigMapList{}
+	if err := r.List(ctx, configMapList, client.MatchingLabels(addressExportLabels(onion))); err != nil {
+		return err
+	}
+	for i := range configMapList.Items {
+		if kept(&configMapList.Items[i]) {
+			continue
+		}
+		if err := r.Delete(ctx, &configMapList.Items[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	secretList := &corev1.SecretList{}
+	if err := r.List(ctx, secretList, client.MatchingLabels(addressExportLabels(onion))); err != nil {
+		return err
+	}
+	for i := range secretList.Items {
+		if kept(&secretList.Items[i]) {
+			continue
+		}
+		if err := r.Delete(ctx, &secretList.Items[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// onionServiceForAddressExport enqueues the OnionService of an address
+// export, so that it is restored when edited or deleted.
+func (r *OnionServiceReconciler) onionServiceForAddressExport(ctx context.Context, obj client.Object) []reconcile.Request {
+	if obj.GetLabels()[v1beta1.AddressExportLabel] != "true" {
+		return nil
+	}
+	annotations := obj.GetAnnotations()
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{
+			Name:      annotations[v1beta1.OnionServiceAnnotation],
+			Namespace: annotations[v1beta1.OnionServiceNamespaceAnnotation],
+		},
+	}}
+}
+
+// onionServicesForNamespace enqueues the OnionServices exporting their
+// address to the namespace, so that they follow its label.
+func (r *OnionServiceReconciler) onionServicesForNamespace(ctx context.Context, obj client.Object) []reconcile.Request {
+	onionList := &v1beta1.OnionServiceList{}
+	if err := r.List(ctx, onionList); err != nil {
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, onion := range onionList.Items {
+		exported := onion.Spec.AddressExport != nil && slices.Contains(onion.Spec.AddressExport.Namespaces, obj.GetName())
+		if !exported && backendNamespace(&onion) != obj.GetName() {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: onion.Name, Namespace: onion.Namespace},
+		})
+	}
+	return requests
+}
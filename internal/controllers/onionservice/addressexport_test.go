@@ -0,0 +1,118 @@
+package onionservice
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/onion"
+)
+
+var _ = Describe("Address export", func() {
+	It("lists the address, the URL of every port and the public key", func() {
+		key, err := onion.GenerateKey()
+		Expect(err).NotTo(HaveOccurred())
+
+		data, err := addressExportData(key.Hostname, []hiddenServicePort{
+			{port: 443, target: "web:8443"},
+			{port: 80, target: "web:8080"},
+			{port: 8080, target: "api:80"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		publicKey, err := base64.StdEncoding.DecodeString(data["publicKey"])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(key.PublicKey).To(HaveSuffix(string(publicKey)))
+
+		delete(data, "publicKey")
+		Expect(data).To(Equal(map[string]string{
+			"hostname": key.Hostname,
+			"url":      "https://" + key.Hostname,
+			"url-443":  "https://" + key.Hostname,
+			"url-80":   "http://" + key.Hostname,
+			"url-8080": "http://" + key.Hostname + ":8080",
+		}))
+	})
+
+	It("rejects invalid onion addresses", func() {
+		_, err := addressExportData("notanonion.onion", []hiddenServicePort{{port: 80}})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("only writes to the namespaces which opted in", func() {
+		ctx := context.Background()
+		scheme := runtime.NewScheme()
+		Expect(v1beta1.AddToScheme(scheme)).To(Succeed())
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+
+		key, err := onion.GenerateKey()
+		Expect(err).NotTo(HaveOccurred())
+
+		// Too long for a label value.
+		name := strings.Repeat("a", 100)
+		onionService := &v1beta1.OnionService{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: v1beta1.OnionServiceSpec{
+				AddressExport: &v1beta1.AddressExport{
+					Name:       "onion",
+					Namespaces: []string{"default", "allowed", "other"},
+				},
+			},
+			Status: v1beta1.OnionServiceStatus{OnionAddress: key.Hostname},
+		}
+		allowed := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   "allowed",
+			Labels: map[string]string{v1beta1.AddressExportLabel: "true"},
+		}}
+		other := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(onionService, allowed, other).
+			WithStatusSubresource(onionService).Build()
+		r := &OnionServiceReconciler{Client: c, Scheme: scheme}
+
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(onionService), onionService)).To(Succeed())
+		ports := []hiddenServicePort{{port: 80, target: "web:8080"}}
+		Expect(r.reconcileAddressExports(ctx, onionService, ports)).To(Succeed())
+
+		for _, namespace := range []string{"default", "allowed"} {
+			cm := &corev1.ConfigMap{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "onion", Namespace: namespace}, cm)).To(Succeed())
+			Expect(cm.Data).To(HaveKeyWithValue("hostname", key.Hostname))
+			Expect(r.onionServiceForAddressExport(ctx, cm)).To(Equal([]reconcile.Request{{
+				NamespacedName: types.NamespacedName{Name: name, Namespace: "default"},
+			}}))
+		}
+		err = c.Get(ctx, types.NamespacedName{Name: "onion", Namespace: "other"}, &corev1.ConfigMap{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+
+		found := &v1beta1.OnionService{}
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(onionService), found)).To(Succeed())
+		condition := meta.FindStatusCondition(found.Status.Conditions, v1beta1.ConditionAddressExported)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal(v1beta1.ReasonNamespaceNotAllowed))
+		Expect(condition.Message).To(ContainSubstring("other"))
+
+		// Labelling the namespace exports the address to it.
+		other.Labels = map[string]string{v1beta1.AddressExportLabel: "true"}
+		Expect(c.Update(ctx, other)).To(Succeed())
+		Expect(r.onionServicesForNamespace(ctx, other)).To(HaveLen(1))
+		Expect(r.reconcileAddressExports(ctx, found, ports)).To(Succeed())
+
+		Expect(c.Get(ctx, types.NamespacedName{Name: "onion", Namespace: "other"}, &corev1.ConfigMap{})).To(Succeed())
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(onionService), found)).To(Succeed())
+		Expect(meta.IsStatusConditionTrue(found.Status.Conditions, v1beta1.ConditionAddressExported)).To(BeTrue())
+	})
+})
@@ -0,0 +1,176 @@
+package onionservice
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+// ClusterDomain is the DNS domain of the cluster, used to build the
+// fully qualified name of referenced Services.
+var ClusterDomain = "cluster.local"
+
+// serviceRefIndexKey indexes OnionServices by the namespace/name of the
+// Service they target.
+const serviceRefIndexKey = ".spec.backend.serviceRef"
+
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch
+
+// hiddenServicePort is a resolved HiddenServicePort line of the torrc.
+type hiddenServicePort struct {
+	port   int32
+	target string
+}
+
+// resolveBackend returns the HiddenServicePort lines of the OnionService.
+// When the referenced Service or one of its ports cannot be found, the
+// condition is reported in status and no port is returned.
+func (r *OnionServiceReconciler) resolveBackend(ctx context.Context, onion *v1beta1.OnionService) ([]hiddenServicePort, error) {
+	ref := serviceRef(onion)
+	if ref == nil {
+		if onion.Spec.HiddenServicePort <= 0 {
+			return nil, r.updateBackendCondition(ctx, onion, v1beta1.ReasonInvalidPort,
+				"hiddenServicePort is required")
+		}
+		return []hiddenServicePort{
+			{port: int32(onion.Spec.HiddenServicePort), target: onion.Spec.HiddenServiceTarget},
+		}, nil
+	}
+
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = onion.Namespace
+	}
+
+	service := &corev1.Service{}
+	err := r.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespace}, service)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, r.updateBackendCondition(ctx, onion, v1beta1.ReasonBackendNotFound,
+				fmt.Sprintf("Service %s/%s not found", namespace, ref.Name))
+		}
+		return nil, err
+	}
+
+	ports := onion.Spec.Ports
+	if len(ports) == 0 {
+		ports = []v1beta1.OnionServicePort{
+			{Port: int32(onion.Spec.HiddenServicePort), TargetPort: ref.Port},
+		}
+	}
+
+	host := fmt.Sprintf("%s.%s.svc.%s", ref.Name, namespace, ClusterDomain)
+	resolved := make([]hiddenServicePort, 0, len(ports))
+	for _, p := range ports {
+		if p.Port <= 0 {
+			return nil, r.updateBackendCondition(ctx, onion, v1beta1.ReasonInvalidPort,
+				fmt.Sprintf("invalid onion port %d, set hiddenServicePort or ports", p.Port))
+		}
+
+		targetPort := p.TargetPort
+		if targetPort.Type == intstr.Int && targetPort.IntVal == 0 {
+			targetPort = intstr.FromInt32(p.Port)
+		}
+
+		port, ok := servicePort(service, targetPort)
+		if !ok {
+			return nil, r.updateBackendCondition(ctx, onion, v1beta1.ReasonPortNotFound,
+				fmt.Sprintf("Service %s/%s has no port %s", namespace, ref.Name, targetPort.String()))
+		}
+		resolved = append(resolved, hiddenServicePort{port: p.Port, target: fmt.Sprintf("%s:%d", host, port)})
+	}
+
+	changed := meta.SetStatusCondition(&onion.Status.Conditions, metav1.Condition{
+		Type:               v1beta1.ConditionBackendResolved,
+		Status:             metav1.ConditionTrue,
+		Reason:             v1beta1.ReasonBackendResolved,
+		Message:            fmt.Sprintf("Service %s/%s resolved to %s", namespace, ref.Name, host),
+		ObservedGeneration: onion.Generation,
+	})
+	// A Ready OnionService may not update its status again, persist the
+	// condition now rather than keeping a stale failure.
+	if changed {
+		if err := r.Status().Update(ctx, onion); err != nil {
+			return nil, err
+		}
+	}
+
+	return resolved, nil
+}
+
+// servicePort looks up a Service port by name or by number.
+func servicePort(service *corev1.Service, port intstr.IntOrString) (int32, bool) {
+	for _, p := range service.Spec.Ports {
+		if port.Type == intstr.String && p.Name == port.StrVal {
+			return p.Port, true
+		}
+		if port.Type == intstr.Int && p.Port == port.IntVal {
+			return p.Port, true
+		}
+	}
+	return 0, false
+}
+
+func (r *OnionServiceReconciler) updateBackendCondition(ctx context.Context, onion *v1beta1.OnionService, reason, message string) error {
+	meta.SetStatusCondition(&onion.Status.Conditions, metav1.Condition{
+		Type:               v1beta1.ConditionBackendResolved,
+		Status:             metav1.ConditionFalse,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: onion.Generation,
+	})
+
+	// The tor pod keeps running with its previous configuration, so the
+	// address stays the same until the backend comes back.
+	return r.updateStatus(ctx, onion, "Pending", onion.Status.OnionAddress, message)
+}
+
+func serviceRef(onion *v1beta1.OnionService) *v1beta1.ServiceReference {
+	if onion.Spec.Backend == nil {
+		return nil
+	}
+	return onion.Spec.Backend.ServiceRef
+}
+
+// indexServiceRef is the IndexerFunc for serviceRefIndexKey.
+func indexServiceRef(obj client.Object) []string {
+	onion := obj.(*v1beta1.OnionService)
+	ref := serviceRef(onion)
+	if ref == nil {
+		return nil
+	}
+
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = onion.Namespace
+	}
+	return []string{namespace + "/" + ref.Name}
+}
+
+// onionServicesForService enqueues the OnionServices targeting the Service,
+// so that changes and deletions are reflected in their torrc and status.
+func (r *OnionServiceReconciler) onionServicesForService(ctx context.Context, obj client.Object) []reconcile.Request {
+	onionList := &v1beta1.OnionServiceList{}
+	err := r.List(ctx, onionList, client.MatchingFields{serviceRefIndexKey: obj.GetNamespace() + "/" + obj.GetName()})
+	if err != nil {
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(onionList.Items))
+	for _, onion := range onionList.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: onion.Name, Namespace: onion.Namespace},
+		})
+	}
+	return requests
+}
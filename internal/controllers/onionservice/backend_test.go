@@ -0,0 +1,62 @@
+package onionservice
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+var _ = Describe("backend", func() {
+	It("persists the condition once the Service is fixed", func() {
+		ctx := context.Background()
+		scheme := runtime.NewScheme()
+		Expect(v1beta1.AddToScheme(scheme)).To(Succeed())
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+
+		onion := &v1beta1.OnionService{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec: v1beta1.OnionServiceSpec{
+				HiddenServicePort: 80,
+				Backend: &v1beta1.OnionServiceBackend{
+					ServiceRef: &v1beta1.ServiceReference{Name: "web", Port: intstr.FromString("http")},
+				},
+			},
+			Status: v1beta1.OnionServiceStatus{
+				Phase: "Ready",
+				Conditions: []metav1.Condition{{
+					Type:               v1beta1.ConditionBackendResolved,
+					Status:             metav1.ConditionFalse,
+					Reason:             v1beta1.ReasonPortNotFound,
+					LastTransitionTime: metav1.Now(),
+				}},
+			},
+		}
+		service := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 8080}}},
+		}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(onion, service).
+			WithStatusSubresource(onion).Build()
+		r := &OnionServiceReconciler{Client: c, Scheme: scheme}
+
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(onion), onion)).To(Succeed())
+		ports, err := r.resolveBackend(ctx, onion)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ports).To(Equal([]hiddenServicePort{{port: 80, target: "web.default.svc.cluster.local:8080"}}))
+
+		found := &v1beta1.OnionService{}
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(onion), found)).To(Succeed())
+		Expect(meta.IsStatusConditionTrue(found.Status.Conditions, v1beta1.ConditionBackendResolved)).To(BeTrue())
+		Expect(found.Status.Phase).To(Equal("Ready"))
+	})
+})
@@ -0,0 +1,123 @@
+package onionservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/agent"
+	"github.com/fulviodenza/torproxy/internal/backup"
+)
+
+// agentBackupArgs make the agent back up the keys of the HiddenServiceDirs
+// of the tor pod, the next one too during a key rotation.
+func agentBackupArgs(onion *v1beta1.OnionService) []string {
+	spec := onion.Spec.Backup
+	if spec == nil || onion.Spec.HighAvailability != nil {
+		return nil
+	}
+
+	args := []string{
+		"--backup-endpoint=" + spec.Storage.Endpoint,
+		"--backup-bucket=" + spec.Storage.Bucket,
+		"--backup-prefix=" + backup.ObjectPrefix(spec.Storage.Prefix, onion.Namespace, onion.Name),
+		"--backup-recipient=" + spec.Recipient,
+		"--backup-dir=" + hiddenServiceDir(onion),
+	}
+	if rotating(onion) {
+		args = append(args, "--backup-dir="+nextHiddenServiceDir(onion))
+	}
+	if spec.Storage.Region != "" {
+		args = append(args, "--backup-region="+spec.Storage.Region)
+	}
+	if spec.PeriodSeconds > 0 {
+		args = append(args, fmt.Sprintf("--backup-interval=%ds", spec.PeriodSeconds))
+	}
+	return args
+}
+
+// agentBackupEnv passes the credentials of the backup storage to the
+// agent.
+func agentBackupEnv(onion *v1beta1.OnionService) []corev1.EnvVar {
+	spec := onion.Spec.Backup
+	if spec == nil || onion.Spec.HighAvailability != nil {
+		return nil
+	}
+
+	credential := func(env, key string) corev1.EnvVar {
+		return corev1.EnvVar{
+			Name: env,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: spec.Storage.CredentialsSecret},
+					Key:                  key,
+				},
+			},
+		}
+	}
+	return []corev1.EnvVar{
+		credential("AWS_ACCESS_KEY_ID", "accessKeyID"),
+		credential("AWS_SECRET_ACCESS_KEY", "secretAccessKey"),
+	}
+}
+
+// reconcileBackupStatus reports in status the last backup published by
+// the agent of a running tor pod.
+func (r *OnionServiceReconciler) reconcileBackupStatus(ctx context.Context, onion *v1beta1.OnionService) error {
+	var status *v1beta1.OnionBackupStatus
+	if onion.Spec.Backup != nil {
+		selector, err := metav1.LabelSelectorAsSelector(torPodSelector(onion))
+		if err != nil {
+			return err
+		}
+		podList := &corev1.PodList{}
+		err = r.List(ctx, podList, client.InNamespace(onion.Namespace), client.MatchingLabelsSelector{Selector: selector})
+		if err != nil {
+			return err
+		}
+
+		for _, pod := range podList.Items {
+			if pod.Status.Phase != corev1.PodRunning {
+				continue
+			}
+			published, err := agent.Published(ctx, r.Client, onion.Namespace, onion.Name, pod.Name, agent.BackupKey)
+			if err != nil {
+				torAccessFailures.WithLabelValues("agent").Inc()
+				return err
+			}
+			if published == "" {
+				continue
+			}
+			state := agent.BackupState{}
+			if err := json.Unmarshal([]byte(published), &state); err != nil {
+				log.FromContext(ctx).Info("Ignoring malformed backup state", "pod", pod.Name, "error", err.Error())
+				continue
+			}
+
+			lastBackupTime := metav1.NewTime(state.Time).Rfc3339Copy()
+			status = &v1beta1.OnionBackupStatus{
+				LastBackupTime: &lastBackupTime,
+				Objects:        state.Objects,
+				LastError:      state.Error,
+			}
+			break
+		}
+		// Keep reporting the last backup while the pod restarts.
+		if status == nil {
+			status = onion.Status.Backup
+		}
+	}
+
+	if equality.Semantic.DeepEqual(onion.Status.Backup, status) {
+		return nil
+	}
+	onion.Status.Backup = status
+	return r.Status().Update(ctx, onion)
+}
@@ -0,0 +1,161 @@
+package onionservice
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+// ExporterImage runs cmd/tor-exporter, it is shipped in the manager image.
+var ExporterImage = "fulviodenza/torproxy:latest"
+
+const (
+	// controlPortAddress and metricsPortAddress only listen on the loopback
+	// of the pod, they are reached by the agent and exporter sidecars.
+	controlPortAddress = "127.0.0.1:9051"
+	metricsPortAddress = "127.0.0.1:9035"
+
+	// controlDirectory is shared by tor, the agent and the exporter for the
+	// authentication cookie of the control port.
+	controlDirectory  = "/var/run/tor"
+	controlCookieFile = controlDirectory + "/control.authcookie"
+
+	defaultExporterPort = 9130
+)
+
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+
+func exporterPort(onion *v1beta1.OnionService) int32 {
+	if onion.Spec.Metrics.Port == 0 {
+		return defaultExporterPort
+	}
+	return onion.Spec.Metrics.Port
+}
+
+// generateControlTorrc opens the control port of tor to the agent and,
+// with metrics, the MetricsPort to the exporter.
+func generateControlTorrc(onion *v1beta1.OnionService) string {
+	var config strings.Builder
+	fmt.Fprintf(&config, "ControlPort %s\n", controlPortAddress)
+	fmt.Fprintf(&config, "CookieAuthentication 1\n")
+	fmt.Fprintf(&config, "CookieAuthFile %s\n", controlCookieFile)
+	if onion.Spec.Metrics != nil {
+		fmt.Fprintf(&config, "MetricsPort %s\n", metricsPortAddress)
+		fmt.Fprintf(&config, "MetricsPortPolicy accept 127.0.0.1\n")
+	}
+	return config.String()
+}
+
+// exporterContainer reads the control port and the MetricsPort of tor,
+// sharing the directory of the control port cookie.
+func exporterContainer(onion *v1beta1.OnionService) corev1.Container {
+	torUID := int64(101)
+	port := exporterPort(onion)
+	return corev1.Container{
+		Name:    "exporter",
+		Image:   ExporterImage,
+		Command: []string{"/tor-exporter"},
+		Args: []string{
+			"--control-address=" + controlPortAddress,
+			"--cookie-file=" + controlCookieFile,
+			"--tor-metrics-url=http://" + metricsPortAddress + "/metrics",
+			fmt.Sprintf("--listen-address=:%d", port),
+		},
+		Ports: []corev1.ContainerPort{
+			{
+				Name:          "metrics",
+				ContainerPort: port,
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "tor-control",
+				MountPath: controlDirectory,
+				ReadOnly:  true,
+			},
+		},
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser:  &torUID,
+			RunAsGroup: &torUID,
+		},
+	}
+}
+
+// reconcileMetricsService exposes the exporters of the tor pods of the
+// OnionService, the Service is deleted when metrics are disabled.
+func (r *OnionServiceReconciler) reconcileMetricsService(ctx context.Context, onion *v1beta1.OnionService) error {
+	name := onion.Name + "-metrics"
+
+	if onion.Spec.Metrics == nil {
+		service := &corev1.Service{}
+		err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: onion.Namespace}, service)
+		if err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if !metav1.IsControlledBy(service, onion) {
+			return nil
+		}
+		return client.IgnoreNotFound(r.Delete(ctx, service))
+	}
+
+	app := onion.Name
+	if onion.Spec.HighAvailability != nil {
+		app = onion.Name + "-backend"
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: onion.Namespace,
+			Labels: map[string]string{
+				v1beta1.OnionMetricsLabel:     "true",
+				v1beta1.OnionServiceHashLabel: onionServiceHash(onion),
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(onion, v1beta1.GroupVersion.WithKind("OnionService")),
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{
+				"app": app,
+			},
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "metrics",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       exporterPort(onion),
+					TargetPort: intstr.FromString("metrics"),
+				},
+			},
+		},
+	}
+
+	found := &corev1.Service{}
+	err := r.Get(ctx, types.NamespacedName{Name: service.Name, Namespace: service.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return r.Create(ctx, service)
+	} else if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(found.Labels, service.Labels) ||
+		!reflect.DeepEqual(found.Spec.Selector, service.Spec.Selector) ||
+		!reflect.DeepEqual(found.Spec.Ports, service.Spec.Ports) {
+		found.Labels = service.Labels
+		found.Spec.Selector = service.Spec.Selector
+		found.Spec.Ports = service.Spec.Ports
+		return r.Update(ctx, found)
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package onionservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/agent"
+)
+
+// agentCheckArgs make the agent check the backends of the tor pod.
+func agentCheckArgs(onion *v1beta1.OnionService) []string {
+	healthCheck := onion.Spec.HealthCheck
+	if healthCheck == nil {
+		return nil
+	}
+
+	checkType := agent.CheckTCP
+	if healthCheck.Type == "HTTP" {
+		checkType = agent.CheckHTTP
+	}
+	args := []string{"--check-backends=" + checkType, "--torrc=" + torrcFile}
+	if checkType == agent.CheckHTTP && healthCheck.Path != "" {
+		args = append(args, "--check-path="+healthCheck.Path)
+	}
+	if healthCheck.PeriodSeconds > 0 {
+		args = append(args, fmt.Sprintf("--check-interval=%ds", healthCheck.PeriodSeconds))
+	}
+	if healthCheck.TimeoutSeconds > 0 {
+		args = append(args, fmt.Sprintf("--check-timeout=%ds", healthCheck.TimeoutSeconds))
+	}
+	if healthCheck.StopAdvertising {
+		args = append(args, "--stop-advertising")
+	}
+	return args
+}
+
+// reconcileBackendHealth reports in status the health of the backends
+// published by the agents of the running tor pods.
+func (r *OnionServiceReconciler) reconcileBackendHealth(ctx context.Context, onion *v1beta1.OnionService) error {
+	log := log.FromContext(ctx)
+
+	var backendHealth []v1beta1.OnionPortHealth
+	if onion.Spec.HealthCheck != nil {
+		selector, err := metav1.LabelSelectorAsSelector(torPodSelector(onion))
+		if err != nil {
+			return err
+		}
+		podList := &corev1.PodList{}
+		err = r.List(ctx, podList, client.InNamespace(onion.Namespace), client.MatchingLabelsSelector{Selector: selector})
+		if err != nil {
+			return err
+		}
+
+		results := map[string][]agent.BackendHealth{}
+		for _, pod := range podList.Items {
+			if pod.Status.Phase != corev1.PodRunning {
+				continue
+			}
+			published, err := agent.Published(ctx, r.Client, onion.Namespace, onion.Name, pod.Name, agent.BackendsKey)
+			if err != nil {
+				torAccessFailures.WithLabelValues("agent").Inc()
+				return err
+			}
+			if published == "" {
+				continue
+			}
+			var podResults []agent.BackendHealth
+			if err := json.Unmarshal([]byte(published), &podResults); err != nil {
+				log.Info("Ignoring malformed backend health", "pod", pod.Name, "error", err.Error())
+				continue
+			}
+			results[pod.Name] = podResults
+		}
+		backendHealth = aggregateBackendHealth(results)
+	}
+
+	if equality.Semantic.DeepEqual(onion.Status.BackendHealth, backendHealth) {
+		return nil
+	}
+	onion.Status.BackendHealth = backendHealth
+	return r.Status().Update(ctx, onion)
+}
+
+// aggregateBackendHealth merges the checks of the tor pods: a target is
+// reachable when it is from every pod, its latency is the slowest one.
+func aggregateBackendHealth(results map[string][]agent.BackendHealth) []v1beta1.OnionPortHealth {
+	pods := make([]string, 0, len(results))
+	for pod := range results {
+		pods = append(pods, pod)
+	}
+	sort.Strings(pods)
+
+	health := []v1beta1.OnionPortHealth{}
+	index := map[string]int{}
+	for _, pod := range pods {
+		for _, result := range results[pod] {
+			key := fmt.Sprintf("%d %s", result.Port, result.Target)
+			i, ok := index[key]
+			if !ok {
+				i = len(health)
+				index[key] = i
+				health = append(health, v1beta1.OnionPortHealth{
+					Port:      result.Port,
+					Target:    result.Target,
+					Reachable: true,
+				})
+			}
+
+			port := &health[i]
+			if result.Reachable {
+				port.LatencyMilliseconds = max(port.LatencyMilliseconds, result.LatencyMs)
+			} else {
+				port.Reachable = false
+				if port.LastError == "" {
+					port.LastError = pod + ": " + strings.TrimSpace(result.Error)
+				}
+			}
+			if port.LastCheckTime == nil || result.CheckTime.After(port.LastCheckTime.Time) {
+				checkTime := metav1.NewTime(result.CheckTime).Rfc3339Copy()
+				port.LastCheckTime = &checkTime
+			}
+		}
+	}
+
+	if len(health) == 0 {
+		return nil
+	}
+	return health
+}
@@ -0,0 +1,57 @@
+package onionservice
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/agent"
+)
+
+var _ = Describe("Backend health", func() {
+	It("passes the check settings to the agent", func() {
+		Expect(agentCheckArgs(&v1beta1.OnionService{})).To(BeEmpty())
+		Expect(agentCheckArgs(&v1beta1.OnionService{Spec: v1beta1.OnionServiceSpec{
+			HealthCheck: &v1beta1.BackendHealthCheck{
+				Type:            "HTTP",
+				Path:            "/healthz",
+				PeriodSeconds:   10,
+				TimeoutSeconds:  3,
+				StopAdvertising: true,
+			},
+		}})).To(Equal([]string{
+			"--check-backends=http",
+			"--torrc=/etc/tor/torrc",
+			"--check-path=/healthz",
+			"--check-interval=10s",
+			"--check-timeout=3s",
+			"--stop-advertising",
+		}))
+	})
+
+	It("is reachable only when it is from every tor pod", func() {
+		earlier := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+		later := earlier.Add(5 * time.Second)
+
+		health := aggregateBackendHealth(map[string][]agent.BackendHealth{
+			"web-backend-1": {
+				{Port: 80, Target: "web:80", Reachable: true, LatencyMs: 12, CheckTime: later},
+				{Port: 22, Target: "ssh:22", Error: "connection refused", CheckTime: later},
+			},
+			"web-backend-0": {
+				{Port: 80, Target: "web:80", Reachable: true, LatencyMs: 30, CheckTime: earlier},
+				{Port: 22, Target: "ssh:22", Error: "i/o timeout", CheckTime: earlier},
+			},
+		})
+
+		Expect(health).To(Equal([]v1beta1.OnionPortHealth{
+			{Port: 80, Target: "web:80", Reachable: true, LatencyMilliseconds: 30, LastCheckTime: &metav1.Time{Time: later}},
+			{Port: 22, Target: "ssh:22", LastError: "web-backend-0: i/o timeout", LastCheckTime: &metav1.Time{Time: later}},
+		}))
+		Expect(aggregateBackendHealth(nil)).To(BeNil())
+	})
+})
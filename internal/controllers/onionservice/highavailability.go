@@ -0,0 +1,461 @@
+package onionservice
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/agent"
+	"github.com/fulviodenza/torproxy/internal/network"
+	"github.com/fulviodenza/torproxy/internal/onion"
+)
+
+var OnionbalanceDockerImage = "onionbalance:latest"
+
+const onionbalanceConfigPath = "/etc/onionbalance/config.yaml"
+
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
+
+// reconcileHighAvailability runs the OnionService as a set of Onionbalance
+// v3 backend instances, each one publishing its own descriptor, and a
+// frontend publishing the master descriptor that points to all of them.
+func (r *OnionServiceReconciler) reconcileHighAvailability(ctx context.Context, onionService *v1beta1.OnionService, ports []hiddenServicePort, torNetwork *network.Config) error {
+	masterAddress, err := r.reconcileMasterKey(ctx, onionService)
+	if err != nil {
+		return err
+	}
+
+	if err := r.reconcileConfigMap(ctx, onionService, generateTorrcConfig(onionService, ports)+torNetwork.Torrc()); err != nil {
+		torrcRenderErrors.WithLabelValues("configmap").Inc()
+		return err
+	}
+
+	if err := r.reconcileBackendStatefulSet(ctx, onionService, masterAddress); err != nil {
+		return err
+	}
+
+	backends, err := r.backendAddresses(ctx, onionService)
+	if err != nil {
+		return err
+	}
+
+	if err := r.reconcileOnionbalanceConfigMap(ctx, onionService, generateOnionbalanceConfig(backends)); err != nil {
+		return err
+	}
+
+	if err := r.reconcileOnionbalanceDeployment(ctx, onionService, torNetwork); err != nil {
+		return err
+	}
+
+	return r.reconcileHighAvailabilityStatus(ctx, onionService, masterAddress, backends)
+}
+
+// reconcileMasterKey makes sure the master identity used by the frontend
+// exists, unless it is the KeySecret, and returns its onion address.
+func (r *OnionServiceReconciler) reconcileMasterKey(ctx context.Context, onionService *v1beta1.OnionService) (string, error) {
+	if onionService.Spec.KeySecret != "" {
+		return r.keySecretAddress(ctx, onionService)
+	}
+
+	found := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: onionService.Name + "-master-key", Namespace: onionService.Namespace}, found)
+	if err == nil {
+		return string(found.Data[onion.HostnameFile]), nil
+	} else if !errors.IsNotFound(err) {
+		return "", err
+	}
+
+	key, err := onion.GenerateKey()
+	if err != nil {
+		return "", err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      onionService.Name + "-master-key",
+			Namespace: onionService.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(onionService, v1beta1.GroupVersion.WithKind("OnionService")),
+			},
+		},
+		Data: map[string][]byte{
+			onion.SecretKeyFile: key.SecretKey,
+			onion.PublicKeyFile: key.PublicKey,
+			onion.HostnameFile:  []byte(key.Hostname),
+		},
+	}
+	if err := r.Create(ctx, secret); err != nil {
+		return "", err
+	}
+
+	return key.Hostname, nil
+}
+
+// generateOnionbalanceConfig renders the onionbalance config.yaml listing
+// the backend instances known so far.
+func generateOnionbalanceConfig(backends []v1beta1.OnionBackendStatus) string {
+	var config strings.Builder
+
+	fmt.Fprintf(&config, "services:\n")
+	fmt.Fprintf(&config, "- key: /etc/onionbalance/keys/%s\n", onion.SecretKeyFile)
+	fmt.Fprintf(&config, "  instances:\n")
+	for _, backend := range backends {
+		if backend.OnionAddress == "" {
+			continue
+		}
+		fmt.Fprintf(&config, "  - address: %s\n", backend.OnionAddress)
+		fmt.Fprintf(&config, "    name: %s\n", backend.Name)
+	}
+
+	return config.String()
+}
+
+func (r *OnionServiceReconciler) reconcileBackendStatefulSet(ctx context.Context, onionService *v1beta1.OnionService, masterAddress string) error {
+	name := onionService.Name + "-backend"
+	hiddenServiceDir := hiddenServiceDir(onionService)
+
+	podSpec := torPodSpec(onionService)
+	// Every instance must know the master address it is serving, tor reads
+	// it from the ob_config file inside the HiddenServiceDir.
+	podSpec.InitContainers[0].Command[2] += fmt.Sprintf(" && echo 'MasterOnionAddress %s' > %s && chown 101:101 %s",
+		masterAddress, filepath.Join(hiddenServiceDir, "ob_config"), filepath.Join(hiddenServiceDir, "ob_config"))
+	podSpec.Affinity = &corev1.Affinity{
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+				{
+					Weight: 100,
+					PodAffinityTerm: corev1.PodAffinityTerm{
+						LabelSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{
+								"app": name,
+							},
+						},
+						TopologyKey: corev1.LabelHostname,
+					},
+				},
+			},
+		},
+	}
+
+	replicas := onionService.Spec.HighAvailability.Backends
+	if replicas < 2 {
+		replicas = 2
+	}
+
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: onionService.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(onionService, v1beta1.GroupVersion.WithKind("OnionService")),
+			},
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:            &replicas,
+			PodManagementPolicy: appsv1.ParallelPodManagement,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": name,
+				},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app": name,
+					},
+				},
+				Spec: podSpec,
+			},
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "hidden-service",
+					},
+					Spec: corev1.PersistentVolumeClaimSpec{
+						AccessModes: []corev1.PersistentVolumeAccessMode{
+							corev1.ReadWriteOnce,
+						},
+						Resources: corev1.VolumeResourceRequirements{
+							Requests: corev1.ResourceList{
+								corev1.ResourceStorage: resource.MustParse("100Mi"),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	found := &appsv1.StatefulSet{}
+	err := r.Get(ctx, types.NamespacedName{Name: statefulSet.Name, Namespace: statefulSet.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return r.Create(ctx, statefulSet)
+	} else if err != nil {
+		return err
+	}
+
+	// Volume claim templates are immutable, only replicas and the pod
+	// template are kept in sync.
+	found.Spec.Replicas = statefulSet.Spec.Replicas
+	found.Spec.Template = statefulSet.Spec.Template
+	return r.Update(ctx, found)
+}
+
+// deleteInactiveMode removes the objects of the mode the OnionService does
+// not run in, left over after highAvailability was toggled: the Deployment
+// and the PVC of a single tor pod, or the backend StatefulSet, its PVCs and
+// the frontend. The master key Secret is kept, to get the same address back.
+func (r *OnionServiceReconciler) deleteInactiveMode(ctx context.Context, onionService *v1beta1.OnionService) error {
+	if onionService.Spec.HighAvailability != nil {
+		if err := r.deleteOwned(ctx, onionService, &appsv1.Deployment{}, onionService.Name); err != nil {
+			return err
+		}
+		return r.deleteOwned(ctx, onionService, &corev1.PersistentVolumeClaim{}, onionService.Name+"-hidden-service")
+	}
+
+	onionService.Status.Backends = nil
+	backend := onionService.Name + "-backend"
+	if err := r.deleteOwned(ctx, onionService, &appsv1.StatefulSet{}, backend); err != nil {
+		return err
+	}
+	if err := r.deleteOwned(ctx, onionService, &appsv1.Deployment{}, onionService.Name+"-onionbalance"); err != nil {
+		return err
+	}
+	if err := r.deleteOwned(ctx, onionService, &corev1.ConfigMap{}, onionService.Name+"-onionbalance"); err != nil {
+		return err
+	}
+
+	// The StatefulSet leaves its PVCs behind, they are not owned and only
+	// hold the disposable keys of the instances.
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	err := r.List(ctx, pvcs, client.InNamespace(onionService.Namespace), client.MatchingLabels{"app": backend})
+	if err != nil {
+		return err
+	}
+	for i := range pvcs.Items {
+		if !strings.HasPrefix(pvcs.Items[i].Name, "hidden-service-"+backend+"-") {
+			continue
+		}
+		if err := r.Delete(ctx, &pvcs.Items[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteOwned deletes the object of name if the OnionService controls it.
+func (r *OnionServiceReconciler) deleteOwned(ctx context.Context, onionService *v1beta1.OnionService, object client.Object, name string) error {
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: onionService.Namespace}, object)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(object, onionService) {
+		return nil
+	}
+	return client.IgnoreNotFound(r.Delete(ctx, object))
+}
+
+// backendAddresses reads the instance address of every running backend pod.
+func (r *OnionServiceReconciler) backendAddresses(ctx context.Context, onionService *v1beta1.OnionService) ([]v1beta1.OnionBackendStatus, error) {
+	log := log.FromContext(ctx)
+
+	podList := &corev1.PodList{}
+	err := r.List(ctx, podList, client.InNamespace(onionService.Namespace), client.MatchingLabels{"app": onionService.Name + "-backend"})
+	if err != nil {
+		return nil, err
+	}
+
+	backends := []v1beta1.OnionBackendStatus{}
+	for _, pod := range podList.Items {
+		backend := v1beta1.OnionBackendStatus{Name: pod.Name}
+		if pod.Status.Phase == corev1.PodRunning {
+			address, err := agent.Published(ctx, r.Client, onionService.Namespace, onionService.Name, pod.Name, hostnameKey)
+			if err != nil {
+				torAccessFailures.WithLabelValues("agent").Inc()
+				log.Info("Failed to read backend onion address, will retry", "pod", pod.Name, "error", err.Error())
+			}
+			backend.OnionAddress = address
+		}
+		backends = append(backends, backend)
+	}
+
+	sort.Slice(backends, func(i, j int) bool {
+		return backends[i].Name < backends[j].Name
+	})
+
+	return backends, nil
+}
+
+func (r *OnionServiceReconciler) reconcileOnionbalanceConfigMap(ctx context.Context, onionService *v1beta1.OnionService, config string) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      onionService.Name + "-onionbalance",
+			Namespace: onionService.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(onionService, v1beta1.GroupVersion.WithKind("OnionService")),
+			},
+		},
+		Data: map[string]string{
+			"config.yaml": config,
+		},
+	}
+
+	found := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: cm.Name, Namespace: cm.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return r.Create(ctx, cm)
+	} else if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(found.Data, cm.Data) {
+		found.Data = cm.Data
+		return r.Update(ctx, found)
+	}
+
+	return nil
+}
+
+func (r *OnionServiceReconciler) reconcileOnionbalanceDeployment(ctx context.Context, onionService *v1beta1.OnionService, torNetwork *network.Config) error {
+	name := onionService.Name + "-onionbalance"
+
+	image := onionService.Spec.HighAvailability.OnionbalanceImage
+	if image == "" {
+		image = OnionbalanceDockerImage
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: onionService.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(onionService, v1beta1.GroupVersion.WithKind("OnionService")),
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": name,
+				},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app": name,
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "tor",
+							Image: image,
+							Command: append([]string{
+								"tor",
+								"--SocksPort", "0",
+								"--ControlPort", "127.0.0.1:9051",
+								"--CookieAuthentication", "0",
+							}, torNetwork.Args()...),
+						},
+						{
+							Name:  "onionbalance",
+							Image: image,
+							Command: []string{
+								"onionbalance",
+								"-v", "info",
+								"-c", onionbalanceConfigPath,
+								"-p", "9051",
+							},
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "config",
+									MountPath: onionbalanceConfigPath,
+									SubPath:   "config.yaml",
+								},
+								{
+									Name:      "master-key",
+									MountPath: "/etc/onionbalance/keys",
+									ReadOnly:  true,
+								},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "config",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: name,
+									},
+								},
+							},
+						},
+						{
+							Name: "master-key",
+							VolumeSource: corev1.VolumeSource{
+								Secret: &corev1.SecretVolumeSource{
+									SecretName: masterKeySecretName(onionService),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	found := &appsv1.Deployment{}
+	err := r.Get(ctx, types.NamespacedName{Name: deployment.Name, Namespace: deployment.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return r.Create(ctx, deployment)
+	} else if err != nil {
+		return err
+	}
+
+	found.Spec = deployment.Spec
+	return r.Update(ctx, found)
+}
+
+func (r *OnionServiceReconciler) reconcileHighAvailabilityStatus(ctx context.Context, onionService *v1beta1.OnionService, masterAddress string, backends []v1beta1.OnionBackendStatus) error {
+	onionService.Status.Backends = backends
+
+	published := 0
+	for _, backend := range backends {
+		if backend.OnionAddress != "" {
+			published++
+		}
+	}
+	if published == 0 {
+		return r.updateStatus(ctx, onionService, "Initializing", masterAddress, "Waiting for backend instances to generate their onion addresses")
+	}
+
+	frontend := &appsv1.Deployment{}
+	err := r.Get(ctx, types.NamespacedName{Name: onionService.Name + "-onionbalance", Namespace: onionService.Namespace}, frontend)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return r.updateStatus(ctx, onionService, "Pending", masterAddress, "Onionbalance frontend not yet created")
+		}
+		return err
+	}
+
+	if frontend.Status.ReadyReplicas == 0 {
+		return r.updateStatus(ctx, onionService, "Initializing", masterAddress, "Waiting for onionbalance frontend to become ready")
+	}
+
+	return r.updateStatus(ctx, onionService, "Ready", masterAddress,
+		fmt.Sprintf("OnionService is ready, %d/%d backend instances published", published, len(backends)))
+}
@@ -0,0 +1,190 @@
+package onionservice
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/network"
+)
+
+var _ = Describe("High availability", func() {
+	ctx := context.Background()
+	ports := []hiddenServicePort{{port: 80, target: "web.default.svc.cluster.local:80"}}
+
+	highlyAvailable := func(spec v1beta1.OnionServiceSpec) *v1beta1.OnionService {
+		if spec.HighAvailability == nil {
+			spec.HighAvailability = &v1beta1.HighAvailabilitySpec{Backends: 3}
+		}
+		return &v1beta1.OnionService{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "onion-uid"},
+			Spec:       spec,
+		}
+	}
+
+	newClient := func(objects ...client.Object) client.Client {
+		scheme := runtime.NewScheme()
+		Expect(v1beta1.AddToScheme(scheme)).To(Succeed())
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+	}
+
+	DescribeTable("flags the hidden service of the backends as an instance",
+		func(onion *v1beta1.OnionService, torrc string) {
+			Expect(generateTorrcConfig(onion, ports)).To(Equal(torrc))
+		},
+		Entry("single tor pod", &v1beta1.OnionService{},
+			"HiddenServiceDir /var/lib/tor/hidden_service/\n"+
+				"HiddenServicePort 80 web.default.svc.cluster.local:80\n"+
+				"ControlPort 127.0.0.1:9051\n"+
+				"CookieAuthentication 1\n"+
+				"CookieAuthFile /var/run/tor/control.authcookie\n"+
+				"DataDirectory /var/lib/tor\n"+
+				"RunAsDaemon 0\n"),
+		Entry("backend instance", highlyAvailable(v1beta1.OnionServiceSpec{SOCKSPort: 9050}),
+			"SOCKSPort 9050\n"+
+				"HiddenServiceDir /var/lib/tor/hidden_service/\n"+
+				"HiddenServicePort 80 web.default.svc.cluster.local:80\n"+
+				"HiddenServiceOnionbalanceInstance 1\n"+
+				"ControlPort 127.0.0.1:9051\n"+
+				"CookieAuthentication 1\n"+
+				"CookieAuthFile /var/run/tor/control.authcookie\n"+
+				"DataDirectory /var/lib/tor\n"+
+				"RunAsDaemon 0\n"),
+	)
+
+	DescribeTable("lists the published instances in the onionbalance config",
+		func(backends []v1beta1.OnionBackendStatus, config string) {
+			Expect(generateOnionbalanceConfig(backends)).To(Equal(config))
+		},
+		Entry("none yet", nil,
+			"services:\n"+
+				"- key: /etc/onionbalance/keys/hs_ed25519_secret_key\n"+
+				"  instances:\n"),
+		Entry("unpublished ones are left out", []v1beta1.OnionBackendStatus{
+			{Name: "web-backend-0", OnionAddress: "aaaa.onion"},
+			{Name: "web-backend-1"},
+			{Name: "web-backend-2", OnionAddress: "cccc.onion"},
+		},
+			"services:\n"+
+				"- key: /etc/onionbalance/keys/hs_ed25519_secret_key\n"+
+				"  instances:\n"+
+				"  - address: aaaa.onion\n"+
+				"    name: web-backend-0\n"+
+				"  - address: cccc.onion\n"+
+				"    name: web-backend-2\n"),
+	)
+
+	It("points every backend to the master address", func() {
+		onion := highlyAvailable(v1beta1.OnionServiceSpec{HighAvailability: &v1beta1.HighAvailabilitySpec{Backends: 3}})
+		c := newClient()
+		r := &OnionServiceReconciler{Client: c, Scheme: c.Scheme()}
+		Expect(r.reconcileBackendStatefulSet(ctx, onion, "master.onion")).To(Succeed())
+
+		statefulSet := &appsv1.StatefulSet{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "web-backend", Namespace: "default"}, statefulSet)).To(Succeed())
+		Expect(*statefulSet.Spec.Replicas).To(Equal(int32(3)))
+		Expect(statefulSet.Spec.Template.Spec.InitContainers[0].Command[2]).To(HaveSuffix(
+			" && echo 'MasterOnionAddress master.onion' > /var/lib/tor/hidden_service/ob_config" +
+				" && chown 101:101 /var/lib/tor/hidden_service/ob_config"))
+	})
+
+	DescribeTable("runs the frontend with the master key",
+		func(onion *v1beta1.OnionService, torNetwork *network.Config, secret, image string, args []string) {
+			c := newClient()
+			r := &OnionServiceReconciler{Client: c, Scheme: c.Scheme()}
+			Expect(r.reconcileOnionbalanceDeployment(ctx, onion, torNetwork)).To(Succeed())
+
+			frontend := &appsv1.Deployment{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "web-onionbalance", Namespace: "default"}, frontend)).To(Succeed())
+			spec := frontend.Spec.Template.Spec
+			Expect(spec.Containers[0].Image).To(Equal(image))
+			Expect(spec.Containers[0].Command).To(Equal(append([]string{
+				"tor", "--SocksPort", "0", "--ControlPort", "127.0.0.1:9051", "--CookieAuthentication", "0",
+			}, args...)))
+			Expect(spec.Containers[1].Command).To(Equal([]string{
+				"onionbalance", "-v", "info", "-c", "/etc/onionbalance/config.yaml", "-p", "9051",
+			}))
+			Expect(spec.Volumes[0].ConfigMap.Name).To(Equal("web-onionbalance"))
+			Expect(spec.Volumes[1].Secret.SecretName).To(Equal(secret))
+		},
+		Entry("generated master key", highlyAvailable(v1beta1.OnionServiceSpec{}), nil,
+			"web-master-key", OnionbalanceDockerImage, nil),
+		Entry("key Secret and image", highlyAvailable(v1beta1.OnionServiceSpec{
+			KeySecret:        "web-key",
+			HighAvailability: &v1beta1.HighAvailabilitySpec{Backends: 2, OnionbalanceImage: "example.com/onionbalance"},
+		}), nil, "web-key", "example.com/onionbalance", nil),
+		Entry("private network", highlyAvailable(v1beta1.OnionServiceSpec{}),
+			&network.Config{DirAuthorities: []string{"auth0 orport=5000 no-v2 v3ident=AAAA 10.96.0.10:7000 BBBB"}},
+			"web-master-key", OnionbalanceDockerImage,
+			[]string{"--TestingTorNetwork", "1", "--DirAuthority", "auth0 orport=5000 no-v2 v3ident=AAAA 10.96.0.10:7000 BBBB"}),
+	)
+
+	It("deletes the objects of the other mode when highAvailability is toggled", func() {
+		onion := highlyAvailable(v1beta1.OnionServiceSpec{})
+		owned := metav1.ObjectMeta{
+			Namespace:       "default",
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(onion, v1beta1.GroupVersion.WithKind("OnionService"))},
+		}
+		named := func(name string) metav1.ObjectMeta {
+			meta := *owned.DeepCopy()
+			meta.Name = name
+			return meta
+		}
+		backendPVC := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+			Name: "hidden-service-web-backend-0", Namespace: "default", Labels: map[string]string{"app": "web-backend"},
+		}}
+		c := newClient(
+			&appsv1.Deployment{ObjectMeta: named("web")},
+			&corev1.PersistentVolumeClaim{ObjectMeta: named("web-hidden-service")},
+			&appsv1.StatefulSet{ObjectMeta: named("web-backend")},
+			backendPVC,
+			&appsv1.Deployment{ObjectMeta: named("web-onionbalance")},
+			&corev1.ConfigMap{ObjectMeta: named("web-onionbalance")},
+			&corev1.Secret{ObjectMeta: named("web-master-key")},
+			// Not owned by the OnionService.
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "other-onionbalance", Namespace: "default"}},
+		)
+		r := &OnionServiceReconciler{Client: c, Scheme: c.Scheme()}
+		exists := func(object client.Object, name string) bool {
+			err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, object)
+			if errors.IsNotFound(err) {
+				return false
+			}
+			Expect(err).NotTo(HaveOccurred())
+			return true
+		}
+
+		// Turned on, the single tor pod goes away.
+		Expect(r.deleteInactiveMode(ctx, onion)).To(Succeed())
+		Expect(exists(&appsv1.Deployment{}, "web")).To(BeFalse())
+		Expect(exists(&corev1.PersistentVolumeClaim{}, "web-hidden-service")).To(BeFalse())
+		Expect(exists(&appsv1.StatefulSet{}, "web-backend")).To(BeTrue())
+		Expect(exists(&appsv1.Deployment{}, "web-onionbalance")).To(BeTrue())
+
+		// Turned off, the backends and the frontend go away.
+		onion.Spec.HighAvailability = nil
+		onion.Status.Backends = []v1beta1.OnionBackendStatus{{Name: "web-backend-0", OnionAddress: "aaaa.onion"}}
+		Expect(r.deleteInactiveMode(ctx, onion)).To(Succeed())
+		Expect(exists(&appsv1.StatefulSet{}, "web-backend")).To(BeFalse())
+		Expect(exists(&corev1.PersistentVolumeClaim{}, backendPVC.Name)).To(BeFalse())
+		Expect(exists(&appsv1.Deployment{}, "web-onionbalance")).To(BeFalse())
+		Expect(exists(&corev1.ConfigMap{}, "web-onionbalance")).To(BeFalse())
+		Expect(onion.Status.Backends).To(BeEmpty())
+
+		Expect(exists(&corev1.Secret{}, "web-master-key")).To(BeTrue())
+		Expect(exists(&appsv1.Deployment{}, "other-onionbalance")).To(BeTrue())
+	})
+})
@@ -0,0 +1,176 @@
+package onionservice
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/onion"
+)
+
+// A key rotation generates the next key in the <name>-next-key Secret and
+// serves it from a second HiddenServiceDir next to the current one. Once
+// the overlap period is over, it is moved to the <name>-rotated-key Secret
+// installed in the HiddenServiceDir in place of the previous key.
+
+func nextKeySecretName(onion *v1beta1.OnionService) string {
+	return onion.Name + "-next-key"
+}
+
+func rotatedKeySecretName(onion *v1beta1.OnionService) string {
+	return onion.Name + "-rotated-key"
+}
+
+// nextHiddenServiceDir serves the next key during the overlap period, on
+// the hidden service volume.
+func nextHiddenServiceDir(onion *v1beta1.OnionService) string {
+	return filepath.Join(filepath.Dir(hiddenServiceDir(onion)), "next_hidden_service") + "/"
+}
+
+// rotating reports whether the OnionService is in the overlap period of a
+// key rotation, serving both addresses.
+func rotating(onion *v1beta1.OnionService) bool {
+	return onion.Status.KeyRotation != nil && onion.Status.KeyRotation.RetireTime != nil
+}
+
+// mainKeySecret is the Secret installed in the HiddenServiceDir: the key
+// of the last rotation, or the KeySecret, if any.
+func (r *OnionServiceReconciler) mainKeySecret(ctx context.Context, onionService *v1beta1.OnionService) (string, error) {
+	err := r.Get(ctx, types.NamespacedName{Name: rotatedKeySecretName(onionService), Namespace: onionService.Namespace}, &corev1.Secret{})
+	if err == nil {
+		return rotatedKeySecretName(onionService), nil
+	} else if !errors.IsNotFound(err) {
+		return "", err
+	}
+	return onionService.Spec.KeySecret, nil
+}
+
+// reconcileKeyRotation starts a rotation when the trigger changes and
+// retires the previous key at the end of the overlap period. It returns
+// the time left until then.
+func (r *OnionServiceReconciler) reconcileKeyRotation(ctx context.Context, onionService *v1beta1.OnionService) (time.Duration, error) {
+	status := onionService.Status.KeyRotation
+
+	if rotating(onionService) {
+		if left := time.Until(status.RetireTime.Time); left > 0 {
+			return left, nil
+		}
+		return 0, r.retireKey(ctx, onionService)
+	}
+
+	spec := onionService.Spec.KeyRotation
+	if spec == nil || spec.Trigger == "" || (status != nil && status.Trigger == spec.Trigger) {
+		return 0, nil
+	}
+	// There is nothing to rotate until the first key is known.
+	if onionService.Status.OnionAddress == "" {
+		return 0, nil
+	}
+
+	hostname, err := r.reconcileNextKey(ctx, onionService)
+	if err != nil {
+		return 0, err
+	}
+
+	log.FromContext(ctx).Info("rotating the onion key", "previous", onionService.Status.OnionAddress, "next", hostname)
+	now := metav1.Now()
+	overlap := time.Duration(spec.OverlapSeconds) * time.Second
+	onionService.Status.KeyRotation = &v1beta1.KeyRotationStatus{
+		Trigger:              spec.Trigger,
+		OnionAddress:         hostname,
+		PreviousOnionAddress: onionService.Status.OnionAddress,
+		StartTime:            &now,
+		RetireTime:           &metav1.Time{Time: now.Add(overlap)},
+	}
+	onionService.Status.OnionAddress = hostname
+	return overlap, r.Status().Update(ctx, onionService)
+}
+
+// reconcileNextKey generates the next key, unless a previous attempt did,
+// and returns its onion address.
+func (r *OnionServiceReconciler) reconcileNextKey(ctx context.Context, onionService *v1beta1.OnionService) (string, error) {
+	found := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: nextKeySecretName(onionService), Namespace: onionService.Namespace}, found)
+	if err == nil {
+		return string(found.Data[onion.HostnameFile]), nil
+	} else if !errors.IsNotFound(err) {
+		return "", err
+	}
+
+	key, err := onion.GenerateKey()
+	if err != nil {
+		return "", err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      nextKeySecretName(onionService),
+			Namespace: onionService.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(onionService, v1beta1.GroupVersion.WithKind("OnionService")),
+			},
+		},
+		Data: map[string][]byte{
+			onion.SecretKeyFile: key.SecretKey,
+			onion.PublicKeyFile: key.PublicKey,
+			onion.HostnameFile:  []byte(key.Hostname),
+		},
+	}
+	return key.Hostname, r.Create(ctx, secret)
+}
+
+// retireKey moves the next key to the rotated key Secret, so it replaces
+// the previous key in the HiddenServiceDir, and ends the overlap period.
+func (r *OnionServiceReconciler) retireKey(ctx context.Context, onionService *v1beta1.OnionService) error {
+	next := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: nextKeySecretName(onionService), Namespace: onionService.Namespace}, next)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	// A previous attempt may have moved it already.
+	moved := errors.IsNotFound(err)
+	if !moved {
+		rotated := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      rotatedKeySecretName(onionService),
+				Namespace: onionService.Namespace,
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(onionService, v1beta1.GroupVersion.WithKind("OnionService")),
+				},
+			},
+			Data: next.Data,
+		}
+
+		found := &corev1.Secret{}
+		err := r.Get(ctx, types.NamespacedName{Name: rotated.Name, Namespace: rotated.Namespace}, found)
+		if err != nil && errors.IsNotFound(err) {
+			err = r.Create(ctx, rotated)
+		} else if err == nil {
+			found.Data = rotated.Data
+			err = r.Update(ctx, found)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	log.FromContext(ctx).Info("retiring the previous onion key", "address", onionService.Status.KeyRotation.PreviousOnionAddress)
+	onionService.Status.KeyRotation.PreviousOnionAddress = ""
+	onionService.Status.KeyRotation.RetireTime = nil
+	if err := r.Status().Update(ctx, onionService); err != nil {
+		return err
+	}
+
+	if moved {
+		return nil
+	}
+	return client.IgnoreNotFound(r.Delete(ctx, next))
+}
@@ -0,0 +1,58 @@
+package onionservice
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+var _ = Describe("Key rotation", func() {
+	onion := &v1beta1.OnionService{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: v1beta1.OnionServiceSpec{
+			KeyRotation: &v1beta1.KeyRotation{Trigger: "2024-05", OverlapSeconds: 3600},
+		},
+	}
+	ports := []hiddenServicePort{{port: 80, target: "web.default.svc.cluster.local:80"}}
+
+	It("serves both keys during the overlap", func() {
+		rotating := onion.DeepCopy()
+		rotating.Status.KeyRotation = &v1beta1.KeyRotationStatus{
+			Trigger:    "2024-05",
+			RetireTime: &metav1.Time{Time: time.Now().Add(time.Hour)},
+		}
+
+		Expect(generateTorrcConfig(rotating, ports)).To(HavePrefix(
+			"HiddenServiceDir /var/lib/tor/hidden_service/\n" +
+				"HiddenServicePort 80 web.default.svc.cluster.local:80\n" +
+				"HiddenServiceDir /var/lib/tor/hidden_service/next_hidden_service/\n" +
+				"HiddenServicePort 80 web.default.svc.cluster.local:80\n"))
+
+		podSpec := torPodSpec(rotating)
+		installKey(&podSpec, "next-onion-key", nextKeySecretName(rotating), nextHiddenServiceDir(rotating))
+		Expect(podSpec.Volumes[len(podSpec.Volumes)-1].Secret.SecretName).To(Equal("web-next-key"))
+		Expect(podSpec.InitContainers[0].Command[2]).To(HaveSuffix(
+			" && mkdir -p /var/lib/tor/hidden_service/next_hidden_service/" +
+				" && cp -L /etc/tor/keys/next-onion-key/hs_ed25519_secret_key /etc/tor/keys/next-onion-key/hs_ed25519_public_key" +
+				" /etc/tor/keys/next-onion-key/hostname /var/lib/tor/hidden_service/next_hidden_service/" +
+				" && chown -R 101:101 /var/lib/tor/hidden_service/next_hidden_service/" +
+				" && chmod 700 /var/lib/tor/hidden_service/next_hidden_service/" +
+				" && chmod 600 /var/lib/tor/hidden_service/next_hidden_service/hs_ed25519_secret_key" +
+				" /var/lib/tor/hidden_service/next_hidden_service/hs_ed25519_public_key" +
+				" /var/lib/tor/hidden_service/next_hidden_service/hostname"))
+	})
+
+	It("serves the rotated key alone after the overlap", func() {
+		retired := onion.DeepCopy()
+		retired.Status.KeyRotation = &v1beta1.KeyRotationStatus{Trigger: "2024-05"}
+
+		Expect(rotating(retired)).To(BeFalse())
+		Expect(strings.Count(generateTorrcConfig(retired, ports), "HiddenServiceDir")).To(Equal(1))
+	})
+})
@@ -0,0 +1,101 @@
+package onionservice
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/onion"
+)
+
+// keySecretPath is where the init container reads the key Secrets from.
+const keySecretPath = "/etc/tor/keys"
+
+// masterKeySecretName is the Secret holding the key of the frontend of a
+// highly available OnionService.
+func masterKeySecretName(onion *v1beta1.OnionService) string {
+	if onion.Spec.KeySecret != "" {
+		return onion.Spec.KeySecret
+	}
+	return onion.Name + "-master-key"
+}
+
+// keySecretAddress returns the onion address of the KeySecret, empty until
+// the Secret exists and holds a valid key.
+func (r *OnionServiceReconciler) keySecretAddress(ctx context.Context, onionService *v1beta1.OnionService) (string, error) {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: onionService.Spec.KeySecret, Namespace: onionService.Namespace}, secret)
+	if err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+
+	if _, err := onion.ParseSecretKey(secret.Data[onion.SecretKeyFile]); err != nil {
+		return "", nil
+	}
+	pub, err := onion.ParsePublicKey(secret.Data[onion.PublicKeyFile])
+	if err != nil {
+		return "", nil
+	}
+	hostname := onion.Address(pub) + ".onion"
+	if string(secret.Data[onion.HostnameFile]) != hostname {
+		return "", fmt.Errorf("the hostname of secret %s does not match its public key", secret.Name)
+	}
+	return hostname, nil
+}
+
+// installKey makes the init container of the tor pod copy the key files
+// of the Secret into dir, replacing the ones tor would generate.
+func installKey(podSpec *corev1.PodSpec, volume, secretName, dir string) {
+	mountPath := filepath.Join(keySecretPath, volume)
+
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: volume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: secretName,
+			},
+		},
+	})
+
+	init := &podSpec.InitContainers[0]
+	init.VolumeMounts = append(init.VolumeMounts, corev1.VolumeMount{
+		Name:      volume,
+		MountPath: mountPath,
+		ReadOnly:  true,
+	})
+
+	sources, targets := []string{}, []string{}
+	for _, file := range []string{onion.SecretKeyFile, onion.PublicKeyFile, onion.HostnameFile} {
+		sources = append(sources, filepath.Join(mountPath, file))
+		targets = append(targets, filepath.Join(dir, file))
+	}
+	// Secret files are symlinks, -L copies their content.
+	init.Command[2] += fmt.Sprintf(" && mkdir -p %s && cp -L %s %s && chown -R 101:101 %s && chmod 700 %s && chmod 600 %s",
+		dir, strings.Join(sources, " "), dir, dir, dir, strings.Join(targets, " "))
+}
+
+// onionServicesForKeySecret enqueues the OnionServices of the namespace
+// using the Secret as KeySecret, e.g. once an OnionKeyGeneration fills it.
+func (r *OnionServiceReconciler) onionServicesForKeySecret(ctx context.Context, obj client.Object) []reconcile.Request {
+	onionList := &v1beta1.OnionServiceList{}
+	if err := r.List(ctx, onionList, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, onion := range onionList.Items {
+		if onion.Spec.KeySecret == obj.GetName() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: onion.Name, Namespace: onion.Namespace},
+			})
+		}
+	}
+	return requests
+}
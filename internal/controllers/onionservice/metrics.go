@@ -0,0 +1,110 @@
+package onionservice
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+var (
+	// timeToReady is observed when an OnionService first gets its onion
+	// address.
+	timeToReady = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "torproxy_onionservice_time_to_ready_seconds",
+		Help:    "Time from the creation of an OnionService to its onion address being published in its status.",
+		Buckets: []float64{5, 10, 30, 60, 120, 300, 600, 1800},
+	})
+
+	// torAccessFailures counts the failed attempts to read the state of
+	// tor published by the agent of the pods, by method (agent).
+	torAccessFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "torproxy_tor_access_failures_total",
+		Help: "Failed attempts to read the state of tor from its pods, by method.",
+	}, []string{"method"})
+
+	// torrcRenderErrors counts the reconciliations which could not produce
+	// the torrc, by reason: backend, network or configmap.
+	torrcRenderErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "torproxy_torrc_render_errors_total",
+		Help: "Reconciliations of OnionServices which failed to render or store their torrc, by reason.",
+	}, []string{"reason"})
+
+	onionServicesDesc = prometheus.NewDesc(
+		"torproxy_onionservices",
+		"Number of OnionServices by phase.",
+		[]string{"phase"}, nil)
+	onionServiceReadyDesc = prometheus.NewDesc(
+		"torproxy_onionservice_ready",
+		"Whether the OnionService is Ready, 1, or not, 0.",
+		[]string{"namespace", "name"}, nil)
+)
+
+// statusCollector exports the phases of the OnionServices as found in the
+// cache at scrape time, so deleted ones do not leave stale series behind.
+type statusCollector struct {
+	client client.Client
+}
+
+func (c *statusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- onionServicesDesc
+	ch <- onionServiceReadyDesc
+}
+
+func (c *statusCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	onionList := &v1beta1.OnionServiceList{}
+	if err := c.client.List(ctx, onionList); err != nil {
+		ch <- prometheus.NewInvalidMetric(onionServicesDesc, err)
+		return
+	}
+
+	phases := map[string]int{}
+	for _, onion := range onionList.Items {
+		phase := onion.Status.Phase
+		if phase == "" {
+			phase = "Unknown"
+		}
+		phases[phase]++
+
+		ready := 0.0
+		if phase == "Ready" {
+			ready = 1
+		}
+		ch <- prometheus.MustNewConstMetric(onionServiceReadyDesc, prometheus.GaugeValue, ready, onion.Namespace, onion.Name)
+	}
+
+	for phase, count := range phases {
+		ch <- prometheus.MustNewConstMetric(onionServicesDesc, prometheus.GaugeValue, float64(count), phase)
+	}
+}
+
+// registerMetrics adds the OnionService metrics to the controller-runtime
+// registry served by the manager.
+func registerMetrics(c client.Client) error {
+	for _, collector := range []prometheus.Collector{
+		timeToReady,
+		torAccessFailures,
+		torrcRenderErrors,
+		&statusCollector{client: c},
+	} {
+		if err := metrics.Registry.Register(collector); err != nil {
+			if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// observeReady records the time to ready of the OnionService, once its
+// first ReadyTime is stored.
+func observeReady(onion *v1beta1.OnionService) {
+	timeToReady.Observe(onion.Status.ReadyTime.Sub(onion.CreationTimestamp.Time).Seconds())
+}
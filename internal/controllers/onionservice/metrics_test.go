@@ -0,0 +1,89 @@
+package onionservice
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+var _ = Describe("metrics", func() {
+	onion := func(namespace, name, phase string) *v1beta1.OnionService {
+		return &v1beta1.OnionService{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Status:     v1beta1.OnionServiceStatus{Phase: phase},
+		}
+	}
+
+	It("exports the phases and readiness of the OnionServices", func() {
+		scheme := runtime.NewScheme()
+		Expect(v1beta1.AddToScheme(scheme)).To(Succeed())
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			onion("default", "blog", "Ready"),
+			onion("default", "shop", "Initializing"),
+			onion("web", "wiki", "Initializing"),
+		).Build()
+
+		Expect(testutil.CollectAndCompare(&statusCollector{client: c}, strings.NewReader(`
+# HELP torproxy_onionservice_ready Whether the OnionService is Ready, 1, or not, 0.
+# TYPE torproxy_onionservice_ready gauge
+torproxy_onionservice_ready{name="blog",namespace="default"} 1
+torproxy_onionservice_ready{name="shop",namespace="default"} 0
+torproxy_onionservice_ready{name="wiki",namespace="web"} 0
+# HELP torproxy_onionservices Number of OnionServices by phase.
+# TYPE torproxy_onionservices gauge
+torproxy_onionservices{phase="Initializing"} 2
+torproxy_onionservices{phase="Ready"} 1
+`))).To(Succeed())
+	})
+
+	It("observes the time to the first ready once it is stored", func() {
+		ctx := context.Background()
+		scheme := runtime.NewScheme()
+		Expect(v1beta1.AddToScheme(scheme)).To(Succeed())
+		blog := onion("default", "blog", "Initializing")
+		upgraded := onion("default", "wiki", "Ready")
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(blog, upgraded).
+			WithStatusSubresource(blog, upgraded).Build()
+		r := &OnionServiceReconciler{Client: c, Scheme: scheme}
+		count := histogramCount()
+
+		// A conflicting update stores nothing and observes nothing.
+		stale := blog.DeepCopy()
+		stale.ResourceVersion = "1"
+		Expect(r.updateStatus(ctx, stale, "Ready", "", "")).NotTo(Succeed())
+		Expect(histogramCount()).To(Equal(count))
+
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(blog), blog)).To(Succeed())
+		Expect(r.updateStatus(ctx, blog, "Ready", "", "")).To(Succeed())
+		Expect(blog.Status.ReadyTime).NotTo(BeNil())
+		Expect(histogramCount()).To(Equal(count + 1))
+
+		// Later transitions to Ready are restarts or outages.
+		Expect(r.updateStatus(ctx, blog, "Initializing", "", "")).To(Succeed())
+		Expect(r.updateStatus(ctx, blog, "Ready", "", "")).To(Succeed())
+		Expect(histogramCount()).To(Equal(count + 1))
+
+		// OnionServices already Ready get a ReadyTime without observation.
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(upgraded), upgraded)).To(Succeed())
+		Expect(r.updateStatus(ctx, upgraded, "Ready", "", "")).To(Succeed())
+		Expect(upgraded.Status.ReadyTime).NotTo(BeNil())
+		Expect(histogramCount()).To(Equal(count + 1))
+	})
+})
+
+func histogramCount() uint64 {
+	metric := &dto.Metric{}
+	Expect(timeToReady.Write(metric)).To(Succeed())
+	return metric.GetHistogram().GetSampleCount()
+}
@@ -0,0 +1,334 @@
+package onionservice
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+
+// torPodSelector selects the pods running tor for the OnionService, the
+// Onionbalance backends included.
+func torPodSelector(onion *v1beta1.OnionService) *metav1.LabelSelector {
+	return &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{
+				Key:      "app",
+				Operator: metav1.LabelSelectorOpIn,
+				Values:   []string{onion.Name, onion.Name + "-backend"},
+			},
+		},
+	}
+}
+
+func networkPolicyLabels(onion *v1beta1.OnionService) map[string]string {
+	return map[string]string{
+		v1beta1.OnionServiceHashLabel: onionServiceHash(onion),
+	}
+}
+
+// reconcileNetworkPolicies restricts the ingress of the tor pods to the
+// SOCKS clients and, when asked to, the ingress of the backend pods to
+// tor. Policies no longer wanted are deleted.
+func (r *OnionServiceReconciler) reconcileNetworkPolicies(ctx context.Context, onion *v1beta1.OnionService) error {
+	wanted := []*networkingv1.NetworkPolicy{}
+
+	if onion.Spec.NetworkPolicy != nil {
+		wanted = append(wanted, socksNetworkPolicy(onion))
+
+		if onion.Spec.NetworkPolicy.RestrictBackend {
+			backend, err := r.backendNetworkPolicy(ctx, onion)
+			if err != nil {
+				return err
+			}
+			if backend != nil {
+				wanted = append(wanted, backend)
+			}
+		}
+	}
+
+	for _, networkPolicy := range wanted {
+		if err := r.reconcileNetworkPolicy(ctx, networkPolicy); err != nil {
+			return err
+		}
+	}
+
+	return r.deleteNetworkPolicies(ctx, onion, wanted...)
+}
+
+func socksNetworkPolicy(onion *v1beta1.OnionService) *networkingv1.NetworkPolicy {
+	peers := append(socksPolicyPeers(onion.Spec.SOCKSPolicy), onion.Spec.NetworkPolicy.SOCKSFrom...)
+
+	ingress := []networkingv1.NetworkPolicyIngressRule{}
+	if onion.Spec.SOCKSPort > 0 && len(peers) > 0 {
+		tcp := corev1.ProtocolTCP
+		port := intstr.FromInt(onion.Spec.SOCKSPort)
+		ingress = append(ingress, networkingv1.NetworkPolicyIngressRule{
+			From:  peers,
+			Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port}},
+		})
+	}
+	if onion.Spec.Metrics != nil {
+		// The exporter only serves metrics, let Prometheus scrape it from
+		// wherever it runs.
+		tcp := corev1.ProtocolTCP
+		port := intstr.FromInt32(exporterPort(onion))
+		ingress = append(ingress, networkingv1.NetworkPolicyIngressRule{
+			Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port}},
+		})
+	}
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        onion.Name + "-tor",
+			Namespace:   onion.Namespace,
+			Labels:      networkPolicyLabels(onion),
+			Annotations: onionServiceAnnotations(onion),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(onion, v1beta1.GroupVersion.WithKind("OnionService")),
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: *torPodSelector(onion),
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress:     ingress,
+		},
+	}
+}
+
+// socksPolicyPeers mirrors the SOCKSPolicy entries into ipBlocks. As in
+// tor, the first matching entry wins: an accept entry is dropped when an
+// earlier reject covers it, and the earlier rejects inside it are carved
+// out of it.
+func socksPolicyPeers(policy []string) []networkingv1.NetworkPolicyPeer {
+	peers := []networkingv1.NetworkPolicyPeer{}
+	rejected := []*net.IPNet{}
+
+	for _, entry := range policy {
+		fields := strings.Fields(entry)
+		if len(fields) != 2 {
+			continue
+		}
+		action := strings.TrimSuffix(fields[0], "6")
+
+		networks := []*net.IPNet{}
+		switch fields[1] {
+		case "*":
+			networks = append(networks, allIPv4, allIPv6)
+		case "*4":
+			networks = append(networks, allIPv4)
+		case "*6":
+			networks = append(networks, allIPv6)
+		default:
+			if network := parsePolicyAddress(fields[1]); network != nil {
+				networks = append(networks, network)
+			}
+		}
+
+		for _, network := range networks {
+			switch action {
+			case "accept":
+				if peer, ok := ipBlockPeer(network, rejected); ok {
+					peers = append(peers, peer)
+				}
+			case "reject":
+				rejected = append(rejected, network)
+			}
+		}
+	}
+	return peers
+}
+
+var (
+	_, allIPv4, _ = net.ParseCIDR("0.0.0.0/0")
+	_, allIPv6, _ = net.ParseCIDR("::/0")
+)
+
+// parsePolicyAddress parses ADDR[/MASK][:PORT], brackets around IPv6
+// addresses included. Ports cannot be expressed by ipBlocks and are dropped.
+func parsePolicyAddress(address string) *net.IPNet {
+	if strings.HasPrefix(address, "[") {
+		end := strings.Index(address, "]")
+		if end < 0 {
+			return nil
+		}
+		rest := address[end+1:]
+		address = address[1:end]
+		if strings.HasPrefix(rest, "/") {
+			address += strings.SplitN(rest, ":", 2)[0]
+		}
+	} else if strings.Count(address, ":") == 1 {
+		address = strings.SplitN(address, ":", 2)[0]
+	}
+
+	if !strings.Contains(address, "/") {
+		if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+			address += "/32"
+		} else {
+			address += "/128"
+		}
+	}
+
+	_, network, err := net.ParseCIDR(address)
+	if err != nil {
+		return nil
+	}
+	return network
+}
+
+// ipBlockPeer allows the network but the rejected ones. Networks are either
+// disjoint or nested: the network is not allowed at all when a rejected one
+// contains it, and rejected networks inside it are excepted.
+func ipBlockPeer(network *net.IPNet, rejected []*net.IPNet) (networkingv1.NetworkPolicyPeer, bool) {
+	block := &networkingv1.IPBlock{CIDR: network.String()}
+
+	ones, bits := network.Mask.Size()
+	for _, reject := range rejected {
+		rejectOnes, rejectBits := reject.Mask.Size()
+		if rejectBits != bits {
+			continue
+		}
+		switch {
+		case rejectOnes <= ones && reject.Contains(network.IP):
+			return networkingv1.NetworkPolicyPeer{}, false
+		case rejectOnes > ones && network.Contains(reject.IP):
+			block.Except = append(block.Except, reject.String())
+		}
+	}
+	return networkingv1.NetworkPolicyPeer{IPBlock: block}, true
+}
+
+// backendNamespace is the namespace of the Service referenced by the
+// backend, empty when there is none.
+func backendNamespace(onion *v1beta1.OnionService) string {
+	ref := serviceRef(onion)
+	if ref == nil {
+		return ""
+	}
+	if ref.Namespace == "" {
+		return onion.Namespace
+	}
+	return ref.Namespace
+}
+
+// backendNetworkPolicy selects the pods of the referenced Service, it is nil
+// when the backend is not a Service with a selector. It isolates the pods,
+// so it is only created in another namespace when the namespace opted in
+// with the AddressExportLabel.
+func (r *OnionServiceReconciler) backendNetworkPolicy(ctx context.Context, onion *v1beta1.OnionService) (*networkingv1.NetworkPolicy, error) {
+	ref := serviceRef(onion)
+	if ref == nil {
+		return nil, nil
+	}
+
+	namespace := backendNamespace(onion)
+	allowed, err := r.namespaceAllowed(ctx, onion, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		log.FromContext(ctx).Info("Backend namespace not labelled, the backend is not restricted",
+			"namespace", namespace, "label", v1beta1.AddressExportLabel)
+		return nil, nil
+	}
+
+	service := &corev1.Service{}
+	if err := r.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespace}, service); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(service.Spec.Selector) == 0 {
+		return nil, nil
+	}
+
+	networkPolicy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			// The Service may live in another namespace, the policy is
+			// cleaned up through its labels.
+			Name:        onion.Name + "-onion-backend",
+			Namespace:   namespace,
+			Labels:      networkPolicyLabels(onion),
+			Annotations: onionServiceAnnotations(onion),
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: service.Spec.Selector},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					From: []networkingv1.NetworkPolicyPeer{
+						{
+							NamespaceSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{corev1.LabelMetadataName: onion.Namespace},
+							},
+							PodSelector: torPodSelector(onion),
+						},
+					},
+				},
+			},
+		},
+	}
+	if namespace == onion.Namespace {
+		networkPolicy.OwnerReferences = []metav1.OwnerReference{
+			*metav1.NewControllerRef(onion, v1beta1.GroupVersion.WithKind("OnionService")),
+		}
+	}
+	return networkPolicy, nil
+}
+
+func (r *OnionServiceReconciler) reconcileNetworkPolicy(ctx context.Context, networkPolicy *networkingv1.NetworkPolicy) error {
+	found := &networkingv1.NetworkPolicy{}
+	err := r.Get(ctx, types.NamespacedName{Name: networkPolicy.Name, Namespace: networkPolicy.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return r.Create(ctx, networkPolicy)
+	} else if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(found.Spec, networkPolicy.Spec) {
+		found.Spec = networkPolicy.Spec
+		return r.Update(ctx, found)
+	}
+
+	return nil
+}
+
+// deleteNetworkPolicies deletes the NetworkPolicies of the OnionService, in
+// any namespace, but the kept ones.
+func (r *OnionServiceReconciler) deleteNetworkPolicies(ctx context.Context, onion *v1beta1.OnionService, keep ...*networkingv1.NetworkPolicy) error {
+	policyList := &networkingv1.NetworkPolicyList{}
+	if err := r.List(ctx, policyList, client.MatchingLabels(networkPolicyLabels(onion))); err != nil {
+		return err
+	}
+
+	for i := range policyList.Items {
+		networkPolicy := &policyList.Items[i]
+		kept := false
+		for _, k := range keep {
+			if k.Name == networkPolicy.Name && k.Namespace == networkPolicy.Namespace {
+				kept = true
+			}
+		}
+		if kept {
+			continue
+		}
+		if err := r.Delete(ctx, networkPolicy); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package onionservice
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+func TestOnionService(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "OnionService Suite")
+}
+
+var _ = Describe("SOCKSPolicy mirroring", func() {
+	blocks := func(peers []networkingv1.NetworkPolicyPeer) []networkingv1.IPBlock {
+		result := []networkingv1.IPBlock{}
+		for _, peer := range peers {
+			result = append(result, *peer.IPBlock)
+		}
+		return result
+	}
+
+	It("allows the accepted networks until reject *", func() {
+		peers := socksPolicyPeers([]string{
+			"accept 192.168.0.0/16",
+			"accept6 FC00::/7",
+			"reject *",
+			"accept 10.0.0.0/8",
+		})
+		Expect(blocks(peers)).To(Equal([]networkingv1.IPBlock{
+			{CIDR: "192.168.0.0/16"},
+			{CIDR: "fc00::/7"},
+		}))
+	})
+
+	It("carves the rejected networks out of the later accepts", func() {
+		peers := socksPolicyPeers([]string{
+			"reject 10.1.0.0/16",
+			"accept 10.0.0.0/8:9050",
+			"accept *",
+		})
+		Expect(blocks(peers)).To(Equal([]networkingv1.IPBlock{
+			{CIDR: "10.0.0.0/8", Except: []string{"10.1.0.0/16"}},
+			{CIDR: "0.0.0.0/0", Except: []string{"10.1.0.0/16"}},
+			{CIDR: "::/0"},
+		}))
+	})
+
+	DescribeTable("lets the first matching entry win",
+		func(policy []string, expected []networkingv1.IPBlock) {
+			Expect(blocks(socksPolicyPeers(policy))).To(Equal(expected))
+		},
+		Entry("reject inside a later accept",
+			[]string{"reject 10.1.0.0/16", "accept 10.0.0.0/8"},
+			[]networkingv1.IPBlock{{CIDR: "10.0.0.0/8", Except: []string{"10.1.0.0/16"}}}),
+		Entry("reject covering a later accept",
+			[]string{"reject 10.0.0.0/8", "accept 10.1.0.0/16"},
+			[]networkingv1.IPBlock{}),
+		Entry("reject equal to a later accept",
+			[]string{"reject 10.0.0.0/8", "accept 10.0.0.0/8"},
+			[]networkingv1.IPBlock{}),
+		Entry("accept inside a later reject",
+			[]string{"accept 10.1.0.0/16", "reject 10.0.0.0/8"},
+			[]networkingv1.IPBlock{{CIDR: "10.1.0.0/16"}}),
+		Entry("accept covering a later reject",
+			[]string{"accept 10.0.0.0/8", "reject 10.1.0.0/16"},
+			[]networkingv1.IPBlock{{CIDR: "10.0.0.0/8"}}),
+		Entry("disjoint reject",
+			[]string{"reject 192.168.0.0/16", "accept 10.0.0.0/8"},
+			[]networkingv1.IPBlock{{CIDR: "10.0.0.0/8"}}),
+		Entry("reject of a single family",
+			[]string{"reject *4", "accept 10.0.0.0/8", "accept *"},
+			[]networkingv1.IPBlock{{CIDR: "::/0"}}),
+	)
+
+	It("parses single and bracketed addresses", func() {
+		Expect(parsePolicyAddress("10.0.0.1").String()).To(Equal("10.0.0.1/32"))
+		Expect(parsePolicyAddress("[fc00::1]:9050").String()).To(Equal("fc00::1/128"))
+		Expect(parsePolicyAddress("[fc00::]/7:*").String()).To(Equal("fc00::/7"))
+		Expect(parsePolicyAddress("example.com")).To(BeNil())
+	})
+
+	It("rejects everything without entries", func() {
+		Expect(socksPolicyPeers(nil)).To(BeEmpty())
+	})
+})
+
+var _ = Describe("backend NetworkPolicy", func() {
+	It("is only created in the namespaces which opted in", func() {
+		ctx := context.Background()
+		scheme := runtime.NewScheme()
+		Expect(v1beta1.AddToScheme(scheme)).To(Succeed())
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(networkingv1.AddToScheme(scheme)).To(Succeed())
+
+		onionService := &v1beta1.OnionService{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec: v1beta1.OnionServiceSpec{
+				Backend: &v1beta1.OnionServiceBackend{
+					ServiceRef: &v1beta1.ServiceReference{Name: "web", Namespace: "other"},
+				},
+				NetworkPolicy: &v1beta1.OnionNetworkPolicy{RestrictBackend: true},
+			},
+		}
+		service := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "other"},
+			Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "web"}},
+		}
+		other := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(onionService, service, other).Build()
+		r := &OnionServiceReconciler{Client: c, Scheme: scheme}
+
+		Expect(r.reconcileNetworkPolicies(ctx, onionService)).To(Succeed())
+		err := c.Get(ctx, types.NamespacedName{Name: "web-onion-backend", Namespace: "other"}, &networkingv1.NetworkPolicy{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+
+		// Labelling the namespace restricts the backend.
+		other.Labels = map[string]string{v1beta1.AddressExportLabel: "true"}
+		Expect(c.Update(ctx, other)).To(Succeed())
+		Expect(r.onionServicesForNamespace(ctx, other)).To(HaveLen(1))
+		Expect(r.reconcileNetworkPolicies(ctx, onionService)).To(Succeed())
+
+		networkPolicy := &networkingv1.NetworkPolicy{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "web-onion-backend", Namespace: "other"}, networkPolicy)).To(Succeed())
+		Expect(networkPolicy.Spec.PodSelector.MatchLabels).To(Equal(service.Spec.Selector))
+
+		// Removing the label lifts the restriction.
+		other.Labels = nil
+		Expect(c.Update(ctx, other)).To(Succeed())
+		Expect(r.reconcileNetworkPolicies(ctx, onionService)).To(Succeed())
+		err = c.Get(ctx, types.NamespacedName{Name: "web-onion-backend", Namespace: "other"}, &networkingv1.NetworkPolicy{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+})
@@ -2,44 +2,51 @@ package onionservice
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"path/filepath"
 	"reflect"
 	"strings"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/remotecommand"
 
 	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/agent"
+	"github.com/fulviodenza/torproxy/internal/network"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 type OnionServiceReconciler struct {
 	client.Client
-	KubeClient kubernetes.Interface
-	Scheme     *runtime.Scheme
+	Scheme *runtime.Scheme
 }
 
 var TorDockerImage = "dperson/torproxy:latest"
 
 const torFinalizerName = "onionservice.tor.stack.io/finalizer"
 
+// hostnameKey is the key the agent publishes the onion address with.
+const hostnameKey = "hostname"
+
 // +kubebuilder:rbac:groups=tor.stack.io,resources=onionservices,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=tor.stack.io,resources=onionservices/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=tor.stack.io,resources=onionservices/finalizers,verbs=update
+// +kubebuilder:rbac:groups=tor.stack.io,resources=tornetworks,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;update;patch;delete;create
-// +kubebuilder:rbac:groups="",resources=pods/exec,verbs=create
 // +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;update;patch;delete;create
 // +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;update;patch;delete;create
@@ -60,9 +67,13 @@ func (r *OnionServiceReconciler) Reconcile(ctx context.Context, req reconcile.Re
 
 	if !onionService.DeletionTimestamp.IsZero() {
 		if controllerutil.ContainsFinalizer(onionService, torFinalizerName) {
-			// if err := r.cleanupOnionService(ctx, onionService); err != nil {
-			// 	return reconcile.Result{}, err
-			// }
+			// NetworkPolicies in other namespaces are not garbage collected.
+			if err := r.deleteNetworkPolicies(ctx, onionService); err != nil {
+				return reconcile.Result{}, err
+			}
+			if err := r.deleteAddressExports(ctx, onionService, nil); err != nil {
+				return reconcile.Result{}, err
+			}
 
 			controllerutil.RemoveFinalizer(onionService, torFinalizerName)
 			if err := r.Update(ctx, onionService); err != nil {
@@ -79,9 +90,97 @@ func (r *OnionServiceReconciler) Reconcile(ctx context.Context, req reconcile.Re
 		}
 	}
 
-	torrcConfig := generateTorrcConfig(onionService)
+	ports, err := r.resolveBackend(ctx, onionService)
+	if err != nil {
+		torrcRenderErrors.WithLabelValues("backend").Inc()
+		return reconcile.Result{}, err
+	}
+	if len(ports) == 0 {
+		// Wait for the referenced Service to show up, it is watched.
+		return reconcile.Result{}, nil
+	}
+
+	if err := r.reconcileNetworkPolicies(ctx, onionService); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	torNetwork, err := network.Resolve(ctx, r.Client, onionService.Namespace, onionService.Spec.TorNetwork)
+	if network.IsNotReady(err) {
+		// The TorNetwork is watched, requeue anyway in case it is created
+		// later on.
+		err := r.updateStatus(ctx, onionService, "Pending", onionService.Status.OnionAddress, err.Error())
+		return reconcile.Result{RequeueAfter: 10 * time.Second}, err
+	} else if err != nil {
+		torrcRenderErrors.WithLabelValues("network").Inc()
+		return reconcile.Result{}, err
+	}
+
+	if err := r.reconcileMetricsService(ctx, onionService); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	err = agent.Reconcile(ctx, r.Client,
+		*metav1.NewControllerRef(onionService, v1beta1.GroupVersion.WithKind("OnionService")),
+		onionService.Namespace, onionService.Name)
+	if agent.IsConflict(err) {
+		// The names are taken by a relay or another object, stop before
+		// the torrc ConfigMap of the same name is overwritten too.
+		err := r.updateStatus(ctx, onionService, "Pending", onionService.Status.OnionAddress, err.Error())
+		return reconcile.Result{}, err
+	} else if err != nil {
+		return reconcile.Result{}, err
+	}
+	if err := r.pruneAgentStatus(ctx, onionService); err != nil {
+		return reconcile.Result{}, err
+	}
+	if err := r.reconcileBackendHealth(ctx, onionService); err != nil {
+		return reconcile.Result{}, err
+	}
+	if err := r.reconcileBackupStatus(ctx, onionService); err != nil {
+		return reconcile.Result{}, err
+	}
+	if err := r.reconcileAddressExports(ctx, onionService, ports); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if onionService.Spec.KeySecret != "" {
+		address, err := r.keySecretAddress(ctx, onionService)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if address == "" {
+			// The Secret is watched.
+			err := r.updateStatus(ctx, onionService, "Pending", onionService.Status.OnionAddress,
+				fmt.Sprintf("Waiting for the key in Secret %s", onionService.Spec.KeySecret))
+			return reconcile.Result{}, err
+		}
+	}
+
+	if err := r.deleteInactiveMode(ctx, onionService); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if onionService.Spec.HighAvailability != nil {
+		if err := r.reconcileHighAvailability(ctx, onionService, ports, torNetwork); err != nil {
+			return reconcile.Result{}, err
+		}
+		// Backend addresses are read from the pods, keep polling until all
+		// of them are published.
+		if onionService.Status.Phase != "Ready" || len(onionService.Status.Backends) < int(onionService.Spec.HighAvailability.Backends) {
+			return reconcile.Result{RequeueAfter: 10 * time.Second}, nil
+		}
+		return reconcile.Result{}, nil
+	}
+
+	retireAfter, err := r.reconcileKeyRotation(ctx, onionService)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	torrcConfig := generateTorrcConfig(onionService, ports) + torNetwork.Torrc()
 
 	if err := r.reconcileConfigMap(ctx, onionService, torrcConfig); err != nil {
+		torrcRenderErrors.WithLabelValues("configmap").Inc()
 		return reconcile.Result{}, err
 	}
 
@@ -98,11 +197,13 @@ func (r *OnionServiceReconciler) Reconcile(ctx context.Context, req reconcile.Re
 		return reconcile.Result{}, err
 	}
 
-	return reconcile.Result{}, nil
+	// Come back to retire the previous key at the end of the overlap.
+	return reconcile.Result{RequeueAfter: retireAfter}, nil
 }
 
-// Generate torrc configuration based on OnionService spec
-func generateTorrcConfig(onion *v1beta1.OnionService) string {
+// Generate torrc configuration based on OnionService spec and on its
+// resolved HiddenServicePort lines.
+func generateTorrcConfig(onion *v1beta1.OnionService, ports []hiddenServicePort) string {
 	var config strings.Builder
 
 	if onion.Spec.SOCKSPort > 0 {
@@ -113,15 +214,24 @@ func generateTorrcConfig(onion *v1beta1.OnionService) string {
 		fmt.Fprintf(&config, "SOCKSPolicy %s\n", policy)
 	}
 
-	hiddenServiceDir := onion.Spec.HiddenServiceDir
-	if hiddenServiceDir == "" {
-		hiddenServiceDir = "/var/lib/tor/hidden_service/"
+	dirs := []string{hiddenServiceDir(onion)}
+	// The next key of a rotation is served along the previous one.
+	if rotating(onion) {
+		dirs = append(dirs, nextHiddenServiceDir(onion))
+	}
+	for _, dir := range dirs {
+		fmt.Fprintf(&config, "HiddenServiceDir %s\n", dir)
+		for _, port := range ports {
+			fmt.Fprintf(&config, "HiddenServicePort %d %s\n", port.port, port.target)
+		}
+		// Backends of a highly available OnionService are Onionbalance
+		// instances, there is no key rotation then.
+		if onion.Spec.HighAvailability != nil {
+			fmt.Fprintf(&config, "HiddenServiceOnionbalanceInstance 1\n")
+		}
 	}
 
-	fmt.Fprintf(&config, "HiddenServiceDir %s\n", hiddenServiceDir)
-	fmt.Fprintf(&config, "HiddenServicePort %d %s\n",
-		onion.Spec.HiddenServicePort,
-		onion.Spec.HiddenServiceTarget)
+	config.WriteString(generateControlTorrc(onion))
 
 	fmt.Fprintf(&config, "DataDirectory /var/lib/tor\n")
 	fmt.Fprintf(&config, "RunAsDaemon 0\n")
@@ -194,14 +304,26 @@ func (r *OnionServiceReconciler) reconcilePVC(ctx context.Context, onion *v1beta
 }
 
 func (r *OnionServiceReconciler) reconcileDeployment(ctx context.Context, onion *v1beta1.OnionService) error {
-	hiddenServiceDir := onion.Spec.HiddenServiceDir
-	if hiddenServiceDir == "" {
-		hiddenServiceDir = "/var/lib/tor/hidden_service/"
+	podSpec := torPodSpec(onion)
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: "hidden-service",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: onion.Name + "-hidden-service",
+			},
+		},
+	})
+	keySecret, err := r.mainKeySecret(ctx, onion)
+	if err != nil {
+		return err
+	}
+	if keySecret != "" {
+		installKey(&podSpec, "onion-key", keySecret, hiddenServiceDir(onion))
+	}
+	if rotating(onion) {
+		installKey(&podSpec, "next-onion-key", nextKeySecretName(onion), nextHiddenServiceDir(onion))
 	}
 
-	torUID := int64(101)
-	torGID := int64(101)
-	var zero int64 = 0
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      onion.Name,
@@ -222,90 +344,13 @@ func (r *OnionServiceReconciler) reconcileDeployment(ctx context.Context, onion
 						"app": onion.Name,
 					},
 				},
-				Spec: corev1.PodSpec{
-					InitContainers: []corev1.Container{
-						{
-							Name:  "init-permissions", // init container to set up permissions
-							Image: "busybox",
-							Command: []string{
-								"sh",
-								"-c",
-								fmt.Sprintf("mkdir -p %s && chown -R 101:101 %s && chmod -R 700 %s",
-									hiddenServiceDir, filepath.Dir(hiddenServiceDir), hiddenServiceDir),
-							},
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "hidden-service",
-									MountPath: filepath.Dir(hiddenServiceDir),
-								},
-							},
-							SecurityContext: &corev1.SecurityContext{
-								RunAsUser: &zero,
-							},
-						},
-					},
-					SecurityContext: &corev1.PodSecurityContext{
-						FSGroup: &torGID,
-					},
-					Containers: []corev1.Container{
-						{
-							Name:  "tor",
-							Image: TorDockerImage,
-							Command: []string{
-								"sh",
-								"-c",
-								"tor -f /etc/tor/torrc",
-							},
-							Ports: []corev1.ContainerPort{
-								{
-									Name:          "socks",
-									ContainerPort: int32(onion.Spec.SOCKSPort),
-								},
-							},
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "torrc",
-									MountPath: "/etc/tor/torrc",
-									SubPath:   "torrc",
-								},
-								{
-									Name:      "hidden-service",
-									MountPath: filepath.Dir(hiddenServiceDir),
-								},
-							},
-							SecurityContext: &corev1.SecurityContext{
-								RunAsUser:  &torUID,
-								RunAsGroup: &torGID,
-							},
-						},
-					},
-					Volumes: []corev1.Volume{
-						{
-							Name: "torrc",
-							VolumeSource: corev1.VolumeSource{
-								ConfigMap: &corev1.ConfigMapVolumeSource{
-									LocalObjectReference: corev1.LocalObjectReference{
-										Name: onion.Name + "-torrc",
-									},
-								},
-							},
-						},
-						{
-							Name: "hidden-service",
-							VolumeSource: corev1.VolumeSource{
-								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-									ClaimName: onion.Name + "-hidden-service",
-								},
-							},
-						},
-					},
-				},
+				Spec: podSpec,
 			},
 		},
 	}
 
 	found := &appsv1.Deployment{}
-	err := r.Get(ctx, types.NamespacedName{Name: deployment.Name, Namespace: deployment.Namespace}, found)
+	err = r.Get(ctx, types.NamespacedName{Name: deployment.Name, Namespace: deployment.Namespace}, found)
 	if err != nil && errors.IsNotFound(err) {
 		return r.Create(ctx, deployment)
 	} else if err != nil {
@@ -316,6 +361,162 @@ func (r *OnionServiceReconciler) reconcileDeployment(ctx context.Context, onion
 	return r.Update(ctx, found)
 }
 
+// torPodSpec returns the pod running tor with the torrc of the given
+// OnionService. The "hidden-service" volume is left to the caller, since
+// it is backed by a PVC or by a StatefulSet volume claim template.
+func torPodSpec(onion *v1beta1.OnionService) corev1.PodSpec {
+	hiddenServiceDir := hiddenServiceDir(onion)
+
+	// The agent publishes the onion address and the bootstrap progress of
+	// tor to the status ConfigMap and serves the probes of tor.
+	agentContainer := agent.Container(onion.Name,
+		map[string]string{hostnameKey: filepath.Join(hiddenServiceDir, "hostname")},
+		&agent.Control{Address: controlPortAddress, CookieFile: controlCookieFile},
+		[]corev1.VolumeMount{
+			{
+				Name:      "torrc",
+				MountPath: torrcFile,
+				SubPath:   "torrc",
+			},
+			{
+				Name:      "hidden-service",
+				MountPath: filepath.Dir(hiddenServiceDir),
+			},
+			{
+				Name:      "tor-control",
+				MountPath: controlDirectory,
+			},
+		})
+	agentContainer.Args = append(agentContainer.Args, agentHealthArgs(onion)...)
+	agentContainer.Args = append(agentContainer.Args, agentCheckArgs(onion)...)
+	agentContainer.Args = append(agentContainer.Args, agentBackupArgs(onion)...)
+	agentContainer.Env = append(agentContainer.Env, agentBackupEnv(onion)...)
+	agentContainer.Ports = []corev1.ContainerPort{
+		{
+			Name:          "health",
+			ContainerPort: agent.HealthPort,
+		},
+	}
+	readiness, liveness := torProbes(onion)
+
+	torUID := int64(101)
+	torGID := int64(101)
+	var zero int64 = 0
+	podSpec := corev1.PodSpec{
+		InitContainers: []corev1.Container{
+			{
+				Name:  "init-permissions", // init container to set up permissions
+				Image: "busybox",
+				Command: []string{
+					"sh",
+					"-c",
+					fmt.Sprintf("mkdir -p %s && chown -R 101:101 %s && chmod -R 700 %s",
+						hiddenServiceDir, filepath.Dir(hiddenServiceDir), hiddenServiceDir),
+				},
+				VolumeMounts: []corev1.VolumeMount{
+					{
+						Name:      "hidden-service",
+						MountPath: filepath.Dir(hiddenServiceDir),
+					},
+				},
+				SecurityContext: &corev1.SecurityContext{
+					RunAsUser: &zero,
+				},
+			},
+		},
+		SecurityContext: &corev1.PodSecurityContext{
+			FSGroup: &torGID,
+		},
+		Containers: []corev1.Container{
+			{
+				Name:  "tor",
+				Image: TorDockerImage,
+				Command: []string{
+					"sh",
+					"-c",
+					"tor -f /etc/tor/torrc",
+				},
+				Ports: []corev1.ContainerPort{
+					{
+						Name:          "socks",
+						ContainerPort: int32(onion.Spec.SOCKSPort),
+					},
+				},
+				VolumeMounts: []corev1.VolumeMount{
+					{
+						Name:      "torrc",
+						MountPath: torrcFile,
+						SubPath:   "torrc",
+					},
+					{
+						Name:      "hidden-service",
+						MountPath: filepath.Dir(hiddenServiceDir),
+					},
+					{
+						Name:      "tor-control",
+						MountPath: controlDirectory,
+					},
+				},
+				ReadinessProbe: readiness,
+				LivenessProbe:  liveness,
+				SecurityContext: &corev1.SecurityContext{
+					RunAsUser:  &torUID,
+					RunAsGroup: &torGID,
+				},
+			},
+			agentContainer,
+		},
+		ServiceAccountName: agent.ServiceAccountName(onion.Name),
+		Volumes: []corev1.Volume{
+			{
+				Name: "tor-control",
+				VolumeSource: corev1.VolumeSource{
+					EmptyDir: &corev1.EmptyDirVolumeSource{},
+				},
+			},
+			{
+				Name: "torrc",
+				VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{
+							Name: onion.Name + "-torrc",
+						},
+					},
+				},
+			},
+		},
+	}
+	if onion.Spec.Metrics != nil {
+		podSpec.Containers = append(podSpec.Containers, exporterContainer(onion))
+	}
+	return podSpec
+}
+
+// hiddenServiceDir returns the HiddenServiceDir of the OnionService,
+// falling back to the default one.
+func hiddenServiceDir(onion *v1beta1.OnionService) string {
+	if onion.Spec.HiddenServiceDir == "" {
+		return "/var/lib/tor/hidden_service/"
+	}
+	return onion.Spec.HiddenServiceDir
+}
+
+// onionServiceHash identifies the OnionService in label values, which are
+// shorter than object names.
+func onionServiceHash(onion *v1beta1.OnionService) string {
+	sum := sha256.Sum256([]byte(onion.Namespace + "/" + onion.Name))
+	return hex.EncodeToString(sum[:16])
+}
+
+// onionServiceAnnotations point back to the OnionService from the objects
+// labelled with its hash.
+func onionServiceAnnotations(onion *v1beta1.OnionService) map[string]string {
+	return map[string]string{
+		v1beta1.OnionServiceAnnotation:          onion.Name,
+		v1beta1.OnionServiceNamespaceAnnotation: onion.Namespace,
+	}
+}
+
 func (r *OnionServiceReconciler) reconcileStatus(ctx context.Context, onion *v1beta1.OnionService) error {
 	log := log.FromContext(ctx)
 
@@ -360,76 +561,81 @@ func (r *OnionServiceReconciler) reconcileStatus(ctx context.Context, onion *v1b
 		return r.updateStatus(ctx, onion, "Initializing", "", "Waiting for pod to start")
 	}
 
-	hiddenServiceDir := onion.Spec.HiddenServiceDir
-	if hiddenServiceDir == "" {
-		hiddenServiceDir = "/var/lib/tor/hidden_service"
-	}
-	hostnameFile := filepath.Join(hiddenServiceDir, "hostname")
-
-	onionAddress, err := r.execInPod(ctx, runningPod.Name, runningPod.Namespace, "tor", []string{"cat", hostnameFile})
+	onionAddress, err := agent.Published(ctx, r.Client, onion.Namespace, onion.Name, runningPod.Name, hostnameKey)
 	if err != nil {
-		log.Info("Failed to read onion address, will retry", "error", err.Error())
+		torAccessFailures.WithLabelValues("agent").Inc()
+		return err
+	}
+	if onionAddress == "" {
 		return r.updateStatus(ctx, onion, "Initializing", "", "Waiting for Tor to generate .onion address")
 	}
+	if rotation := onion.Status.KeyRotation; rotation != nil {
+		// The previous key is still in the HiddenServiceDir during the
+		// overlap, and in the pods which did not restart after it.
+		if !rotating(onion) && onionAddress != rotation.OnionAddress {
+			return r.updateStatus(ctx, onion, "Initializing", rotation.OnionAddress, "Waiting for Tor to load the rotated key")
+		}
+		onionAddress = rotation.OnionAddress
+	}
 
-	onionAddress = strings.TrimSpace(onionAddress)
-	if onionAddress == "" {
-		return r.updateStatus(ctx, onion, "Initializing", "", "Onion address file is empty, waiting for Tor")
+	bootstrap, err := agent.Published(ctx, r.Client, onion.Namespace, onion.Name, runningPod.Name, agent.BootstrapKey)
+	if err != nil {
+		torAccessFailures.WithLabelValues("agent").Inc()
+		return err
+	}
+	if bootstrap != "100" {
+		if bootstrap == "" {
+			bootstrap = "0"
+		}
+		return r.updateStatus(ctx, onion, "Initializing", onionAddress, fmt.Sprintf("Tor is bootstrapping, %s%% done", bootstrap))
 	}
 
 	log.Info("Successfully retrieved onion address", "address", onionAddress)
 	return r.updateStatus(ctx, onion, "Ready", onionAddress, "OnionService is ready")
 }
 
-// execInPod executes a command in a pod and returns the output
-func (r *OnionServiceReconciler) execInPod(ctx context.Context, podName, namespace, containerName string, command []string) (string, error) {
-	if r.KubeClient == nil {
-		return "", fmt.Errorf("KubeClient is not initialized")
-	}
-
-	req := r.KubeClient.CoreV1().RESTClient().
-		Post().
-		Resource("pods").
-		Name(podName).
-		Namespace(namespace).
-		SubResource("exec").
-		VersionedParams(&corev1.PodExecOptions{
-			Container: containerName,
-			Command:   command,
-			Stdout:    true,
-			Stderr:    true,
-		}, runtime.NewParameterCodec(r.Scheme))
-
-	config, err := ctrl.GetConfig()
+// pruneAgentStatus forgets what the agents of deleted tor pods published.
+func (r *OnionServiceReconciler) pruneAgentStatus(ctx context.Context, onion *v1beta1.OnionService) error {
+	selector, err := metav1.LabelSelectorAsSelector(torPodSelector(onion))
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	podList := &corev1.PodList{}
+	err = r.List(ctx, podList, client.InNamespace(onion.Namespace), client.MatchingLabelsSelector{Selector: selector})
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	var stdout, stderr strings.Builder
-	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
-		Stdout: &stdout,
-		Stderr: &stderr,
-	})
-
-	if err != nil {
-		return "", fmt.Errorf("exec failed: %w, stderr: %s", err, stderr.String())
+	pods := make([]string, 0, len(podList.Items))
+	for _, pod := range podList.Items {
+		pods = append(pods, pod.Name)
 	}
-
-	return stdout.String(), nil
+	return agent.Prune(ctx, r.Client, onion.Namespace, onion.Name, pods)
 }
 
 // updateStatus updates the OnionService status
 func (r *OnionServiceReconciler) updateStatus(ctx context.Context, onion *v1beta1.OnionService, phase, onionAddress, message string) error {
+	// Only the first time to ready is observed, later ones are restarts or
+	// outages. OnionServices Ready before ReadyTime existed are skipped.
+	firstReady := phase == "Ready" && onion.Status.ReadyTime == nil
+	if firstReady {
+		now := metav1.Now()
+		onion.Status.ReadyTime = &now
+		firstReady = onion.Status.Phase != "Ready"
+	}
+
 	onion.Status.Phase = phase
 	onion.Status.OnionAddress = onionAddress
 	onion.Status.Message = message
 
-	return r.Status().Update(ctx, onion)
+	if err := r.Status().Update(ctx, onion); err != nil {
+		return err
+	}
+	if firstReady {
+		observeReady(onion)
+	}
+	return nil
 }
 
 // func (r *OnionServiceReconciler) cleanupOnionService(ctx context.Context, onion *v1beta1.OnionService) error {
@@ -437,7 +643,26 @@ func (r *OnionServiceReconciler) updateStatus(ctx context.Context, onion *v1beta
 // }
 
 func (r *OnionServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := registerMetrics(mgr.GetClient()); err != nil {
+		return err
+	}
+
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1beta1.OnionService{}, serviceRefIndexKey, indexServiceRef)
+	if err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1beta1.OnionService{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&appsv1.StatefulSet{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Watches(&corev1.Service{}, handler.EnqueueRequestsFromMapFunc(r.onionServicesForService)).
+		Watches(&v1beta1.TorNetwork{}, handler.EnqueueRequestsFromMapFunc(r.onionServicesForTorNetwork)).
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.onionServiceForAddressExport)).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.onionServiceForAddressExport)).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.onionServicesForKeySecret)).
+		Watches(&corev1.Namespace{}, handler.EnqueueRequestsFromMapFunc(r.onionServicesForNamespace)).
 		Complete(r)
 }
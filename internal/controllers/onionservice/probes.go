@@ -0,0 +1,75 @@
+package onionservice
+
+import (
+	"fmt"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/agent"
+)
+
+// torProbes returns the readiness and liveness probes of the tor
+// container, served by the agent from the state of tor.
+func torProbes(onion *v1beta1.OnionService) (readiness, liveness *corev1.Probe) {
+	readiness = agentProbe(agent.ReadinessPath, nil)
+	liveness = agentProbe(agent.LivenessPath, nil)
+
+	if onion.Spec.Probes != nil {
+		if onion.Spec.Probes.Readiness != nil {
+			readiness = agentProbe(agent.ReadinessPath, &onion.Spec.Probes.Readiness.ProbeThresholds)
+			readiness.SuccessThreshold = onion.Spec.Probes.Readiness.SuccessThreshold
+		}
+		if onion.Spec.Probes.Liveness != nil {
+			liveness = agentProbe(agent.LivenessPath, onion.Spec.Probes.Liveness)
+		}
+	}
+
+	return readiness, liveness
+}
+
+func agentProbe(path string, thresholds *v1beta1.ProbeThresholds) *corev1.Probe {
+	probe := &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: path,
+				Port: intstr.FromInt32(agent.HealthPort),
+			},
+		},
+	}
+	if thresholds != nil {
+		probe.InitialDelaySeconds = thresholds.InitialDelaySeconds
+		probe.PeriodSeconds = thresholds.PeriodSeconds
+		probe.TimeoutSeconds = thresholds.TimeoutSeconds
+		probe.FailureThreshold = thresholds.FailureThreshold
+	}
+	return probe
+}
+
+// torrcFile is where the torrc is mounted in the tor and agent containers.
+const torrcFile = "/etc/tor/torrc"
+
+// agentHealthArgs make the agent serve the probes, checking the
+// descriptor upload unless disabled and the backends when asked to.
+func agentHealthArgs(onion *v1beta1.OnionService) []string {
+	args := []string{fmt.Sprintf("--health-address=:%d", agent.HealthPort)}
+
+	descriptor, backend := true, false
+	if onion.Spec.Probes != nil && onion.Spec.Probes.Readiness != nil {
+		readiness := onion.Spec.Probes.Readiness
+		if readiness.Descriptor != nil {
+			descriptor = *readiness.Descriptor
+		}
+		backend = readiness.Backend
+	}
+
+	if descriptor {
+		args = append(args, "--ready-descriptor="+filepath.Join(hiddenServiceDir(onion), "hostname"))
+	}
+	if backend {
+		args = append(args, "--ready-backends", "--torrc="+torrcFile)
+	}
+	return args
+}
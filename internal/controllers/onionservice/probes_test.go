@@ -0,0 +1,84 @@
+package onionservice
+
+import (
+	"flag"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/utils/ptr"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/agent"
+)
+
+var _ = Describe("Probes", func() {
+	It("waits for the descriptor by default", func() {
+		onion := &v1beta1.OnionService{}
+		Expect(agentHealthArgs(onion)).To(Equal([]string{
+			"--health-address=:9131",
+			"--ready-descriptor=/var/lib/tor/hidden_service/hostname",
+		}))
+
+		readiness, liveness := torProbes(onion)
+		Expect(readiness.HTTPGet.Path).To(Equal("/readyz"))
+		Expect(readiness.HTTPGet.Port.IntValue()).To(Equal(9131))
+		Expect(liveness.HTTPGet.Path).To(Equal("/livez"))
+		Expect(liveness.FailureThreshold).To(BeZero())
+	})
+
+	It("applies the thresholds and checks of the spec", func() {
+		onion := &v1beta1.OnionService{Spec: v1beta1.OnionServiceSpec{
+			Probes: &v1beta1.OnionProbes{
+				Readiness: &v1beta1.OnionReadinessProbe{
+					ProbeThresholds:  v1beta1.ProbeThresholds{PeriodSeconds: 30, FailureThreshold: 2},
+					SuccessThreshold: 2,
+					Descriptor:       ptr.To(false),
+					Backend:          true,
+				},
+				Liveness: &v1beta1.ProbeThresholds{InitialDelaySeconds: 60, TimeoutSeconds: 5},
+			},
+		}}
+		Expect(agentHealthArgs(onion)).To(Equal([]string{
+			"--health-address=:9131",
+			"--ready-backends",
+			"--torrc=/etc/tor/torrc",
+		}))
+
+		readiness, liveness := torProbes(onion)
+		Expect(readiness.PeriodSeconds).To(Equal(int32(30)))
+		Expect(readiness.FailureThreshold).To(Equal(int32(2)))
+		Expect(readiness.SuccessThreshold).To(Equal(int32(2)))
+		Expect(liveness.InitialDelaySeconds).To(Equal(int32(60)))
+		Expect(liveness.TimeoutSeconds).To(Equal(int32(5)))
+	})
+
+	It("passes arguments the agent parses", func() {
+		onion := &v1beta1.OnionService{Spec: v1beta1.OnionServiceSpec{
+			Probes: &v1beta1.OnionProbes{
+				Readiness: &v1beta1.OnionReadinessProbe{Backend: true},
+			},
+			HealthCheck: &v1beta1.BackendHealthCheck{Type: "HTTP", Path: "/healthz", StopAdvertising: true},
+		}}
+
+		var args []string
+		for _, container := range torPodSpec(onion).Containers {
+			if container.Name == agent.ContainerName {
+				args = container.Args
+			}
+		}
+		Expect(args).NotTo(BeEmpty())
+
+		flags := &agent.Flags{}
+		Expect(flags.NewFlagSet("tor-agent", flag.ContinueOnError).Parse(args)).To(Succeed())
+		Expect(flags.HealthAddress).To(Equal(":9131"))
+		Expect(flags.ReadyDescriptor).To(Equal("/var/lib/tor/hidden_service/hostname"))
+		Expect(flags.ReadyBackends).To(BeTrue())
+		Expect(flags.Torrc).To(Equal(torrcFile))
+		Expect(flags.CheckBackends).To(Equal(agent.CheckHTTP))
+		Expect(flags.CheckPath).To(Equal("/healthz"))
+		Expect(flags.StopAdvertising).To(BeTrue())
+		Expect(flags.ControlAddress).To(Equal(controlPortAddress))
+		Expect(flags.Files).To(HaveKeyWithValue(hostnameKey, "/var/lib/tor/hidden_service/hostname"))
+	})
+})
@@ -0,0 +1,31 @@
+package onionservice
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+// onionServicesForTorNetwork enqueues the OnionServices published on the
+// TorNetwork, so that they follow its directory authorities.
+func (r *OnionServiceReconciler) onionServicesForTorNetwork(ctx context.Context, obj client.Object) []reconcile.Request {
+	onionList := &v1beta1.OnionServiceList{}
+	if err := r.List(ctx, onionList, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, onion := range onionList.Items {
+		if onion.Spec.TorNetwork != obj.GetName() {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: onion.Name, Namespace: onion.Namespace},
+		})
+	}
+	return requests
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+// ServiceReconciler exposes Services annotated with tor.stack.io/onion as
+// onion services, by owning a corresponding OnionService.
+type ServiceReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+const defaultSOCKSPort = 9050
+
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=tor.stack.io,resources=onionservices,verbs=get;list;watch;create;update;patch;delete
+
+func (r *ServiceReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	log := log.FromContext(ctx)
+
+	service := &corev1.Service{}
+	err := r.Get(ctx, req.NamespacedName, service)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	// The OnionService is garbage collected with the Service, we only need
+	// to take care of the annotation being removed.
+	if !service.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	if service.Annotations[v1beta1.OnionAnnotation] != "true" {
+		return reconcile.Result{}, r.cleanup(ctx, service)
+	}
+
+	desired, err := onionServiceFor(service)
+	if err := r.reconcileError(ctx, service, err); err != nil {
+		return reconcile.Result{}, err
+	}
+	if err != nil {
+		log.Info("service cannot be exposed as an onion service", "reason", err.Error())
+		return reconcile.Result{}, nil
+	}
+
+	onion, err := r.reconcileOnionService(ctx, service, desired)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if onion.Status.OnionAddress != "" && service.Annotations[v1beta1.OnionAddressAnnotation] != onion.Status.OnionAddress {
+		log.Info("publishing onion address on service", "address", onion.Status.OnionAddress)
+		patch := client.MergeFrom(service.DeepCopy())
+		service.Annotations[v1beta1.OnionAddressAnnotation] = onion.Status.OnionAddress
+		if err := r.Patch(ctx, service, patch); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	return reconcile.Result{}, nil
+}
+
+// onionServiceFor builds the OnionService exposing the Service ports. It
+// fails on Services without TCP ports and on malformed annotations.
+func onionServiceFor(service *corev1.Service) (*v1beta1.OnionService, error) {
+	socksPort := defaultSOCKSPort
+	if value, ok := service.Annotations[v1beta1.OnionSOCKSPortAnnotation]; ok {
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 0 || port > 65535 {
+			return nil, fmt.Errorf("%s: %q is not a port number", v1beta1.OnionSOCKSPortAnnotation, value)
+		}
+		socksPort = port
+	}
+
+	ports := []v1beta1.OnionServicePort{}
+	for _, p := range service.Spec.Ports {
+		if p.Protocol != "" && p.Protocol != corev1.ProtocolTCP {
+			continue
+		}
+		ports = append(ports, v1beta1.OnionServicePort{
+			Name:       p.Name,
+			Port:       p.Port,
+			TargetPort: intstr.FromInt32(p.Port),
+		})
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("the service has no TCP port, onion services only forward TCP")
+	}
+
+	onion := &v1beta1.OnionService{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      service.Name + "-onion",
+			Namespace: service.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(service, corev1.SchemeGroupVersion.WithKind("Service")),
+			},
+		},
+		Spec: v1beta1.OnionServiceSpec{
+			SOCKSPort: socksPort,
+			Backend: &v1beta1.OnionServiceBackend{
+				ServiceRef: &v1beta1.ServiceReference{
+					Name: service.Name,
+				},
+			},
+			Ports: ports,
+		},
+	}
+
+	if value, ok := service.Annotations[v1beta1.OnionBackendsAnnotation]; ok {
+		backends, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || backends < 2 {
+			return nil, fmt.Errorf("%s: %q is not a number of backends of at least 2", v1beta1.OnionBackendsAnnotation, value)
+		}
+		onion.Spec.HighAvailability = &v1beta1.HighAvailabilitySpec{
+			Backends: int32(backends),
+		}
+	}
+
+	return onion, nil
+}
+
+// reconcileError reports why the Service cannot be exposed in the
+// OnionErrorAnnotation, removed once it is fixed. Services have no status
+// conditions for it.
+func (r *ServiceReconciler) reconcileError(ctx context.Context, service *corev1.Service, reason error) error {
+	value := ""
+	if reason != nil {
+		value = reason.Error()
+	}
+	if service.Annotations[v1beta1.OnionErrorAnnotation] == value {
+		return nil
+	}
+
+	patch := client.MergeFrom(service.DeepCopy())
+	if value == "" {
+		delete(service.Annotations, v1beta1.OnionErrorAnnotation)
+	} else {
+		service.Annotations[v1beta1.OnionErrorAnnotation] = value
+	}
+	return r.Patch(ctx, service, patch)
+}
+
+func (r *ServiceReconciler) reconcileOnionService(ctx context.Context, service *corev1.Service, onion *v1beta1.OnionService) (*v1beta1.OnionService, error) {
+	found := &v1beta1.OnionService{}
+	err := r.Get(ctx, types.NamespacedName{Name: onion.Name, Namespace: onion.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return onion, r.Create(ctx, onion)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !metav1.IsControlledBy(found, service) {
+		log.FromContext(ctx).Info("OnionService exists and is not owned by the service, skipping", "onionservice", found.Name)
+		return found, nil
+	}
+
+	if !reflect.DeepEqual(found.Spec, onion.Spec) {
+		found.Spec = onion.Spec
+		return found, r.Update(ctx, found)
+	}
+
+	return found, nil
+}
+
+// cleanup deletes the OnionService owned by the Service and its address and
+// error annotations once tor.stack.io/onion is removed.
+func (r *ServiceReconciler) cleanup(ctx context.Context, service *corev1.Service) error {
+	found := &v1beta1.OnionService{}
+	err := r.Get(ctx, types.NamespacedName{Name: service.Name + "-onion", Namespace: service.Namespace}, found)
+	if err == nil && metav1.IsControlledBy(found, service) {
+		if err := r.Delete(ctx, found); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	} else if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	patch := client.MergeFrom(service.DeepCopy())
+	changed := false
+	for _, annotation := range []string{v1beta1.OnionAddressAnnotation, v1beta1.OnionErrorAnnotation} {
+		if _, ok := service.Annotations[annotation]; ok {
+			delete(service.Annotations, annotation)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return r.Patch(ctx, service, patch)
+}
+
+func (r *ServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Service{}).
+		Owns(&v1beta1.OnionService{}).
+		Complete(r)
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+func TestService(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Service Suite")
+}
+
+// annotatedService is a Service exposed as an onion service with the extra
+// annotations.
+func annotatedService(ports []corev1.ServicePort, annotations map[string]string) *corev1.Service {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			UID:         "service-uid",
+			Annotations: map[string]string{v1beta1.OnionAnnotation: "true"},
+		},
+		Spec: corev1.ServiceSpec{Ports: ports},
+	}
+	for key, value := range annotations {
+		service.Annotations[key] = value
+	}
+	return service
+}
+
+var _ = Describe("onion Service", func() {
+	tcp := []corev1.ServicePort{
+		{Name: "http", Port: 80},
+		{Name: "dns", Port: 53, Protocol: corev1.ProtocolUDP},
+	}
+
+	It("forwards the TCP ports with the annotated settings", func() {
+		onion, err := onionServiceFor(annotatedService(tcp, map[string]string{
+			v1beta1.OnionSOCKSPortAnnotation: "0",
+			v1beta1.OnionBackendsAnnotation:  "3",
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(onion.Name).To(Equal("web-onion"))
+		Expect(onion.Spec.SOCKSPort).To(Equal(0))
+		Expect(onion.Spec.Ports).To(HaveLen(1))
+		Expect(onion.Spec.Ports[0].Port).To(Equal(int32(80)))
+		Expect(onion.Spec.HighAvailability.Backends).To(Equal(int32(3)))
+
+		onion, err = onionServiceFor(annotatedService(tcp, nil))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(onion.Spec.SOCKSPort).To(Equal(defaultSOCKSPort))
+		Expect(onion.Spec.HighAvailability).To(BeNil())
+	})
+
+	DescribeTable("rejects the services it cannot expose",
+		func(ports []corev1.ServicePort, annotations map[string]string, reason string) {
+			_, err := onionServiceFor(annotatedService(ports, annotations))
+			Expect(err).To(MatchError(ContainSubstring(reason)))
+		},
+		Entry("no ports", nil, nil, "no TCP port"),
+		Entry("UDP and SCTP only", []corev1.ServicePort{
+			{Port: 53, Protocol: corev1.ProtocolUDP},
+			{Port: 9899, Protocol: corev1.ProtocolSCTP},
+		}, nil, "no TCP port"),
+		Entry("malformed SOCKS port", tcp, map[string]string{v1beta1.OnionSOCKSPortAnnotation: "socks"}, v1beta1.OnionSOCKSPortAnnotation),
+		Entry("SOCKS port out of range", tcp, map[string]string{v1beta1.OnionSOCKSPortAnnotation: "70000"}, v1beta1.OnionSOCKSPortAnnotation),
+		Entry("a single backend", tcp, map[string]string{v1beta1.OnionBackendsAnnotation: "1"}, v1beta1.OnionBackendsAnnotation),
+		Entry("malformed backends", tcp, map[string]string{v1beta1.OnionBackendsAnnotation: "two"}, v1beta1.OnionBackendsAnnotation),
+	)
+
+	It("reports the error on the service instead of creating the OnionService", func() {
+		ctx := context.Background()
+		scheme := runtime.NewScheme()
+		Expect(v1beta1.AddToScheme(scheme)).To(Succeed())
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+
+		service := annotatedService([]corev1.ServicePort{{Port: 53, Protocol: corev1.ProtocolUDP}}, nil)
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(service).Build()
+		r := &ServiceReconciler{Client: c, Scheme: scheme}
+		request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(service)}
+		onionKey := types.NamespacedName{Name: "web-onion", Namespace: "default"}
+
+		_, err := r.Reconcile(ctx, request)
+		Expect(err).NotTo(HaveOccurred())
+
+		found := &corev1.Service{}
+		Expect(c.Get(ctx, request.NamespacedName, found)).To(Succeed())
+		Expect(found.Annotations[v1beta1.OnionErrorAnnotation]).To(ContainSubstring("no TCP port"))
+		err = c.Get(ctx, onionKey, &v1beta1.OnionService{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+
+		// Adding a TCP port clears the report and creates the OnionService.
+		found.Spec.Ports = append(found.Spec.Ports, corev1.ServicePort{Port: 80})
+		Expect(c.Update(ctx, found)).To(Succeed())
+		_, err = r.Reconcile(ctx, request)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(c.Get(ctx, request.NamespacedName, found)).To(Succeed())
+		Expect(found.Annotations).NotTo(HaveKey(v1beta1.OnionErrorAnnotation))
+		onion := &v1beta1.OnionService{}
+		Expect(c.Get(ctx, onionKey, onion)).To(Succeed())
+		Expect(metav1.IsControlledBy(onion, found)).To(BeTrue())
+		Expect(onion.Spec.Ports).To(HaveLen(1))
+	})
+})
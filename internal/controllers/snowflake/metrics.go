@@ -0,0 +1,85 @@
+package snowflake
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+const (
+	metricsPort = 9999
+	metricsPath = "/internal/metrics"
+
+	connectionsMetric   = "tor_snowflake_proxy_connections_total"
+	inboundBytesMetric  = "tor_snowflake_proxy_traffic_inbound_bytes_total"
+	outboundBytesMetric = "tor_snowflake_proxy_traffic_outbound_bytes_total"
+)
+
+var metricsClient = &http.Client{Timeout: 5 * time.Second}
+
+// proxyStats are the summary stats of a proxy since it started.
+type proxyStats struct {
+	Connections   int64
+	InboundBytes  int64
+	OutboundBytes int64
+}
+
+func (s *proxyStats) add(other proxyStats) {
+	s.Connections += other.Connections
+	s.InboundBytes += other.InboundBytes
+	s.OutboundBytes += other.OutboundBytes
+}
+
+// scrape reads the Prometheus metrics of the proxy listening at podIP.
+func scrape(ctx context.Context, podIP string) (proxyStats, error) {
+	url := "http://" + net.JoinHostPort(podIP, strconv.Itoa(metricsPort)) + metricsPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return proxyStats{}, err
+	}
+
+	resp, err := metricsClient.Do(req)
+	if err != nil {
+		return proxyStats{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return proxyStats{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return parseMetrics(resp.Body)
+}
+
+// parseMetrics sums the counters of the proxy over their labels, e.g. the
+// country of the clients.
+func parseMetrics(r io.Reader) (proxyStats, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(r)
+	if err != nil {
+		return proxyStats{}, err
+	}
+
+	sum := func(name string) int64 {
+		family, ok := families[name]
+		if !ok {
+			return 0
+		}
+		total := 0.0
+		for _, metric := range family.GetMetric() {
+			total += metric.GetCounter().GetValue()
+		}
+		return int64(total)
+	}
+
+	return proxyStats{
+		Connections:   sum(connectionsMetric),
+		InboundBytes:  sum(inboundBytesMetric),
+		OutboundBytes: sum(outboundBytesMetric),
+	}, nil
+}
@@ -0,0 +1,283 @@
+package snowflake
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+// ProxyImage runs the standalone Snowflake proxy.
+const ProxyImage = "thetorproject/snowflake-proxy:latest"
+
+// scrapeInterval between two reads of the proxy metrics.
+const scrapeInterval = time.Minute
+
+type SnowflakeProxyReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=tor.stack.io,resources=snowflakeproxies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=tor.stack.io,resources=snowflakeproxies/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+
+func (r *SnowflakeProxyReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	log := log.FromContext(ctx)
+	log.Info("got new request: ", "namespace/name", req.NamespacedName.String())
+
+	proxy := &v1beta1.SnowflakeProxy{}
+	err := r.Get(ctx, req.NamespacedName, proxy)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	// Owned objects are garbage collected with the SnowflakeProxy.
+	if !proxy.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	if err := r.reconcileDeployment(ctx, proxy); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if err := r.reconcileStatus(ctx, proxy); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// The stats only live in the proxies, keep scraping them.
+	return reconcile.Result{RequeueAfter: scrapeInterval}, nil
+}
+
+// proxyArgs are the flags of the snowflake proxy binary.
+func proxyArgs(proxy *v1beta1.SnowflakeProxy) []string {
+	args := []string{
+		"-metrics",
+		"-metrics-address", "0.0.0.0",
+		"-metrics-port", fmt.Sprint(metricsPort),
+	}
+
+	if proxy.Spec.BrokerURL != "" {
+		args = append(args, "-broker", proxy.Spec.BrokerURL)
+	}
+	if len(proxy.Spec.STUNURLs) > 0 {
+		args = append(args, "-stun", strings.Join(proxy.Spec.STUNURLs, ","))
+	}
+	if proxy.Spec.RelayURL != "" {
+		args = append(args, "-relay", proxy.Spec.RelayURL)
+	}
+	if proxy.Spec.Capacity > 0 {
+		args = append(args, "-capacity", fmt.Sprint(proxy.Spec.Capacity))
+	}
+
+	if nat := proxy.Spec.NAT; nat != nil {
+		if nat.EphemeralPortsRange != "" {
+			args = append(args, "-ephemeral-ports-range", nat.EphemeralPortsRange)
+		}
+		if nat.ProbeServer != "" {
+			args = append(args, "-nat-probe-server", nat.ProbeServer)
+		}
+		if nat.RetestInterval != nil {
+			args = append(args, "-nat-retest-interval", nat.RetestInterval.Duration.String())
+		}
+	}
+
+	return args
+}
+
+func (r *SnowflakeProxyReconciler) reconcileDeployment(ctx context.Context, proxy *v1beta1.SnowflakeProxy) error {
+	image := proxy.Spec.Image
+	if image == "" {
+		image = ProxyImage
+	}
+
+	hostNetwork := proxy.Spec.NAT != nil && proxy.Spec.NAT.HostNetwork
+	metrics := corev1.ContainerPort{Name: "metrics", ContainerPort: metricsPort, Protocol: corev1.ProtocolTCP}
+	if hostNetwork {
+		// Proxies sharing a node would fight for the metrics port.
+		metrics.HostPort = metricsPort
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      proxy.Name,
+			Namespace: proxy.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(proxy, v1beta1.GroupVersion.WithKind("SnowflakeProxy")),
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: proxy.Spec.Replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": proxy.Name,
+				},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app": proxy.Name,
+					},
+				},
+				Spec: corev1.PodSpec{
+					HostNetwork: hostNetwork,
+					Containers: []corev1.Container{
+						{
+							Name:      "snowflake-proxy",
+							Image:     image,
+							Args:      proxyArgs(proxy),
+							Ports:     []corev1.ContainerPort{metrics},
+							Resources: proxy.Spec.Resources,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	found := &appsv1.Deployment{}
+	err := r.Get(ctx, types.NamespacedName{Name: deployment.Name, Namespace: deployment.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return r.Create(ctx, deployment)
+	} else if err != nil {
+		return err
+	}
+
+	if !deploymentChanged(found, deployment) {
+		return nil
+	}
+	found.Spec = deployment.Spec
+	return r.Update(ctx, found)
+}
+
+// deploymentChanged compares the fields set by the controller, the others
+// are defaulted by the API server.
+func deploymentChanged(found, deployment *appsv1.Deployment) bool {
+	foundPod, pod := found.Spec.Template.Spec, deployment.Spec.Template.Spec
+	if !equality.Semantic.DeepEqual(found.Spec.Replicas, deployment.Spec.Replicas) ||
+		!equality.Semantic.DeepEqual(found.Spec.Template.Labels, deployment.Spec.Template.Labels) ||
+		foundPod.HostNetwork != pod.HostNetwork ||
+		len(foundPod.Containers) != len(pod.Containers) {
+		return true
+	}
+	for i, container := range pod.Containers {
+		foundContainer := foundPod.Containers[i]
+		if foundContainer.Name != container.Name ||
+			foundContainer.Image != container.Image ||
+			!equality.Semantic.DeepEqual(foundContainer.Args, container.Args) ||
+			!equality.Semantic.DeepEqual(foundContainer.Ports, container.Ports) ||
+			!equality.Semantic.DeepEqual(foundContainer.Resources, container.Resources) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *SnowflakeProxyReconciler) reconcileStatus(ctx context.Context, proxy *v1beta1.SnowflakeProxy) error {
+	original := proxy.Status.DeepCopy()
+
+	deployment := &appsv1.Deployment{}
+	err := r.Get(ctx, types.NamespacedName{Name: proxy.Name, Namespace: proxy.Namespace}, deployment)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return r.updateStatus(ctx, proxy, original, "Pending", "Deployment not yet created")
+		}
+		return err
+	}
+
+	proxy.Status.Replicas = deployment.Status.Replicas
+	proxy.Status.ReadyReplicas = deployment.Status.ReadyReplicas
+	proxy.Status.Selector = "app=" + proxy.Name
+
+	if deployment.Status.ReadyReplicas == 0 {
+		return r.updateStatus(ctx, proxy, original, "Initializing", "Waiting for the proxy pods to become ready")
+	}
+
+	// Reconciles triggered by the Deployment come in between the scrapes.
+	last := proxy.Status.LastScrapeTime
+	if last == nil || time.Since(last.Time) >= scrapeInterval {
+		if err := r.scrapeStats(ctx, proxy); err != nil {
+			return err
+		}
+	}
+
+	return r.updateStatus(ctx, proxy, original, "Ready",
+		fmt.Sprintf("%d/%d proxy pods ready", deployment.Status.ReadyReplicas, deployment.Status.Replicas))
+}
+
+// scrapeStats sums the stats of the running proxies. Proxies which cannot
+// be scraped are skipped, their stats are back on the next scrape.
+func (r *SnowflakeProxyReconciler) scrapeStats(ctx context.Context, proxy *v1beta1.SnowflakeProxy) error {
+	log := log.FromContext(ctx)
+
+	pods := &corev1.PodList{}
+	err := r.List(ctx, pods, client.InNamespace(proxy.Namespace), client.MatchingLabels{"app": proxy.Name})
+	if err != nil {
+		return err
+	}
+
+	total := proxyStats{}
+	scraped := 0
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+			continue
+		}
+
+		stats, err := scrape(ctx, pod.Status.PodIP)
+		if err != nil {
+			log.Info("Failed to scrape the proxy metrics", "pod", pod.Name, "error", err.Error())
+			continue
+		}
+		total.add(stats)
+		scraped++
+	}
+	if scraped == 0 {
+		return nil
+	}
+
+	now := metav1.Now()
+	proxy.Status.Connections = total.Connections
+	proxy.Status.InboundBytes = total.InboundBytes
+	proxy.Status.OutboundBytes = total.OutboundBytes
+	proxy.Status.LastScrapeTime = &now
+	return nil
+}
+
+// updateStatus updates the SnowflakeProxy status
+func (r *SnowflakeProxyReconciler) updateStatus(ctx context.Context, proxy *v1beta1.SnowflakeProxy, original *v1beta1.SnowflakeProxyStatus, phase, message string) error {
+	proxy.Status.Phase = phase
+	proxy.Status.Message = message
+
+	// Unchanged status would only trigger another reconcile.
+	if equality.Semantic.DeepEqual(&proxy.Status, original) {
+		return nil
+	}
+	return r.Status().Update(ctx, proxy)
+}
+
+func (r *SnowflakeProxyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1beta1.SnowflakeProxy{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&appsv1.Deployment{}).
+		Complete(r)
+}
@@ -0,0 +1,78 @@
+package snowflake
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+func TestSnowflake(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Snowflake Suite")
+}
+
+var _ = Describe("snowflake proxy", func() {
+	It("renders the proxy flags", func() {
+		proxy := &v1beta1.SnowflakeProxy{
+			Spec: v1beta1.SnowflakeProxySpec{
+				BrokerURL: "https://broker.example.com/",
+				STUNURLs:  []string{"stun:stun.l.google.com:19302", "stun:stun.example.com:3478"},
+				Capacity:  10,
+				NAT: &v1beta1.SnowflakeNAT{
+					EphemeralPortsRange: "30000:30100",
+					RetestInterval:      &metav1.Duration{Duration: time.Hour},
+				},
+			},
+		}
+
+		Expect(strings.Join(proxyArgs(proxy), " ")).To(Equal(
+			"-metrics -metrics-address 0.0.0.0 -metrics-port 9999 " +
+				"-broker https://broker.example.com/ " +
+				"-stun stun:stun.l.google.com:19302,stun:stun.example.com:3478 " +
+				"-capacity 10 -ephemeral-ports-range 30000:30100 -nat-retest-interval 1h0m0s"))
+	})
+
+	It("only updates the Deployment when the controller fields change", func() {
+		deployment := func(args ...string) *appsv1.Deployment {
+			return &appsv1.Deployment{Spec: appsv1.DeploymentSpec{
+				Replicas: ptr.To(int32(1)),
+				Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "snowflake-proxy", Image: ProxyImage, Args: args}},
+				}},
+			}}
+		}
+
+		found := deployment("-metrics")
+		// Defaulted by the API server.
+		found.Spec.RevisionHistoryLimit = ptr.To(int32(10))
+		found.Spec.Template.Spec.Containers[0].ImagePullPolicy = corev1.PullAlways
+		found.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyAlways
+
+		Expect(deploymentChanged(found, deployment("-metrics"))).To(BeFalse())
+		Expect(deploymentChanged(found, deployment("-metrics", "-capacity", "10"))).To(BeTrue())
+		Expect(deploymentChanged(found, deployment())).To(BeTrue())
+	})
+
+	It("sums the stats over the countries", func() {
+		stats, err := parseMetrics(strings.NewReader(`# HELP tor_snowflake_proxy_connections_total The total number of successful connections handled by the snowflake proxy
+# TYPE tor_snowflake_proxy_connections_total counter
+tor_snowflake_proxy_connections_total{country="DE"} 3
+tor_snowflake_proxy_connections_total{country="IR"} 5
+# TYPE tor_snowflake_proxy_traffic_inbound_bytes_total counter
+tor_snowflake_proxy_traffic_inbound_bytes_total 1024
+# TYPE tor_snowflake_proxy_traffic_outbound_bytes_total counter
+tor_snowflake_proxy_traffic_outbound_bytes_total 4096
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats).To(Equal(proxyStats{Connections: 8, InboundBytes: 1024, OutboundBytes: 4096}))
+	})
+})
@@ -0,0 +1,263 @@
+package torbridge
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/agent"
+	"github.com/fulviodenza/torproxy/internal/relay"
+)
+
+// bridgeLineKey holds the bridge line in the Secret.
+const bridgeLineKey = "bridgeline"
+
+type TorBridgeReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=tor.stack.io,resources=torbridges,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=tor.stack.io,resources=torbridges/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
+
+func (r *TorBridgeReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	log := log.FromContext(ctx)
+	log.Info("got new request: ", "namespace/name", req.NamespacedName.String())
+
+	torBridge := &v1beta1.TorBridge{}
+	err := r.Get(ctx, req.NamespacedName, torBridge)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	if !torBridge.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	address, err := relay.PublicAddress(ctx, r.Client, torBridge.Namespace, torBridge.Name, torBridge.Spec.Expose)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	err = relay.Reconcile(ctx, r.Client,
+		*metav1.NewControllerRef(torBridge, v1beta1.GroupVersion.WithKind("TorBridge")),
+		torBridge.Namespace, torBridge.Name, generateRelay(torBridge, address))
+	if agent.IsConflict(err) {
+		// The names are taken by another object, e.g. an OnionService:
+		// report it instead of overwriting its resources.
+		return reconcile.Result{}, r.updateStatus(ctx, torBridge, "Error", err.Error())
+	} else if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if err := r.reconcileStatus(ctx, torBridge, address); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// The address, the fingerprint and the obfs4 cert show up later on.
+	if torBridge.Status.Phase != "Ready" {
+		return reconcile.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+	return reconcile.Result{}, nil
+}
+
+// generateRelay describes the bridge pod. The transport binary is copied
+// from its image by an init container when one is given.
+func generateRelay(torBridge *v1beta1.TorBridge, address string) relay.Relay {
+	transport := torBridge.Spec.Transport
+
+	bridge := relay.Relay{
+		Torrc: generateTorrcConfig(torBridge, address),
+		Ports: []corev1.ContainerPort{
+			{Name: "orport", ContainerPort: torBridge.Spec.ORPort, Protocol: corev1.ProtocolTCP},
+			{Name: transport.Type, ContainerPort: transport.Port, Protocol: corev1.ProtocolTCP},
+		},
+		Expose:    torBridge.Spec.Expose,
+		Image:     torBridge.Spec.Image,
+		Resources: torBridge.Spec.Resources,
+		Storage:   torBridge.Spec.Storage,
+	}
+	if transport.Type == v1beta1.BridgeTransportObfs4 {
+		bridge.Published = map[string]string{relay.Obfs4BridgeLineKey: relay.Obfs4BridgeLineFile}
+	}
+
+	if transport.Image != "" {
+		mount := corev1.VolumeMount{
+			Name:      "transport",
+			MountPath: relay.TransportDirectory,
+		}
+		binary := transport.Binary
+		if binary == "" {
+			binary = relay.TransportBinary(&v1beta1.BridgeTransport{Type: transport.Type})
+		}
+
+		bridge.InitContainers = []corev1.Container{
+			{
+				Name:         "transport",
+				Image:        transport.Image,
+				Command:      []string{"cp", binary, relay.TransportBinary(&transport)},
+				VolumeMounts: []corev1.VolumeMount{mount},
+			},
+		}
+		bridge.Volumes = []corev1.Volume{
+			{
+				Name: "transport",
+				VolumeSource: corev1.VolumeSource{
+					EmptyDir: &corev1.EmptyDirVolumeSource{},
+				},
+			},
+		}
+		bridge.VolumeMounts = []corev1.VolumeMount{mount}
+	}
+
+	return bridge
+}
+
+// Generate the torrc of a bridge. Bridges are not listed publicly, the
+// family would disclose them.
+func generateTorrcConfig(torBridge *v1beta1.TorBridge, address string) string {
+	if torBridge.Spec.Expose != v1beta1.RelayExposeLoadBalancer {
+		address = ""
+	}
+
+	spec := torBridge.Spec.TorRelaySpec
+	spec.MyFamily = nil
+
+	var config strings.Builder
+
+	config.WriteString(relay.Torrc(&spec, address))
+	config.WriteString(relay.BridgeTorrc(&torBridge.Spec))
+
+	return config.String()
+}
+
+func (r *TorBridgeReconciler) reconcileStatus(ctx context.Context, torBridge *v1beta1.TorBridge, address string) error {
+	log := log.FromContext(ctx)
+
+	if torBridge.Spec.Address != "" {
+		address = torBridge.Spec.Address
+	}
+	torBridge.Status.Address = address
+
+	running, err := relay.Running(ctx, r.Client, torBridge.Namespace, torBridge.Name)
+	if err != nil {
+		return err
+	}
+	if !running {
+		return r.updateStatus(ctx, torBridge, "Initializing", "Waiting for the bridge pod to start")
+	}
+
+	if torBridge.Status.Fingerprint == "" {
+		fingerprint, err := relay.Fingerprint(ctx, r.Client, torBridge.Namespace, torBridge.Name)
+		if err != nil {
+			log.Info("Failed to read the bridge fingerprint, will retry", "error", err.Error())
+			return r.updateStatus(ctx, torBridge, "Initializing", "Waiting for tor to generate the identity keys")
+		}
+		if fingerprint == "" {
+			return r.updateStatus(ctx, torBridge, "Initializing", "Waiting for tor to generate the identity keys")
+		}
+		torBridge.Status.Fingerprint = fingerprint
+	}
+
+	if address == "" {
+		return r.updateStatus(ctx, torBridge, "Initializing", "Waiting for the transport address")
+	}
+
+	obfs4BridgeLine := ""
+	if torBridge.Spec.Transport.Type == v1beta1.BridgeTransportObfs4 {
+		obfs4BridgeLine, err = relay.Published(ctx, r.Client, torBridge.Namespace, torBridge.Name, relay.Obfs4BridgeLineKey)
+		if err != nil {
+			return err
+		}
+		if obfs4BridgeLine == "" {
+			return r.updateStatus(ctx, torBridge, "Initializing", "Waiting for obfs4 to generate its cert")
+		}
+	}
+
+	bridgeLine, err := relay.BridgeLine(&torBridge.Spec, address, torBridge.Status.Fingerprint, obfs4BridgeLine)
+	if err != nil {
+		return r.updateStatus(ctx, torBridge, "Error", err.Error())
+	}
+	torBridge.Status.BridgeLine = bridgeLine
+
+	if err := r.reconcileSecret(ctx, torBridge); err != nil {
+		return err
+	}
+	torBridge.Status.SecretName = bridgeLineSecretName(torBridge)
+
+	return r.updateStatus(ctx, torBridge, "Ready", "Bridge running")
+}
+
+func bridgeLineSecretName(torBridge *v1beta1.TorBridge) string {
+	return torBridge.Name + "-bridgeline"
+}
+
+// reconcileSecret shares the bridge line with the clients of the bridge.
+func (r *TorBridgeReconciler) reconcileSecret(ctx context.Context, torBridge *v1beta1.TorBridge) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      bridgeLineSecretName(torBridge),
+			Namespace: torBridge.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(torBridge, v1beta1.GroupVersion.WithKind("TorBridge")),
+			},
+		},
+		Data: map[string][]byte{
+			bridgeLineKey: []byte(torBridge.Status.BridgeLine),
+		},
+	}
+
+	found := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: secret.Name, Namespace: secret.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return r.Create(ctx, secret)
+	} else if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(found.Data, secret.Data) {
+		found.Data = secret.Data
+		return r.Update(ctx, found)
+	}
+
+	return nil
+}
+
+// updateStatus updates the TorBridge status
+func (r *TorBridgeReconciler) updateStatus(ctx context.Context, torBridge *v1beta1.TorBridge, phase, message string) error {
+	torBridge.Status.Phase = phase
+	torBridge.Status.Message = message
+
+	return r.Status().Update(ctx, torBridge)
+}
+
+func (r *TorBridgeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1beta1.TorBridge{}).
+		Owns(&appsv1.StatefulSet{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Service{}).
+		Owns(&corev1.Secret{}).
+		Complete(r)
+}
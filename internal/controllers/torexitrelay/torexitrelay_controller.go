@@ -0,0 +1,171 @@
+package torexitrelay
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/agent"
+	"github.com/fulviodenza/torproxy/internal/relay"
+)
+
+type TorExitRelayReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=tor.stack.io,resources=torexitrelays,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=tor.stack.io,resources=torexitrelays/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
+
+func (r *TorExitRelayReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	log := log.FromContext(ctx)
+	log.Info("got new request: ", "namespace/name", req.NamespacedName.String())
+
+	exitRelay := &v1beta1.TorExitRelay{}
+	err := r.Get(ctx, req.NamespacedName, exitRelay)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	if !exitRelay.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	// The webhook may be disabled, never start an exit with a policy it
+	// would have refused.
+	if _, errs := relay.ValidateExit(&exitRelay.Spec); len(errs) > 0 {
+		messages := make([]string, 0, len(errs))
+		for _, err := range errs {
+			messages = append(messages, err.Error())
+		}
+		return reconcile.Result{}, r.updateStatus(ctx, exitRelay, "Error", strings.Join(messages, "; "))
+	}
+
+	address, err := relay.PublicAddress(ctx, r.Client, exitRelay.Namespace, exitRelay.Name, exitRelay.Spec.Expose)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	files := map[string]string{}
+	if resolvConf := relay.ResolvConf(&exitRelay.Spec); resolvConf != "" {
+		files["resolv.conf"] = resolvConf
+	}
+
+	err = relay.Reconcile(ctx, r.Client,
+		*metav1.NewControllerRef(exitRelay, v1beta1.GroupVersion.WithKind("TorExitRelay")),
+		exitRelay.Namespace, exitRelay.Name, relay.Relay{
+			Torrc: generateTorrcConfig(exitRelay, address),
+			Ports: []corev1.ContainerPort{
+				{Name: "orport", ContainerPort: exitRelay.Spec.ORPort, Protocol: corev1.ProtocolTCP},
+			},
+			Expose:    exitRelay.Spec.Expose,
+			Image:     exitRelay.Spec.Image,
+			Resources: exitRelay.Spec.Resources,
+			Storage:   exitRelay.Spec.Storage,
+			Files:     files,
+		})
+	if agent.IsConflict(err) {
+		// The names are taken by another object, e.g. an OnionService:
+		// report it instead of overwriting its resources.
+		return reconcile.Result{}, r.updateStatus(ctx, exitRelay, "Error", err.Error())
+	} else if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if err := r.reconcileStatus(ctx, exitRelay, address); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if exitRelay.Status.Phase != "Ready" {
+		return reconcile.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+	return reconcile.Result{}, nil
+}
+
+// Generate the torrc of an exit relay, the abuse contact is published
+// along with the contact info.
+func generateTorrcConfig(exitRelay *v1beta1.TorExitRelay, address string) string {
+	if exitRelay.Spec.Expose != v1beta1.RelayExposeLoadBalancer {
+		address = ""
+	}
+
+	spec := exitRelay.Spec.TorRelaySpec
+	spec.ContactInfo = strings.TrimSpace(spec.ContactInfo + " abuse:" + exitRelay.Spec.AbuseContact)
+
+	var config strings.Builder
+
+	config.WriteString(relay.Torrc(&spec, address))
+	config.WriteString(relay.ExitTorrc(&exitRelay.Spec))
+
+	return config.String()
+}
+
+func (r *TorExitRelayReconciler) reconcileStatus(ctx context.Context, exitRelay *v1beta1.TorExitRelay, address string) error {
+	log := log.FromContext(ctx)
+
+	if exitRelay.Spec.Address != "" {
+		address = exitRelay.Spec.Address
+	}
+	exitRelay.Status.Address = address
+
+	running, err := relay.Running(ctx, r.Client, exitRelay.Namespace, exitRelay.Name)
+	if err != nil {
+		return err
+	}
+	if !running {
+		return r.updateStatus(ctx, exitRelay, "Initializing", "Waiting for the relay pod to start")
+	}
+
+	if exitRelay.Status.Fingerprint == "" {
+		fingerprint, err := relay.Fingerprint(ctx, r.Client, exitRelay.Namespace, exitRelay.Name)
+		if err != nil {
+			log.Info("Failed to read the relay fingerprint, will retry", "error", err.Error())
+			return r.updateStatus(ctx, exitRelay, "Initializing", "Waiting for tor to generate the identity keys")
+		}
+		if fingerprint == "" {
+			return r.updateStatus(ctx, exitRelay, "Initializing", "Waiting for tor to generate the identity keys")
+		}
+		exitRelay.Status.Fingerprint = fingerprint
+	}
+
+	if address == "" {
+		return r.updateStatus(ctx, exitRelay, "Initializing", "Waiting for the ORPort address")
+	}
+
+	return r.updateStatus(ctx, exitRelay, "Ready", "Exit relay running")
+}
+
+// updateStatus updates the TorExitRelay status
+func (r *TorExitRelayReconciler) updateStatus(ctx context.Context, exitRelay *v1beta1.TorExitRelay, phase, message string) error {
+	exitRelay.Status.Phase = phase
+	exitRelay.Status.Message = message
+
+	return r.Status().Update(ctx, exitRelay)
+}
+
+func (r *TorExitRelayReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1beta1.TorExitRelay{}).
+		Owns(&appsv1.StatefulSet{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Service{}).
+		Complete(r)
+}
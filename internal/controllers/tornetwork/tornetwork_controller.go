@@ -0,0 +1,512 @@
+package tornetwork
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/controllers/onionservice"
+	"github.com/fulviodenza/torproxy/internal/network"
+)
+
+const (
+	configHashAnnotation = "tor.stack.io/config-hash"
+
+	// networkLabel and authorityLabel select the authority objects of a
+	// network, to remove the ones left over after scaling down.
+	networkLabel   = "tor.stack.io/tor-network"
+	authorityLabel = "tor.stack.io/authority"
+
+	v3identKey     = "v3ident"
+	fingerprintKey = "fingerprint"
+
+	// certificateValidity of the authority signing keys, private networks
+	// are not expected to outlive it.
+	certificateValidity = 365 * 24 * time.Hour
+)
+
+type TorNetworkReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=tor.stack.io,resources=tornetworks,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=tor.stack.io,resources=tornetworks/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+
+func (r *TorNetworkReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	log := log.FromContext(ctx)
+	log.Info("got new request: ", "namespace/name", req.NamespacedName.String())
+
+	torNetwork := &v1beta1.TorNetwork{}
+	err := r.Get(ctx, req.NamespacedName, torNetwork)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	// Owned objects are garbage collected with the TorNetwork.
+	if !torNetwork.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	// The DirAuthority set is known before the authorities run: the keys
+	// are generated here and the addresses are the ClusterIPs of their
+	// Services.
+	config := &network.Config{}
+	addresses := []string{}
+	for i := 0; i < int(torNetwork.Spec.Authorities); i++ {
+		name := authorityName(torNetwork, i)
+
+		keys, err := r.reconcileAuthorityKeys(ctx, torNetwork, name)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+
+		address, err := r.reconcileAuthorityService(ctx, torNetwork, name)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+
+		addresses = append(addresses, address)
+		config.DirAuthorities = append(config.DirAuthorities, network.DirAuthority(
+			authorityNickname(i), address, string(keys.Data[v3identKey]), string(keys.Data[fingerprintKey])))
+	}
+
+	if err := r.deleteSurplusAuthorities(ctx, torNetwork); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	torrcs := map[string]string{
+		"relay": network.RelayTorrc(config, false),
+		"exit":  network.RelayTorrc(config, true),
+	}
+	for i, address := range addresses {
+		torrcs[authorityName(torNetwork, i)] = network.AuthorityTorrc(config, authorityNickname(i), address)
+	}
+	if err := r.reconcileConfigMap(ctx, torNetwork, torrcs); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	for i := range addresses {
+		name := authorityName(torNetwork, i)
+		if err := r.reconcileDeployment(ctx, torNetwork, name, authorityLabels(torNetwork), 1, torrcs[name], authorityPodSpec(torNetwork, name)); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+	if err := r.reconcileDeployment(ctx, torNetwork, torNetwork.Name+"-relay", nil, torNetwork.Spec.Relays, torrcs["relay"], relayPodSpec(torNetwork, "relay")); err != nil {
+		return reconcile.Result{}, err
+	}
+	if err := r.reconcileDeployment(ctx, torNetwork, torNetwork.Name+"-exit", nil, torNetwork.Spec.Exits, torrcs["exit"], relayPodSpec(torNetwork, "exit")); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{}, r.reconcileStatus(ctx, torNetwork, config)
+}
+
+func authorityName(torNetwork *v1beta1.TorNetwork, i int) string {
+	return fmt.Sprintf("%s-auth-%d", torNetwork.Name, i)
+}
+
+func authorityNickname(i int) string {
+	return fmt.Sprintf("auth%d", i)
+}
+
+func authorityLabels(torNetwork *v1beta1.TorNetwork) map[string]string {
+	return map[string]string{
+		networkLabel:   torNetwork.Name,
+		authorityLabel: "true",
+	}
+}
+
+// reconcileAuthorityKeys generates the keys of the authority once, they are
+// kept in a Secret for the lifetime of the network.
+func (r *TorNetworkReconciler) reconcileAuthorityKeys(ctx context.Context, torNetwork *v1beta1.TorNetwork, name string) (*corev1.Secret, error) {
+	found := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: torNetwork.Namespace}, found)
+	if err == nil {
+		return found, nil
+	} else if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	keys, err := network.GenerateAuthority(time.Now(), certificateValidity)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: torNetwork.Namespace,
+			Labels:    authorityLabels(torNetwork),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(torNetwork, v1beta1.GroupVersion.WithKind("TorNetwork")),
+			},
+		},
+		Data: map[string][]byte{
+			v3identKey:     []byte(keys.V3Ident),
+			fingerprintKey: []byte(keys.Fingerprint),
+		},
+	}
+	for file, content := range keys.Files {
+		secret.Data[file] = content
+	}
+
+	if err := r.Create(ctx, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// reconcileAuthorityService gives the authority a stable address and
+// returns it.
+func (r *TorNetworkReconciler) reconcileAuthorityService(ctx context.Context, torNetwork *v1beta1.TorNetwork, name string) (string, error) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: torNetwork.Namespace,
+			Labels:    authorityLabels(torNetwork),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(torNetwork, v1beta1.GroupVersion.WithKind("TorNetwork")),
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{
+				"app": name,
+			},
+			Ports: []corev1.ServicePort{
+				{Name: "orport", Port: network.ORPort, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromInt32(network.ORPort)},
+				{Name: "dirport", Port: network.DirPort, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromInt32(network.DirPort)},
+			},
+		},
+	}
+
+	found := &corev1.Service{}
+	err := r.Get(ctx, types.NamespacedName{Name: service.Name, Namespace: service.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		if err := r.Create(ctx, service); err != nil {
+			return "", err
+		}
+		return service.Spec.ClusterIP, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return found.Spec.ClusterIP, nil
+}
+
+// deleteSurplusAuthorities removes the authorities left over after the
+// network was scaled down.
+func (r *TorNetworkReconciler) deleteSurplusAuthorities(ctx context.Context, torNetwork *v1beta1.TorNetwork) error {
+	wanted := map[string]bool{}
+	for i := 0; i < int(torNetwork.Spec.Authorities); i++ {
+		wanted[authorityName(torNetwork, i)] = true
+	}
+
+	lists := []client.ObjectList{&appsv1.DeploymentList{}, &corev1.ServiceList{}, &corev1.SecretList{}}
+	for _, list := range lists {
+		err := r.List(ctx, list, client.InNamespace(torNetwork.Namespace), client.MatchingLabels(authorityLabels(torNetwork)))
+		if err != nil {
+			return err
+		}
+
+		var objects []client.Object
+		switch list := list.(type) {
+		case *appsv1.DeploymentList:
+			for i := range list.Items {
+				objects = append(objects, &list.Items[i])
+			}
+		case *corev1.ServiceList:
+			for i := range list.Items {
+				objects = append(objects, &list.Items[i])
+			}
+		case *corev1.SecretList:
+			for i := range list.Items {
+				objects = append(objects, &list.Items[i])
+			}
+		}
+
+		for _, object := range objects {
+			if wanted[object.GetName()] {
+				continue
+			}
+			if err := r.Delete(ctx, object); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *TorNetworkReconciler) reconcileConfigMap(ctx context.Context, torNetwork *v1beta1.TorNetwork, torrcs map[string]string) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      torNetwork.Name + "-torrc",
+			Namespace: torNetwork.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(torNetwork, v1beta1.GroupVersion.WithKind("TorNetwork")),
+			},
+		},
+		Data: torrcs,
+	}
+
+	found := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: cm.Name, Namespace: cm.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return r.Create(ctx, cm)
+	} else if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(found.Data, cm.Data) {
+		found.Data = cm.Data
+		return r.Update(ctx, found)
+	}
+
+	return nil
+}
+
+// nodePodSpec runs tor with the torrc key of the network ConfigMap and
+// the given extra arguments.
+func nodePodSpec(torNetwork *v1beta1.TorNetwork, torrcKey, args string) corev1.PodSpec {
+	image := torNetwork.Spec.Image
+	if image == "" {
+		image = onionservice.TorDockerImage
+	}
+
+	torUID := int64(101)
+	return corev1.PodSpec{
+		SecurityContext: &corev1.PodSecurityContext{
+			FSGroup: &torUID,
+		},
+		Containers: []corev1.Container{
+			{
+				Name:  "tor",
+				Image: image,
+				Command: []string{
+					"sh",
+					"-c",
+					"tor -f /etc/tor/torrc" + args,
+				},
+				Ports: []corev1.ContainerPort{
+					{Name: "orport", ContainerPort: network.ORPort, Protocol: corev1.ProtocolTCP},
+					{Name: "dirport", ContainerPort: network.DirPort, Protocol: corev1.ProtocolTCP},
+				},
+				Resources: torNetwork.Spec.Resources,
+				VolumeMounts: []corev1.VolumeMount{
+					{
+						Name:      "torrc",
+						MountPath: "/etc/tor/torrc",
+						SubPath:   torrcKey,
+					},
+					{
+						Name:      "data",
+						MountPath: network.DataDirectory,
+					},
+				},
+				SecurityContext: &corev1.SecurityContext{
+					RunAsUser:  &torUID,
+					RunAsGroup: &torUID,
+				},
+			},
+		},
+		Volumes: []corev1.Volume{
+			{
+				Name: "torrc",
+				VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{
+							Name: torNetwork.Name + "-torrc",
+						},
+					},
+				},
+			},
+			{
+				Name: "data",
+				VolumeSource: corev1.VolumeSource{
+					EmptyDir: &corev1.EmptyDirVolumeSource{},
+				},
+			},
+		},
+	}
+}
+
+// authorityPodSpec copies the authority keys from their Secret into the
+// keys directory, with the permissions tor expects. The identity key is
+// not needed by tor and stays in the Secret.
+func authorityPodSpec(torNetwork *v1beta1.TorNetwork, name string) corev1.PodSpec {
+	podSpec := nodePodSpec(torNetwork, name, "")
+
+	keysDirectory := network.DataDirectory + "/keys"
+	podSpec.InitContainers = []corev1.Container{
+		{
+			Name:  "keys",
+			Image: podSpec.Containers[0].Image,
+			Command: []string{
+				"sh",
+				"-c",
+				fmt.Sprintf("mkdir -p %[1]s && cp /keys/* %[1]s/ && chmod 700 %[1]s && chmod 600 %[1]s/*", keysDirectory),
+			},
+			VolumeMounts: []corev1.VolumeMount{
+				{
+					Name:      "data",
+					MountPath: network.DataDirectory,
+				},
+				{
+					Name:      "keys",
+					MountPath: "/keys",
+					ReadOnly:  true,
+				},
+			},
+			SecurityContext: podSpec.Containers[0].SecurityContext,
+		},
+	}
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: "keys",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: name,
+				Items: []corev1.KeyToPath{
+					{Key: network.SigningKeyFile, Path: network.SigningKeyFile},
+					{Key: network.CertificateFile, Path: network.CertificateFile},
+					{Key: network.RelayKeyFile, Path: network.RelayKeyFile},
+				},
+			},
+		},
+	})
+
+	return podSpec
+}
+
+// relayPodSpec advertises the pod address, private addresses are allowed
+// in testing networks.
+func relayPodSpec(torNetwork *v1beta1.TorNetwork, torrcKey string) corev1.PodSpec {
+	podSpec := nodePodSpec(torNetwork, torrcKey, " --Address $POD_IP")
+	podSpec.Containers[0].Env = []corev1.EnvVar{
+		{
+			Name: "POD_IP",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.podIP"},
+			},
+		},
+	}
+	return podSpec
+}
+
+func (r *TorNetworkReconciler) reconcileDeployment(ctx context.Context, torNetwork *v1beta1.TorNetwork, name string, labels map[string]string, replicas int32, torrc string, podSpec corev1.PodSpec) error {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: torNetwork.Namespace,
+			Labels:    labels,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(torNetwork, v1beta1.GroupVersion.WithKind("TorNetwork")),
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			// Two pods must never share the keys of an authority, nodes are
+			// cheap to restart anyway.
+			Strategy: appsv1.DeploymentStrategy{
+				Type: appsv1.RecreateDeploymentStrategyType,
+			},
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": name,
+				},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app": name,
+					},
+					// tor does not reload the mounted torrc, restart the
+					// node whenever it changes.
+					Annotations: map[string]string{
+						configHashAnnotation: fmt.Sprintf("%x", sha256.Sum256([]byte(torrc))),
+					},
+				},
+				Spec: podSpec,
+			},
+		},
+	}
+
+	found := &appsv1.Deployment{}
+	err := r.Get(ctx, types.NamespacedName{Name: deployment.Name, Namespace: deployment.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return r.Create(ctx, deployment)
+	} else if err != nil {
+		return err
+	}
+
+	found.Labels = deployment.Labels
+	found.Spec = deployment.Spec
+	return r.Update(ctx, found)
+}
+
+func (r *TorNetworkReconciler) reconcileStatus(ctx context.Context, torNetwork *v1beta1.TorNetwork, config *network.Config) error {
+	torNetwork.Status.DirAuthorities = config.DirAuthorities
+
+	names := []string{torNetwork.Name + "-relay", torNetwork.Name + "-exit"}
+	for i := 0; i < int(torNetwork.Spec.Authorities); i++ {
+		names = append(names, authorityName(torNetwork, i))
+	}
+
+	nodes, ready := int32(0), int32(0)
+	for _, name := range names {
+		deployment := &appsv1.Deployment{}
+		err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: torNetwork.Namespace}, deployment)
+		if err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if deployment.Spec.Replicas != nil {
+			nodes += *deployment.Spec.Replicas
+		}
+		ready += deployment.Status.ReadyReplicas
+	}
+	torNetwork.Status.Nodes = nodes
+	torNetwork.Status.ReadyNodes = ready
+
+	if ready < nodes {
+		return r.updateStatus(ctx, torNetwork, "Initializing", fmt.Sprintf("%d/%d nodes ready", ready, nodes))
+	}
+	return r.updateStatus(ctx, torNetwork, "Ready", fmt.Sprintf("%d nodes ready", nodes))
+}
+
+// updateStatus updates the TorNetwork status
+func (r *TorNetworkReconciler) updateStatus(ctx context.Context, torNetwork *v1beta1.TorNetwork, phase, message string) error {
+	torNetwork.Status.Phase = phase
+	torNetwork.Status.Message = message
+
+	return r.Status().Update(ctx, torNetwork)
+}
+
+func (r *TorNetworkReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1beta1.TorNetwork{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.Service{}).
+		Complete(r)
+}
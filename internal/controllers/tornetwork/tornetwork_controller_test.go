@@ -0,0 +1,108 @@
+package tornetwork
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+func TestTorNetwork(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "TorNetwork Suite")
+}
+
+var _ = Describe("TorNetwork", func() {
+	ctx := context.Background()
+
+	// authorityService stands for the Service of an authority, with the
+	// ClusterIP the API server would have allocated.
+	authorityService := func(torNetwork *v1beta1.TorNetwork, i int, clusterIP string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      authorityName(torNetwork, i),
+				Namespace: torNetwork.Namespace,
+				Labels:    authorityLabels(torNetwork),
+			},
+			Spec: corev1.ServiceSpec{ClusterIP: clusterIP},
+		}
+	}
+
+	It("runs the authorities, relays and exits of the network", func() {
+		scheme := runtime.NewScheme()
+		Expect(v1beta1.AddToScheme(scheme)).To(Succeed())
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+
+		torNetwork := &v1beta1.TorNetwork{
+			ObjectMeta: metav1.ObjectMeta{Name: "testnet", Namespace: "default", UID: "network-uid"},
+			Spec:       v1beta1.TorNetworkSpec{Authorities: 2, Relays: 3, Exits: 1},
+		}
+		c := fake.NewClientBuilder().WithScheme(scheme).
+			WithObjects(torNetwork, authorityService(torNetwork, 0, "10.96.0.10"), authorityService(torNetwork, 1, "10.96.0.11")).
+			WithStatusSubresource(torNetwork).Build()
+		r := &TorNetworkReconciler{Client: c, Scheme: scheme}
+		request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(torNetwork)}
+
+		_, err := r.Reconcile(ctx, request)
+		Expect(err).NotTo(HaveOccurred())
+
+		cm := &corev1.ConfigMap{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "testnet-torrc", Namespace: "default"}, cm)).To(Succeed())
+		Expect(cm.Data).To(HaveKey("relay"))
+		Expect(cm.Data).To(HaveKey("testnet-auth-0"))
+		Expect(cm.Data).To(HaveKey("testnet-auth-1"))
+		Expect(cm.Data["exit"]).To(ContainSubstring("ExitPolicyRejectPrivate 0\nExitPolicy accept *:*\n"))
+		Expect(cm.Data["relay"]).To(ContainSubstring("ExitPolicy reject *:*\n"))
+		Expect(cm.Data["testnet-auth-1"]).To(ContainSubstring("Address 10.96.0.11\n"))
+
+		keys := &corev1.Secret{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "testnet-auth-0", Namespace: "default"}, keys)).To(Succeed())
+		Expect(cm.Data["relay"]).To(ContainSubstring("DirAuthority auth0 orport=5000 no-v2 v3ident=" + string(keys.Data[v3identKey]) + " 10.96.0.10:"))
+
+		for name, replicas := range map[string]int32{"testnet-auth-0": 1, "testnet-auth-1": 1, "testnet-relay": 3, "testnet-exit": 1} {
+			deployment := &appsv1.Deployment{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, deployment)).To(Succeed(), name)
+			Expect(*deployment.Spec.Replicas).To(Equal(replicas), name)
+		}
+		exit := &appsv1.Deployment{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "testnet-exit", Namespace: "default"}, exit)).To(Succeed())
+		Expect(exit.Spec.Template.Spec.Containers[0].VolumeMounts[0].SubPath).To(Equal("exit"))
+		Expect(exit.Spec.Template.Spec.Containers[0].Command[2]).To(Equal("tor -f /etc/tor/torrc --Address $POD_IP"))
+
+		found := &v1beta1.TorNetwork{}
+		Expect(c.Get(ctx, request.NamespacedName, found)).To(Succeed())
+		Expect(found.Status.Phase).To(Equal("Initializing"))
+		Expect(found.Status.Message).To(Equal("0/6 nodes ready"))
+		Expect(found.Status.DirAuthorities).To(HaveLen(2))
+
+		// Scaling the authorities down removes the surplus one, the keys
+		// of the others are kept.
+		found.Spec.Authorities = 1
+		Expect(c.Update(ctx, found)).To(Succeed())
+		_, err = r.Reconcile(ctx, request)
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, object := range []client.Object{&appsv1.Deployment{}, &corev1.Service{}, &corev1.Secret{}} {
+			err := c.Get(ctx, types.NamespacedName{Name: "testnet-auth-1", Namespace: "default"}, object)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		}
+		kept := &corev1.Secret{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "testnet-auth-0", Namespace: "default"}, kept)).To(Succeed())
+		Expect(kept.Data).To(Equal(keys.Data))
+		Expect(c.Get(ctx, types.NamespacedName{Name: "testnet-torrc", Namespace: "default"}, cm)).To(Succeed())
+		Expect(cm.Data).NotTo(HaveKey("testnet-auth-1"))
+	})
+})
@@ -0,0 +1,393 @@
+package torproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/controllers/onionservice"
+	"github.com/fulviodenza/torproxy/internal/network"
+)
+
+type TorProxyReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+const (
+	defaultSOCKSPort = 9050
+
+	configHashAnnotation = "tor.stack.io/config-hash"
+)
+
+// +kubebuilder:rbac:groups=tor.stack.io,resources=torproxies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=tor.stack.io,resources=torproxies/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=tor.stack.io,resources=tornetworks,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+
+func (r *TorProxyReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	log := log.FromContext(ctx)
+	log.Info("got new request: ", "namespace/name", req.NamespacedName.String())
+
+	torProxy := &v1beta1.TorProxy{}
+	err := r.Get(ctx, req.NamespacedName, torProxy)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	// Owned objects are garbage collected with the TorProxy.
+	if !torProxy.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	torNetwork, err := network.Resolve(ctx, r.Client, torProxy.Namespace, torProxy.Spec.TorNetwork)
+	if network.IsNotReady(err) {
+		err := r.updateStatus(ctx, torProxy, "Pending", err.Error())
+		return reconcile.Result{RequeueAfter: 10 * time.Second}, err
+	} else if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	torrcConfig := generateTorrcConfig(torProxy) + torNetwork.Torrc()
+
+	if err := r.reconcileConfigMap(ctx, torProxy, torrcConfig); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if err := r.reconcileDeployment(ctx, torProxy, torrcConfig); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if err := r.reconcileService(ctx, torProxy); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{}, r.reconcileStatus(ctx, torProxy)
+}
+
+func socksPort(torProxy *v1beta1.TorProxy) int32 {
+	if torProxy.Spec.SOCKS == nil {
+		return defaultSOCKSPort
+	}
+	return torProxy.Spec.SOCKS.Port
+}
+
+// Generate a client-only torrc based on the TorProxy spec
+func generateTorrcConfig(torProxy *v1beta1.TorProxy) string {
+	var config strings.Builder
+
+	isolation := ""
+	for _, flag := range torProxy.Spec.IsolationFlags {
+		isolation += " " + string(flag)
+	}
+
+	fmt.Fprintf(&config, "SOCKSPort 0.0.0.0:%d%s\n", socksPort(torProxy), isolation)
+	if torProxy.Spec.HTTPTunnel != nil {
+		fmt.Fprintf(&config, "HTTPTunnelPort 0.0.0.0:%d%s\n", torProxy.Spec.HTTPTunnel.Port, isolation)
+	}
+	if torProxy.Spec.DNS != nil {
+		fmt.Fprintf(&config, "DNSPort 0.0.0.0:%d%s\n", torProxy.Spec.DNS.Port, isolation)
+		fmt.Fprintf(&config, "AutomapHostsOnResolve 1\n")
+	}
+
+	// SOCKSPolicy is applied to the SOCKS and HTTP tunnel listeners alike,
+	// DNSPort has no policy of its own in tor.
+	for _, rule := range torProxy.Spec.Policy {
+		fmt.Fprintf(&config, "SOCKSPolicy %s %s\n", rule.Action, rule.Address)
+	}
+
+	if len(torProxy.Spec.ExitCountries) > 0 {
+		fmt.Fprintf(&config, "ExitNodes %s\n", countries(torProxy.Spec.ExitCountries))
+	}
+	if len(torProxy.Spec.ExcludeExitCountries) > 0 {
+		fmt.Fprintf(&config, "ExcludeExitNodes %s\n", countries(torProxy.Spec.ExcludeExitCountries))
+	}
+	if torProxy.Spec.StrictNodes {
+		fmt.Fprintf(&config, "StrictNodes 1\n")
+	}
+
+	fmt.Fprintf(&config, "DataDirectory /var/lib/tor\n")
+	fmt.Fprintf(&config, "RunAsDaemon 0\n")
+
+	return config.String()
+}
+
+// countries renders ISO country codes as a tor node list, e.g. {de},{nl}.
+func countries(codes []string) string {
+	nodes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		nodes = append(nodes, "{"+strings.ToLower(code)+"}")
+	}
+	return strings.Join(nodes, ",")
+}
+
+func (r *TorProxyReconciler) reconcileConfigMap(ctx context.Context, torProxy *v1beta1.TorProxy, torrcConfig string) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      torProxy.Name + "-torrc",
+			Namespace: torProxy.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(torProxy, v1beta1.GroupVersion.WithKind("TorProxy")),
+			},
+		},
+		Data: map[string]string{
+			"torrc": torrcConfig,
+		},
+	}
+
+	found := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: cm.Name, Namespace: cm.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return r.Create(ctx, cm)
+	} else if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(found.Data, cm.Data) {
+		found.Data = cm.Data
+		return r.Update(ctx, found)
+	}
+
+	return nil
+}
+
+// ContainerPorts are the listeners of the TorProxy pods.
+func ContainerPorts(torProxy *v1beta1.TorProxy) []corev1.ContainerPort {
+	ports := []corev1.ContainerPort{
+		{Name: "socks", ContainerPort: socksPort(torProxy), Protocol: corev1.ProtocolTCP},
+	}
+	if torProxy.Spec.HTTPTunnel != nil {
+		ports = append(ports, corev1.ContainerPort{Name: "http-tunnel", ContainerPort: torProxy.Spec.HTTPTunnel.Port, Protocol: corev1.ProtocolTCP})
+	}
+	if torProxy.Spec.DNS != nil {
+		ports = append(ports, corev1.ContainerPort{Name: "dns", ContainerPort: torProxy.Spec.DNS.Port, Protocol: corev1.ProtocolUDP})
+	}
+	return ports
+}
+
+func (r *TorProxyReconciler) reconcileDeployment(ctx context.Context, torProxy *v1beta1.TorProxy, torrcConfig string) error {
+	image := torProxy.Spec.Image
+	if image == "" {
+		image = onionservice.TorDockerImage
+	}
+
+	torUID := int64(101)
+	torGID := int64(101)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      torProxy.Name,
+			Namespace: torProxy.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(torProxy, v1beta1.GroupVersion.WithKind("TorProxy")),
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: torProxy.Spec.Replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": torProxy.Name,
+				},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app":                 torProxy.Name,
+						v1beta1.TorProxyLabel: torProxy.Name,
+					},
+					// tor does not reload the mounted torrc, roll the pods
+					// whenever it changes.
+					Annotations: map[string]string{
+						configHashAnnotation: fmt.Sprintf("%x", sha256.Sum256([]byte(torrcConfig))),
+					},
+				},
+				Spec: corev1.PodSpec{
+					SecurityContext: &corev1.PodSecurityContext{
+						FSGroup: &torGID,
+					},
+					Containers: []corev1.Container{
+						{
+							Name:  "tor",
+							Image: image,
+							Command: []string{
+								"sh",
+								"-c",
+								"tor -f /etc/tor/torrc",
+							},
+							Ports:     ContainerPorts(torProxy),
+							Resources: torProxy.Spec.Resources,
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "torrc",
+									MountPath: "/etc/tor/torrc",
+									SubPath:   "torrc",
+								},
+								{
+									Name:      "data",
+									MountPath: "/var/lib/tor",
+								},
+							},
+							SecurityContext: &corev1.SecurityContext{
+								RunAsUser:  &torUID,
+								RunAsGroup: &torGID,
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "torrc",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: torProxy.Name + "-torrc",
+									},
+								},
+							},
+						},
+						{
+							Name: "data",
+							VolumeSource: corev1.VolumeSource{
+								EmptyDir: &corev1.EmptyDirVolumeSource{},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	found := &appsv1.Deployment{}
+	err := r.Get(ctx, types.NamespacedName{Name: deployment.Name, Namespace: deployment.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return r.Create(ctx, deployment)
+	} else if err != nil {
+		return err
+	}
+
+	found.Spec = deployment.Spec
+	return r.Update(ctx, found)
+}
+
+func (r *TorProxyReconciler) reconcileService(ctx context.Context, torProxy *v1beta1.TorProxy) error {
+	ports := []corev1.ServicePort{}
+	for _, port := range ContainerPorts(torProxy) {
+		ports = append(ports, corev1.ServicePort{
+			Name:       port.Name,
+			Port:       port.ContainerPort,
+			Protocol:   port.Protocol,
+			TargetPort: intstr.FromString(port.Name),
+		})
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      torProxy.Name,
+			Namespace: torProxy.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(torProxy, v1beta1.GroupVersion.WithKind("TorProxy")),
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{
+				"app": torProxy.Name,
+			},
+			Ports: ports,
+		},
+	}
+
+	found := &corev1.Service{}
+	err := r.Get(ctx, types.NamespacedName{Name: service.Name, Namespace: service.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return r.Create(ctx, service)
+	} else if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(found.Spec.Ports, service.Spec.Ports) || !reflect.DeepEqual(found.Spec.Selector, service.Spec.Selector) {
+		found.Spec.Ports = service.Spec.Ports
+		found.Spec.Selector = service.Spec.Selector
+		return r.Update(ctx, found)
+	}
+
+	return nil
+}
+
+func (r *TorProxyReconciler) reconcileStatus(ctx context.Context, torProxy *v1beta1.TorProxy) error {
+	deployment := &appsv1.Deployment{}
+	err := r.Get(ctx, types.NamespacedName{Name: torProxy.Name, Namespace: torProxy.Namespace}, deployment)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return r.updateStatus(ctx, torProxy, "Pending", "Deployment not yet created")
+		}
+		return err
+	}
+
+	torProxy.Status.Replicas = deployment.Status.Replicas
+	torProxy.Status.ReadyReplicas = deployment.Status.ReadyReplicas
+	torProxy.Status.Selector = "app=" + torProxy.Name
+	torProxy.Status.ServiceName = torProxy.Name
+
+	if deployment.Status.ReadyReplicas == 0 {
+		return r.updateStatus(ctx, torProxy, "Initializing", "Waiting for tor pods to become ready")
+	}
+
+	return r.updateStatus(ctx, torProxy, "Ready",
+		fmt.Sprintf("%d/%d tor pods ready", deployment.Status.ReadyReplicas, deployment.Status.Replicas))
+}
+
+// updateStatus updates the TorProxy status
+func (r *TorProxyReconciler) updateStatus(ctx context.Context, torProxy *v1beta1.TorProxy, phase, message string) error {
+	torProxy.Status.Phase = phase
+	torProxy.Status.Message = message
+
+	return r.Status().Update(ctx, torProxy)
+}
+
+func (r *TorProxyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1beta1.TorProxy{}).
+		Owns(&appsv1.Deployment{}).
+		Watches(&v1beta1.TorNetwork{}, handler.EnqueueRequestsFromMapFunc(r.torProxiesForTorNetwork)).
+		Complete(r)
+}
+
+// torProxiesForTorNetwork enqueues the TorProxies using the TorNetwork, so
+// that they follow its directory authorities.
+func (r *TorProxyReconciler) torProxiesForTorNetwork(ctx context.Context, obj client.Object) []reconcile.Request {
+	torProxyList := &v1beta1.TorProxyList{}
+	if err := r.List(ctx, torProxyList, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, torProxy := range torProxyList.Items {
+		if torProxy.Spec.TorNetwork != obj.GetName() {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: torProxy.Name, Namespace: torProxy.Namespace},
+		})
+	}
+	return requests
+}
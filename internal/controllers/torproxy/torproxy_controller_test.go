@@ -0,0 +1,62 @@
+package torproxy
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+func TestTorProxy(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "TorProxy Suite")
+}
+
+var _ = Describe("TorProxy torrc", func() {
+	It("listens on the SOCKS port by default", func() {
+		torrc := generateTorrcConfig(&v1beta1.TorProxy{})
+		Expect(torrc).To(Equal("SOCKSPort 0.0.0.0:9050\n" +
+			"DataDirectory /var/lib/tor\n" +
+			"RunAsDaemon 0\n"))
+	})
+
+	It("renders the listeners with the isolation flags", func() {
+		torrc := generateTorrcConfig(&v1beta1.TorProxy{Spec: v1beta1.TorProxySpec{
+			SOCKS:          &v1beta1.TorProxyListener{Port: 1080},
+			HTTPTunnel:     &v1beta1.TorProxyListener{Port: 8118},
+			DNS:            &v1beta1.TorProxyListener{Port: 5353},
+			IsolationFlags: []v1beta1.IsolationFlag{"IsolateClientAddr", "IsolateDestPort"},
+		}})
+		Expect(torrc).To(ContainSubstring("SOCKSPort 0.0.0.0:1080 IsolateClientAddr IsolateDestPort\n"))
+		Expect(torrc).To(ContainSubstring("HTTPTunnelPort 0.0.0.0:8118 IsolateClientAddr IsolateDestPort\n"))
+		Expect(torrc).To(ContainSubstring("DNSPort 0.0.0.0:5353 IsolateClientAddr IsolateDestPort\nAutomapHostsOnResolve 1\n"))
+	})
+
+	It("renders the client policy in order", func() {
+		torrc := generateTorrcConfig(&v1beta1.TorProxy{Spec: v1beta1.TorProxySpec{
+			Policy: []v1beta1.TorPolicyRule{
+				{Action: "accept", Address: "10.0.0.0/8"},
+				{Action: "accept", Address: "[fd00::]/8"},
+				{Action: "reject", Address: "*"},
+			},
+		}})
+		Expect(torrc).To(ContainSubstring("SOCKSPolicy accept 10.0.0.0/8\n" +
+			"SOCKSPolicy accept [fd00::]/8\n" +
+			"SOCKSPolicy reject *\n"))
+	})
+
+	It("renders the exit countries as node lists", func() {
+		torrc := generateTorrcConfig(&v1beta1.TorProxy{Spec: v1beta1.TorProxySpec{
+			ExitCountries:        []string{"DE", "nl"},
+			ExcludeExitCountries: []string{"Us"},
+			StrictNodes:          true,
+		}})
+		Expect(torrc).To(ContainSubstring("ExitNodes {de},{nl}\n"))
+		Expect(torrc).To(ContainSubstring("ExcludeExitNodes {us}\n"))
+		Expect(torrc).To(ContainSubstring("StrictNodes 1\n"))
+
+		Expect(generateTorrcConfig(&v1beta1.TorProxy{})).NotTo(ContainSubstring("ExitNodes"))
+	})
+})
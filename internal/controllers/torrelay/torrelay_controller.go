@@ -0,0 +1,157 @@
+package torrelay
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/agent"
+	"github.com/fulviodenza/torproxy/internal/relay"
+)
+
+type TorRelayReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=tor.stack.io,resources=torrelays,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=tor.stack.io,resources=torrelays/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
+
+func (r *TorRelayReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	log := log.FromContext(ctx)
+	log.Info("got new request: ", "namespace/name", req.NamespacedName.String())
+
+	torRelay := &v1beta1.TorRelay{}
+	err := r.Get(ctx, req.NamespacedName, torRelay)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	// Owned objects are garbage collected with the TorRelay, the identity
+	// keys volume is kept by the StatefulSet.
+	if !torRelay.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	address, err := relay.PublicAddress(ctx, r.Client, torRelay.Namespace, torRelay.Name, torRelay.Spec.Expose)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	err = relay.Reconcile(ctx, r.Client,
+		*metav1.NewControllerRef(torRelay, v1beta1.GroupVersion.WithKind("TorRelay")),
+		torRelay.Namespace, torRelay.Name, relay.Relay{
+			Torrc: generateTorrcConfig(torRelay, address),
+			Ports: []corev1.ContainerPort{
+				{Name: "orport", ContainerPort: torRelay.Spec.ORPort, Protocol: corev1.ProtocolTCP},
+			},
+			Expose:    torRelay.Spec.Expose,
+			Image:     torRelay.Spec.Image,
+			Resources: torRelay.Spec.Resources,
+			Storage:   torRelay.Spec.Storage,
+		})
+	if agent.IsConflict(err) {
+		// The names are taken by another object, e.g. an OnionService:
+		// report it instead of overwriting its resources.
+		return reconcile.Result{}, r.updateStatus(ctx, torRelay, "Error", err.Error())
+	} else if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if err := r.reconcileStatus(ctx, torRelay, address); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// The LoadBalancer address and the fingerprint show up later on.
+	if torRelay.Status.Phase != "Ready" {
+		return reconcile.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+	return reconcile.Result{}, nil
+}
+
+// Generate the torrc of a non-exit relay. Only the LoadBalancer address is
+// advertised, tor finds out the node address by itself in HostPort mode.
+func generateTorrcConfig(torRelay *v1beta1.TorRelay, address string) string {
+	if torRelay.Spec.Expose != v1beta1.RelayExposeLoadBalancer {
+		address = ""
+	}
+
+	var config strings.Builder
+
+	config.WriteString(relay.Torrc(&torRelay.Spec, address))
+	fmt.Fprintf(&config, "ExitRelay 0\n")
+	fmt.Fprintf(&config, "ExitPolicy reject *:*\n")
+
+	return config.String()
+}
+
+func (r *TorRelayReconciler) reconcileStatus(ctx context.Context, torRelay *v1beta1.TorRelay, address string) error {
+	log := log.FromContext(ctx)
+
+	if torRelay.Spec.Address != "" {
+		address = torRelay.Spec.Address
+	}
+	torRelay.Status.Address = address
+
+	running, err := relay.Running(ctx, r.Client, torRelay.Namespace, torRelay.Name)
+	if err != nil {
+		return err
+	}
+	if !running {
+		return r.updateStatus(ctx, torRelay, "Initializing", "Waiting for the relay pod to start")
+	}
+
+	if torRelay.Status.Fingerprint == "" {
+		fingerprint, err := relay.Fingerprint(ctx, r.Client, torRelay.Namespace, torRelay.Name)
+		if err != nil {
+			log.Info("Failed to read the relay fingerprint, will retry", "error", err.Error())
+			return r.updateStatus(ctx, torRelay, "Initializing", "Waiting for tor to generate the identity keys")
+		}
+		if fingerprint == "" {
+			return r.updateStatus(ctx, torRelay, "Initializing", "Waiting for tor to generate the identity keys")
+		}
+		torRelay.Status.Fingerprint = fingerprint
+	}
+
+	if address == "" {
+		return r.updateStatus(ctx, torRelay, "Initializing", "Waiting for the ORPort address")
+	}
+
+	return r.updateStatus(ctx, torRelay, "Ready", "Relay running")
+}
+
+// updateStatus updates the TorRelay status
+func (r *TorRelayReconciler) updateStatus(ctx context.Context, torRelay *v1beta1.TorRelay, phase, message string) error {
+	torRelay.Status.Phase = phase
+	torRelay.Status.Message = message
+
+	return r.Status().Update(ctx, torRelay)
+}
+
+func (r *TorRelayReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1beta1.TorRelay{}).
+		Owns(&appsv1.StatefulSet{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Service{}).
+		Complete(r)
+}
@@ -0,0 +1,147 @@
+package exporter
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/fulviodenza/torproxy/internal/torcontrol"
+)
+
+const (
+	// circuitPurposeIntro and circuitPurposeRend are the purposes of the
+	// circuits of an onion service to its introduction points and to the
+	// rendezvous points chosen by clients.
+	circuitPurposeIntro = "HS_SERVICE_INTRO"
+	circuitPurposeRend  = "HS_SERVICE_REND"
+)
+
+var (
+	upDesc = prometheus.NewDesc(
+		"tor_up",
+		"Whether the control port of tor could be queried, 1, or not, 0.",
+		nil, nil)
+	bootstrapDesc = prometheus.NewDesc(
+		"tor_bootstrap_progress_percent",
+		"Bootstrap progress of tor, 100 once it is connected to the network.",
+		nil, nil)
+	readBytesDesc = prometheus.NewDesc(
+		"tor_traffic_read_bytes_total",
+		"Bytes read by tor since it started.",
+		nil, nil)
+	writtenBytesDesc = prometheus.NewDesc(
+		"tor_traffic_written_bytes_total",
+		"Bytes written by tor since it started.",
+		nil, nil)
+	circuitsDesc = prometheus.NewDesc(
+		"tor_circuits",
+		"Circuits of tor by purpose and state.",
+		[]string{"purpose", "state"}, nil)
+	introCircuitsDesc = prometheus.NewDesc(
+		"tor_hs_intro_circuits",
+		"Built circuits of the onion service to its introduction points.",
+		nil, nil)
+	rendCircuitsDesc = prometheus.NewDesc(
+		"tor_hs_rend_circuits",
+		"Built circuits of the onion service to rendezvous points, one per client connection.",
+		nil, nil)
+)
+
+// Collector queries the control port of tor at every scrape.
+type Collector struct {
+	Address    string
+	CookieFile string
+	Timeout    time.Duration
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- upDesc
+	ch <- bootstrapDesc
+	ch <- readBytesDesc
+	ch <- writtenBytesDesc
+	ch <- circuitsDesc
+	ch <- introCircuitsDesc
+	ch <- rendCircuitsDesc
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	info, err := c.getInfo()
+	if err != nil {
+		// Failing the whole scrape would hide the MetricsPort ones, tor_up
+		// reports the failure instead.
+		log.Printf("querying the control port: %v", err)
+		ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 0)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 1)
+
+	if progress, ok := torcontrol.BootstrapProgress(info["status/bootstrap-phase"]); ok {
+		ch <- prometheus.MustNewConstMetric(bootstrapDesc, prometheus.GaugeValue, float64(progress))
+	}
+	if read, err := strconv.ParseFloat(info["traffic/read"], 64); err == nil {
+		ch <- prometheus.MustNewConstMetric(readBytesDesc, prometheus.CounterValue, read)
+	}
+	if written, err := strconv.ParseFloat(info["traffic/written"], 64); err == nil {
+		ch <- prometheus.MustNewConstMetric(writtenBytesDesc, prometheus.CounterValue, written)
+	}
+
+	circuits := countCircuits(info["circuit-status"])
+	for key, count := range circuits {
+		ch <- prometheus.MustNewConstMetric(circuitsDesc, prometheus.GaugeValue, float64(count), key.purpose, key.state)
+	}
+	ch <- prometheus.MustNewConstMetric(introCircuitsDesc, prometheus.GaugeValue,
+		float64(circuits[circuitKey{purpose: circuitPurposeIntro, state: "BUILT"}]))
+	ch <- prometheus.MustNewConstMetric(rendCircuitsDesc, prometheus.GaugeValue,
+		float64(circuits[circuitKey{purpose: circuitPurposeRend, state: "BUILT"}]))
+}
+
+func (c *Collector) getInfo() (map[string]string, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := torcontrol.Dial(ctx, c.Address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.AuthenticateCookie(c.CookieFile); err != nil {
+		return nil, err
+	}
+	return conn.GetInfo("status/bootstrap-phase", "traffic/read", "traffic/written", "circuit-status")
+}
+
+type circuitKey struct {
+	purpose string
+	state   string
+}
+
+// countCircuits counts the circuits of a circuit-status reply by purpose
+// and state, one circuit per line:
+// <id> <state> [<path>] [BUILD_FLAGS=...] [PURPOSE=...] ...
+func countCircuits(status string) map[circuitKey]int {
+	circuits := map[circuitKey]int{}
+	for _, line := range strings.Split(status, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		key := circuitKey{purpose: "GENERAL", state: fields[1]}
+		for _, field := range fields[2:] {
+			if purpose, ok := strings.CutPrefix(field, "PURPOSE="); ok {
+				key.purpose = purpose
+			}
+		}
+		circuits[key]++
+	}
+	return circuits
+}
@@ -0,0 +1,149 @@
+package exporter
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestExporter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Exporter Suite")
+}
+
+// fakeTor answers AUTHENTICATE with the cookie and the GETINFO keys of
+// replies, like the control port of tor.
+func fakeTor(cookie string, replies map[string]string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	DeferCleanup(listener.Close)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					fields := strings.Fields(line)
+					switch fields[0] {
+					case "AUTHENTICATE":
+						if len(fields) != 2 || fields[1] != cookie {
+							conn.Write([]byte("515 Authentication failed\r\n"))
+							return
+						}
+						conn.Write([]byte("250 OK\r\n"))
+					case "GETINFO":
+						for _, key := range fields[1:] {
+							value := replies[key]
+							if strings.Contains(value, "\n") {
+								conn.Write([]byte("250+" + key + "=\r\n" + strings.ReplaceAll(value, "\n", "\r\n") + "\r\n.\r\n"))
+							} else {
+								conn.Write([]byte("250-" + key + "=" + value + "\r\n"))
+							}
+						}
+						conn.Write([]byte("250 OK\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func cookieFile(cookie []byte) string {
+	file := filepath.Join(GinkgoT().TempDir(), "control.authcookie")
+	Expect(os.WriteFile(file, cookie, 0o600)).To(Succeed())
+	return file
+}
+
+var _ = Describe("Collector", func() {
+	It("exports bootstrap, traffic and circuits read from the control port", func() {
+		address := fakeTor("01ff", map[string]string{
+			"status/bootstrap-phase": `NOTICE BOOTSTRAP PROGRESS=100 TAG=done SUMMARY="Done"`,
+			"traffic/read":           "1024",
+			"traffic/written":        "2048",
+			"circuit-status": strings.Join([]string{
+				"1 BUILT $AAAA~a,$BBBB~b,$CCCC~c BUILD_FLAGS=NEED_CAPACITY PURPOSE=GENERAL",
+				"2 BUILT $AAAA~a,$BBBB~b,$CCCC~c BUILD_FLAGS=IS_INTERNAL PURPOSE=HS_SERVICE_INTRO HS_STATE=HSSI_ESTABLISHED",
+				"3 BUILT $AAAA~a,$BBBB~b,$CCCC~c BUILD_FLAGS=IS_INTERNAL PURPOSE=HS_SERVICE_INTRO HS_STATE=HSSI_ESTABLISHED",
+				"4 BUILT $AAAA~a,$BBBB~b,$CCCC~c BUILD_FLAGS=IS_INTERNAL PURPOSE=HS_SERVICE_REND HS_STATE=HSSR_JOINED",
+				"5 LAUNCHED BUILD_FLAGS=IS_INTERNAL PURPOSE=HS_SERVICE_REND",
+			}, "\n"),
+		})
+
+		collector := &Collector{Address: address, CookieFile: cookieFile([]byte{0x01, 0xff})}
+		Expect(testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP tor_bootstrap_progress_percent Bootstrap progress of tor, 100 once it is connected to the network.
+# TYPE tor_bootstrap_progress_percent gauge
+tor_bootstrap_progress_percent 100
+# HELP tor_circuits Circuits of tor by purpose and state.
+# TYPE tor_circuits gauge
+tor_circuits{purpose="GENERAL",state="BUILT"} 1
+tor_circuits{purpose="HS_SERVICE_INTRO",state="BUILT"} 2
+tor_circuits{purpose="HS_SERVICE_REND",state="BUILT"} 1
+tor_circuits{purpose="HS_SERVICE_REND",state="LAUNCHED"} 1
+# HELP tor_hs_intro_circuits Built circuits of the onion service to its introduction points.
+# TYPE tor_hs_intro_circuits gauge
+tor_hs_intro_circuits 2
+# HELP tor_hs_rend_circuits Built circuits of the onion service to rendezvous points, one per client connection.
+# TYPE tor_hs_rend_circuits gauge
+tor_hs_rend_circuits 1
+# HELP tor_traffic_read_bytes_total Bytes read by tor since it started.
+# TYPE tor_traffic_read_bytes_total counter
+tor_traffic_read_bytes_total 1024
+# HELP tor_traffic_written_bytes_total Bytes written by tor since it started.
+# TYPE tor_traffic_written_bytes_total counter
+tor_traffic_written_bytes_total 2048
+# HELP tor_up Whether the control port of tor could be queried, 1, or not, 0.
+# TYPE tor_up gauge
+tor_up 1
+`))).To(Succeed())
+	})
+
+	It("reports tor down with a wrong cookie", func() {
+		address := fakeTor("01ff", map[string]string{})
+		collector := &Collector{Address: address, CookieFile: cookieFile([]byte{0x02})}
+		Expect(testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP tor_up Whether the control port of tor could be queried, 1, or not, 0.
+# TYPE tor_up gauge
+tor_up 0
+`))).To(Succeed())
+	})
+})
+
+var _ = Describe("MetricsPortGatherer", func() {
+	It("relays the metrics of tor", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte("# TYPE tor_hs_pow_num_pqueue_rdv gauge\n" +
+				"tor_hs_pow_num_pqueue_rdv{onion=\"abc\"} 3\n" +
+				"# TYPE tor_hs_intro_num_total counter\n" +
+				"tor_hs_intro_num_total{onion=\"abc\"} 12\n"))
+		}))
+		DeferCleanup(server.Close)
+
+		families, err := (&MetricsPortGatherer{URL: server.URL}).Gather()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(families).To(HaveLen(2))
+		Expect(families[0].GetName()).To(Equal("tor_hs_intro_num_total"))
+		Expect(families[1].GetMetric()[0].GetGauge().GetValue()).To(Equal(3.0))
+	})
+})
@@ -0,0 +1,131 @@
+package network
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// IdentityKeyFile, SigningKeyFile and CertificateFile are the files
+	// written by tor-gencert in the keys directory of an authority.
+	IdentityKeyFile = "authority_identity_key"
+	SigningKeyFile  = "authority_signing_key"
+	CertificateFile = "authority_certificate"
+	// RelayKeyFile is the RSA identity of the authority as a relay.
+	RelayKeyFile = "secret_id_key"
+
+	identityKeyBits = 3072
+	signingKeyBits  = 1024
+	relayKeyBits    = 1024
+
+	timeLayout = "2006-01-02 15:04:05"
+)
+
+// AuthorityKeys are the keys of a directory authority, in the on-disk
+// format of tor.
+type AuthorityKeys struct {
+	// Files of the keys directory, by name.
+	Files map[string][]byte
+	// V3Ident is the fingerprint of the authority identity key.
+	V3Ident string
+	// Fingerprint of the relay identity key.
+	Fingerprint string
+}
+
+// GenerateAuthority creates the keys of a new directory authority, like
+// tor-gencert does, with a certificate valid for the given duration.
+func GenerateAuthority(now time.Time, validity time.Duration) (*AuthorityKeys, error) {
+	identityKey, err := rsa.GenerateKey(rand.Reader, identityKeyBits)
+	if err != nil {
+		return nil, err
+	}
+	signingKey, err := rsa.GenerateKey(rand.Reader, signingKeyBits)
+	if err != nil {
+		return nil, err
+	}
+	relayKey, err := rsa.GenerateKey(rand.Reader, relayKeyBits)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := certify(identityKey, signingKey, now, validity)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthorityKeys{
+		Files: map[string][]byte{
+			IdentityKeyFile: privateKeyPEM(identityKey),
+			SigningKeyFile:  privateKeyPEM(signingKey),
+			CertificateFile: certificate,
+			RelayKeyFile:    privateKeyPEM(relayKey),
+		},
+		V3Ident:     Fingerprint(&identityKey.PublicKey),
+		Fingerprint: Fingerprint(&relayKey.PublicKey),
+	}, nil
+}
+
+// Fingerprint is the hex SHA1 digest of the DER encoded public key, as
+// used by tor to identify RSA keys.
+func Fingerprint(key *rsa.PublicKey) string {
+	return strings.ToUpper(hex.EncodeToString(digest(key)))
+}
+
+func digest(key *rsa.PublicKey) []byte {
+	sum := sha1.Sum(x509.MarshalPKCS1PublicKey(key))
+	return sum[:]
+}
+
+// certify renders the key certificate binding the signing key to the
+// identity key, as described in dir-spec section 3.1.
+func certify(identityKey, signingKey *rsa.PrivateKey, now time.Time, validity time.Duration) ([]byte, error) {
+	var certificate bytes.Buffer
+
+	fmt.Fprintf(&certificate, "dir-key-certificate-version 3\n")
+	fmt.Fprintf(&certificate, "fingerprint %s\n", Fingerprint(&identityKey.PublicKey))
+	fmt.Fprintf(&certificate, "dir-key-published %s\n", now.UTC().Format(timeLayout))
+	fmt.Fprintf(&certificate, "dir-key-expires %s\n", now.Add(validity).UTC().Format(timeLayout))
+	fmt.Fprintf(&certificate, "dir-identity-key\n%s", publicKeyPEM(&identityKey.PublicKey))
+	fmt.Fprintf(&certificate, "dir-signing-key\n%s", publicKeyPEM(&signingKey.PublicKey))
+
+	// The signing key signs the digest of the identity key.
+	crossCert, err := sign(signingKey, digest(&identityKey.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(&certificate, "dir-key-crosscert\n%s", pem.EncodeToMemory(&pem.Block{Type: "ID SIGNATURE", Bytes: crossCert}))
+
+	// The identity key signs the certificate up to this line.
+	fmt.Fprintf(&certificate, "dir-key-certification\n")
+	sum := sha1.Sum(certificate.Bytes())
+	certification, err := sign(identityKey, sum[:])
+	if err != nil {
+		return nil, err
+	}
+	certificate.Write(pem.EncodeToMemory(&pem.Block{Type: "SIGNATURE", Bytes: certification}))
+
+	return certificate.Bytes(), nil
+}
+
+// sign pads the digest as PKCS#1 v1.5 without the DigestInfo prefix, like
+// tor does for its directory signatures.
+func sign(key *rsa.PrivateKey, digest []byte) ([]byte, error) {
+	return rsa.SignPKCS1v15(rand.Reader, key, crypto.Hash(0), digest)
+}
+
+func privateKeyPEM(key *rsa.PrivateKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func publicKeyPEM(key *rsa.PublicKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(key)})
+}
@@ -0,0 +1,153 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+const (
+	ORPort  = 5000
+	DirPort = 7000
+
+	// DataDirectory of the nodes, the authority keys are copied in its
+	// keys directory.
+	DataDirectory = "/var/lib/tor"
+)
+
+// ErrNotReady is returned while the referenced TorNetwork does not know
+// its directory authorities yet.
+var ErrNotReady = errors.New("TorNetwork not ready")
+
+// IsNotReady reports whether err is, or wraps, ErrNotReady.
+func IsNotReady(err error) bool {
+	return errors.Is(err, ErrNotReady)
+}
+
+// DirAuthority renders the DirAuthority line of an authority reachable at
+// address.
+func DirAuthority(nickname, address, v3Ident, fingerprint string) string {
+	return fmt.Sprintf("%s orport=%d no-v2 v3ident=%s %s:%d %s", nickname, ORPort, v3Ident, address, DirPort, fingerprint)
+}
+
+// Config is what tor needs to join a private network instead of the
+// public Tor network. The zero Config is the public Tor network.
+type Config struct {
+	DirAuthorities []string
+}
+
+// Torrc renders the settings to append to a torrc.
+func (c *Config) Torrc() string {
+	if c == nil || len(c.DirAuthorities) == 0 {
+		return ""
+	}
+
+	var config strings.Builder
+
+	fmt.Fprintf(&config, "TestingTorNetwork 1\n")
+	for _, dirAuthority := range c.DirAuthorities {
+		fmt.Fprintf(&config, "DirAuthority %s\n", dirAuthority)
+	}
+
+	return config.String()
+}
+
+// Args are the same settings as tor command line arguments.
+func (c *Config) Args() []string {
+	if c == nil || len(c.DirAuthorities) == 0 {
+		return nil
+	}
+
+	args := []string{"--TestingTorNetwork", "1"}
+	for _, dirAuthority := range c.DirAuthorities {
+		args = append(args, "--DirAuthority", dirAuthority)
+	}
+	return args
+}
+
+// Resolve returns the Config of the TorNetwork name, nil when name is
+// empty. ErrNotReady is returned until the network is up.
+func Resolve(ctx context.Context, c client.Client, namespace, name string) (*Config, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	torNetwork := &v1beta1.TorNetwork{}
+	if err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, torNetwork); err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			return nil, fmt.Errorf("%w: TorNetwork %s not found", ErrNotReady, name)
+		}
+		return nil, err
+	}
+
+	if len(torNetwork.Status.DirAuthorities) == 0 {
+		return nil, fmt.Errorf("%w: waiting for the directory authorities of %s", ErrNotReady, name)
+	}
+	return &Config{DirAuthorities: torNetwork.Status.DirAuthorities}, nil
+}
+
+// testingTorrc are the settings shared by every node of the network.
+// TestingTorNetwork allows private addresses and shortens the timings,
+// the authorities vote every 20 seconds to bootstrap quickly.
+func testingTorrc(config *Config) string {
+	var torrc strings.Builder
+
+	torrc.WriteString(config.Torrc())
+	fmt.Fprintf(&torrc, "SOCKSPort 0\n")
+	fmt.Fprintf(&torrc, "ORPort %d\n", ORPort)
+	fmt.Fprintf(&torrc, "DirPort %d\n", DirPort)
+	fmt.Fprintf(&torrc, "DataDirectory %s\n", DataDirectory)
+	fmt.Fprintf(&torrc, "RunAsDaemon 0\n")
+
+	return torrc.String()
+}
+
+// AuthorityTorrc renders the torrc of the authority nickname, advertising
+// address.
+func AuthorityTorrc(config *Config, nickname, address string) string {
+	var torrc strings.Builder
+
+	torrc.WriteString(testingTorrc(config))
+	fmt.Fprintf(&torrc, "Nickname %s\n", nickname)
+	fmt.Fprintf(&torrc, "Address %s\n", address)
+	fmt.Fprintf(&torrc, "AuthoritativeDirectory 1\n")
+	fmt.Fprintf(&torrc, "V3AuthoritativeDirectory 1\n")
+	fmt.Fprintf(&torrc, "V3AuthVotingInterval 20\n")
+	fmt.Fprintf(&torrc, "V3AuthVoteDelay 4\n")
+	fmt.Fprintf(&torrc, "V3AuthDistDelay 4\n")
+	fmt.Fprintf(&torrc, "TestingV3AuthInitialVotingInterval 20\n")
+	fmt.Fprintf(&torrc, "TestingV3AuthInitialVoteDelay 4\n")
+	fmt.Fprintf(&torrc, "TestingV3AuthInitialDistDelay 4\n")
+	// Every node gets the flags needed to be used right away.
+	fmt.Fprintf(&torrc, "TestingDirAuthVoteGuard *\n")
+	fmt.Fprintf(&torrc, "TestingDirAuthVoteExit *\n")
+	fmt.Fprintf(&torrc, "TestingDirAuthVoteHSDir *\n")
+	fmt.Fprintf(&torrc, "ExitPolicy reject *:*\n")
+
+	return torrc.String()
+}
+
+// RelayTorrc renders the torrc of the relays, or of the exits. The address
+// of the pod is given on the command line.
+func RelayTorrc(config *Config, exit bool) string {
+	var torrc strings.Builder
+
+	torrc.WriteString(testingTorrc(config))
+	if exit {
+		fmt.Fprintf(&torrc, "ExitRelay 1\n")
+		// The cluster network is private, tor rejects it by default.
+		fmt.Fprintf(&torrc, "ExitPolicyRejectPrivate 0\n")
+		fmt.Fprintf(&torrc, "ExitPolicy accept *:*\n")
+	} else {
+		fmt.Fprintf(&torrc, "ExitRelay 0\n")
+		fmt.Fprintf(&torrc, "ExitPolicy reject *:*\n")
+	}
+
+	return torrc.String()
+}
@@ -0,0 +1,103 @@
+package network
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestNetwork(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Network Suite")
+}
+
+func publicKey(block []byte) *rsa.PublicKey {
+	p, _ := pem.Decode(block)
+	Expect(p).NotTo(BeNil())
+	key, err := x509.ParsePKCS1PublicKey(p.Bytes)
+	Expect(err).NotTo(HaveOccurred())
+	return key
+}
+
+func privateKey(block []byte) *rsa.PrivateKey {
+	p, _ := pem.Decode(block)
+	Expect(p).NotTo(BeNil())
+	key, err := x509.ParsePKCS1PrivateKey(p.Bytes)
+	Expect(err).NotTo(HaveOccurred())
+	return key
+}
+
+var _ = Describe("authority keys", func() {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	keys, err := GenerateAuthority(now, 24*time.Hour)
+
+	It("writes a certificate signed like tor-gencert does", func() {
+		Expect(err).NotTo(HaveOccurred())
+
+		certificate := string(keys.Files[CertificateFile])
+		Expect(certificate).To(HavePrefix("dir-key-certificate-version 3\nfingerprint " + keys.V3Ident + "\n"))
+		Expect(certificate).To(ContainSubstring("dir-key-published 2024-05-01 12:00:00\ndir-key-expires 2024-05-02 12:00:00\n"))
+
+		identityKey := &privateKey(keys.Files[IdentityKeyFile]).PublicKey
+		signingKey := &privateKey(keys.Files[SigningKeyFile]).PublicKey
+		Expect(Fingerprint(identityKey)).To(Equal(keys.V3Ident))
+
+		rest := certificate[strings.Index(certificate, "dir-identity-key\n")+len("dir-identity-key\n"):]
+		Expect(publicKey([]byte(rest))).To(Equal(identityKey))
+		rest = rest[strings.Index(rest, "dir-signing-key\n")+len("dir-signing-key\n"):]
+		Expect(publicKey([]byte(rest))).To(Equal(signingKey))
+
+		rest = rest[strings.Index(rest, "dir-key-crosscert\n")+len("dir-key-crosscert\n"):]
+		crossCert, _ := pem.Decode([]byte(rest))
+		Expect(crossCert.Type).To(Equal("ID SIGNATURE"))
+		Expect(rsa.VerifyPKCS1v15(signingKey, crypto.Hash(0), digest(identityKey), crossCert.Bytes)).To(Succeed())
+
+		signed := certificate[:strings.Index(certificate, "dir-key-certification\n")+len("dir-key-certification\n")]
+		certification, _ := pem.Decode([]byte(certificate[len(signed):]))
+		Expect(certification.Type).To(Equal("SIGNATURE"))
+		sum := sha1.Sum([]byte(signed))
+		Expect(rsa.VerifyPKCS1v15(identityKey, crypto.Hash(0), sum[:], certification.Bytes)).To(Succeed())
+	})
+
+	It("fingerprints the relay identity key", func() {
+		relayKey := privateKey(keys.Files[RelayKeyFile])
+		Expect(relayKey.N.BitLen()).To(Equal(1024))
+		Expect(keys.Fingerprint).To(Equal(Fingerprint(&relayKey.PublicKey)))
+		Expect(keys.Fingerprint).To(MatchRegexp("^[0-9A-F]{40}$"))
+	})
+})
+
+var _ = Describe("network config", func() {
+	config := &Config{DirAuthorities: []string{
+		DirAuthority("auth0", "10.96.0.10", "AAAA", "BBBB"),
+	}}
+
+	It("renders the DirAuthority lines", func() {
+		Expect(config.Torrc()).To(Equal("TestingTorNetwork 1\n" +
+			"DirAuthority auth0 orport=5000 no-v2 v3ident=AAAA 10.96.0.10:7000 BBBB\n"))
+		Expect(config.Args()).To(Equal([]string{
+			"--TestingTorNetwork", "1",
+			"--DirAuthority", "auth0 orport=5000 no-v2 v3ident=AAAA 10.96.0.10:7000 BBBB",
+		}))
+	})
+
+	It("leaves the public network alone", func() {
+		var public *Config
+		Expect(public.Torrc()).To(BeEmpty())
+		Expect(public.Args()).To(BeEmpty())
+	})
+
+	It("renders the node roles", func() {
+		Expect(AuthorityTorrc(config, "auth0", "10.96.0.10")).To(ContainSubstring("Address 10.96.0.10\nAuthoritativeDirectory 1\nV3AuthoritativeDirectory 1\n"))
+		Expect(RelayTorrc(config, true)).To(HaveSuffix("ExitRelay 1\nExitPolicyRejectPrivate 0\nExitPolicy accept *:*\n"))
+		Expect(RelayTorrc(config, false)).To(HaveSuffix("ExitRelay 0\nExitPolicy reject *:*\n"))
+	})
+})
@@ -0,0 +1,110 @@
+package onion
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha3"
+	"crypto/sha512"
+	"encoding/base32"
+	"fmt"
+	"strings"
+)
+
+const (
+	// SecretKeyFile and PublicKeyFile are the names tor gives to the v3
+	// identity key files inside a HiddenServiceDir.
+	SecretKeyFile = "hs_ed25519_secret_key"
+	PublicKeyFile = "hs_ed25519_public_key"
+	HostnameFile  = "hostname"
+
+	secretKeyHeader = "== ed25519v1-secret: type0 ==\x00\x00\x00"
+	publicKeyHeader = "== ed25519v1-public: type0 ==\x00\x00\x00"
+
+	version = byte(0x03)
+)
+
+// Key is a v3 onion service identity in the on-disk format used by tor.
+type Key struct {
+	// SecretKey is the content of hs_ed25519_secret_key.
+	SecretKey []byte
+	// PublicKey is the content of hs_ed25519_public_key.
+	PublicKey []byte
+	// Hostname is the onion address, including the .onion suffix.
+	Hostname string
+}
+
+// GenerateKey creates a new random v3 onion service identity.
+func GenerateKey() (*Key, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return NewKey(pub, priv.Seed()), nil
+}
+
+// NewKey builds the tor key files for the given ed25519 key pair.
+func NewKey(pub ed25519.PublicKey, seed []byte) *Key {
+	return &Key{
+		SecretKey: append([]byte(secretKeyHeader), ExpandSeed(seed)...),
+		PublicKey: append([]byte(publicKeyHeader), pub...),
+		Hostname:  Address(pub) + ".onion",
+	}
+}
+
+// ExpandSeed returns the 64 bytes expanded secret key tor stores instead
+// of the ed25519 seed.
+func ExpandSeed(seed []byte) []byte {
+	h := sha512.Sum512(seed)
+	h[0] &= 248
+	h[31] &= 127
+	h[31] |= 64
+	return h[:]
+}
+
+// Address returns the onion address, without the .onion suffix, for the
+// given ed25519 public key as described in rend-spec-v3.
+func Address(pub ed25519.PublicKey) string {
+	checksum := sha3.Sum256(append(append([]byte(".onion checksum"), pub...), version))
+
+	var buf bytes.Buffer
+	buf.Write(pub)
+	buf.Write(checksum[:2])
+	buf.WriteByte(version)
+
+	return strings.ToLower(base32.StdEncoding.EncodeToString(buf.Bytes()))
+}
+
+// ParseSecretKey validates a hs_ed25519_secret_key file and returns the
+// expanded secret key it contains.
+func ParseSecretKey(data []byte) ([]byte, error) {
+	if len(data) != len(secretKeyHeader)+64 || !bytes.HasPrefix(data, []byte(secretKeyHeader)) {
+		return nil, fmt.Errorf("invalid %s file", SecretKeyFile)
+	}
+	return data[len(secretKeyHeader):], nil
+}
+
+// ParsePublicKey validates a hs_ed25519_public_key file and returns the
+// public key it contains.
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	if len(data) != len(publicKeyHeader)+ed25519.PublicKeySize || !bytes.HasPrefix(data, []byte(publicKeyHeader)) {
+		return nil, fmt.Errorf("invalid %s file", PublicKeyFile)
+	}
+	return ed25519.PublicKey(data[len(publicKeyHeader):]), nil
+}
+
+// PublicKeyFromAddress returns the ed25519 public key encoded in the onion
+// address, with or without the .onion suffix, after checking its version
+// and checksum.
+func PublicKeyFromAddress(address string) (ed25519.PublicKey, error) {
+	decoded, err := base32.StdEncoding.DecodeString(strings.ToUpper(strings.TrimSuffix(address, ".onion")))
+	if err != nil || len(decoded) != ed25519.PublicKeySize+3 {
+		return nil, fmt.Errorf("invalid onion address %q", address)
+	}
+
+	pub := ed25519.PublicKey(decoded[:ed25519.PublicKeySize])
+	if Address(pub) != strings.ToLower(strings.TrimSuffix(address, ".onion")) {
+		return nil, fmt.Errorf("invalid checksum or version in onion address %q", address)
+	}
+	return pub, nil
+}
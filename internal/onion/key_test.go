@@ -0,0 +1,91 @@
+package onion
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestOnion(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Onion Suite")
+}
+
+func decodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	Expect(err).NotTo(HaveOccurred())
+	return b
+}
+
+var _ = Describe("onion keys", func() {
+	// The seed and public key are the first test vector of RFC 8032, tor
+	// uses the public key in test_build_address of test_hs_common.c.
+	const (
+		seed     = "9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60"
+		pub      = "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a"
+		expanded = "307c83864f2833cb427a2ef1c00a013cfdff2768d980c0a3a520f006904de94f" +
+			"9b4f0afe280b746a778684e75442502057b7473a03f08f96f5a38e9287e01f8f"
+		address = "25njqamcweflpvkl73j4szahhihoc4xt3ktcgjnpaingr5yhkenl5sid"
+	)
+
+	It("builds the onion address of tor", func() {
+		Expect(Address(decodeHex(pub))).To(Equal(address))
+	})
+
+	It("expands the seed as tor stores it", func() {
+		Expect(hex.EncodeToString(ExpandSeed(decodeHex(seed)))).To(Equal(expanded))
+	})
+
+	It("writes the key files of tor", func() {
+		priv := ed25519.NewKeyFromSeed(decodeHex(seed))
+		key := NewKey(priv.Public().(ed25519.PublicKey), decodeHex(seed))
+
+		Expect(key.Hostname).To(Equal(address + ".onion"))
+		Expect(key.SecretKey).To(Equal(append([]byte("== ed25519v1-secret: type0 ==\x00\x00\x00"), decodeHex(expanded)...)))
+		Expect(key.PublicKey).To(Equal(append([]byte("== ed25519v1-public: type0 ==\x00\x00\x00"), decodeHex(pub)...)))
+
+		secret, err := ParseSecretKey(key.SecretKey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hex.EncodeToString(secret)).To(Equal(expanded))
+		public, err := ParsePublicKey(key.PublicKey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hex.EncodeToString(public)).To(Equal(pub))
+	})
+
+	It("rejects malformed key files", func() {
+		key, err := GenerateKey()
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = ParseSecretKey(key.SecretKey[:len(key.SecretKey)-1])
+		Expect(err).To(HaveOccurred())
+		_, err = ParseSecretKey(key.PublicKey)
+		Expect(err).To(HaveOccurred())
+		_, err = ParsePublicKey(append(key.PublicKey, 0))
+		Expect(err).To(HaveOccurred())
+		_, err = ParsePublicKey(key.SecretKey[:len(key.PublicKey)])
+		Expect(err).To(HaveOccurred())
+	})
+
+	DescribeTable("decodes onion addresses",
+		func(address string, valid bool) {
+			pub, err := PublicKeyFromAddress(address)
+			if !valid {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(Address(pub)).To(Equal(strings.ToLower(strings.TrimSuffix(address, ".onion"))))
+		},
+		// Examples of rend-spec-v3.
+		Entry("with the suffix", "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion", true),
+		Entry("without the suffix", "sp3k262uwy4r2k3ycr5awluarykdpag6a7y33jxop4cs2lu5uz5sseqd", true),
+		Entry("in upper case", "XA4R2IADXM55FBNQGWWI5MYMQDCOFIU3W6RPBTQN7B2DYN7MGWJ64JYD.onion", true),
+		Entry("with a wrong checksum", "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscrad.onion", false),
+		Entry("with a wrong version", "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryb.onion", false),
+		Entry("too short", "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4psc.onion", false),
+	)
+})
@@ -0,0 +1,113 @@
+package onion
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// AttemptsKey and RateKey are published by every search pod with the
+	// keys it generated so far and the number per second.
+	AttemptsKey = "attempts"
+	RateKey     = "rate"
+)
+
+// MaxPrefixLength is the longest vanity prefix searched, longer ones take
+// years on a cluster.
+const MaxPrefixLength = 12
+
+// ValidPrefix checks the prefix can be found: it is made of base32
+// characters and not too long.
+func ValidPrefix(prefix string) error {
+	if prefix == "" || len(prefix) > MaxPrefixLength {
+		return fmt.Errorf("prefix %q must be 1 to %d characters long", prefix, MaxPrefixLength)
+	}
+	if strings.Trim(strings.ToLower(prefix), "abcdefghijklmnopqrstuvwxyz234567") != "" {
+		return fmt.Errorf("prefix %q is not base32, only a-z and 2-7 are allowed", prefix)
+	}
+	return nil
+}
+
+// ExpectedAttempts is the average number of keys to generate to find an
+// address starting with prefix.
+func ExpectedAttempts(prefix string) float64 {
+	return math.Pow(32, float64(len(prefix)))
+}
+
+// Search generates keys on workers goroutines until the address of one
+// starts with prefix, or ctx is done. Attempts, when not nil, counts the
+// keys generated.
+func Search(ctx context.Context, prefix string, workers int, attempts *atomic.Uint64) (*Key, error) {
+	if err := ValidPrefix(prefix); err != nil {
+		return nil, err
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	if attempts == nil {
+		attempts = &atomic.Uint64{}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make(chan *Key, workers)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if key := search(ctx, []byte(strings.ToUpper(prefix)), attempts); key != nil {
+				found <- key
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(found)
+	}()
+
+	key, ok := <-found
+	if !ok {
+		return nil, ctx.Err()
+	}
+	return key, nil
+}
+
+// search is a worker of Search. The address starts with the base32 of the
+// public key, only the bytes covering the prefix are encoded.
+func search(ctx context.Context, prefix []byte, attempts *atomic.Uint64) *Key {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	size := (len(prefix)*5 + 7) / 8
+	encoded := make([]byte, encoding.EncodedLen(size))
+	seed := make([]byte, ed25519.SeedSize)
+
+	for {
+		// Checking ctx costs more than a key, do it every so often.
+		for i := range 256 {
+			if _, err := rand.Read(seed); err != nil {
+				return nil
+			}
+			pub := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+
+			encoding.Encode(encoded, pub[:size])
+			if bytes.HasPrefix(encoded, prefix) {
+				attempts.Add(uint64(i + 1))
+				return NewKey(pub, seed)
+			}
+		}
+		attempts.Add(256)
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package onion
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("vanity search", func() {
+	DescribeTable("finds keys with the prefix",
+		func(prefix, hostnamePrefix string) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			attempts := &atomic.Uint64{}
+			key, err := Search(ctx, prefix, 2, attempts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(key.Hostname).To(HavePrefix(hostnamePrefix))
+			Expect(attempts.Load()).To(BeNumerically(">", 0))
+
+			pub, err := ParsePublicKey(key.PublicKey)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(Address(pub) + ".onion").To(Equal(key.Hostname))
+			_, err = ParseSecretKey(key.SecretKey)
+			Expect(err).NotTo(HaveOccurred())
+		},
+		Entry("one character", "a", "a"),
+		Entry("two characters", "x7", "x7"),
+		Entry("upper case", "Ab", "ab"),
+	)
+
+	It("rejects prefixes which cannot be found", func() {
+		_, err := Search(context.Background(), "sh0p", 1, nil)
+		Expect(err).To(HaveOccurred())
+		_, err = Search(context.Background(), "", 1, nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("stops with the context", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := Search(ctx, "aaaaaaaaaaaa", 1, nil)
+		Expect(err).To(MatchError(context.Canceled))
+	})
+})
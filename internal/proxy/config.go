@@ -0,0 +1,241 @@
+package proxy
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type PathType string
+
+const (
+	PathPrefix            PathType = "Prefix"
+	PathExact             PathType = "Exact"
+	PathRegularExpression PathType = "RegularExpression"
+)
+
+// Backend is a cluster address (host:port) traffic is forwarded to.
+type Backend struct {
+	Address string
+	// Weight of the backend among the ones of the same route, 0 means 1.
+	Weight int32
+}
+
+// Route forwards requests matching the path to its backends.
+type Route struct {
+	Path     string
+	PathType PathType
+	Backends []Backend
+}
+
+// pathSpecialCharacters end a location path in nginx.conf, start a
+// comment or a quoted string, or escape the next character.
+const pathSpecialCharacters = "{};\"#\\"
+
+// Validate rejects the routes whose path nginx would not read as a single
+// location path, they could otherwise add directives to nginx.conf.
+// Regular expressions are quoted instead, they must be valid RE2
+// expressions: its syntax is mostly a subset of the PCRE one of nginx.
+func (r Route) Validate() error {
+	if r.PathType == PathRegularExpression {
+		for _, c := range r.Path {
+			if unicode.IsControl(c) {
+				return fmt.Errorf("regular expression %q contains %q", r.Path, c)
+			}
+		}
+		if _, err := regexp.Compile(r.Path); err != nil {
+			return fmt.Errorf("invalid regular expression %q: %w", r.Path, err)
+		}
+		return nil
+	}
+
+	if !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("path %q does not start with /", r.Path)
+	}
+	for _, c := range r.Path {
+		if unicode.IsSpace(c) || unicode.IsControl(c) || strings.ContainsRune(pathSpecialCharacters, c) {
+			return fmt.Errorf("path %q contains %q", r.Path, c)
+		}
+	}
+	return nil
+}
+
+// location is the location argument matching the route.
+func (r Route) location() string {
+	switch r.PathType {
+	case PathExact:
+		return "= " + r.Path
+	case PathRegularExpression:
+		// nginx unescapes \\ and \" in quoted strings, and turns \n, \r
+		// and \t into control characters.
+		return `~ "` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(r.Path) + `"`
+	default:
+		return r.Path
+	}
+}
+
+// HTTPServer routes HTTP requests received on Port by host and path.
+type HTTPServer struct {
+	Port int32
+	// Routes by Host header, the "" host matches every request, including
+	// the ones sent to the onion address.
+	Routes map[string][]Route
+	// DefaultBackends serve requests not matching any route.
+	DefaultBackends []Backend
+}
+
+// TCPServer forwards connections received on Port to its backends.
+type TCPServer struct {
+	Port     int32
+	Backends []Backend
+}
+
+// Config is the routing table of a reverse proxy sitting between tor and
+// the cluster Services.
+type Config struct {
+	HTTP []HTTPServer
+	TCP  []TCPServer
+}
+
+// Ports returns the ports the proxy listens on.
+func (c Config) Ports() []int32 {
+	ports := []int32{}
+	for _, server := range c.HTTP {
+		ports = append(ports, server.Port)
+	}
+	for _, server := range c.TCP {
+		ports = append(ports, server.Port)
+	}
+	return ports
+}
+
+// Render returns the nginx.conf implementing the routing table.
+func (c Config) Render() string {
+	var config strings.Builder
+	r := &renderer{}
+
+	fmt.Fprintf(&config, "worker_processes 1;\n")
+	fmt.Fprintf(&config, "events {}\n")
+
+	var servers strings.Builder
+	for _, server := range c.HTTP {
+		hosts := make([]string, 0, len(server.Routes))
+		for host := range server.Routes {
+			if host != "" {
+				hosts = append(hosts, host)
+			}
+		}
+		sort.Strings(hosts)
+
+		fmt.Fprintf(&servers, "  server {\n")
+		fmt.Fprintf(&servers, "    listen %d default_server;\n", server.Port)
+		fmt.Fprintf(&servers, "    server_name _;\n")
+		r.writeLocations(&servers, server.DefaultBackends, server.Routes[""])
+		fmt.Fprintf(&servers, "  }\n")
+
+		for _, host := range hosts {
+			fmt.Fprintf(&servers, "  server {\n")
+			fmt.Fprintf(&servers, "    listen %d;\n", server.Port)
+			fmt.Fprintf(&servers, "    server_name %s;\n", host)
+			r.writeLocations(&servers, server.DefaultBackends, server.Routes[host])
+			fmt.Fprintf(&servers, "  }\n")
+		}
+	}
+
+	fmt.Fprintf(&config, "http {\n")
+	fmt.Fprintf(&config, "  proxy_set_header Host $host;\n")
+	fmt.Fprintf(&config, "  proxy_set_header X-Forwarded-Proto http;\n")
+	fmt.Fprintf(&config, "  server_tokens off;\n")
+	config.WriteString(r.upstreams.String())
+	config.WriteString(servers.String())
+	fmt.Fprintf(&config, "}\n")
+
+	if len(c.TCP) > 0 {
+		r.upstreams.Reset()
+		servers.Reset()
+		for _, server := range c.TCP {
+			fmt.Fprintf(&servers, "  server {\n")
+			fmt.Fprintf(&servers, "    listen %d;\n", server.Port)
+			fmt.Fprintf(&servers, "    proxy_pass %s;\n", r.upstream(server.Backends))
+			fmt.Fprintf(&servers, "  }\n")
+		}
+
+		fmt.Fprintf(&config, "stream {\n")
+		config.WriteString(r.upstreams.String())
+		config.WriteString(servers.String())
+		fmt.Fprintf(&config, "}\n")
+	}
+
+	return config.String()
+}
+
+// renderer collects the upstream blocks, which nginx only accepts at the
+// http and stream level, while the servers are rendered.
+type renderer struct {
+	upstreams strings.Builder
+	count     int
+}
+
+func (r *renderer) writeLocations(config *strings.Builder, defaultBackends []Backend, routes []Route) {
+	hasRoot := false
+	seen := map[string]bool{}
+	for _, route := range routes {
+		// Invalid routes are reported by the controllers building the
+		// configuration.
+		if len(route.Backends) == 0 || route.Validate() != nil {
+			continue
+		}
+
+		if route.PathType != PathExact && route.PathType != PathRegularExpression {
+			hasRoot = hasRoot || route.Path == "/"
+		}
+
+		// nginx refuses duplicated locations, the first route wins.
+		location := route.location()
+		if seen[location] {
+			continue
+		}
+		seen[location] = true
+
+		fmt.Fprintf(config, "    location %s {\n", location)
+		fmt.Fprintf(config, "      proxy_pass http://%s;\n", r.upstream(route.Backends))
+		fmt.Fprintf(config, "    }\n")
+	}
+
+	if hasRoot {
+		return
+	}
+
+	fmt.Fprintf(config, "    location / {\n")
+	if len(defaultBackends) > 0 {
+		fmt.Fprintf(config, "      proxy_pass http://%s;\n", r.upstream(defaultBackends))
+	} else {
+		fmt.Fprintf(config, "      return 404;\n")
+	}
+	fmt.Fprintf(config, "    }\n")
+}
+
+// upstream returns the address to proxy_pass to: the backend itself when
+// there is only one of them, otherwise a weighted upstream.
+func (r *renderer) upstream(backends []Backend) string {
+	if len(backends) == 1 {
+		return backends[0].Address
+	}
+
+	r.count++
+	name := fmt.Sprintf("upstream%d", r.count)
+
+	fmt.Fprintf(&r.upstreams, "  upstream %s {\n", name)
+	for _, backend := range backends {
+		weight := backend.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		fmt.Fprintf(&r.upstreams, "    server %s weight=%d;\n", backend.Address, weight)
+	}
+	fmt.Fprintf(&r.upstreams, "  }\n")
+
+	return name
+}
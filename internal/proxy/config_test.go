@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestProxy(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Proxy Suite")
+}
+
+var _ = Describe("nginx.conf", func() {
+	backends := []Backend{{Address: "web.default.svc.cluster.local:80"}}
+
+	It("routes by host and path", func() {
+		config := Config{HTTP: []HTTPServer{{
+			Port: 80,
+			Routes: map[string][]Route{
+				"": {
+					{Path: "/api", PathType: PathPrefix, Backends: backends},
+					{Path: "/healthz", PathType: PathExact, Backends: backends},
+				},
+				"shop.example": {{Path: "/", PathType: PathPrefix, Backends: backends}},
+			},
+		}}}.Render()
+
+		Expect(config).To(ContainSubstring("    location /api {\n      proxy_pass http://web.default.svc.cluster.local:80;\n"))
+		Expect(config).To(ContainSubstring("    location = /healthz {\n"))
+		Expect(config).To(ContainSubstring("    server_name shop.example;\n"))
+		Expect(config).To(ContainSubstring("      return 404;\n"))
+	})
+
+	DescribeTable("rejects paths which are not a single location path",
+		func(path string) {
+			route := Route{Path: path, PathType: PathPrefix, Backends: backends}
+			Expect(route.Validate()).NotTo(Succeed())
+
+			config := Config{HTTP: []HTTPServer{{
+				Port:   80,
+				Routes: map[string][]Route{"": {route}},
+			}}}.Render()
+			Expect(config).NotTo(ContainSubstring("location /a"))
+			Expect(config).NotTo(ContainSubstring("evil"))
+		},
+		Entry("space", "/a { return 200 evil; } location /b"),
+		Entry("new line", "/a\nevil"),
+		Entry("tab", "/a\tevil"),
+		Entry("opening brace", "/a{evil"),
+		Entry("closing brace", "/a}evil"),
+		Entry("semicolon", "/a;evil"),
+		Entry("double quote", "/a\"evil"),
+		Entry("comment", "/a#evil"),
+		Entry("backslash", "/a\\evil"),
+		Entry("relative path", "evil"),
+	)
+
+	DescribeTable("quotes regular expressions",
+		func(path, location string) {
+			route := Route{Path: path, PathType: PathRegularExpression, Backends: backends}
+			Expect(route.Validate()).To(Succeed())
+
+			config := Config{HTTP: []HTTPServer{{
+				Port:   80,
+				Routes: map[string][]Route{"": {route}},
+			}}}.Render()
+			Expect(config).To(ContainSubstring("    location " + location + " {\n      proxy_pass"))
+		},
+		Entry("plain", "^/api/v[0-9]+$", `~ "^/api/v[0-9]+$"`),
+		Entry("braces and semicolons", "^/a{2}; return 200 b;", `~ "^/a{2}; return 200 b;"`),
+		Entry("double quote", `^/a" { return 200 b; } location ~ "c`, `~ "^/a\" { return 200 b; } location ~ \"c"`),
+		Entry("backslashes", `^/a\.b\d\n\"`, `~ "^/a\\.b\\d\\n\\\""`),
+	)
+
+	DescribeTable("rejects invalid regular expressions",
+		func(path string) {
+			route := Route{Path: path, PathType: PathRegularExpression, Backends: backends}
+			Expect(route.Validate()).NotTo(Succeed())
+
+			config := Config{HTTP: []HTTPServer{{
+				Port:   80,
+				Routes: map[string][]Route{"": {route}},
+			}}}.Render()
+			Expect(config).NotTo(ContainSubstring("location ~"))
+		},
+		Entry("unbalanced parenthesis", "^/(a"),
+		Entry("new line", "^/a\n}"),
+		Entry("PCRE only syntax", "^/(?!admin)"),
+	)
+
+	It("accepts the characters of URL paths", func() {
+		Expect(Route{Path: "/a-b_c.d~e/f%20g/$h&i'j(k)*l+m,n=o:p@q!", PathType: PathExact}.Validate()).To(Succeed())
+	})
+})
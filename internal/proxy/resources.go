@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Image is the reverse proxy routing onion traffic to the cluster Services.
+var Image = "nginx:alpine"
+
+const configHashAnnotation = "tor.stack.io/config-hash"
+
+// Reconcile creates or updates the ConfigMap, Deployment and Service,
+// all called name, running the proxy described by config on behalf of
+// the owner.
+func Reconcile(ctx context.Context, c client.Client, owner metav1.OwnerReference, namespace, name string, config Config) error {
+	rendered := config.Render()
+
+	if err := reconcileConfigMap(ctx, c, owner, namespace, name, rendered); err != nil {
+		return err
+	}
+
+	if err := reconcileDeployment(ctx, c, owner, namespace, name, rendered, config.Ports()); err != nil {
+		return err
+	}
+
+	return reconcileService(ctx, c, owner, namespace, name, config.Ports())
+}
+
+func reconcileConfigMap(ctx context.Context, c client.Client, owner metav1.OwnerReference, namespace, name, config string) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       namespace,
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		Data: map[string]string{
+			"nginx.conf": config,
+		},
+	}
+
+	found := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: cm.Name, Namespace: cm.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return c.Create(ctx, cm)
+	} else if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(found.Data, cm.Data) {
+		found.Data = cm.Data
+		return c.Update(ctx, found)
+	}
+
+	return nil
+}
+
+func reconcileDeployment(ctx context.Context, c client.Client, owner metav1.OwnerReference, namespace, name, config string, ports []int32) error {
+	containerPorts := []corev1.ContainerPort{}
+	for _, port := range ports {
+		containerPorts = append(containerPorts, corev1.ContainerPort{ContainerPort: port})
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       namespace,
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": name,
+				},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app": name,
+					},
+					// nginx does not reload its configuration, roll the pods
+					// whenever the routes change.
+					Annotations: map[string]string{
+						configHashAnnotation: fmt.Sprintf("%x", sha256.Sum256([]byte(config))),
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "proxy",
+							Image: Image,
+							Ports: containerPorts,
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "config",
+									MountPath: "/etc/nginx/nginx.conf",
+									SubPath:   "nginx.conf",
+								},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "config",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: name,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	found := &appsv1.Deployment{}
+	err := c.Get(ctx, types.NamespacedName{Name: deployment.Name, Namespace: deployment.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return c.Create(ctx, deployment)
+	} else if err != nil {
+		return err
+	}
+
+	found.Spec = deployment.Spec
+	return c.Update(ctx, found)
+}
+
+func reconcileService(ctx context.Context, c client.Client, owner metav1.OwnerReference, namespace, name string, ports []int32) error {
+	servicePorts := []corev1.ServicePort{}
+	for _, port := range ports {
+		servicePorts = append(servicePorts, corev1.ServicePort{
+			Name:       fmt.Sprintf("port-%d", port),
+			Port:       port,
+			TargetPort: intstr.FromInt32(port),
+		})
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       namespace,
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{
+				"app": name,
+			},
+			Ports: servicePorts,
+		},
+	}
+
+	found := &corev1.Service{}
+	err := c.Get(ctx, types.NamespacedName{Name: service.Name, Namespace: service.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return c.Create(ctx, service)
+	} else if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(found.Spec.Ports, service.Spec.Ports) || !reflect.DeepEqual(found.Spec.Selector, service.Spec.Selector) {
+		found.Spec.Ports = service.Spec.Ports
+		found.Spec.Selector = service.Spec.Selector
+		return c.Update(ctx, found)
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package relay
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+// TransportDirectory is where the transport binary is copied when it comes
+// from its own image.
+const TransportDirectory = "/opt/pt"
+
+// Obfs4BridgeLineFile is written by the obfs4 transport with the cert of
+// the bridge.
+var Obfs4BridgeLineFile = filepath.Join(DataDirectory, "pt_state", "obfs4_bridgeline.txt")
+
+// Obfs4BridgeLineKey is the key the agent publishes Obfs4BridgeLineFile
+// with.
+const Obfs4BridgeLineKey = "obfs4-bridgeline"
+
+// webTunnelPlaceholder is the address of webtunnel bridge lines, clients
+// connect to the url instead.
+const webTunnelPlaceholder = "[2001:db8::1]:443"
+
+// TransportBinary is the path tor executes the transport from.
+func TransportBinary(transport *v1beta1.BridgeTransport) string {
+	binary := transport.Binary
+	if binary == "" {
+		binary = "/usr/bin/lyrebird"
+		if transport.Type == v1beta1.BridgeTransportWebTunnel {
+			binary = "/usr/bin/webtunnel"
+		}
+	}
+
+	if transport.Image != "" {
+		return path.Join(TransportDirectory, path.Base(binary))
+	}
+	return Value(binary)
+}
+
+// BridgeTorrc renders the bridge settings, to be appended to Torrc.
+func BridgeTorrc(spec *v1beta1.TorBridgeSpec) string {
+	var config strings.Builder
+
+	transport := spec.Transport.Type
+	fmt.Fprintf(&config, "BridgeRelay 1\n")
+	fmt.Fprintf(&config, "ExtORPort auto\n")
+	fmt.Fprintf(&config, "ServerTransportPlugin %s exec %s\n", transport, TransportBinary(&spec.Transport))
+	fmt.Fprintf(&config, "ServerTransportListenAddr %s 0.0.0.0:%d\n", transport, spec.Transport.Port)
+	if transport == v1beta1.BridgeTransportWebTunnel {
+		fmt.Fprintf(&config, "ServerTransportOptions webtunnel url=%s\n", Value(spec.Transport.URL))
+		// The ORPort is not meant to be reachable, clients come through
+		// the web server.
+		fmt.Fprintf(&config, "AssumeReachable 1\n")
+	}
+	if spec.Distribution != "" {
+		fmt.Fprintf(&config, "BridgeDistribution %s\n", spec.Distribution)
+	}
+
+	return config.String()
+}
+
+// BridgeLine builds the line given to clients. obfs4 lines need the
+// content of Obfs4BridgeLineFile, webtunnel ones the url.
+func BridgeLine(spec *v1beta1.TorBridgeSpec, address, fingerprint, obfs4BridgeLine string) (string, error) {
+	if spec.Transport.Type == v1beta1.BridgeTransportWebTunnel {
+		return fmt.Sprintf("webtunnel %s %s url=%s", webTunnelPlaceholder, fingerprint, spec.Transport.URL), nil
+	}
+
+	// The file holds a template line:
+	// Bridge obfs4 <IP ADDRESS>:<PORT> <FINGERPRINT> cert=... iat-mode=0
+	scanner := bufio.NewScanner(strings.NewReader(obfs4BridgeLine))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "Bridge" || fields[1] != v1beta1.BridgeTransportObfs4 {
+			continue
+		}
+
+		line := []string{v1beta1.BridgeTransportObfs4, net.JoinHostPort(address, strconv.Itoa(int(spec.Transport.Port))), fingerprint}
+		for _, field := range fields[2:] {
+			if strings.HasPrefix(field, "cert=") || strings.HasPrefix(field, "iat-mode=") {
+				line = append(line, field)
+			}
+		}
+		if len(line) == 3 {
+			return "", fmt.Errorf("no cert in the obfs4 bridge line %q", scanner.Text())
+		}
+		return strings.Join(line, " "), nil
+	}
+
+	return "", fmt.Errorf("no obfs4 bridge line found")
+}
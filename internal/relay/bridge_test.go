@@ -0,0 +1,69 @@
+package relay
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+var _ = Describe("bridge", func() {
+	obfs4 := &v1beta1.TorBridgeSpec{
+		Transport:    v1beta1.BridgeTransport{Type: v1beta1.BridgeTransportObfs4, Port: 9002},
+		Distribution: "moat",
+	}
+	webTunnel := &v1beta1.TorBridgeSpec{
+		Transport: v1beta1.BridgeTransport{
+			Type:  v1beta1.BridgeTransportWebTunnel,
+			Port:  15000,
+			Image: "example.com/webtunnel",
+			URL:   "https://example.com/secret-path",
+		},
+	}
+
+	It("renders the transport settings", func() {
+		Expect(BridgeTorrc(obfs4)).To(Equal("BridgeRelay 1\n" +
+			"ExtORPort auto\n" +
+			"ServerTransportPlugin obfs4 exec /usr/bin/lyrebird\n" +
+			"ServerTransportListenAddr obfs4 0.0.0.0:9002\n" +
+			"BridgeDistribution moat\n"))
+	})
+
+	It("runs the binary copied from the transport image", func() {
+		torrc := BridgeTorrc(webTunnel)
+		Expect(torrc).To(ContainSubstring("ServerTransportPlugin webtunnel exec /opt/pt/webtunnel\n"))
+		Expect(torrc).To(ContainSubstring("ServerTransportOptions webtunnel url=https://example.com/secret-path\n"))
+	})
+
+	It("keeps the transport settings on their line", func() {
+		spec := &v1beta1.TorBridgeSpec{
+			Transport: v1beta1.BridgeTransport{
+				Type:   v1beta1.BridgeTransportWebTunnel,
+				Port:   15000,
+				Binary: "/usr/bin/webtunnel\nExitRelay 1",
+				URL:    "https://example.com/\r\nBridgeDistribution any",
+			},
+		}
+		torrc := BridgeTorrc(spec)
+		Expect(torrc).To(ContainSubstring("ServerTransportPlugin webtunnel exec /usr/bin/webtunnelExitRelay 1\n"))
+		Expect(torrc).To(ContainSubstring("ServerTransportOptions webtunnel url=https://example.com/BridgeDistribution any\n"))
+		Expect(torrc).NotTo(MatchRegexp("(?m)^(ExitRelay|BridgeDistribution)"))
+	})
+
+	It("fills the obfs4 bridge line template", func() {
+		file := "# lyrebird bridge line\n" +
+			"Bridge obfs4 <IP ADDRESS>:<PORT> <FINGERPRINT> cert=c2VjcmV0 iat-mode=0\n"
+		Expect(BridgeLine(obfs4, "203.0.113.7", "ABCDEF", file)).To(
+			Equal("obfs4 203.0.113.7:9002 ABCDEF cert=c2VjcmV0 iat-mode=0"))
+		Expect(BridgeLine(obfs4, "2001:db8::7", "ABCDEF", file)).To(
+			HavePrefix("obfs4 [2001:db8::7]:9002 ABCDEF"))
+
+		_, err := BridgeLine(obfs4, "203.0.113.7", "ABCDEF", "# empty\n")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("points webtunnel bridge lines at the url", func() {
+		Expect(BridgeLine(webTunnel, "203.0.113.7", "ABCDEF", "")).To(
+			Equal("webtunnel [2001:db8::1]:443 ABCDEF url=https://example.com/secret-path"))
+	})
+})
@@ -0,0 +1,76 @@
+package relay
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+// DataDirectory keeps the identity keys of the relay, it is backed by a
+// persistent volume.
+const DataDirectory = "/var/lib/tor"
+
+// Value drops the control characters of a free form torrc value, line
+// breaks would start new options. The CRDs reject them, objects created
+// before are covered too.
+func Value(value string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, value)
+}
+
+// Torrc renders the settings shared by every kind of relay. The address is
+// advertised when spec.Address is not set.
+func Torrc(spec *v1beta1.TorRelaySpec, address string) string {
+	var config strings.Builder
+
+	fmt.Fprintf(&config, "Nickname %s\n", spec.Nickname)
+	if spec.ContactInfo != "" {
+		fmt.Fprintf(&config, "ContactInfo %s\n", Value(spec.ContactInfo))
+	}
+
+	fmt.Fprintf(&config, "ORPort %d\n", spec.ORPort)
+	if spec.Address != "" {
+		address = spec.Address
+	}
+	if address = Value(address); address != "" {
+		fmt.Fprintf(&config, "Address %s\n", address)
+	}
+	// Relays do not serve local clients.
+	fmt.Fprintf(&config, "SOCKSPort 0\n")
+
+	if spec.Bandwidth != nil {
+		fmt.Fprintf(&config, "RelayBandwidthRate %s\n", spec.Bandwidth.Rate)
+		if spec.Bandwidth.Burst != "" {
+			fmt.Fprintf(&config, "RelayBandwidthBurst %s\n", spec.Bandwidth.Burst)
+		}
+	}
+
+	if spec.Accounting != nil {
+		fmt.Fprintf(&config, "AccountingMax %s\n", spec.Accounting.Max)
+		if spec.Accounting.Start != "" {
+			fmt.Fprintf(&config, "AccountingStart %s\n", Value(spec.Accounting.Start))
+		}
+		if spec.Accounting.Rule != "" {
+			fmt.Fprintf(&config, "AccountingRule %s\n", spec.Accounting.Rule)
+		}
+	}
+
+	if len(spec.MyFamily) > 0 {
+		family := make([]string, 0, len(spec.MyFamily))
+		for _, fingerprint := range spec.MyFamily {
+			family = append(family, "$"+strings.ToUpper(strings.TrimPrefix(fingerprint, "$")))
+		}
+		fmt.Fprintf(&config, "MyFamily %s\n", strings.Join(family, ","))
+	}
+
+	fmt.Fprintf(&config, "DataDirectory %s\n", DataDirectory)
+	fmt.Fprintf(&config, "RunAsDaemon 0\n")
+
+	return config.String()
+}
@@ -0,0 +1,71 @@
+package relay
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+func TestRelay(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Relay Suite")
+}
+
+var _ = Describe("relay torrc", func() {
+	spec := &v1beta1.TorRelaySpec{
+		Nickname: "k8smiddle",
+		ORPort:   9001,
+		Address:  "198.51.100.1",
+		Bandwidth: &v1beta1.RelayBandwidth{
+			Rate: "5 MBytes",
+		},
+		Accounting: &v1beta1.RelayAccounting{
+			Max:  "500 GBytes",
+			Rule: "sum",
+		},
+		MyFamily: []string{
+			"$ABCDEF0123456789ABCDEF0123456789ABCDEF01",
+			"abcdef0123456789abcdef0123456789abcdef02",
+		},
+	}
+
+	It("renders the relay settings", func() {
+		torrc := Torrc(spec, "")
+		Expect(torrc).To(ContainSubstring("Nickname k8smiddle\n"))
+		Expect(torrc).To(ContainSubstring("ORPort 9001\n"))
+		Expect(torrc).To(ContainSubstring("Address 198.51.100.1\n"))
+		Expect(torrc).To(ContainSubstring("SOCKSPort 0\n"))
+		Expect(torrc).To(ContainSubstring("RelayBandwidthRate 5 MBytes\n"))
+		Expect(torrc).NotTo(ContainSubstring("RelayBandwidthBurst"))
+		Expect(torrc).To(ContainSubstring("AccountingMax 500 GBytes\nAccountingRule sum\n"))
+	})
+
+	It("normalizes the family fingerprints", func() {
+		Expect(Torrc(spec, "")).To(ContainSubstring(
+			"MyFamily $ABCDEF0123456789ABCDEF0123456789ABCDEF01,$ABCDEF0123456789ABCDEF0123456789ABCDEF02\n"))
+	})
+
+	It("keeps free form values on their line", func() {
+		torrc := Torrc(&v1beta1.TorRelaySpec{
+			Nickname:    "k8smiddle",
+			ContactInfo: "admin@example.com\r\nExitRelay 1",
+			Address:     "198.51.100.1\nExitPolicy accept *:*",
+			Accounting: &v1beta1.RelayAccounting{
+				Max:   "500 GBytes",
+				Start: "day 00:00\nSOCKSPort 9050",
+			},
+		}, "")
+		Expect(torrc).To(ContainSubstring("ContactInfo admin@example.comExitRelay 1\n"))
+		Expect(torrc).To(ContainSubstring("Address 198.51.100.1ExitPolicy accept *:*\n"))
+		Expect(torrc).To(ContainSubstring("AccountingStart day 00:00SOCKSPort 9050\n"))
+		Expect(torrc).NotTo(MatchRegexp("(?m)^(ExitRelay|ExitPolicy|SOCKSPort 9050)"))
+	})
+
+	It("advertises the given address unless one is set", func() {
+		Expect(Torrc(spec, "203.0.113.7")).To(ContainSubstring("Address 198.51.100.1\n"))
+		Expect(Torrc(&v1beta1.TorRelaySpec{Nickname: "k8smiddle"}, "203.0.113.7")).To(ContainSubstring("Address 203.0.113.7\n"))
+	})
+})
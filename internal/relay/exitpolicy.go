@@ -0,0 +1,275 @@
+package relay
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+// ResolvConfFile is the resolv.conf used by exits with custom nameservers.
+const ResolvConfFile = "/etc/tor/resolv.conf"
+
+// reducedExitPorts are the ports accepted by the reduced exit policy,
+// https://gitlab.torproject.org/legacy/trac/-/wikis/doc/ReducedExitPolicy
+var reducedExitPorts = []string{
+	"20-21", "22", "23", "43", "53", "79-81", "88", "110", "143", "194", "220",
+	"389", "443", "464-465", "531", "543-544", "554", "563", "587", "636",
+	"706", "749", "853", "873", "902-904", "981", "989-995", "1194", "1220",
+	"1293", "1500", "1533", "1677", "1723", "1755", "1863", "2082-2083",
+	"2086-2087", "2095-2096", "2102-2104", "3128", "3389", "3690", "4321",
+	"4643", "5050", "5190", "5222-5223", "5228", "5900", "6660-6669", "6679",
+	"6697", "8000", "8008", "8074", "8080", "8082", "8087-8088", "8232-8233",
+	"8332-8333", "8443", "8888", "9418", "9999", "10000", "11371", "19294",
+	"19638", "50002", "64738",
+}
+
+// privateNetworks must never be reached through an exit.
+var privateNetworks = []string{
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7", "fe80::/10",
+}
+
+// smtpPort is rejected by every sane exit, open SMTP exits are used for spam.
+const smtpPort = 25
+
+// ExitTorrc renders the exit settings of the relay, to be appended to
+// Torrc.
+func ExitTorrc(spec *v1beta1.TorExitRelaySpec) string {
+	var config strings.Builder
+
+	fmt.Fprintf(&config, "ExitRelay 1\n")
+	if spec.IPv6Exit {
+		fmt.Fprintf(&config, "IPv6Exit 1\n")
+	}
+
+	for _, rule := range spec.ExitPolicy.Rules {
+		fmt.Fprintf(&config, "ExitPolicy %s %s:%s\n", rule.Action, ruleAddress(rule), rulePorts(rule))
+	}
+
+	switch spec.ExitPolicy.Preset {
+	case v1beta1.ExitPolicyPresetReduced:
+		for _, ports := range reducedExitPorts {
+			fmt.Fprintf(&config, "ExitPolicy accept *:%s\n", ports)
+		}
+		fmt.Fprintf(&config, "ExitPolicy reject *:*\n")
+	case v1beta1.ExitPolicyPresetWeb:
+		fmt.Fprintf(&config, "ExitPolicy accept *:80\n")
+		fmt.Fprintf(&config, "ExitPolicy accept *:443\n")
+		fmt.Fprintf(&config, "ExitPolicy reject *:*\n")
+	case v1beta1.ExitPolicyPresetDefault:
+		// tor appends its default exit policy.
+	default:
+		fmt.Fprintf(&config, "ExitPolicy reject *:*\n")
+	}
+
+	if spec.DNS != nil {
+		if len(spec.DNS.Nameservers) > 0 {
+			fmt.Fprintf(&config, "ServerDNSResolvConfFile %s\n", ResolvConfFile)
+		}
+		if spec.DNS.DetectHijacking != nil && !*spec.DNS.DetectHijacking {
+			fmt.Fprintf(&config, "ServerDNSDetectHijacking 0\n")
+		}
+	}
+
+	return config.String()
+}
+
+// ResolvConf lists the nameservers of the exit, it is empty when the pod
+// resolver is used.
+func ResolvConf(spec *v1beta1.TorExitRelaySpec) string {
+	if spec.DNS == nil {
+		return ""
+	}
+
+	var resolvConf strings.Builder
+	for _, nameserver := range spec.DNS.Nameservers {
+		fmt.Fprintf(&resolvConf, "nameserver %s\n", nameserver)
+	}
+	return resolvConf.String()
+}
+
+func ruleAddress(rule v1beta1.ExitPolicyRule) string {
+	if rule.Address == "" {
+		return "*"
+	}
+	return rule.Address
+}
+
+func rulePorts(rule v1beta1.ExitPolicyRule) string {
+	if rule.Ports == "" {
+		return "*"
+	}
+	return rule.Ports
+}
+
+// parsePorts parses *, a port or a range.
+func parsePorts(ports string) (int, int, error) {
+	if ports == "*" {
+		return 1, 65535, nil
+	}
+
+	bounds := strings.SplitN(ports, "-", 2)
+	low, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port %q", bounds[0])
+	}
+	high := low
+	if len(bounds) == 2 {
+		high, err = strconv.Atoi(bounds[1])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid port %q", bounds[1])
+		}
+	}
+
+	if low < 1 || high > 65535 || low > high {
+		return 0, 0, fmt.Errorf("invalid port range %q", ports)
+	}
+	return low, high, nil
+}
+
+// parseExitAddress parses the address of an exit policy rule, the network
+// is nil for the *, *4 and *6 wildcards.
+func parseExitAddress(address string) (network *net.IPNet, ipv6 bool, err error) {
+	switch address {
+	case "*", "*4":
+		return nil, false, nil
+	case "*6":
+		return nil, true, nil
+	}
+
+	if strings.HasPrefix(address, "[") {
+		end := strings.Index(address, "]")
+		if end < 0 {
+			return nil, false, fmt.Errorf("invalid address %q", address)
+		}
+		address = address[1:end] + address[end+1:]
+		ipv6 = true
+	} else if strings.Contains(address, ":") {
+		return nil, false, fmt.Errorf("IPv6 address %q must be written between brackets", address)
+	}
+
+	if !strings.Contains(address, "/") {
+		if ipv6 {
+			address += "/128"
+		} else {
+			address += "/32"
+		}
+	}
+
+	_, network, err = net.ParseCIDR(address)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid address %q", address)
+	}
+	if (network.IP.To4() == nil) != ipv6 {
+		return nil, false, fmt.Errorf("invalid address %q", address)
+	}
+	return network, ipv6, nil
+}
+
+// wildcardFamilies returns the IP families, "4" and "6", a wildcard address
+// covers.
+func wildcardFamilies(address string) []string {
+	switch address {
+	case "*":
+		return []string{"4", "6"}
+	case "*4":
+		return []string{"4"}
+	case "*6":
+		return []string{"6"}
+	}
+	return nil
+}
+
+func overlapsPrivate(network *net.IPNet) bool {
+	for _, cidr := range privateNetworks {
+		_, private, _ := net.ParseCIDR(cidr)
+		if private.Contains(network.IP) || network.Contains(private.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateExit refuses malformed or obviously dangerous exit settings:
+// rules tor would not parse, IPv6 rules without IPv6Exit, accepting
+// private networks and open SMTP relaying. Harmless oddities are returned
+// as warnings.
+func ValidateExit(spec *v1beta1.TorExitRelaySpec) (warnings []string, errs []error) {
+	if strings.TrimSpace(spec.AbuseContact) == "" {
+		errs = append(errs, fmt.Errorf("abuseContact is required for exit relays"))
+	}
+	// They are written verbatim in the torrc.
+	for _, field := range []struct{ name, value string }{
+		{"abuseContact", spec.AbuseContact},
+		{"contactInfo", spec.ContactInfo},
+		{"address", spec.Address},
+	} {
+		if Value(field.value) != field.value {
+			errs = append(errs, fmt.Errorf("%s contains control characters", field.name))
+		}
+	}
+
+	// The wildcards cover IPv4, IPv6 or both, SMTP is decided for each
+	// family by the first wildcard rule covering it. IPv6 is only relayed
+	// with IPv6Exit.
+	smtpDecided := map[string]bool{"4": false, "6": !spec.IPv6Exit}
+	accepts := spec.ExitPolicy.Preset != ""
+	for i, rule := range spec.ExitPolicy.Rules {
+		network, ipv6, err := parseExitAddress(ruleAddress(rule))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("exitPolicy.rules[%d]: %w", i, err))
+			continue
+		}
+		low, high, err := parsePorts(rulePorts(rule))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("exitPolicy.rules[%d]: %w", i, err))
+			continue
+		}
+
+		if ipv6 && !spec.IPv6Exit {
+			errs = append(errs, fmt.Errorf("exitPolicy.rules[%d]: IPv6 rules need ipv6Exit", i))
+		}
+
+		smtp := network == nil && low <= smtpPort && smtpPort <= high
+		if rule.Action != "accept" {
+			if smtp {
+				for _, family := range wildcardFamilies(ruleAddress(rule)) {
+					smtpDecided[family] = true
+				}
+			}
+			continue
+		}
+		accepts = true
+
+		if network != nil && overlapsPrivate(network) {
+			errs = append(errs, fmt.Errorf("exitPolicy.rules[%d]: accepting private network %s", i, network))
+		}
+		if smtp {
+			openRelay := false
+			for _, family := range wildcardFamilies(ruleAddress(rule)) {
+				openRelay = openRelay || !smtpDecided[family]
+				smtpDecided[family] = true
+			}
+			if openRelay {
+				errs = append(errs, fmt.Errorf("exitPolicy.rules[%d]: accepting port %d everywhere makes an open mail relay, reject it first", i, smtpPort))
+			}
+		}
+	}
+
+	if !accepts {
+		warnings = append(warnings, "the exit policy accepts nothing, the relay will not be used as an exit")
+	}
+
+	if spec.DNS != nil {
+		for i, nameserver := range spec.DNS.Nameservers {
+			if net.ParseIP(nameserver) == nil {
+				errs = append(errs, fmt.Errorf("dns.nameservers[%d]: %q is not an IP address", i, nameserver))
+			}
+		}
+	}
+
+	return warnings, errs
+}
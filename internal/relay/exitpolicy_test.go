@@ -0,0 +1,117 @@
+package relay
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/ptr"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+)
+
+var _ = Describe("exit policy", func() {
+	exit := func(rules ...v1beta1.ExitPolicyRule) *v1beta1.TorExitRelaySpec {
+		return &v1beta1.TorExitRelaySpec{
+			AbuseContact: "abuse@example.com",
+			ExitPolicy:   v1beta1.ExitPolicy{Rules: rules},
+		}
+	}
+
+	It("renders the rules before the preset", func() {
+		spec := exit(v1beta1.ExitPolicyRule{Action: "reject", Address: "*", Ports: "25"})
+		spec.ExitPolicy.Preset = v1beta1.ExitPolicyPresetWeb
+		Expect(ExitTorrc(spec)).To(Equal("ExitRelay 1\n" +
+			"ExitPolicy reject *:25\n" +
+			"ExitPolicy accept *:80\n" +
+			"ExitPolicy accept *:443\n" +
+			"ExitPolicy reject *:*\n"))
+	})
+
+	It("rejects everything not accepted without preset", func() {
+		spec := exit(v1beta1.ExitPolicyRule{Action: "accept", Address: "*", Ports: "443"})
+		Expect(ExitTorrc(spec)).To(HaveSuffix("ExitPolicy accept *:443\nExitPolicy reject *:*\n"))
+	})
+
+	It("lets tor append its default policy", func() {
+		spec := exit()
+		spec.ExitPolicy.Preset = v1beta1.ExitPolicyPresetDefault
+		Expect(ExitTorrc(spec)).NotTo(ContainSubstring("ExitPolicy"))
+	})
+
+	It("renders the DNS settings", func() {
+		spec := exit()
+		spec.DNS = &v1beta1.ExitDNS{Nameservers: []string{"127.0.0.1"}, DetectHijacking: ptr.To(false)}
+		Expect(ExitTorrc(spec)).To(ContainSubstring("ServerDNSResolvConfFile /etc/tor/resolv.conf\nServerDNSDetectHijacking 0\n"))
+		Expect(ResolvConf(spec)).To(Equal("nameserver 127.0.0.1\n"))
+	})
+
+	It("accepts sane policies", func() {
+		spec := exit(
+			v1beta1.ExitPolicyRule{Action: "reject", Address: "*", Ports: "25"},
+			v1beta1.ExitPolicyRule{Action: "accept", Address: "*", Ports: "*"},
+		)
+		warnings, errs := ValidateExit(spec)
+		Expect(warnings).To(BeEmpty())
+		Expect(errs).To(BeEmpty())
+
+		spec.ExitPolicy.Rules = []v1beta1.ExitPolicyRule{
+			{Action: "reject", Address: "*4", Ports: "25"},
+			{Action: "accept", Address: "*4", Ports: "*"},
+		}
+		_, errs = ValidateExit(spec)
+		Expect(errs).To(BeEmpty())
+
+		spec.ExitPolicy.Rules = []v1beta1.ExitPolicyRule{{Action: "accept", Address: "[2001:db8::]/32", Ports: "80-443"}}
+		spec.IPv6Exit = true
+		_, errs = ValidateExit(spec)
+		Expect(errs).To(BeEmpty())
+	})
+
+	DescribeTable("refuses dangerous or malformed policies",
+		func(spec *v1beta1.TorExitRelaySpec, message string) {
+			_, errs := ValidateExit(spec)
+			Expect(errs).To(ContainElement(MatchError(ContainSubstring(message))))
+		},
+		Entry("open mail relay", exit(v1beta1.ExitPolicyRule{Action: "accept", Address: "*", Ports: "1-1024"}), "open mail relay"),
+		Entry("mail relay rejected on IPv6 only", exit(
+			v1beta1.ExitPolicyRule{Action: "reject", Address: "*6", Ports: "25"},
+			v1beta1.ExitPolicyRule{Action: "accept", Address: "*", Ports: "*"},
+		), "open mail relay"),
+		Entry("mail relay rejected on IPv4 only with ipv6Exit", func() *v1beta1.TorExitRelaySpec {
+			spec := exit(
+				v1beta1.ExitPolicyRule{Action: "reject", Address: "*4", Ports: "25"},
+				v1beta1.ExitPolicyRule{Action: "accept", Address: "*", Ports: "*"},
+			)
+			spec.IPv6Exit = true
+			return spec
+		}(), "open mail relay"),
+		Entry("private network", exit(v1beta1.ExitPolicyRule{Action: "accept", Address: "10.1.0.0/16", Ports: "443"}), "private network"),
+		Entry("loopback", exit(v1beta1.ExitPolicyRule{Action: "accept", Address: "[::1]", Ports: "443"}), "private network"),
+		Entry("IPv6 without ipv6Exit", exit(v1beta1.ExitPolicyRule{Action: "reject", Address: "*6", Ports: "*"}), "ipv6Exit"),
+		Entry("unbracketed IPv6", exit(v1beta1.ExitPolicyRule{Action: "reject", Address: "2001:db8::1", Ports: "*"}), "brackets"),
+		Entry("malformed address", exit(v1beta1.ExitPolicyRule{Action: "reject", Address: "example.com", Ports: "*"}), "invalid address"),
+		Entry("port out of range", exit(v1beta1.ExitPolicyRule{Action: "reject", Address: "*", Ports: "70000"}), "invalid port range"),
+		Entry("reversed range", exit(v1beta1.ExitPolicyRule{Action: "reject", Address: "*", Ports: "443-80"}), "invalid port range"),
+		Entry("missing abuse contact", &v1beta1.TorExitRelaySpec{}, "abuseContact"),
+		Entry("line break in the abuse contact", &v1beta1.TorExitRelaySpec{
+			AbuseContact: "abuse@example.com\nExitPolicy accept *:*",
+		}, "abuseContact contains control characters"),
+		Entry("line break in the contact info", &v1beta1.TorExitRelaySpec{
+			TorRelaySpec: v1beta1.TorRelaySpec{ContactInfo: "admin@example.com\r\nExitPolicy accept *:*"},
+			AbuseContact: "abuse@example.com",
+		}, "contactInfo contains control characters"),
+		Entry("line break in the address", &v1beta1.TorExitRelaySpec{
+			TorRelaySpec: v1beta1.TorRelaySpec{Address: "198.51.100.1\nExitPolicy accept *:*"},
+			AbuseContact: "abuse@example.com",
+		}, "address contains control characters"),
+		Entry("hostname nameserver", &v1beta1.TorExitRelaySpec{
+			AbuseContact: "abuse@example.com",
+			DNS:          &v1beta1.ExitDNS{Nameservers: []string{"dns.example.com"}},
+		}, "not an IP address"),
+	)
+
+	It("warns about policies accepting nothing", func() {
+		warnings, errs := ValidateExit(exit(v1beta1.ExitPolicyRule{Action: "reject", Address: "*", Ports: "*"}))
+		Expect(errs).To(BeEmpty())
+		Expect(warnings).To(HaveLen(1))
+	})
+})
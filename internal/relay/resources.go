@@ -0,0 +1,348 @@
+package relay
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/agent"
+	"github.com/fulviodenza/torproxy/internal/controllers/onionservice"
+)
+
+const (
+	// ContainerName of the tor container of the relay pod.
+	ContainerName = "tor"
+
+	configHashAnnotation = "tor.stack.io/config-hash"
+)
+
+// Relay describes the pod running a relay.
+type Relay struct {
+	Torrc string
+	// Ports are exposed according to Expose, the ORPort first.
+	Ports  []corev1.ContainerPort
+	Expose string
+
+	Image     string
+	Resources corev1.ResourceRequirements
+	Storage   *resource.Quantity
+	// Containers run next to tor, InitContainers before it, e.g. to
+	// provide pluggable transports.
+	Containers     []corev1.Container
+	InitContainers []corev1.Container
+	// Volumes of the pod, VolumeMounts of the tor container.
+	Volumes      []corev1.Volume
+	VolumeMounts []corev1.VolumeMount
+	// Files are added to the torrc ConfigMap and mounted in /etc/tor.
+	Files map[string]string
+	// Published are files of the data directory the agent publishes, by
+	// key, on top of the fingerprint.
+	Published map[string]string
+}
+
+// PodName is the single pod of the relay StatefulSet.
+func PodName(name string) string {
+	return name + "-0"
+}
+
+// Reconcile creates or updates the ConfigMap, StatefulSet and, in
+// LoadBalancer mode, Service running the relay on behalf of the owner. The
+// identity keys live in the StatefulSet volume and survive restarts. It
+// fails with agent.ErrConflict when one of them belongs to another object.
+func Reconcile(ctx context.Context, c client.Client, owner metav1.OwnerReference, namespace, name string, relay Relay) error {
+	if err := agent.Reconcile(ctx, c, owner, namespace, name); err != nil {
+		return err
+	}
+
+	if err := reconcileConfigMap(ctx, c, owner, namespace, name, relay); err != nil {
+		return err
+	}
+
+	if err := reconcileStatefulSet(ctx, c, owner, namespace, name, relay); err != nil {
+		return err
+	}
+
+	if relay.Expose == v1beta1.RelayExposeLoadBalancer {
+		return reconcileService(ctx, c, owner, namespace, name, relay.Ports)
+	}
+	return deleteService(ctx, c, owner, namespace, name)
+}
+
+// deleteService removes the LoadBalancer Service of the relay, if any, and
+// leaves alone the Services of the same name it does not control.
+func deleteService(ctx context.Context, c client.Client, owner metav1.OwnerReference, namespace, name string) error {
+	found := &corev1.Service{}
+	err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, found)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if agent.CheckController(found, "Service", owner) != nil {
+		return nil
+	}
+	return client.IgnoreNotFound(c.Delete(ctx, found))
+}
+
+func reconcileConfigMap(ctx context.Context, c client.Client, owner metav1.OwnerReference, namespace, name string, relay Relay) error {
+	data := map[string]string{
+		"torrc": relay.Torrc,
+	}
+	for file, content := range relay.Files {
+		data[file] = content
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name + "-torrc",
+			Namespace:       namespace,
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		Data: data,
+	}
+
+	found := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: cm.Name, Namespace: cm.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return c.Create(ctx, cm)
+	} else if err != nil {
+		return err
+	}
+	if err := agent.CheckController(found, "ConfigMap", owner); err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(found.Data, cm.Data) {
+		found.Data = cm.Data
+		return c.Update(ctx, found)
+	}
+
+	return nil
+}
+
+func reconcileStatefulSet(ctx context.Context, c client.Client, owner metav1.OwnerReference, namespace, name string, relay Relay) error {
+	image := relay.Image
+	if image == "" {
+		image = onionservice.TorDockerImage
+	}
+
+	storage := resource.MustParse("100Mi")
+	if relay.Storage != nil {
+		storage = *relay.Storage
+	}
+
+	ports := make([]corev1.ContainerPort, 0, len(relay.Ports))
+	for _, port := range relay.Ports {
+		if relay.Expose == v1beta1.RelayExposeHostPort {
+			port.HostPort = port.ContainerPort
+		}
+		ports = append(ports, port)
+	}
+
+	volumeMounts := []corev1.VolumeMount{
+		{
+			Name:      "torrc",
+			MountPath: "/etc/tor/torrc",
+			SubPath:   "torrc",
+		},
+		{
+			Name:      "data",
+			MountPath: DataDirectory,
+		},
+	}
+	volumeMounts = append(volumeMounts, relay.VolumeMounts...)
+	files := make([]string, 0, len(relay.Files))
+	for file := range relay.Files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	config := relay.Torrc
+	for _, file := range files {
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      "torrc",
+			MountPath: "/etc/tor/" + file,
+			SubPath:   file,
+		})
+		config += relay.Files[file]
+	}
+
+	published := map[string]string{
+		fingerprintKey: filepath.Join(DataDirectory, "fingerprint"),
+	}
+	for key, path := range relay.Published {
+		published[key] = path
+	}
+
+	torUID := int64(101)
+	replicas := int32(1)
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       namespace,
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    &replicas,
+			ServiceName: name,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": name,
+				},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app": name,
+					},
+					// tor does not reload the mounted torrc, restart the
+					// relay whenever it changes.
+					Annotations: map[string]string{
+						configHashAnnotation: fmt.Sprintf("%x", sha256.Sum256([]byte(config))),
+					},
+				},
+				Spec: corev1.PodSpec{
+					SecurityContext: &corev1.PodSecurityContext{
+						FSGroup: &torUID,
+					},
+					Containers: append([]corev1.Container{
+						{
+							Name:  ContainerName,
+							Image: image,
+							Command: []string{
+								"sh",
+								"-c",
+								"tor -f /etc/tor/torrc",
+							},
+							Ports:        ports,
+							Resources:    relay.Resources,
+							VolumeMounts: volumeMounts,
+							SecurityContext: &corev1.SecurityContext{
+								RunAsUser:  &torUID,
+								RunAsGroup: &torUID,
+							},
+						},
+						agent.Container(name, published, nil, []corev1.VolumeMount{
+							{
+								Name:      "data",
+								MountPath: DataDirectory,
+							},
+						}),
+					}, relay.Containers...),
+					InitContainers:     relay.InitContainers,
+					ServiceAccountName: agent.ServiceAccountName(name),
+					Volumes: append([]corev1.Volume{
+						{
+							Name: "torrc",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: name + "-torrc",
+									},
+								},
+							},
+						},
+					}, relay.Volumes...),
+				},
+			},
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "data",
+					},
+					Spec: corev1.PersistentVolumeClaimSpec{
+						AccessModes: []corev1.PersistentVolumeAccessMode{
+							corev1.ReadWriteOnce,
+						},
+						Resources: corev1.VolumeResourceRequirements{
+							Requests: corev1.ResourceList{
+								corev1.ResourceStorage: storage,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	found := &appsv1.StatefulSet{}
+	err := c.Get(ctx, types.NamespacedName{Name: statefulSet.Name, Namespace: statefulSet.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return c.Create(ctx, statefulSet)
+	} else if err != nil {
+		return err
+	}
+	if err := agent.CheckController(found, "StatefulSet", owner); err != nil {
+		return err
+	}
+
+	// VolumeClaimTemplates are immutable, the storage size only applies to
+	// new relays.
+	found.Spec.Template = statefulSet.Spec.Template
+	return c.Update(ctx, found)
+}
+
+func reconcileService(ctx context.Context, c client.Client, owner metav1.OwnerReference, namespace, name string, ports []corev1.ContainerPort) error {
+	servicePorts := []corev1.ServicePort{}
+	for _, port := range ports {
+		servicePorts = append(servicePorts, corev1.ServicePort{
+			Name:       port.Name,
+			Port:       port.ContainerPort,
+			Protocol:   corev1.ProtocolTCP,
+			TargetPort: intstr.FromInt32(port.ContainerPort),
+		})
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       namespace,
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeLoadBalancer,
+			Selector: map[string]string{
+				"app": name,
+			},
+			Ports: servicePorts,
+		},
+	}
+
+	found := &corev1.Service{}
+	err := c.Get(ctx, types.NamespacedName{Name: service.Name, Namespace: service.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return c.Create(ctx, service)
+	} else if err != nil {
+		return err
+	}
+	if err := agent.CheckController(found, "Service", owner); err != nil {
+		return err
+	}
+
+	// Keep the node ports allocated to the LoadBalancer.
+	for i := range service.Spec.Ports {
+		for _, port := range found.Spec.Ports {
+			if port.Name == service.Spec.Ports[i].Name {
+				service.Spec.Ports[i].NodePort = port.NodePort
+			}
+		}
+	}
+
+	if !reflect.DeepEqual(found.Spec.Ports, service.Spec.Ports) || found.Spec.Type != service.Spec.Type {
+		found.Spec.Ports = service.Spec.Ports
+		found.Spec.Type = service.Spec.Type
+		return c.Update(ctx, found)
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package relay
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/agent"
+)
+
+var _ = Describe("relay resources", func() {
+	It("does not overwrite the resources of another object of the same name", func() {
+		ctx := context.Background()
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		Expect(rbacv1.AddToScheme(scheme)).To(Succeed())
+		Expect(v1beta1.AddToScheme(scheme)).To(Succeed())
+
+		onion := &v1beta1.OnionService{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "onion-uid"}}
+		torrc := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web-torrc",
+				Namespace: "default",
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(onion, v1beta1.GroupVersion.WithKind("OnionService")),
+				},
+			},
+			Data: map[string]string{"torrc": "HiddenServiceDir /var/lib/tor/hidden_service\n"},
+		}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(torrc).Build()
+
+		torRelay := &v1beta1.TorRelay{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "relay-uid"}}
+		owner := *metav1.NewControllerRef(torRelay, v1beta1.GroupVersion.WithKind("TorRelay"))
+		err := Reconcile(ctx, c, owner, "default", "web", Relay{Torrc: "ORPort 9001\n"})
+		Expect(agent.IsConflict(err)).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("ConfigMap web-torrc is controlled by OnionService web")))
+
+		found := &corev1.ConfigMap{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "web-torrc", Namespace: "default"}, found)).To(Succeed())
+		Expect(found.Data).To(Equal(torrc.Data))
+		err = c.Get(ctx, types.NamespacedName{Name: "web", Namespace: "default"}, &appsv1.StatefulSet{})
+		Expect(err).To(HaveOccurred())
+	})
+})
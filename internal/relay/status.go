@@ -0,0 +1,95 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/agent"
+)
+
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+
+// PublicAddress is the address the relay is reachable at, the LoadBalancer
+// ingress or the external IP of the node running the relay. It is empty
+// until known.
+func PublicAddress(ctx context.Context, c client.Client, namespace, name, expose string) (string, error) {
+	if expose == v1beta1.RelayExposeLoadBalancer {
+		service := &corev1.Service{}
+		if err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, service); err != nil {
+			return "", client.IgnoreNotFound(err)
+		}
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				return ingress.IP, nil
+			}
+			if ingress.Hostname != "" {
+				return ingress.Hostname, nil
+			}
+		}
+		return "", nil
+	}
+
+	pod := &corev1.Pod{}
+	if err := c.Get(ctx, types.NamespacedName{Name: PodName(name), Namespace: namespace}, pod); err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	if pod.Spec.NodeName == "" {
+		return "", nil
+	}
+
+	node := &corev1.Node{}
+	if err := c.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, node); err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeExternalIP {
+			return address.Address, nil
+		}
+	}
+	return "", nil
+}
+
+// Running reports whether the relay pod is running.
+func Running(ctx context.Context, c client.Client, namespace, name string) (bool, error) {
+	pod := &corev1.Pod{}
+	if err := c.Get(ctx, types.NamespacedName{Name: PodName(name), Namespace: namespace}, pod); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return pod.Status.Phase == corev1.PodRunning, nil
+}
+
+// fingerprintKey is the key the agent publishes the fingerprint file of
+// tor with.
+const fingerprintKey = "fingerprint"
+
+// Fingerprint returns the identity fingerprint written by tor in its data
+// directory, the file holds "<nickname> <fingerprint>". It is empty until
+// published by the agent.
+func Fingerprint(ctx context.Context, c client.Client, namespace, name string) (string, error) {
+	content, err := Published(ctx, c, namespace, name, fingerprintKey)
+	if err != nil || content == "" {
+		return "", err
+	}
+
+	fields := strings.Fields(content)
+	if len(fields) != 2 {
+		return "", fmt.Errorf("malformed fingerprint file %q", content)
+	}
+	return fields[1], nil
+}
+
+// Published returns the file published under key by the agent of the
+// relay pod, see Relay.Published. It is empty until published.
+func Published(ctx context.Context, c client.Client, namespace, name, key string) (string, error) {
+	return agent.Published(ctx, c, namespace, name, PodName(name), key)
+}
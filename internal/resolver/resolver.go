@@ -0,0 +1,210 @@
+// Package resolver splits the DNS queries of the pods in transparent mode:
+// cluster names go to the cluster DNS, every other name to the DNSPort of
+// tor, so that public names are never resolved in clear.
+package resolver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// headerSize of a DNS message, the question follows.
+const headerSize = 12
+
+// Forwarder relays DNS queries to the upstream serving their name.
+type Forwarder struct {
+	// ClusterDomain, such as cluster.local, and its subdomains are
+	// resolved by ClusterDNS.
+	ClusterDomain string
+	// ClusterDNS and Tor are the host:port of the upstreams.
+	ClusterDNS string
+	Tor        string
+	Timeout    time.Duration
+}
+
+// QuestionName is the lower cased name of the first question of the DNS
+// message, without the trailing dot.
+func QuestionName(msg []byte) (string, error) {
+	if len(msg) < headerSize || binary.BigEndian.Uint16(msg[4:6]) == 0 {
+		return "", errors.New("no question in the DNS message")
+	}
+
+	labels := []string{}
+	for offset := headerSize; ; {
+		if offset >= len(msg) {
+			return "", errors.New("truncated question name")
+		}
+		size := int(msg[offset])
+		offset++
+		if size == 0 {
+			break
+		}
+		// Queries carry no compression pointers.
+		if size&0xC0 != 0 || offset+size > len(msg) {
+			return "", errors.New("malformed question name")
+		}
+		labels = append(labels, strings.ToLower(string(msg[offset:offset+size])))
+		offset += size
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// Cluster reports whether the name belongs to the cluster domain.
+func (f *Forwarder) Cluster(name string) bool {
+	domain := strings.ToLower(strings.TrimSuffix(f.ClusterDomain, "."))
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+// upstream is the address the query is forwarded to.
+func (f *Forwarder) upstream(query []byte) (string, bool, error) {
+	name, err := QuestionName(query)
+	if err != nil {
+		return "", false, err
+	}
+	if f.Cluster(name) {
+		return f.ClusterDNS, true, nil
+	}
+	return f.Tor, false, nil
+}
+
+// Exchange forwards the query over UDP and returns the answer.
+func (f *Forwarder) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	address, _, err := f.upstream(query)
+	if err != nil {
+		return nil, err
+	}
+	return f.exchange(ctx, "udp", address, query)
+}
+
+func (f *Forwarder) exchange(ctx context.Context, network, address string, query []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.Timeout)
+	defer cancel()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if network == "tcp" {
+		return exchangeStream(conn, query)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	answer := make([]byte, 65535)
+	n, err := conn.Read(answer)
+	if err != nil {
+		return nil, err
+	}
+	return answer[:n], nil
+}
+
+// exchangeStream writes and reads length prefixed messages, as DNS does
+// over TCP.
+func exchangeStream(conn net.Conn, query []byte) ([]byte, error) {
+	if err := writeStream(conn, query); err != nil {
+		return nil, err
+	}
+	return readStream(conn)
+}
+
+func writeStream(w io.Writer, msg []byte) error {
+	_, err := w.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...))
+	return err
+}
+
+func readStream(r io.Reader) ([]byte, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(size[:]))
+	_, err := io.ReadFull(r, msg)
+	return msg, err
+}
+
+// ServeUDP answers the queries received on conn until ctx is done.
+// Queries which cannot be parsed or forwarded are dropped, the client
+// retries or fails.
+func (f *Forwarder) ServeUDP(ctx context.Context, conn net.PacketConn, logf func(string, ...any)) error {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buffer := make([]byte, 65535)
+	for {
+		n, client, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		query := append([]byte{}, buffer[:n]...)
+		go func() {
+			answer, err := f.Exchange(ctx, query)
+			if err != nil {
+				logf("forwarding a query: %v", err)
+				return
+			}
+			_, _ = conn.WriteTo(answer, client)
+		}()
+	}
+}
+
+// ServeTCP answers the queries of the connections accepted on listener
+// until ctx is done. Cluster names are forwarded over TCP, for the answers
+// too large for UDP, the DNSPort of tor only speaks UDP.
+func (f *Forwarder) ServeTCP(ctx context.Context, listener net.Listener, logf func(string, ...any)) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				_ = conn.SetDeadline(time.Now().Add(f.Timeout))
+				query, err := readStream(conn)
+				if err != nil {
+					return
+				}
+				address, cluster, err := f.upstream(query)
+				if err != nil {
+					logf("forwarding a query: %v", err)
+					return
+				}
+				network := "udp"
+				if cluster {
+					network = "tcp"
+				}
+				answer, err := f.exchange(ctx, network, address, query)
+				if err != nil {
+					logf("forwarding a query: %v", err)
+					return
+				}
+				if err := writeStream(conn, answer); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
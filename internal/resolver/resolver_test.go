@@ -0,0 +1,130 @@
+package resolver
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestResolver(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Resolver Suite")
+}
+
+// query builds an A query for the name.
+func query(name string) []byte {
+	msg := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0, 0, 1, 0, 1)
+}
+
+// upstream answers every UDP query with its tag appended.
+func upstream(tag string) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	DeferCleanup(conn.Close)
+
+	go func() {
+		buffer := make([]byte, 512)
+		for {
+			n, client, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(append(buffer[:n:n], tag...), client)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+var _ = Describe("split resolver", func() {
+	It("reads the name of the question", func() {
+		name, err := QuestionName(query("Web.Default.svc.cluster.local"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(name).To(Equal("web.default.svc.cluster.local"))
+
+		_, err = QuestionName([]byte{0, 1})
+		Expect(err).To(HaveOccurred())
+
+		truncated := query("example.com")
+		_, err = QuestionName(truncated[:16])
+		Expect(err).To(HaveOccurred())
+
+		pointer := query("example.com")
+		pointer[headerSize] = 0xC0
+		_, err = QuestionName(pointer)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("sends cluster names to the cluster DNS and the others to tor", func() {
+		forwarder := &Forwarder{
+			ClusterDomain: "cluster.local",
+			ClusterDNS:    upstream("cluster"),
+			Tor:           upstream("tor"),
+			Timeout:       time.Second,
+		}
+
+		for name, tag := range map[string]string{
+			"web.default.svc.cluster.local": "cluster",
+			"cluster.local":                 "cluster",
+			"example.com":                   "tor",
+			"cluster.local.example.com":     "tor",
+			"duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion": "tor",
+		} {
+			answer, err := forwarder.Exchange(context.Background(), query(name))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(answer)).To(HaveSuffix(tag), name)
+		}
+	})
+
+	It("answers over UDP and TCP", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+
+		forwarder := &Forwarder{
+			ClusterDomain: "cluster.local",
+			ClusterDNS:    upstream("cluster"),
+			Tor:           upstream("tor"),
+			Timeout:       time.Second,
+		}
+
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		go func() { _ = forwarder.ServeUDP(ctx, conn, GinkgoWriter.Printf) }()
+
+		client, err := net.Dial("udp", conn.LocalAddr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer client.Close()
+		_, err = client.Write(query("example.com"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(client.SetDeadline(time.Now().Add(time.Second))).To(Succeed())
+		answer := make([]byte, 512)
+		n, err := client.Read(answer)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(answer[:n])).To(HaveSuffix("tor"))
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		go func() { _ = forwarder.ServeTCP(ctx, listener, GinkgoWriter.Printf) }()
+
+		stream, err := net.Dial("tcp", listener.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer stream.Close()
+		msg := query("example.com")
+		_, err = stream.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stream.SetDeadline(time.Now().Add(time.Second))).To(Succeed())
+		answer, err = readStream(stream)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(answer)).To(HaveSuffix("tor"))
+	})
+})
@@ -0,0 +1,191 @@
+// Package torcontrol is a minimal client of the control port of tor.
+package torcontrol
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Conn is a connection to the control port of tor, see control-spec.
+type Conn struct {
+	conn net.Conn
+	text *textproto.Conn
+}
+
+// Dial connects to the control port at address.
+func Dial(ctx context.Context, address string) (*Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return &Conn{
+		conn: conn,
+		text: textproto.NewConn(conn),
+	}, nil
+}
+
+// SetDeadline replaces the deadline taken from the context of Dial, the
+// zero time waits forever, e.g. for events.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *Conn) Close() error {
+	return c.text.Close()
+}
+
+// AuthenticateCookie authenticates with the content of the cookie file
+// written by tor (CookieAuthentication 1).
+func (c *Conn) AuthenticateCookie(cookieFile string) error {
+	cookie, err := os.ReadFile(cookieFile)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.command("AUTHENTICATE " + hex.EncodeToString(cookie))
+	return err
+}
+
+// GetInfo returns the values of the given GETINFO keys.
+func (c *Conn) GetInfo(keys ...string) (map[string]string, error) {
+	lines, err := c.command("GETINFO " + strings.Join(keys, " "))
+	if err != nil {
+		return nil, err
+	}
+
+	info := map[string]string{}
+	for _, line := range lines {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		info[key] = value
+	}
+	return info, nil
+}
+
+// SetEvents subscribes to the given asynchronous events, read with
+// ReadEvent.
+func (c *Conn) SetEvents(events ...string) error {
+	_, err := c.command("SETEVENTS " + strings.Join(events, " "))
+	return err
+}
+
+// ReadEvent blocks until the next asynchronous event and returns it
+// without its status code, e.g. "HS_DESC UPLOADED <address> ...". Only
+// single line events are returned.
+func (c *Conn) ReadEvent() (string, error) {
+	for {
+		line, err := readLine(c.text.R)
+		if err != nil {
+			return "", err
+		}
+		if event, ok := strings.CutPrefix(line, "650 "); ok {
+			return event, nil
+		}
+	}
+}
+
+// ResetConf resets the given options to their defaults, e.g.
+// HiddenServiceDir removes every onion service.
+func (c *Conn) ResetConf(keys ...string) error {
+	_, err := c.command("RESETCONF " + strings.Join(keys, " "))
+	return err
+}
+
+// Signal sends a signal to tor, e.g. RELOAD to read the torrc again.
+func (c *Conn) Signal(signal string) error {
+	_, err := c.command("SIGNAL " + signal)
+	return err
+}
+
+// command sends a command and returns the lines of its reply. Multi-line
+// values ("250+key=") are joined with newlines, after the key.
+func (c *Conn) command(command string) ([]string, error) {
+	if err := c.text.PrintfLine("%s", command); err != nil {
+		return nil, err
+	}
+	return readReply(c.text.R)
+}
+
+func readReply(r *bufio.Reader) ([]string, error) {
+	lines := []string{}
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) < 4 {
+			return nil, fmt.Errorf("malformed reply line %q", line)
+		}
+
+		status, separator, text := line[:3], line[3], line[4:]
+		if status != "250" {
+			return nil, fmt.Errorf("tor replied %s", line)
+		}
+
+		switch separator {
+		case ' ':
+			// The final line, "250 OK" for commands without data.
+			if text != "OK" {
+				lines = append(lines, text)
+			}
+			return lines, nil
+		case '-':
+			lines = append(lines, text)
+		case '+':
+			data := []string{}
+			for {
+				dataLine, err := readLine(r)
+				if err != nil {
+					return nil, err
+				}
+				if dataLine == "." {
+					break
+				}
+				data = append(data, strings.TrimPrefix(dataLine, "."))
+			}
+			lines = append(lines, strings.TrimSuffix(text, "=")+"="+strings.Join(data, "\n"))
+		default:
+			return nil, fmt.Errorf("malformed reply line %q", line)
+		}
+	}
+}
+
+// BootstrapProgress parses the PROGRESS of a bootstrap status event, e.g.
+// NOTICE BOOTSTRAP PROGRESS=100 TAG=done SUMMARY="Done".
+func BootstrapProgress(phase string) (int, bool) {
+	for _, field := range strings.Fields(phase) {
+		value, ok := strings.CutPrefix(field, "PROGRESS=")
+		if !ok {
+			continue
+		}
+		progress, err := strconv.Atoi(value)
+		return progress, err == nil
+	}
+	return 0, false
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
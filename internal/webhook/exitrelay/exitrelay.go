@@ -0,0 +1,50 @@
+package exitrelay
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/relay"
+)
+
+// +kubebuilder:webhook:path=/validate-tor-stack-io-v1beta1-torexitrelay,mutating=false,failurePolicy=fail,sideEffects=None,groups=tor.stack.io,resources=torexitrelays,verbs=create;update,versions=v1beta1,name=vtorexitrelay.tor.stack.io,admissionReviewVersions=v1
+
+// Validator refuses TorExitRelays with malformed or dangerous exit
+// policies, see relay.ValidateExit.
+type Validator struct{}
+
+// SetupWithManager registers the validator with the webhook server.
+func SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&v1beta1.TorExitRelay{}).
+		WithValidator(&Validator{}).
+		Complete()
+}
+
+func (v *Validator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return validate(obj)
+}
+
+func (v *Validator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	return validate(newObj)
+}
+
+func (v *Validator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func validate(obj runtime.Object) (admission.Warnings, error) {
+	exitRelay, ok := obj.(*v1beta1.TorExitRelay)
+	if !ok {
+		return nil, fmt.Errorf("expected a TorExitRelay, got %T", obj)
+	}
+
+	warnings, errs := relay.ValidateExit(&exitRelay.Spec)
+	return warnings, utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,150 @@
+package sidecar
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/controllers/onionservice"
+)
+
+// +kubebuilder:rbac:groups=tor.stack.io,resources=toregresspolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=tor.stack.io,resources=torproxies,verbs=get;list;watch
+
+// egressPolicy returns the TorEgressPolicy selecting the pod, the first by
+// name when several of them do.
+func (i *Injector) egressPolicy(ctx context.Context, namespace string, pod *corev1.Pod) (*v1beta1.TorEgressPolicy, error) {
+	policyList := &v1beta1.TorEgressPolicyList{}
+	if err := i.Client.List(ctx, policyList, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(policyList.Items, func(a, b v1beta1.TorEgressPolicy) int {
+		if a.Name < b.Name {
+			return -1
+		}
+		return 1
+	})
+
+	for _, policy := range policyList.Items {
+		if !policy.DeletionTimestamp.IsZero() {
+			continue
+		}
+		selected, err := Selects(&policy, pod)
+		if err != nil {
+			return nil, err
+		}
+		if selected {
+			return &policy, nil
+		}
+	}
+	return nil, nil
+}
+
+// PodSelector is the policy selector, leaving out the TorProxy pods when
+// they live in the same namespace.
+func PodSelector(policy *v1beta1.TorEgressPolicy) metav1.LabelSelector {
+	selector := *policy.Spec.PodSelector.DeepCopy()
+
+	key, ok := TorProxyKey(policy)
+	if policy.Spec.Mode == v1beta1.EgressModeGateway && ok && key.Namespace == policy.Namespace {
+		selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      "app",
+			Operator: metav1.LabelSelectorOpNotIn,
+			Values:   []string{key.Name},
+		})
+	}
+	return selector
+}
+
+// Selects reports whether the policy applies to the pod.
+func Selects(policy *v1beta1.TorEgressPolicy, pod *corev1.Pod) (bool, error) {
+	labelSelector := PodSelector(policy)
+	selector, err := metav1.LabelSelectorAsSelector(&labelSelector)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(pod.Labels)), nil
+}
+
+// TorProxyKey is the TorProxy referenced by the policy.
+func TorProxyKey(policy *v1beta1.TorEgressPolicy) (types.NamespacedName, bool) {
+	ref := policy.Spec.TorProxy
+	if ref == nil {
+		return types.NamespacedName{}, false
+	}
+
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = policy.Namespace
+	}
+	return types.NamespacedName{Name: ref.Name, Namespace: namespace}, true
+}
+
+func (i *Injector) torProxy(ctx context.Context, policy *v1beta1.TorEgressPolicy) (*v1beta1.TorProxy, error) {
+	key, ok := TorProxyKey(policy)
+	if !ok {
+		return nil, nil
+	}
+
+	torProxy := &v1beta1.TorProxy{}
+	if err := i.Client.Get(ctx, key, torProxy); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return torProxy, nil
+}
+
+// proxyURLs are the HTTP tunnel, if any, and SOCKS URLs of the TorProxy
+// Service.
+func proxyURLs(torProxy *v1beta1.TorProxy) (httpProxy, allProxy string) {
+	host := fmt.Sprintf("%s.%s.svc.%s", torProxy.Name, torProxy.Namespace, onionservice.ClusterDomain)
+
+	socksPort := int32(SOCKSPort)
+	if torProxy.Spec.SOCKS != nil {
+		socksPort = torProxy.Spec.SOCKS.Port
+	}
+	if torProxy.Spec.HTTPTunnel != nil {
+		httpProxy = fmt.Sprintf("http://%s:%d", host, torProxy.Spec.HTTPTunnel.Port)
+	}
+	return httpProxy, fmt.Sprintf("socks5h://%s:%d", host, socksPort)
+}
+
+// Wire points the containers of the pod not excluded through the annotation
+// to the TorProxy Service.
+func Wire(pod *corev1.Pod, torProxy *v1beta1.TorProxy) {
+	httpProxy, allProxy := proxyURLs(torProxy)
+
+	excluded := excludedContainers(pod)
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		if slices.Contains(excluded, container.Name) {
+			continue
+		}
+		container.Env = append(container.Env, proxyEnv(httpProxy, allProxy)...)
+	}
+}
+
+// Wired reports whether every container of the pod is pointed to the
+// TorProxy, as done by the webhook for policies in Gateway mode.
+func Wired(pod *corev1.Pod, torProxy *v1beta1.TorProxy) bool {
+	_, allProxy := proxyURLs(torProxy)
+	for _, container := range pod.Spec.Containers {
+		if !slices.ContainsFunc(container.Env, func(env corev1.EnvVar) bool {
+			return env.Name == "ALL_PROXY" && env.Value == allProxy
+		}) {
+			return false
+		}
+	}
+	return true
+}
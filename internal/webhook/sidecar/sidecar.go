@@ -0,0 +1,413 @@
+package sidecar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/controllers/onionservice"
+)
+
+const (
+	// Path the injector is served at by the webhook server.
+	Path = "/mutate-v1-pod"
+
+	// ContainerName of the injected tor container.
+	ContainerName = "tor"
+	// ConfigMapName is the torrc shared by every injected pod of a namespace.
+	ConfigMapName = "tor-sidecar-torrc"
+
+	SOCKSPort      = 9050
+	HTTPTunnelPort = 8118
+
+	// torUID runs the tor container, its traffic is the only one allowed
+	// out of the pod in transparent mode.
+	torUID = 101
+)
+
+// Image of the injected tor container.
+var Image = onionservice.TorDockerImage
+
+// +kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,failurePolicy=fail,sideEffects=NoneOnDryRun,groups="",resources=pods,verbs=create,versions=v1,name=mpod.tor.stack.io,admissionReviewVersions=v1
+// +kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,failurePolicy=fail,sideEffects=NoneOnDryRun,groups="",resources=pods,verbs=create,versions=v1,name=mpod-egress.tor.stack.io,admissionReviewVersions=v1
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+
+// Injector adds a tor client sidecar to the pods labelled with
+// v1beta1.TorLabel and sets the proxy env vars of their containers. Pods
+// selected by a TorEgressPolicy are configured according to its mode.
+type Injector struct {
+	Client  client.Client
+	Decoder admission.Decoder
+}
+
+func (i *Injector) Handle(ctx context.Context, req admission.Request) admission.Response {
+	pod := &corev1.Pod{}
+	if err := i.Decoder.Decode(req, pod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if response := i.mutate(ctx, req, pod); response != nil {
+		return *response
+	}
+
+	marshaled, err := json.Marshal(pod)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// mutate configures the pod in place. It returns the response when the pod
+// is to be admitted as is, or refused.
+func (i *Injector) mutate(ctx context.Context, req admission.Request, pod *corev1.Pod) *admission.Response {
+	log := log.FromContext(ctx)
+
+	policy, err := i.egressPolicy(ctx, req.Namespace, pod)
+	if err != nil {
+		return ptr.To(admission.Errored(http.StatusInternalServerError, err))
+	}
+
+	if policy == nil {
+		if _, ok := pod.Annotations[v1beta1.SidecarInjectedAnnotation]; ok {
+			return ptr.To(admission.Allowed("tor sidecar already injected"))
+		}
+	} else {
+		// Anyone creating pods can set the annotations and the containers,
+		// the policy is applied from scratch, again when both webhooks
+		// call the injector.
+		Reset(pod)
+		pod.Annotations[v1beta1.EgressPolicyAnnotation] = policy.Name
+		if policy.Spec.Mode == v1beta1.EgressModeSidecar {
+			if reason := bypassReason(pod); reason != "" {
+				return ptr.To(admission.Denied(fmt.Sprintf("tor egress policy %s: %s", policy.Name, reason)))
+			}
+		}
+	}
+
+	switch {
+	case policy != nil && policy.Spec.Mode == v1beta1.EgressModeGateway:
+		torProxy, err := i.torProxy(ctx, policy)
+		if err != nil {
+			return ptr.To(admission.Errored(http.StatusInternalServerError, err))
+		}
+		if torProxy == nil {
+			// Direct egress is blocked anyway, the pod is reported as non
+			// compliant in the policy status.
+			log.Info("TorProxy of the egress policy not found", "policy", policy.Name)
+			break
+		}
+		Wire(pod, torProxy)
+	case policy != nil || Enabled(pod):
+		if policy != nil {
+			pod.Annotations[v1beta1.SidecarTransparentAnnotation] = "true"
+		}
+		if Transparent(pod) {
+			// Guessed networks would let traffic bypass tor, or break the
+			// cluster ones.
+			if err := CheckTransparent(); err != nil {
+				return ptr.To(admission.Denied(fmt.Sprintf("tor transparent mode: %v", err)))
+			}
+		}
+		if req.DryRun == nil || !*req.DryRun {
+			if err := i.reconcileConfigMap(ctx, req.Namespace); err != nil {
+				log.Error(err, "failed to reconcile the tor sidecar torrc", "namespace", req.Namespace)
+				return ptr.To(admission.Errored(http.StatusInternalServerError, err))
+			}
+		}
+		Inject(pod)
+	default:
+		return ptr.To(admission.Allowed("tor sidecar not requested"))
+	}
+
+	return nil
+}
+
+// Enabled reports whether the pod asks for the tor sidecar.
+func Enabled(pod *corev1.Pod) bool {
+	return pod.Labels[v1beta1.TorLabel] == v1beta1.TorLabelHideMe
+}
+
+// Inject adds the tor container and its torrc volume to the pod and points
+// every container not excluded through the annotation to it. Pods in
+// transparent mode also get the init container redirecting their traffic.
+func Inject(pod *corev1.Pod) {
+	excluded := excludedContainers(pod)
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		if slices.Contains(excluded, container.Name) {
+			continue
+		}
+		container.Env = append(container.Env, proxyEnv(
+			fmt.Sprintf("http://127.0.0.1:%d", HTTPTunnelPort),
+			fmt.Sprintf("socks5h://127.0.0.1:%d", SOCKSPort),
+		)...)
+	}
+
+	pod.Spec.Containers = append(pod.Spec.Containers, container())
+	if Transparent(pod) {
+		injectTransparent(pod)
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes,
+		corev1.Volume{
+			Name: "tor-sidecar-torrc",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: ConfigMapName,
+					},
+				},
+			},
+		},
+		corev1.Volume{
+			Name: "tor-sidecar-data",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		},
+	)
+
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[v1beta1.SidecarInjectedAnnotation] = "true"
+}
+
+// sidecarAnnotations tune the injection, they are dropped when a policy
+// applies.
+var sidecarAnnotations = []string{
+	v1beta1.SidecarInjectedAnnotation,
+	v1beta1.SidecarTransparentAnnotation,
+	v1beta1.SidecarExcludeContainersAnnotation,
+	v1beta1.SidecarExcludeCIDRsAnnotation,
+	v1beta1.EgressPolicyAnnotation,
+}
+
+// Reset removes the sidecar annotations, containers, volumes and proxy env
+// vars from the pod, whoever set them.
+func Reset(pod *corev1.Pod) {
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	for _, annotation := range sidecarAnnotations {
+		delete(pod.Annotations, annotation)
+	}
+
+	pod.Spec.InitContainers = slices.DeleteFunc(pod.Spec.InitContainers, func(c corev1.Container) bool {
+		return c.Name == InitContainerName
+	})
+	pod.Spec.Containers = slices.DeleteFunc(pod.Spec.Containers, func(c corev1.Container) bool {
+		return c.Name == ContainerName || c.Name == DNSContainerName
+	})
+	pod.Spec.Volumes = slices.DeleteFunc(pod.Spec.Volumes, func(v corev1.Volume) bool {
+		return v.Name == "tor-sidecar-torrc" || v.Name == "tor-sidecar-data"
+	})
+
+	names := []string{}
+	for _, env := range proxyEnv("http://", "socks5h://") {
+		names = append(names, env.Name)
+	}
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		container.Env = slices.DeleteFunc(container.Env, func(env corev1.EnvVar) bool {
+			return slices.Contains(names, env.Name)
+		})
+	}
+}
+
+// bypassReason tells why a container could get around the redirection of
+// the sidecar: running as the tor or the DNS user, whose traffic is let
+// through, or being able to change the iptables rules. Empty when none can.
+func bypassReason(pod *corev1.Pod) string {
+	podUser := int64(-1)
+	if pod.Spec.SecurityContext != nil && pod.Spec.SecurityContext.RunAsUser != nil {
+		podUser = *pod.Spec.SecurityContext.RunAsUser
+	}
+
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		if c.Name == ContainerName || c.Name == DNSContainerName || c.Name == InitContainerName {
+			// Checked by NonCompliantReason, removed by Reset.
+			continue
+		}
+		context := c.SecurityContext
+		if context == nil {
+			context = &corev1.SecurityContext{}
+		}
+		switch {
+		case context.RunAsUser != nil && *context.RunAsUser == torUID, context.RunAsUser == nil && podUser == torUID:
+			return fmt.Sprintf("container %s runs as the tor user %d", c.Name, torUID)
+		case context.RunAsUser != nil && *context.RunAsUser == dnsUID, context.RunAsUser == nil && podUser == dnsUID:
+			return fmt.Sprintf("container %s runs as the DNS user %d", c.Name, dnsUID)
+		case context.Privileged != nil && *context.Privileged:
+			return fmt.Sprintf("container %s is privileged", c.Name)
+		case context.Capabilities != nil &&
+			(slices.Contains(context.Capabilities.Add, "NET_ADMIN") || slices.Contains(context.Capabilities.Add, "ALL")):
+			return fmt.Sprintf("container %s may change the network configuration", c.Name)
+		}
+	}
+	return ""
+}
+
+// NonCompliantReason explains why the pod does not carry the transparent
+// sidecar injected for TorEgressPolicies in Sidecar mode, it is empty when
+// it does. The containers are checked, annotations can be set by anyone.
+func NonCompliantReason(pod *corev1.Pod) string {
+	want := container()
+	i := slices.IndexFunc(pod.Spec.Containers, func(c corev1.Container) bool { return c.Name == ContainerName })
+	if i < 0 {
+		return "SidecarNotInjected"
+	}
+	tor := pod.Spec.Containers[i]
+	if tor.Image != want.Image || !slices.Equal(tor.Command, want.Command) ||
+		tor.SecurityContext == nil || tor.SecurityContext.RunAsUser == nil || *tor.SecurityContext.RunAsUser != torUID {
+		return "SidecarNotInjected"
+	}
+
+	wantInit := initContainer(ClusterCIDRs, ClusterDNS)
+	if len(pod.Spec.InitContainers) == 0 {
+		return "SidecarNotTransparent"
+	}
+	init := pod.Spec.InitContainers[0]
+	if init.Name != wantInit.Name || init.Image != wantInit.Image || !slices.Equal(init.Command, wantInit.Command) {
+		return "SidecarNotTransparent"
+	}
+	wantDNS := dnsContainer(ClusterDNS)
+	i = slices.IndexFunc(pod.Spec.Containers, func(c corev1.Container) bool { return c.Name == DNSContainerName })
+	if i < 0 {
+		return "SidecarNotTransparent"
+	}
+	dns := pod.Spec.Containers[i]
+	if dns.Image != wantDNS.Image || !slices.Equal(dns.Command, wantDNS.Command) ||
+		dns.SecurityContext == nil || dns.SecurityContext.RunAsUser == nil || *dns.SecurityContext.RunAsUser != dnsUID {
+		return "SidecarNotTransparent"
+	}
+
+	if bypassReason(pod) != "" {
+		return "SidecarBypassed"
+	}
+	return ""
+}
+
+func excludedContainers(pod *corev1.Pod) []string {
+	excluded := []string{}
+	for _, name := range strings.Split(pod.Annotations[v1beta1.SidecarExcludeContainersAnnotation], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			excluded = append(excluded, name)
+		}
+	}
+	return excluded
+}
+
+// proxyEnv points HTTP clients to the HTTP tunnel, when there is one, and
+// everything else to the SOCKS port, resolving names through tor. Cluster
+// names cannot be resolved by tor and are left out.
+func proxyEnv(httpProxy, allProxy string) []corev1.EnvVar {
+	noProxy := "localhost,127.0.0.1,.svc,.cluster.local"
+
+	env := []corev1.EnvVar{}
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY"} {
+		if httpProxy == "" {
+			break
+		}
+		env = append(env,
+			corev1.EnvVar{Name: name, Value: httpProxy},
+			corev1.EnvVar{Name: strings.ToLower(name), Value: httpProxy},
+		)
+	}
+	return append(env,
+		corev1.EnvVar{Name: "ALL_PROXY", Value: allProxy},
+		corev1.EnvVar{Name: "all_proxy", Value: allProxy},
+		corev1.EnvVar{Name: "NO_PROXY", Value: noProxy},
+		corev1.EnvVar{Name: "no_proxy", Value: noProxy},
+	)
+}
+
+func container() corev1.Container {
+	return corev1.Container{
+		Name:  ContainerName,
+		Image: Image,
+		Command: []string{
+			"sh",
+			"-c",
+			"tor -f /etc/tor/torrc",
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "tor-sidecar-torrc",
+				MountPath: "/etc/tor/torrc",
+				SubPath:   "torrc",
+			},
+			{
+				Name:      "tor-sidecar-data",
+				MountPath: "/var/lib/tor",
+			},
+		},
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser:                ptr.To(int64(torUID)),
+			RunAsGroup:               ptr.To(int64(torUID)),
+			AllowPrivilegeEscalation: ptr.To(false),
+		},
+	}
+}
+
+// Torrc is the configuration of the injected tor container, listening on
+// the loopback interface only. The TransPort and DNSPort are only used by
+// the pods in transparent mode.
+func Torrc() string {
+	var config strings.Builder
+
+	fmt.Fprintf(&config, "SOCKSPort 127.0.0.1:%d\n", SOCKSPort)
+	fmt.Fprintf(&config, "HTTPTunnelPort 127.0.0.1:%d\n", HTTPTunnelPort)
+	fmt.Fprintf(&config, "TransPort 127.0.0.1:%d\n", TransPort)
+	fmt.Fprintf(&config, "DNSPort 127.0.0.1:%d\n", DNSPort)
+	fmt.Fprintf(&config, "VirtualAddrNetworkIPv4 %s\n", VirtualNetwork)
+	fmt.Fprintf(&config, "AutomapHostsOnResolve 1\n")
+	fmt.Fprintf(&config, "DataDirectory /var/lib/tor\n")
+	fmt.Fprintf(&config, "RunAsDaemon 0\n")
+
+	return config.String()
+}
+
+func (i *Injector) reconcileConfigMap(ctx context.Context, namespace string) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ConfigMapName,
+			Namespace: namespace,
+		},
+		Data: map[string]string{
+			"torrc": Torrc(),
+		},
+	}
+
+	found := &corev1.ConfigMap{}
+	err := i.Client.Get(ctx, types.NamespacedName{Name: cm.Name, Namespace: cm.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		err = i.Client.Create(ctx, cm)
+		if errors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	if found.Data["torrc"] != cm.Data["torrc"] {
+		found.Data = cm.Data
+		return i.Client.Update(ctx, found)
+	}
+
+	return nil
+}
@@ -0,0 +1,329 @@
+package sidecar
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	podutils "github.com/fulviodenza/torproxy/test/utils/core_v1"
+)
+
+func TestSidecar(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Sidecar Injection Suite")
+}
+
+func env(container corev1.Container, name string) string {
+	for _, env := range container.Env {
+		if env.Name == name {
+			return env.Value
+		}
+	}
+	return ""
+}
+
+var _ = Describe("tor sidecar injection", func() {
+	It("is requested through the tor label", func() {
+		Expect(Enabled(podutils.Pod())).To(BeTrue())
+
+		pod := podutils.Pod()
+		pod.Labels = nil
+		Expect(Enabled(pod)).To(BeFalse())
+	})
+
+	It("adds the tor container and points the others to it", func() {
+		pod := podutils.Pod(podutils.WithContainers("app", "worker"))
+		Inject(pod)
+
+		Expect(pod.Spec.Containers).To(HaveLen(3))
+		Expect(pod.Spec.Containers[2].Name).To(Equal(ContainerName))
+		Expect(pod.Annotations).To(HaveKey(v1beta1.SidecarInjectedAnnotation))
+
+		for _, container := range pod.Spec.Containers[:2] {
+			Expect(env(container, "HTTP_PROXY")).To(Equal("http://127.0.0.1:8118"))
+			Expect(env(container, "ALL_PROXY")).To(Equal("socks5h://127.0.0.1:9050"))
+		}
+		Expect(pod.Spec.Containers[2].Env).To(BeEmpty())
+	})
+
+	It("leaves the excluded containers untouched", func() {
+		pod := podutils.Pod(
+			podutils.WithContainers("app", "metrics"),
+			podutils.WithAnnotation(v1beta1.SidecarExcludeContainersAnnotation, " metrics "),
+		)
+		Inject(pod)
+
+		Expect(env(pod.Spec.Containers[0], "ALL_PROXY")).NotTo(BeEmpty())
+		Expect(pod.Spec.Containers[1].Env).To(BeEmpty())
+	})
+
+	It("binds tor to the loopback interface", func() {
+		Expect(Torrc()).To(ContainSubstring("SOCKSPort 127.0.0.1:9050\n"))
+		Expect(Torrc()).To(ContainSubstring("HTTPTunnelPort 127.0.0.1:8118\n"))
+	})
+})
+
+var _ = Describe("transparent mode", func() {
+	It("redirects the pod traffic before the other init containers run", func() {
+		pod := podutils.Pod(
+			podutils.WithContainers("app"),
+			podutils.WithAnnotation(v1beta1.SidecarTransparentAnnotation, "true"),
+		)
+		pod.Spec.InitContainers = []corev1.Container{{Name: "migrate"}}
+		Inject(pod)
+
+		Expect(pod.Spec.InitContainers).To(HaveLen(2))
+		Expect(pod.Spec.InitContainers[0].Name).To(Equal(InitContainerName))
+		Expect(pod.Spec.InitContainers[0].SecurityContext.Capabilities.Add).To(ContainElement(corev1.Capability("NET_ADMIN")))
+	})
+
+	It("resolves the names of the pod through the DNS container", func() {
+		pod := podutils.Pod(
+			podutils.WithContainers("app"),
+			podutils.WithAnnotation(v1beta1.SidecarTransparentAnnotation, "true"),
+		)
+		Inject(pod)
+
+		Expect(pod.Spec.Containers).To(HaveLen(3))
+		dns := pod.Spec.Containers[2]
+		Expect(dns.Name).To(Equal(DNSContainerName))
+		Expect(dns.Command).To(ContainElements("--listen-address=127.0.0.1:5300", "--tor-dns=127.0.0.1:5353"))
+		Expect(*dns.SecurityContext.RunAsUser).To(Equal(int64(102)))
+	})
+
+	It("is off by default", func() {
+		pod := podutils.Pod(podutils.WithContainers("app"))
+		Inject(pod)
+
+		Expect(pod.Spec.InitContainers).To(BeEmpty())
+	})
+
+	It("excludes the cluster networks and rejects everything else", func() {
+		script := RedirectScript([]string{"10.0.0.0/8", "fd00::/8", "192.0.2.1", "not-a-cidr"}, "")
+
+		Expect(script).To(ContainSubstring("-A OUTPUT -m owner --uid-owner 101 -j RETURN\n"))
+		Expect(script).To(ContainSubstring("-A OUTPUT -p udp --dport 53 -j REDIRECT --to-ports 5300\n"))
+		Expect(script).To(ContainSubstring("-A OUTPUT -p tcp --dport 53 -j REDIRECT --to-ports 5300\n"))
+		Expect(script).To(ContainSubstring("-A OUTPUT -d 10.0.0.0/8 -j RETURN\n"))
+		Expect(script).To(ContainSubstring("-A OUTPUT -d 192.0.2.1 -j RETURN\n"))
+		Expect(script).To(ContainSubstring("-A OUTPUT -d ${KUBERNETES_SERVICE_HOST} -j RETURN\n"))
+		Expect(script).To(ContainSubstring("-A OUTPUT -p tcp --syn -j REDIRECT --to-ports 9040\n"))
+		Expect(script).To(ContainSubstring("-A OUTPUT -d fd00::/8 -j ACCEPT\n"))
+		Expect(script).To(ContainSubstring("-A OUTPUT -j REJECT --reject-with icmp6-port-unreachable\n"))
+		Expect(script).NotTo(ContainSubstring("not-a-cidr"))
+	})
+
+	It("redirects the onion virtual addresses before the excluded networks", func() {
+		script := RedirectScript([]string{"10.0.0.0/8"}, "")
+
+		redirect := strings.Index(script, "-d 127.192.0.0/10 -j REDIRECT")
+		Expect(redirect).To(BeNumerically("<", strings.Index(script, "-o lo -j RETURN")))
+		Expect(redirect).To(BeNumerically("<", strings.Index(script, "-d 10.0.0.0/8 -j RETURN")))
+	})
+
+	It("lets only the DNS container query the cluster DNS", func() {
+		script := RedirectScript([]string{"10.96.0.0/12"}, "10.96.0.10")
+
+		dns := strings.Index(script, "-A OUTPUT -m owner --uid-owner 102 -p udp -d 10.96.0.10 --dport 53 -j RETURN\n")
+		Expect(dns).To(BeNumerically(">", 0))
+		Expect(dns).To(BeNumerically("<", strings.Index(script, "-A OUTPUT -p udp --dport 53 -j REDIRECT")))
+		Expect(script).To(ContainSubstring("-A OUTPUT -m owner --uid-owner 102 -p tcp -d 10.96.0.10 --dport 53 -j RETURN\n"))
+		Expect(script).To(ContainSubstring("-A OUTPUT -m owner --uid-owner 102 -d 10.96.0.10 -p udp --dport 53 -j ACCEPT\n"))
+		Expect(script).NotTo(ContainSubstring("-A OUTPUT -p udp -d 10.96.0.10 --dport 53 -j RETURN\n"))
+		Expect(script).NotTo(ContainSubstring("-A OUTPUT -d 10.96.0.10 -j ACCEPT\n"))
+	})
+
+	Context("configuration", func() {
+		BeforeEach(func() {
+			cidrs, dns, virtual := ClusterCIDRs, ClusterDNS, VirtualNetwork
+			DeferCleanup(func() {
+				ClusterCIDRs, ClusterDNS, VirtualNetwork = cidrs, dns, virtual
+			})
+			ClusterCIDRs = []string{"10.244.0.0/16", "10.96.0.0/12"}
+			ClusterDNS = "10.96.0.10"
+			VirtualNetwork = "127.192.0.0/10"
+		})
+
+		It("accepts the cluster networks", func() {
+			Expect(CheckTransparent()).To(Succeed())
+		})
+
+		It("requires the cluster networks and DNS", func() {
+			ClusterCIDRs = nil
+			Expect(CheckTransparent()).To(MatchError(ContainSubstring("--cluster-cidrs")))
+
+			ClusterCIDRs = []string{"10.96.0.0/12"}
+			ClusterDNS = ""
+			Expect(CheckTransparent()).To(MatchError(ContainSubstring("--cluster-dns")))
+		})
+
+		It("refuses a virtual network overlapping the cluster networks", func() {
+			ClusterCIDRs = []string{"10.0.0.0/8"}
+			VirtualNetwork = "10.192.0.0/10"
+			Expect(CheckTransparent()).To(MatchError(ContainSubstring("overlaps the cluster CIDR 10.0.0.0/8")))
+
+			VirtualNetwork = "100.64.0.0/10"
+			Expect(CheckTransparent()).To(Succeed())
+			Expect(Torrc()).To(ContainSubstring("VirtualAddrNetworkIPv4 100.64.0.0/10\n"))
+		})
+	})
+})
+
+var _ = Describe("egress policies", func() {
+	policy := &v1beta1.TorEgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "egress", Namespace: "default"},
+		Spec: v1beta1.TorEgressPolicySpec{
+			Mode:     v1beta1.EgressModeGateway,
+			TorProxy: &v1beta1.TorProxyReference{Name: "proxy"},
+		},
+	}
+
+	It("select every pod but the TorProxy ones", func() {
+		selected, err := Selects(policy, podutils.Pod())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(selected).To(BeTrue())
+
+		proxyPod := podutils.Pod()
+		proxyPod.Labels = map[string]string{"app": "proxy"}
+		selected, err = Selects(policy, proxyPod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(selected).To(BeFalse())
+	})
+
+	It("point the pods to the TorProxy Service", func() {
+		torProxy := &v1beta1.TorProxy{
+			ObjectMeta: metav1.ObjectMeta{Name: "proxy", Namespace: "default"},
+			Spec: v1beta1.TorProxySpec{
+				HTTPTunnel: &v1beta1.TorProxyListener{Port: 8118},
+			},
+		}
+		pod := podutils.Pod(podutils.WithContainers("app"))
+		Wire(pod, torProxy)
+
+		Expect(env(pod.Spec.Containers[0], "HTTPS_PROXY")).To(Equal("http://proxy.default.svc.cluster.local:8118"))
+		Expect(env(pod.Spec.Containers[0], "ALL_PROXY")).To(Equal("socks5h://proxy.default.svc.cluster.local:9050"))
+	})
+
+	It("leave HTTP_PROXY unset without an HTTP tunnel", func() {
+		torProxy := &v1beta1.TorProxy{ObjectMeta: metav1.ObjectMeta{Name: "proxy", Namespace: "default"}}
+		pod := podutils.Pod(podutils.WithContainers("app"))
+		Wire(pod, torProxy)
+
+		Expect(env(pod.Spec.Containers[0], "HTTP_PROXY")).To(BeEmpty())
+		Expect(env(pod.Spec.Containers[0], "ALL_PROXY")).NotTo(BeEmpty())
+	})
+})
+
+var _ = Describe("egress policies in Sidecar mode", func() {
+	var injector *Injector
+
+	BeforeEach(func() {
+		cidrs, dns := ClusterCIDRs, ClusterDNS
+		DeferCleanup(func() {
+			ClusterCIDRs, ClusterDNS = cidrs, dns
+		})
+		ClusterCIDRs = []string{"10.244.0.0/16", "10.96.0.0/12"}
+		ClusterDNS = "10.96.0.10"
+
+		scheme := runtime.NewScheme()
+		Expect(v1beta1.AddToScheme(scheme)).To(Succeed())
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		policy := &v1beta1.TorEgressPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "egress", Namespace: "default"},
+			Spec:       v1beta1.TorEgressPolicySpec{Mode: v1beta1.EgressModeSidecar},
+		}
+		injector = &Injector{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(policy).Build()}
+	})
+
+	request := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Namespace: "default",
+		DryRun:    ptr.To(true),
+	}}
+
+	It("ignores the annotations and containers the pod brings", func() {
+		pod := podutils.Pod(
+			podutils.WithContainers("app", "metrics"),
+			podutils.WithAnnotation(v1beta1.SidecarInjectedAnnotation, "true"),
+			podutils.WithAnnotation(v1beta1.EgressPolicyAnnotation, "egress"),
+			podutils.WithAnnotation(v1beta1.SidecarExcludeCIDRsAnnotation, "0.0.0.0/0"),
+			podutils.WithAnnotation(v1beta1.SidecarExcludeContainersAnnotation, "metrics"),
+		)
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: ContainerName, Image: "busybox"})
+		Expect(NonCompliantReason(pod)).To(Equal("SidecarNotInjected"))
+
+		Expect(injector.mutate(context.Background(), request, pod)).To(BeNil())
+
+		Expect(pod.Annotations).NotTo(HaveKey(v1beta1.SidecarExcludeCIDRsAnnotation))
+		Expect(pod.Annotations).To(HaveKeyWithValue(v1beta1.SidecarTransparentAnnotation, "true"))
+		Expect(pod.Spec.InitContainers[0].Command[2]).NotTo(ContainSubstring("0.0.0.0/0"))
+		Expect(pod.Spec.Containers).To(HaveLen(4))
+		Expect(pod.Spec.Containers[2].Image).To(Equal(Image))
+		Expect(pod.Spec.Containers[3].Name).To(Equal(DNSContainerName))
+		Expect(env(pod.Spec.Containers[1], "ALL_PROXY")).NotTo(BeEmpty())
+		Expect(NonCompliantReason(pod)).To(BeEmpty())
+
+		// The second webhook leaves the pod as it is.
+		injected := pod.DeepCopy()
+		Expect(injector.mutate(context.Background(), request, pod)).To(BeNil())
+		Expect(pod).To(Equal(injected))
+	})
+
+	It("refuses containers which could get around the sidecar", func() {
+		pod := podutils.Pod(podutils.WithContainers("app"))
+		pod.Spec.SecurityContext = &corev1.PodSecurityContext{RunAsUser: ptr.To(int64(101))}
+
+		response := injector.mutate(context.Background(), request, pod)
+		Expect(response).NotTo(BeNil())
+		Expect(response.Allowed).To(BeFalse())
+		Expect(response.Result.Message).To(ContainSubstring("runs as the tor user"))
+
+		pod = podutils.Pod(podutils.WithContainers("app"))
+		pod.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{RunAsUser: ptr.To(int64(102))}
+		response = injector.mutate(context.Background(), request, pod)
+		Expect(response).NotTo(BeNil())
+		Expect(response.Result.Message).To(ContainSubstring("runs as the DNS user"))
+
+		pod = podutils.Pod(podutils.WithContainers("app"))
+		pod.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{
+			Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"NET_ADMIN"}},
+		}
+		response = injector.mutate(context.Background(), request, pod)
+		Expect(response).NotTo(BeNil())
+		Expect(response.Allowed).To(BeFalse())
+	})
+
+	It("reports pods without the redirection as non compliant", func() {
+		pod := podutils.Pod(
+			podutils.WithContainers("app"),
+			podutils.WithAnnotation(v1beta1.SidecarTransparentAnnotation, "true"),
+			podutils.WithAnnotation(v1beta1.EgressPolicyAnnotation, "egress"),
+		)
+		Expect(NonCompliantReason(pod)).To(Equal("SidecarNotInjected"))
+
+		pod.Annotations[v1beta1.SidecarTransparentAnnotation] = "false"
+		Inject(pod)
+		Expect(NonCompliantReason(pod)).To(Equal("SidecarNotTransparent"))
+
+		Reset(pod)
+		pod.Annotations[v1beta1.SidecarTransparentAnnotation] = "true"
+		Inject(pod)
+		Expect(NonCompliantReason(pod)).To(BeEmpty())
+		pod.Spec.Containers = slices.DeleteFunc(pod.Spec.Containers, func(c corev1.Container) bool {
+			return c.Name == DNSContainerName
+		})
+		Expect(NonCompliantReason(pod)).To(Equal("SidecarNotTransparent"))
+	})
+})
@@ -0,0 +1,234 @@
+package sidecar
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+
+	"github.com/fulviodenza/torproxy/api/v1beta1"
+	"github.com/fulviodenza/torproxy/internal/controllers/onionservice"
+)
+
+const (
+	// InitContainerName of the init container installing the redirection.
+	InitContainerName = "tor-transparent-init"
+	// DNSContainerName of the container resolving the names of the pod.
+	DNSContainerName = "tor-dns"
+
+	TransPort = 9040
+	DNSPort   = 5353
+	// ResolverPort of the DNS container, every DNS query of the pod is
+	// redirected to it.
+	ResolverPort = 5300
+
+	// dnsUID runs the DNS container, the only one allowed to query the
+	// cluster DNS.
+	dnsUID = 102
+)
+
+var (
+	// TransparentImage of the init container, it must ship sh and the
+	// iptables-restore/ip6tables-restore tools.
+	TransparentImage = "nicolaka/netshoot:latest"
+
+	// DNSImage of the DNS container, /tor-dns is run. It is shipped in the
+	// manager image.
+	DNSImage = "fulviodenza/torproxy:latest"
+
+	// ClusterCIDRs are reached directly by pods in transparent mode, they
+	// must cover the pod and service networks of the cluster and nothing
+	// more: any other destination in them bypasses tor.
+	ClusterCIDRs []string
+
+	// ClusterDNS is the IP of the cluster DNS Service, the DNS container of
+	// the pods in transparent mode resolves cluster names with it.
+	ClusterDNS string
+
+	// VirtualNetwork hosts the addresses tor maps .onion names to, it is
+	// redirected before the loopback interface and the excluded CIDRs are
+	// let through and must not overlap them. The default of tor, in the
+	// loopback network, never collides with cluster addresses.
+	VirtualNetwork = "127.192.0.0/10"
+)
+
+// CheckTransparent reports why pods cannot be injected in transparent mode
+// with the configuration of the manager, nil when they can.
+func CheckTransparent() error {
+	if len(ClusterCIDRs) == 0 {
+		return fmt.Errorf("the pod and service CIDRs of the cluster are not configured, set --cluster-cidrs")
+	}
+	if net.ParseIP(ClusterDNS) == nil {
+		return fmt.Errorf("the cluster DNS Service IP is not configured, set --cluster-dns")
+	}
+
+	ip, virtual, err := net.ParseCIDR(VirtualNetwork)
+	if err != nil || ip.To4() == nil {
+		return fmt.Errorf("invalid IPv4 virtual network %q, set --virtual-network", VirtualNetwork)
+	}
+	for _, cidr := range ClusterCIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid cluster CIDR %q: %w", cidr, err)
+		}
+		if network.Contains(virtual.IP) || virtual.Contains(network.IP) {
+			return fmt.Errorf("the virtual network %s overlaps the cluster CIDR %s, set --virtual-network", VirtualNetwork, cidr)
+		}
+	}
+	return nil
+}
+
+// Transparent reports whether the whole pod egress is forced through tor.
+func Transparent(pod *corev1.Pod) bool {
+	return pod.Annotations[v1beta1.SidecarTransparentAnnotation] == "true"
+}
+
+// injectTransparent prepends the init container redirecting the pod
+// traffic to tor, so that the other init containers are covered too, and
+// adds the DNS container.
+func injectTransparent(pod *corev1.Pod) {
+	pod.Spec.InitContainers = append([]corev1.Container{initContainer(excludedCIDRs(pod), ClusterDNS)}, pod.Spec.InitContainers...)
+	pod.Spec.Containers = append(pod.Spec.Containers, dnsContainer(ClusterDNS))
+}
+
+func excludedCIDRs(pod *corev1.Pod) []string {
+	cidrs := append([]string{}, ClusterCIDRs...)
+	for _, cidr := range strings.Split(pod.Annotations[v1beta1.SidecarExcludeCIDRsAnnotation], ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	return cidrs
+}
+
+func initContainer(cidrs []string, clusterDNS string) corev1.Container {
+	return corev1.Container{
+		Name:  InitContainerName,
+		Image: TransparentImage,
+		Command: []string{
+			"sh",
+			"-c",
+			RedirectScript(cidrs, clusterDNS),
+		},
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser:    ptr.To(int64(0)),
+			RunAsNonRoot: ptr.To(false),
+			Capabilities: &corev1.Capabilities{
+				Add:  []corev1.Capability{"NET_ADMIN", "NET_RAW"},
+				Drop: []corev1.Capability{"ALL"},
+			},
+		},
+	}
+}
+
+// dnsContainer splits the DNS queries of the pod: cluster names are
+// resolved by the cluster DNS, the others by tor, so that public names are
+// never sent in clear to the cluster DNS and its upstreams.
+func dnsContainer(clusterDNS string) corev1.Container {
+	return corev1.Container{
+		Name:  DNSContainerName,
+		Image: DNSImage,
+		Command: []string{
+			"/tor-dns",
+			fmt.Sprintf("--listen-address=127.0.0.1:%d", ResolverPort),
+			"--cluster-domain=" + onionservice.ClusterDomain,
+			"--cluster-dns=" + net.JoinHostPort(clusterDNS, "53"),
+			fmt.Sprintf("--tor-dns=127.0.0.1:%d", DNSPort),
+		},
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser:                ptr.To(int64(dnsUID)),
+			RunAsGroup:               ptr.To(int64(dnsUID)),
+			RunAsNonRoot:             ptr.To(true),
+			AllowPrivilegeEscalation: ptr.To(false),
+			Capabilities: &corev1.Capabilities{
+				Drop: []corev1.Capability{"ALL"},
+			},
+		},
+	}
+}
+
+// RedirectScript installs the rules atomically with iptables-restore and
+// fails, keeping the pod from starting, if any of them is rejected.
+//
+// DNS goes to the DNS container, whatever its destination, and new TCP
+// connections to the TransPort. Only the DNS container reaches the cluster
+// DNS on port 53. The loopback interface, the tor user, the excluded CIDRs
+// and the API server are let through. Everything else, UDP and IPv6 included, is rejected so that a
+// stopped or misconfigured tor never leaks traffic.
+func RedirectScript(cidrs []string, clusterDNS string) string {
+	var v4, v6 []string
+	for _, cidr := range cidrs {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			// The annotation may carry a plain address.
+			ip = net.ParseIP(cidr)
+		}
+		switch {
+		case ip == nil:
+			continue
+		case ip.To4() != nil:
+			v4 = append(v4, cidr)
+		default:
+			v6 = append(v6, cidr)
+		}
+	}
+
+	var script strings.Builder
+
+	fmt.Fprintf(&script, "set -e\n")
+	fmt.Fprintf(&script, "cat > /tmp/rules.v4 <<EOF\n")
+	fmt.Fprintf(&script, "*nat\n")
+	fmt.Fprintf(&script, ":OUTPUT ACCEPT [0:0]\n")
+	fmt.Fprintf(&script, "-A OUTPUT -m owner --uid-owner %d -j RETURN\n", torUID)
+	if clusterDNS != "" {
+		fmt.Fprintf(&script, "-A OUTPUT -m owner --uid-owner %d -p udp -d %s --dport 53 -j RETURN\n", dnsUID, clusterDNS)
+		fmt.Fprintf(&script, "-A OUTPUT -m owner --uid-owner %d -p tcp -d %s --dport 53 -j RETURN\n", dnsUID, clusterDNS)
+	}
+	fmt.Fprintf(&script, "-A OUTPUT -p udp --dport 53 -j REDIRECT --to-ports %d\n", ResolverPort)
+	fmt.Fprintf(&script, "-A OUTPUT -p tcp --dport 53 -j REDIRECT --to-ports %d\n", ResolverPort)
+	fmt.Fprintf(&script, "-A OUTPUT -p tcp -d %s -j REDIRECT --to-ports %d\n", VirtualNetwork, TransPort)
+	fmt.Fprintf(&script, "-A OUTPUT -o lo -j RETURN\n")
+	for _, cidr := range v4 {
+		fmt.Fprintf(&script, "-A OUTPUT -d %s -j RETURN\n", cidr)
+	}
+	fmt.Fprintf(&script, "-A OUTPUT -d ${KUBERNETES_SERVICE_HOST} -j RETURN\n")
+	fmt.Fprintf(&script, "-A OUTPUT -p tcp --syn -j REDIRECT --to-ports %d\n", TransPort)
+	fmt.Fprintf(&script, "COMMIT\n")
+	writeFilter(&script, v4, clusterDNS, "${KUBERNETES_SERVICE_HOST}", "icmp-port-unreachable")
+	fmt.Fprintf(&script, "EOF\n")
+	fmt.Fprintf(&script, "iptables-restore < /tmp/rules.v4\n")
+
+	fmt.Fprintf(&script, "if [ -e /proc/net/if_inet6 ]; then\n")
+	fmt.Fprintf(&script, "cat > /tmp/rules.v6 <<EOF\n")
+	writeFilter(&script, v6, "", "", "icmp6-port-unreachable")
+	fmt.Fprintf(&script, "EOF\n")
+	fmt.Fprintf(&script, "ip6tables-restore < /tmp/rules.v6\n")
+	fmt.Fprintf(&script, "fi\n")
+
+	return script.String()
+}
+
+// writeFilter renders the filter table rejecting whatever was neither
+// redirected to tor nor excluded.
+func writeFilter(script *strings.Builder, cidrs []string, clusterDNS, apiServer, reject string) {
+	fmt.Fprintf(script, "*filter\n")
+	fmt.Fprintf(script, ":OUTPUT ACCEPT [0:0]\n")
+	fmt.Fprintf(script, "-A OUTPUT -o lo -j ACCEPT\n")
+	fmt.Fprintf(script, "-A OUTPUT -m owner --uid-owner %d -j ACCEPT\n", torUID)
+	fmt.Fprintf(script, "-A OUTPUT -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT\n")
+	if clusterDNS != "" {
+		fmt.Fprintf(script, "-A OUTPUT -m owner --uid-owner %d -d %s -p udp --dport 53 -j ACCEPT\n", dnsUID, clusterDNS)
+		fmt.Fprintf(script, "-A OUTPUT -m owner --uid-owner %d -d %s -p tcp --dport 53 -j ACCEPT\n", dnsUID, clusterDNS)
+	}
+	for _, cidr := range cidrs {
+		fmt.Fprintf(script, "-A OUTPUT -d %s -j ACCEPT\n", cidr)
+	}
+	if apiServer != "" {
+		fmt.Fprintf(script, "-A OUTPUT -d %s -j ACCEPT\n", apiServer)
+	}
+	fmt.Fprintf(script, "-A OUTPUT -p tcp -j REJECT --reject-with tcp-reset\n")
+	fmt.Fprintf(script, "-A OUTPUT -j REJECT --reject-with %s\n", reject)
+	fmt.Fprintf(script, "COMMIT\n")
+}
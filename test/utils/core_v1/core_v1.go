@@ -42,3 +42,22 @@ var WithFinalizer = func(f string) func(any) {
 		o.(client.Object).SetFinalizers(append(o.(client.Object).GetFinalizers(), f))
 	}
 }
+
+var WithContainers = func(names ...string) func(any) {
+	return func(o any) {
+		for _, name := range names {
+			o.(*corev1.Pod).Spec.Containers = append(o.(*corev1.Pod).Spec.Containers, corev1.Container{Name: name})
+		}
+	}
+}
+
+var WithAnnotation = func(key, value string) func(any) {
+	return func(o any) {
+		annotations := o.(client.Object).GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[key] = value
+		o.(client.Object).SetAnnotations(annotations)
+	}
+}